@@ -0,0 +1,222 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"fmt"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/kuberesource"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	"github.com/vmware-tanzu/velero/pkg/util/boolptr"
+)
+
+// PVCBackupItemAction is a backup item action plugin that snapshots the CSI volumes of PVCs in data mover backups,
+// so the plugin can run without velero-plugin-for-csi
+type PVCBackupItemAction struct {
+	Log logrus.FieldLogger
+}
+
+// AppliesTo returns information indicating that the PVCBackupItemAction should be invoked to backup PVCs.
+func (p *PVCBackupItemAction) AppliesTo() (velero.ResourceSelector, error) {
+	p.Log.Debug("PVCBackupItemAction AppliesTo")
+
+	return velero.ResourceSelector{
+		IncludedResources: []string{"persistentvolumeclaims"},
+	}, nil
+}
+
+// Execute creates a volumesnapshot of the CSI volume bound to the PVC and returns it as an additional item to backup.
+// The data mover then moves the data of its volumesnapshotcontent.
+func (p *PVCBackupItemAction) Execute(item runtime.Unstructured, backup *velerov1api.Backup) (runtime.Unstructured, []velero.ResourceIdentifier, error) {
+	p = &PVCBackupItemAction{Log: util.BackupLogger(p.Log, backup)}
+
+	p.Log.Info("Executing PVCBackupItemAction")
+	ctx, finished, err := util.StartItemAction("PVCBackupItemAction")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer finished()
+
+	// velero-plugin-for-csi snapshots the PVCs unless the plugin is configured to
+	if !util.SnapshotPVCsEnabled() || !util.DataMoverEnabledForBackup(backup) {
+		return item, nil, nil
+	}
+
+	// Do nothing if volume snapshots have not been requested in this backup
+	if boolptr.IsSetToFalse(backup.Spec.SnapshotVolumes) {
+		p.Log.Infof("Volume snapshotting not requested for backup %s/%s", backup.Namespace, backup.Name)
+		return item, nil, nil
+	}
+
+	var pvc corev1api.PersistentVolumeClaim
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), &pvc); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	p.Log = p.Log.WithField(util.LogFieldPVC, util.NamespacedLogField(pvc.Namespace, pvc.Name))
+
+	// leave the PVC to the snapshot velero-plugin-for-csi took of it, if it ran first and is preferred
+	policy, err := util.GetSnapshotDedupPolicy()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if name := util.GetVolumeSnapshotOfPVCForBackup(&pvc, backup); len(name) > 0 && policy == util.SnapshotDedupPolicyCSI {
+		p.Log.Infof("Skipping PVC %s/%s, it was snapshotted as volumesnapshot %s by velero-plugin-for-csi", pvc.Namespace, pvc.Name, name)
+		return item, nil, nil
+	}
+
+	kubeClient, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	p.Log.Debugf("Fetching underlying PV for PVC %s", fmt.Sprintf("%s/%s", pvc.Namespace, pvc.Name))
+	// Do nothing if this is not a CSI provisioned volume
+	pv, err := util.GetPVForPVC(&pvc, kubeClient.CoreV1())
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if pv.Spec.PersistentVolumeSource.CSI == nil {
+		p.Log.Infof("Skipping PVC %s/%s, associated PV %s is not a CSI volume", pvc.Namespace, pvc.Name, pv.Name)
+		return item, nil, nil
+	}
+
+	// Do nothing if file system backup is used to backup this PV
+	fsBackup, err := util.IsPVCBackedUpByFsBackup(pvc.Namespace, pvc.Name, kubeClient.CoreV1(),
+		boolptr.IsSetToTrue(backup.Spec.DefaultVolumesToFsBackup) || boolptr.IsSetToTrue(backup.Spec.DefaultVolumesToRestic))
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if fsBackup {
+		p.Log.Infof("Skipping PVC %s/%s, PV %s will be backed up using file system backup", pvc.Namespace, pvc.Name, pv.Name)
+		return item, nil, nil
+	}
+
+	if pvc.Spec.StorageClassName == nil || len(*pvc.Spec.StorageClassName) == 0 {
+		return nil, nil, errors.Errorf("cannot snapshot PVC %s/%s, PVC has no storage class", pvc.Namespace, pvc.Name)
+	}
+
+	p.Log.Infof("Fetching storage class for PV %s", *pvc.Spec.StorageClassName)
+	storageClass, err := kubeClient.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting storage class")
+	}
+
+	p.Log.Debugf("Fetching volumesnapshot class for %s", storageClass.Provisioner)
+	snapshotClass, err := util.GetVolumeSnapshotClassForStorageClass(storageClass.Provisioner, snapshotClient.SnapshotV1())
+	if errors.Is(err, util.ErrNoVolumeSnapshotClass) && util.FsBackupFallbackEnabled() {
+		return p.fallBackToFsBackup(item, &pvc, storageClass.Provisioner, kubeClient.CoreV1())
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get volumesnapshotclass")
+	}
+	p.Log.Infof("volumesnapshot class=%s", snapshotClass.Name)
+
+	vsLabels := map[string]string{}
+	for k, v := range pvc.Labels {
+		vsLabels[k] = v
+	}
+	vsLabels[velerov1api.BackupNameLabel] = label.GetValidName(backup.Name)
+
+	// Craft the snapshot object to be created
+	snapshot := snapshotv1api.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "velero-" + pvc.Name + "-",
+			Namespace:    pvc.Namespace,
+			Labels:       vsLabels,
+			Annotations: map[string]string{
+				util.PluginSnapshotAnnotation: "true",
+			},
+		},
+		Spec: snapshotv1api.VolumeSnapshotSpec{
+			Source: snapshotv1api.VolumeSnapshotSource{
+				PersistentVolumeClaimName: &pvc.Name,
+			},
+			VolumeSnapshotClassName: &snapshotClass.Name,
+		},
+	}
+
+	upd, err := snapshotClient.SnapshotV1().VolumeSnapshots(pvc.Namespace).Create(ctx, &snapshot, metav1.CreateOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error creating volume snapshot")
+	}
+	p.Log.Infof("Created volumesnapshot %s", fmt.Sprintf("%s/%s", upd.Namespace, upd.Name))
+
+	util.AddAnnotations(&pvc.ObjectMeta, map[string]string{
+		util.VolumeSnapshotLabel:                 upd.Name,
+		velerov1api.BackupNameLabel:              backup.Name,
+		util.MustIncludeAdditionalItemAnnotation: "true",
+	})
+	util.AddLabels(&pvc.ObjectMeta, map[string]string{
+		util.VolumeSnapshotLabel:    upd.Name,
+		velerov1api.BackupNameLabel: label.GetValidName(backup.Name),
+	})
+
+	additionalItems := []velero.ResourceIdentifier{
+		{
+			GroupResource: kuberesource.VolumeSnapshots,
+			Namespace:     upd.Namespace,
+			Name:          upd.Name,
+		},
+	}
+
+	pvcMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pvc)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	p.Log.Infof("Returning from PVCBackupItemAction with %d additionalItems to backup", len(additionalItems))
+	return &unstructured.Unstructured{Object: pvcMap}, additionalItems, nil
+}
+
+// fallBackToFsBackup opts the volume of a PVC that can't be snapshotted into velero's file system backup of the pods
+// mounting it. The pods are returned as additional items: velero fetches additional items from the cluster, so they
+// are backed up with the annotation, unless already backed up.
+func (p *PVCBackupItemAction) fallBackToFsBackup(item runtime.Unstructured, pvc *corev1api.PersistentVolumeClaim, provisioner string, podClient corev1client.PodsGetter) (runtime.Unstructured, []velero.ResourceIdentifier, error) {
+	pods, err := util.AnnotatePodsForFsBackup(pvc.Namespace, pvc.Name, podClient)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	if len(pods) == 0 {
+		return nil, nil, errors.Errorf("cannot back up PVC %s/%s: provisioner %s has no volumesnapshotclass and no pod mounts the PVC for file system backup",
+			pvc.Namespace, pvc.Name, provisioner)
+	}
+
+	additionalItems := []velero.ResourceIdentifier{}
+	for _, pod := range pods {
+		additionalItems = append(additionalItems, velero.ResourceIdentifier{
+			GroupResource: kuberesource.Pods,
+			Namespace:     pod.Namespace,
+			Name:          pod.Name,
+		})
+	}
+
+	p.Log.Infof("Provisioner %s of PVC %s/%s has no volumesnapshotclass, backing up its volume with file system backup of %d pods",
+		provisioner, pvc.Namespace, pvc.Name, len(pods))
+	return item, additionalItems, nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package backup
+
+import (
+	"fmt"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/kuberesource"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+// VolumeSnapshotBackupItemAction is a backup item action plugin that backs up the volumesnapshots of data mover
+// backups along with their volumesnapshotcontent and volumesnapshotclass, so the plugin can run without
+// velero-plugin-for-csi
+type VolumeSnapshotBackupItemAction struct {
+	Log logrus.FieldLogger
+}
+
+// AppliesTo returns information indicating that the VolumeSnapshotBackupItemAction should be invoked to backup volumesnapshots.
+func (p *VolumeSnapshotBackupItemAction) AppliesTo() (velero.ResourceSelector, error) {
+	p.Log.Debug("VolumeSnapshotBackupItemAction AppliesTo")
+
+	return velero.ResourceSelector{
+		IncludedResources: []string{"volumesnapshots.snapshot.storage.k8s.io"},
+	}, nil
+}
+
+// Execute annotates the volumesnapshot with the snapshot handle, CSI driver and volumesnapshotclass of its bound
+// volumesnapshotcontent, which the restore statically binds it with, and returns the volumesnapshotcontent and
+// volumesnapshotclass as additional items to backup. The volumesnapshotcontent is in turn handed to the data mover by
+// the VolumeSnapshotContentBackupItemActionV2.
+func (p *VolumeSnapshotBackupItemAction) Execute(item runtime.Unstructured, backup *velerov1api.Backup) (runtime.Unstructured, []velero.ResourceIdentifier, error) {
+	p = &VolumeSnapshotBackupItemAction{Log: util.BackupLogger(p.Log, backup)}
+
+	p.Log.Info("Executing VolumeSnapshotBackupItemAction")
+	ctx, finished, err := util.StartItemAction("VolumeSnapshotBackupItemAction")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer finished()
+
+	// velero-plugin-for-csi backs up the volumesnapshots unless the plugin is configured to snapshot PVCs itself
+	if !util.SnapshotPVCsEnabled() || !util.DataMoverEnabledForBackup(backup) {
+		return item, nil, nil
+	}
+
+	var vs snapshotv1api.VolumeSnapshot
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), &vs); err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	p.Log = p.Log.WithField(util.LogFieldVolumeSnapshot, util.NamespacedLogField(vs.Namespace, vs.Name))
+
+	_, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	// volumesnapshots taken by the PVCBackupItemAction for this backup are waited for, volumesnapshots created
+	// outside of velero are expected to be bound already
+	backupOngoing := vs.Labels[velerov1api.BackupNameLabel] == label.GetValidName(backup.Name)
+
+	p.Log.Infof("Getting volumesnapshotcontent for volumesnapshot %s/%s", vs.Namespace, vs.Name)
+	vsc, err := util.GetVolumeSnapshotContentForVolumeSnapshot(ctx, &vs, snapshotClient.SnapshotV1(), p.Log, backupOngoing)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	additionalItems := []velero.ResourceIdentifier{}
+	annotations := map[string]string{}
+
+	if vs.Spec.VolumeSnapshotClassName != nil {
+		vsClass, err := snapshotClient.SnapshotV1().VolumeSnapshotClasses().Get(ctx, *vs.Spec.VolumeSnapshotClassName, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to get volumesnapshotclass %s", *vs.Spec.VolumeSnapshotClassName)
+		}
+
+		annotations[util.VolumeSnapshotClassNameAnnotation] = vsClass.Name
+		additionalItems = append(additionalItems, velero.ResourceIdentifier{
+			GroupResource: kuberesource.VolumeSnapshotClasses,
+			Name:          vsClass.Name,
+		})
+	}
+
+	// a volumesnapshot created outside of velero may not be bound yet, it is then backed up without its
+	// volumesnapshotcontent
+	if vsc != nil {
+		annotations[util.CSIDriverNameAnnotation] = vsc.Spec.Driver
+		annotations[util.CSIVSCDeletionPolicy] = string(vsc.Spec.DeletionPolicy)
+		if vsc.Status != nil {
+			if vsc.Status.SnapshotHandle != nil {
+				annotations[util.VolumeSnapshotHandleAnnotation] = *vsc.Status.SnapshotHandle
+			}
+			if vsc.Status.RestoreSize != nil {
+				annotations[util.VolumeSnapshotRestoreSize] = resource.NewQuantity(*vsc.Status.RestoreSize, resource.BinarySI).String()
+			}
+		}
+
+		additionalItems = append(additionalItems, velero.ResourceIdentifier{
+			GroupResource: kuberesource.VolumeSnapshotContents,
+			Name:          vsc.Name,
+		})
+	}
+
+	util.AddAnnotations(&vs.ObjectMeta, annotations)
+	util.AddLabels(&vs.ObjectMeta, map[string]string{
+		velerov1api.BackupNameLabel: label.GetValidName(backup.Name),
+	})
+
+	vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&vs)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+
+	p.Log.Infof("Returning from VolumeSnapshotBackupItemAction for volumesnapshot %s with %d additionalItems to backup", fmt.Sprintf("%s/%s", vs.Namespace, vs.Name), len(additionalItems))
+	return &unstructured.Unstructured{Object: vsMap}, additionalItems, nil
+}
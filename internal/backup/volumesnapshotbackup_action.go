@@ -1,6 +1,10 @@
 package backup
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 
 	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
@@ -8,6 +12,8 @@ import (
 	"github.com/sirupsen/logrus"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -23,18 +29,27 @@ func (p *VolumeSnapshotBackupBackupItemAction) AppliesTo() (velero.ResourceSelec
 	p.Log.Info("VolumeSnapshotBackupBackupItemAction AppliesTo")
 
 	return velero.ResourceSelector{
-		IncludedResources: []string{"volumesnapshotbackups.datamover.oadp.openshift.io"},
+		IncludedResources: []string{util.DataMoverResource("volumesnapshotbackups")},
 	}, nil
 }
 
 // Execute backs up a VolumeSnapshotBackup object with a completely filled status
 func (p *VolumeSnapshotBackupBackupItemAction) Execute(item runtime.Unstructured, backup *velerov1api.Backup) (runtime.Unstructured, []velero.ResourceIdentifier, error) {
+	p = &VolumeSnapshotBackupBackupItemAction{Log: util.BackupLogger(p.Log, backup)}
+
 	p.Log.Infof("Executing VolumeSnapshotBackupBackupItemAction")
+	ctx, finished, err := util.StartItemAction("VolumeSnapshotBackupBackupItemAction")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer finished()
+
 	vsb := datamoverv1alpha1.VolumeSnapshotBackup{}
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), &vsb); err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
-	p.Log.Infof("Converted Item to VSB: %v", vsb)
+	p.Log = p.Log.WithField(util.LogFieldVolumeSnapshotBackup, util.NamespacedLogField(vsb.Namespace, vsb.Name))
+	util.TraceObject(p.Log, "Converted Item to VSB", &vsb)
 
 	// check the VSB has the same backup name from label as the current backup
 	isVSBForCurrentBackup := util.VSBBelongsToBackup(backup.Name, &vsb, p.Log)
@@ -44,11 +59,26 @@ func (p *VolumeSnapshotBackupBackupItemAction) Execute(item runtime.Unstructured
 		return item, nil, nil
 	}
 
-	vsbNew, err := util.GetVolumeSnapshotbackupWithStatusData(vsb.Namespace, vsb.Name, p.Log)
+	// the VSB reports the size of its volume once reconciled, until then the size of the source PVC recorded at its
+	// creation applies
+	size := vsb.Status.SourcePVCData.Size
+	if len(size) == 0 {
+		size = vsb.Annotations[util.VolumeSnapshotMoverSourcePVCSize]
+	}
+	timeout, err := util.GetItemWaitTimeout(backup.Spec.ItemOperationTimeout, util.GetTimeoutOverride(&vsb.ObjectMeta), size)
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
 	}
 
+	vsbNew, err := util.GetVolumeSnapshotbackupWithStatusData(ctx, vsb.Namespace, vsb.Name, timeout, p.Log)
+	if err != nil {
+		if util.IsWaitTimeout(err) {
+			util.RecordBackupEvent(backup, corev1api.EventTypeWarning, util.EventReasonDataMoverTimedOut,
+				fmt.Sprintf("volumesnapshotbackup %s/%s had no status data within %s", vsb.Namespace, vsb.Name, timeout), p.Log)
+		}
+		return nil, nil, errors.WithStack(err)
+	}
+
 	vsb.Status = *vsbNew.Status.DeepCopy()
 
 	vals := map[string]string{
@@ -59,9 +89,25 @@ func (p *VolumeSnapshotBackupBackupItemAction) Execute(item runtime.Unstructured
 		util.VolumeSnapshotMoverVolumeSnapshotClass:   vsb.Status.VolumeSnapshotClassName,
 	}
 
+	// record the fsType of the source volume so restores format the restored volume the same way, volumes whose
+	// fsType can't be determined are restored with the storageclass default
+	fsType, err := p.getSourceFSType(&vsb)
+	if err != nil {
+		p.Log.Warnf("failed to get the fsType of the source volume of volumesnapshotbackup %s: %s", vsb.Name, err.Error())
+	}
+	if len(fsType) > 0 {
+		vals[util.VolumeSnapshotMoverSourcePVFSType] = fsType
+	}
+
 	//Add all the relevant status info as annotations because velero strips status subresource for CRDs
 	util.AddAnnotations(&vsb.ObjectMeta, vals)
 
+	// keep the source snapshot around for fast local restores if retention is configured, this is an optimization
+	// and doesn't fail the backup
+	if err := p.retainSourceSnapshot(&vsb, backup); err != nil {
+		p.Log.Warnf("failed to retain source snapshot of volumesnapshotbackup %s: %s", vsb.Name, err.Error())
+	}
+
 	vsbMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&vsb)
 	if err != nil {
 		return nil, nil, errors.WithStack(err)
@@ -69,3 +115,51 @@ func (p *VolumeSnapshotBackupBackupItemAction) Execute(item runtime.Unstructured
 
 	return &unstructured.Unstructured{Object: vsbMap}, nil, nil
 }
+
+// getSourceFSType returns the fsType of the CSI volume bound to the backed up PVC, empty if it is not set
+func (p *VolumeSnapshotBackupBackupItemAction) getSourceFSType(vsb *datamoverv1alpha1.VolumeSnapshotBackup) (string, error) {
+	kubeClient, _, err := util.GetClients()
+	if err != nil {
+		return "", err
+	}
+
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(vsb.Namespace).Get(context.TODO(), vsb.Status.SourcePVCData.Name, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get persistentvolumeclaim %s/%s", vsb.Namespace, vsb.Status.SourcePVCData.Name)
+	}
+
+	pv, err := util.GetPVForPVC(pvc, kubeClient.CoreV1())
+	if err != nil {
+		return "", err
+	}
+
+	if pv.Spec.CSI == nil {
+		return "", nil
+	}
+	return pv.Spec.CSI.FSType, nil
+}
+
+func (p *VolumeSnapshotBackupBackupItemAction) retainSourceSnapshot(vsb *datamoverv1alpha1.VolumeSnapshotBackup, backup *velerov1api.Backup) error {
+	retention, err := util.SnapshotRetentionForBackup(backup)
+	if err != nil {
+		return err
+	}
+
+	if retention == 0 || vsb.Status.Phase != datamoverv1alpha1.SnapMoverBackupPhaseCompleted {
+		return nil
+	}
+
+	_, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return err
+	}
+
+	retainUntil := time.Now().Add(retention)
+	err = util.RetainVolumeSnapshotContent(vsb.Spec.VolumeSnapshotContent.Name, retainUntil, snapshotClient.SnapshotV1())
+	if err != nil {
+		return errors.Wrapf(err, "failed to retain volumesnapshotcontent %s", vsb.Spec.VolumeSnapshotContent.Name)
+	}
+
+	p.Log.Infof("retaining volumesnapshotcontent %s until %s", vsb.Spec.VolumeSnapshotContent.Name, retainUntil.UTC().Format(time.RFC3339))
+	return nil
+}
@@ -25,15 +25,18 @@ import (
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/kuberesource"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	biav2 "github.com/vmware-tanzu/velero/pkg/plugin/velero/backupitemaction/v2"
 )
@@ -53,121 +56,321 @@ func (p *VolumeSnapshotContentBackupItemActionV2) AppliesTo() (velero.ResourceSe
 	}, nil
 }
 
-// Execute returns the unmodified volumesnapshotcontent object along with the snapshot deletion secret, if any, from its annotation
-// as additional items to backup.
+// Execute creates a VSB for the volumesnapshotcontent and returns the volumesnapshotcontent along with the VolSync restic
+// secret of the backup storage location as an additional item to backup, so restores into another cluster have the
+// repository credentials available.
 func (p *VolumeSnapshotContentBackupItemActionV2) Execute(item runtime.Unstructured, backup *velerov1api.Backup) (runtime.Unstructured, []velero.ResourceIdentifier, string, []velero.ResourceIdentifier, error) {
+	p = &VolumeSnapshotContentBackupItemActionV2{Log: util.BackupLogger(p.Log, backup)}
+
 	p.Log.Infof("Executing VolumeSnapshotContentBackupItemActionV2")
+	ctx, finished, err := util.StartItemAction(p.Name())
+	if err != nil {
+		return nil, nil, "", nil, err
+	}
+	defer finished()
 
 	var snapCont snapshotv1api.VolumeSnapshotContent
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), &snapCont); err != nil {
 		return nil, nil, "", nil, errors.WithStack(err)
 	}
 
+	// expire source snapshots retained by earlier backups whose retention has passed
+	util.ExpireRetainedVolumeSnapshotContentsOnce(p.Log)
+	// and delete the VSBs and VSRs left behind by deleted backups and restores
+	util.DeleteOrphanedDataMoverResourcesOnce(backup.Namespace, p.Log)
+
+	p.Log = p.Log.WithField(util.LogFieldVolumeSnapshotContent, snapCont.Name)
 	itemsToUpdate := []velero.ResourceIdentifier{}
 
+	// record why the data of the VSC is not moved: the data mover is disabled, the backup opted out, or the VSC
+	// belongs to another backup
+	if reason := util.DataMoverSkipReason(backup, &snapCont, p.Log); len(reason) > 0 {
+		return p.skipDataMovement(&snapCont, reason)
+	}
+
+	// fail fast when volume-snapshot-mover can't serve the backup rather than waiting for the VSB of every item
+	if err := util.CheckBackupPreflight(backup, p.Log); err != nil {
+		return nil, nil, "", nil, err
+	}
+
 	// Create VolumeSnapshotBackup CR per VolumeSnapshotContent and add it as an additional item
 	operationID := ""
-	if util.DataMoverCase() {
+	// repository stats are informational, don't fail the backup over them
+	if err := util.RecordRepositoryStats(backup, p.Log); err != nil {
+		p.Log.Warnf("failed to record repository stats for backup %s: %s", backup.Name, err.Error())
+	}
+
+	kubeClient, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+
+	// pre-provisioned VSCs may have a partial volumesnapshot ref, resolve the namespace for the VSB up front
+	vsbNamespace, err := util.ResolveVolumeSnapshotContentNamespace(&snapCont, kubeClient.CoreV1())
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+
+	// in opt-in mode, the namespace of the volume must have opted in
+	reason, err := util.NamespaceSkipReason(vsbNamespace, kubeClient.CoreV1())
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+	if len(reason) > 0 {
+		return p.skipDataMovement(&snapCont, reason)
+	}
 
-		// check the VSC has the same backup name from label as the current backup
-		isVSCForCurrentBackup := util.VSCBelongsToBackup(backup, &snapCont, p.Log)
+	// a PVC snapshotted by both this plugin and velero-plugin-for-csi is moved once, per the dedup policy
+	reason, err = util.DuplicateSnapshotSkipReason(&snapCont, vsbNamespace, backup, snapshotClient.SnapshotV1())
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+	if len(reason) > 0 {
+		return p.skipDataMovement(&snapCont, reason)
+	}
 
-		if !isVSCForCurrentBackup {
-			p.Log.Infof("unrelated volumesnapshotcontent found %s, skipping VSB creation", snapCont.Spec.VolumeSnapshotRef.Name)
+	pvc, err := util.GetSourcePVCForVolumeSnapshotContent(&snapCont, vsbNamespace, snapshotClient.SnapshotV1(), kubeClient.CoreV1())
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
 
-			return item, nil, "", nil, nil
+	// the source PVC may keep the volume to its snapshot as well, velero-plugin-for-csi backs it up like any other
+	if reason := util.VolumeSkipReason(pvc); len(reason) > 0 {
+		return p.skipDataMovement(&snapCont, reason)
+	}
+	if populator := util.GetVolumePopulator(pvc); len(populator) > 0 {
+		p.Log.Infof("moving the data of PVC %s/%s provisioned by volume populator %s, per %s=%s", pvc.Namespace, pvc.Name,
+			populator, util.DatamoverPopulatedPVCPolicy, util.PopulatedPVCPolicyMove)
+	}
+
+	// select the mover backend first, so volumes the data mover can't move with it, like raw block volumes with movers
+	// only mounting filesystems, fail before waiting on the snapshot or creating anything
+	backend, err := p.getMoverBackend(backup, pvc)
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+
+	// the volumesnapshotcontent or its PVC may override the datamover timeout for this volume
+	timeoutOverride, size := getVolumeTimeout(&snapCont, pvc)
+
+	timeout, err := util.GetItemWaitTimeout(backup.Spec.ItemOperationTimeout, timeoutOverride, size)
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+
+	// Wait for VSC to be in ready state
+	VSCReady, err := util.WaitForVolumeSnapshotContentToBeReady(ctx, snapCont, snapshotClient.SnapshotV1(), timeout, p.Log)
+
+	if err != nil {
+		if util.IsWaitTimeout(err) {
+			util.RecordBackupEvent(backup, corev1api.EventTypeWarning, util.EventReasonDataMoverTimedOut,
+				fmt.Sprintf("volumesnapshotcontent %s was not ready within %s", snapCont.Name, timeout), p.Log)
 		}
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+
+	if !VSCReady {
+		p.Log.Infof("volumesnapshotcontent not in ready state, still continuing with the backup")
+	}
+
+	// get the restic secret of the PVC namespace, of the namespace policy of the VSB namespace or else of the BSL
+	resticSecretName, tenantSecret, err := p.getResticSecret(backup, &snapCont, vsbNamespace)
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+
+	// check if this backup already created a VolumeSnapshotBackup CR for the VolumeSnapshotContent
+	existingVSB, err := util.GetVSBForVSC(&snapCont, backup, p.Log)
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
+	}
+
+	// an execution retried for the same item reports the operation of the VSB created before
+	if existingVSB != nil {
+		operationID = existingVSB.Namespace + "/" + existingVSB.Name
+		itemsToUpdate = append(itemsToUpdate, velero.ResourceIdentifier{
+			GroupResource: util.DataMoverGroupResource("volumesnapshotbackups"),
+			Name:          existingVSB.Name,
+			Namespace:     existingVSB.Namespace,
+		})
+	}
 
-		_, snapshotClient, err := util.GetClients()
+	// Create VSB only if does not exist for the VSC
+	if existingVSB == nil {
+
+		// hold the data movement until an operator or policy engine approves moving the data out of the cluster. The VSB
+		// is only created, by Progress, once the marker is removed from the volumesnapshotcontent.
+		if util.ApprovalRequiredForBackup(backup) {
+			if err := util.MarkVolumeSnapshotContentAwaitingApproval(snapCont.Name, snapshotClient.SnapshotV1()); err != nil {
+				return nil, nil, "", nil, errors.Wrapf(err, "error marking volumesnapshotcontent %s as awaiting approval", snapCont.Name)
+			}
+
+			vsbName := util.GetApprovalVolumeSnapshotBackupName(snapCont.Name)
+			operationID = vsbNamespace + "/" + vsbName
+			p.Log.Infof("volumesnapshotcontent %s awaits approval, remove its %s annotation to create volumesnapshotbackup %s", snapCont.Name, util.ApprovalPendingAnnotation, operationID)
+
+			itemsToUpdate = append(itemsToUpdate, velero.ResourceIdentifier{
+				GroupResource: util.DataMoverGroupResource("volumesnapshotbackups"),
+				Name:          vsbName,
+				Namespace:     vsbNamespace,
+			})
+			vscItem, additionalItems, err := p.withResticSecret(&snapCont, resticSecretName, backup.Namespace)
+			if err != nil {
+				return nil, nil, "", nil, err
+			}
+			return vscItem, additionalItems, operationID, itemsToUpdate, nil
+		}
+
+		// hand the VSB a short-lived copy of the restic secret holding the password resolved from Vault, if configured
+		vsbSecretName, err := p.getVolumeSnapshotBackupResticSecret(resticSecretName, tenantSecret, snapCont.Name, backup.Namespace)
 		if err != nil {
 			return nil, nil, "", nil, errors.WithStack(err)
 		}
 
-		// Wait for VSC to be in ready state
-		VSCReady, err := util.WaitForVolumeSnapshotContentToBeReady(snapCont, snapshotClient.SnapshotV1(), p.Log)
+		// craft a VolumeBackupSnapshot object to be created
+		vsb := util.NewVolumeSnapshotBackup(util.GetVolumeSnapshotBackupName(backup, snapCont.Name), vsbNamespace, snapCont.Name, vsbSecretName, backup)
+		setVolumeTimeout(vsb, timeoutOverride, size)
+		setTenantResticSecret(vsb, tenantSecret)
+		setVaultBaseResticSecret(vsb, resticSecretName)
+		if err := p.setCustomCA(vsb, backup); err != nil {
+			return nil, nil, "", nil, errors.WithStack(err)
+		}
+		setMoverType(vsb, backend)
+		setVolumeMode(vsb, pvc)
 
-		if err != nil {
+		// the data mover sizes the restic cache of the mover from its ConfigMap
+		if err := util.SyncDataMoverCacheConfig(backup.Namespace, kubeClient.CoreV1()); err != nil {
 			return nil, nil, "", nil, errors.WithStack(err)
 		}
 
-		if !VSCReady {
-			p.Log.Infof("volumesnapshotcontent not in ready state, still continuing with the backup")
+		vsbClient, err := util.GetVolumeSnapshotMoverClient()
+		if err != nil {
+			return nil, nil, "", nil, errors.Wrapf(err, "error getting volumesnapshotbackup client")
 		}
 
-		// get secret name created by data mover controller
-		resticSecretName, err := util.GetDataMoverCredName(backup, backup.Namespace, p.Log)
+		// don't flood the data mover and the storage with the VSBs of backups covering many volumes, if limited
+		release, err := util.AcquireVolumeSnapshotBackupSlot(ctx, backup, timeout, p.Log)
 		if err != nil {
-			return nil, nil, "", nil, errors.WithStack(err)
+			if util.IsWaitTimeout(err) {
+				util.RecordBackupEvent(backup, corev1api.EventTypeWarning, util.EventReasonDataMoverTimedOut,
+					fmt.Sprintf("too many volumesnapshotbackups were in flight to move volumesnapshotcontent %s within %s", snapCont.Name, timeout), p.Log)
+			}
+			return nil, nil, "", nil, errors.Wrapf(err, "error waiting to create the volumesnapshotbackup of volumesnapshotcontent %s", snapCont.Name)
 		}
 
-		// check if VolumeSnapshotBackup CR exists for VolumeSnapshotContent
-		VSBExists, err := util.VSBExistsForVSC(&snapCont, p.Log)
+		// the VSB is named after the backup and the volumesnapshotcontent, so a VSB that already exists was created by
+		// an earlier execution for this item and is reused
+		created := true
+		err = vsbClient.Create(ctx, vsb)
+		release()
+		if apierrors.IsAlreadyExists(err) {
+			created = false
+			err = nil
+		}
 		if err != nil {
-			return nil, nil, "", nil, errors.WithStack(err)
+			return nil, nil, "", nil, errors.Wrapf(err, "error creating volumesnapshotbackup CR")
 		}
 
-		// Create VSB only if does not exist for the VSC
-		if !VSBExists {
-
-			// craft a VolumeBackupSnapshot object to be created
-			vsb := datamoverv1alpha1.VolumeSnapshotBackup{
-				ObjectMeta: metav1.ObjectMeta{
-					GenerateName: "vsb-",
-					Namespace:    snapCont.Spec.VolumeSnapshotRef.Namespace,
-					Labels: map[string]string{
-						util.BackupNameLabel:                           backup.Name,
-						util.VolumeSnapshotBackupVolumeSnapshotContent: snapCont.Name,
-					},
-				},
-				Spec: datamoverv1alpha1.VolumeSnapshotBackupSpec{
-					VolumeSnapshotContent: corev1api.ObjectReference{
-						Name: snapCont.Name,
-					},
-					ProtectedNamespace: backup.Namespace,
-					ResticSecretRef: corev1api.LocalObjectReference{
-						Name: resticSecretName,
-					},
-				},
-			}
+		// operationID for our datamover usecase is VSB NamespacedName which will unique per operation
+		operationID = vsb.Namespace + "/" + vsb.Name
+		p.Log = p.Log.WithField(util.LogFieldVolumeSnapshotBackup, operationID)
 
-			vsbClient, err := util.GetVolumeSnapshotMoverClient()
-			if err != nil {
-				return nil, nil, "", nil, errors.Wrapf(err, "error getting volumesnapshotbackup client")
+		if created {
+			p.Log.Infof("Created volumesnapshotbackup %s", operationID)
+			util.RecordDataMoverCreated("volumesnapshotbackup")
+			util.RecordBackupEvent(backup, corev1api.EventTypeNormal, util.EventReasonDataMoverCreated,
+				fmt.Sprintf("created volumesnapshotbackup %s for volumesnapshotcontent %s", operationID, snapCont.Name), p.Log)
+
+			// placeholder pods only hint the cluster autoscaler, don't fail the backup over them
+			if err := util.CreatePlaceholderPod(vsb.Name, vsb.Spec.ProtectedNamespace, kubeClient.CoreV1(), p.Log); err != nil {
+				p.Log.Warnf("failed to create placeholder pod for volumesnapshotbackup %s: %s", operationID, err.Error())
 			}
+		} else {
+			p.Log.Infof("volumesnapshotbackup %s already exists, reusing it", operationID)
+		}
 
-			err = vsbClient.Create(context.Background(), &vsb)
+		// adding volumesnapshotbackup instance as an item that needs to be updated in backup's finalizing phase with all its annotations and status
+		itemsToUpdate = append(itemsToUpdate, velero.ResourceIdentifier{
+			GroupResource: util.DataMoverGroupResource("volumesnapshotbackups"),
+			Name:          vsb.Name,
+			Namespace:     vsb.Namespace,
+		})
+	}
 
-			if err != nil {
-				return nil, nil, "", nil, errors.Wrapf(err, "error creating volumesnapshotbackup CR")
-			}
+	vscItem, additionalItems, err := p.withResticSecret(&snapCont, resticSecretName, backup.Namespace)
+	if err != nil {
+		return nil, nil, "", nil, err
+	}
 
-			p.Log.Infof("Created volumesnapshotbackup %s", fmt.Sprintf("%s/%s", vsb.Namespace, vsb.Name))
+	p.Log.Infof("Returning from VolumeSnapshotContentBackupItemActionV2 with %d additionalItems and %d itemsToUpdate to backup", len(additionalItems), len(itemsToUpdate))
+	return vscItem, additionalItems, operationID, itemsToUpdate, nil
+}
 
-			// Now fetch the VSB so that we get the Name of the VSB as we use generate name for VSB CR creation
-			err = vsbClient.Get(context.Background(), client.ObjectKey{Namespace: vsb.Namespace, Name: vsb.Name}, &vsb)
-			if err != nil {
-				return nil, nil, "", nil, errors.Wrapf(err, "error fetching volumesnapshotbackup CR for suppyling operationID")
-			}
+// withResticSecret returns the volumesnapshotcontent along with the restic secret as an additional item. The secret
+// lives in the protected namespace, which backups usually don't include, so velero is told to back it up regardless.
+func (p *VolumeSnapshotContentBackupItemActionV2) withResticSecret(snapCont *snapshotv1api.VolumeSnapshotContent, secretName, namespace string) (runtime.Unstructured, []velero.ResourceIdentifier, error) {
+	util.AddAnnotations(&snapCont.ObjectMeta, map[string]string{
+		util.MustIncludeAdditionalItemAnnotation: "true",
+	})
 
-			// operationID for our datamover usecase is VSB NamespacedName which will unique per operation
-			operationID = vsb.Namespace + "/" + vsb.Name
+	snapContMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(snapCont)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
 
-			p.Log.Infof("Got operationID: %s", operationID)
+	additionalItems := []velero.ResourceIdentifier{{
+		GroupResource: kuberesource.Secrets,
+		Name:          secretName,
+		Namespace:     namespace,
+	}}
 
-			// adding volumesnapshotbackup instance as an item that needs to be updated in backup's finalizing phase with all its annotations and status
-			itemsToUpdate = append(itemsToUpdate, velero.ResourceIdentifier{
-				GroupResource: schema.GroupResource{Group: "datamover.oadp.openshift.io", Resource: "volumesnapshotbackups"},
-				Name:          vsb.Name,
-				Namespace:     vsb.Namespace,
-			})
-		}
+	return &unstructured.Unstructured{Object: snapContMap}, additionalItems, nil
+}
+
+// skipDataMovement returns the volumesnapshotcontent annotated with the reason its data is not moved, so skipped
+// volumes can be listed from the backup contents
+func (p *VolumeSnapshotContentBackupItemActionV2) skipDataMovement(snapCont *snapshotv1api.VolumeSnapshotContent, reason string) (runtime.Unstructured, []velero.ResourceIdentifier, string, []velero.ResourceIdentifier, error) {
+	p.Log.Infof("skipping VSB creation for volumesnapshotcontent %s: %s", snapCont.Name, reason)
+	util.SetDataMoverSkippedReason(&snapCont.ObjectMeta, reason)
+
+	snapContMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(snapCont)
+	if err != nil {
+		return nil, nil, "", nil, errors.WithStack(err)
 	}
 
-	p.Log.Infof("Returning from VolumeSnapshotContentBackupItemActionV2 with %d itemsToUpdate to backup", len(itemsToUpdate))
-	return item, nil, operationID, itemsToUpdate, nil
+	return &unstructured.Unstructured{Object: snapContMap}, nil, "", nil, nil
 }
 
 func (p *VolumeSnapshotContentBackupItemActionV2) Progress(operationID string, backup *velerov1api.Backup) (velero.OperationProgress, error) {
+	p = &VolumeSnapshotContentBackupItemActionV2{Log: util.BackupLogger(p.Log, backup).WithField(util.LogFieldOperationID, operationID)}
+
+	_, span := util.StartSpan(context.Background(), p.Name()+".Progress", attribute.String("operationID", operationID))
+
+	// velero polls the progress of every operation, reuse the recent progress instead of reading the VSB again
+	if progress, ok := util.GetCachedProgress("VolumeSnapshotBackup", operationID); ok {
+		span.SetAttributes(attribute.Bool("cached", true))
+		util.EndSpan(span, nil)
+		return progress, nil
+	}
+	defer util.ObserveProgressDuration("volumesnapshotbackup", time.Now())
+
+	progress, err := p.progress(operationID, backup)
+	if err == nil {
+		util.CacheProgress("VolumeSnapshotBackup", operationID, progress)
+		if progress.Completed {
+			util.RecordDataMoverCompleted("volumesnapshotbackup", len(progress.Err) > 0, progress.NCompleted)
+		}
+		if progress.Completed && len(progress.Err) > 0 {
+			util.RecordBackupEvent(backup, corev1api.EventTypeWarning, util.EventReasonDataMoverFailed,
+				fmt.Sprintf("volumesnapshotbackup %s failed: %s", operationID, progress.Err), p.Log)
+		}
+	}
+	util.EndSpan(span, err)
+	return progress, err
+}
+
+// progress reads the VSB of the operation and returns the progress of its data movement
+func (p *VolumeSnapshotContentBackupItemActionV2) progress(operationID string, backup *velerov1api.Backup) (velero.OperationProgress, error) {
 	progress := velero.OperationProgress{}
 
 	// handle empty operationID case
@@ -191,24 +394,58 @@ func (p *VolumeSnapshotContentBackupItemActionV2) Progress(operationID string, b
 	VSBName := splitOperationID[1]
 
 	err = vsbClient.Get(context.Background(), client.ObjectKey{Namespace: VSBNamespace, Name: VSBName}, &vsb)
+	if apierrors.IsNotFound(err) {
+		// the VSB of a volumesnapshotcontent awaiting approval is not created yet
+		if vscName, ok := util.GetApprovalVolumeSnapshotContentName(VSBName); ok {
+			return p.progressAwaitingApproval(VSBNamespace, VSBName, vscName, backup)
+		}
+
+		// otherwise the VSB was removed, don't leave its operation waiting for it
+		progress = util.GetDeletedBackupOperationProgress(operationID, backup)
+		p.Log.Warnf("volumesnapshotbackup %s no longer exists, backup %s is %s: %s", operationID, backup.Name, backup.Status.Phase, progress.Description)
+		return progress, nil
+	}
 	if err != nil {
 		return progress, errors.Wrapf(err, "error fetching volumesnapshotbackup CR for operationID: %s", operationID)
 	}
 
 	// update progress status via VSB phases
-	if vsb.Status.Phase != "" && vsb.Status.BatchingStatus != "" {
-		progressDescriptionPhase := string(vsb.Status.Phase)
-		progressDescriptionBatchingStatus := string(vsb.Status.BatchingStatus)
-		progress.Description = "Phase: " + progressDescriptionPhase + " BatchingStatus: " + progressDescriptionBatchingStatus
-		p.Log.Infof("current progress description is: %s", progress.Description)
+	progress.Description = util.DescribeDataMoverProgress(string(vsb.Status.Phase), string(vsb.Status.BatchingStatus), vsb.CreationTimestamp)
 
-		if vsb.Status.Phase == datamoverv1alpha1.SnapMoverBackupPhaseCompleted {
-			progress.Completed = true
-		}
+	completed, errMsg, known := util.GetVolumeSnapshotBackupPhaseResult(vsb.Status.Phase)
+	if !known {
+		progress.Description = util.DescribeUnknownDataMoverPhase(string(vsb.Status.Phase), vsb.CreationTimestamp)
+	}
+	p.Log.Infof("current progress description is: %s", progress.Description)
 
-		if vsb.Status.Phase == datamoverv1alpha1.SnapMoverBackupPhaseFailed {
-			progress.Err = "VolumeSnapshotBackup has a failed status"
-			progress.Completed = true
+	progress.Completed = completed
+	progress.Err = errMsg
+
+	// give up on the operation once the backup's item operation timeout has passed
+	if _, err := util.ApplyOperationTimeout(&progress, "VolumeSnapshotBackup", vsb.CreationTimestamp, backup.Spec.ItemOperationTimeout, vsb.Status.SourcePVCData.Size); err != nil {
+		return progress, errors.WithStack(err)
+	}
+
+	p.setBytesProgress(&progress, &vsb)
+
+	// tell why the data of a failed VSB was not moved
+	if len(progress.Err) > 0 {
+		p.addMoverFailures(&progress, &vsb)
+	}
+
+	// the mover pod no longer needs the capacity reserved for it
+	// and the mover no longer needs the repository password
+	if progress.Completed {
+		p.deletePlaceholderPods(&vsb)
+		p.deleteVaultResticSecret(&vsb)
+	}
+
+	// the data mover controller creates the replicationsources without the CA bundle of the backup storage location
+	if !progress.Completed {
+		if updated, err := util.ApplyCustomCAToReplicationSources(&vsb); err != nil {
+			p.Log.Warnf("failed to set the custom CA on the replicationsources of volumesnapshotbackup %s: %s", operationID, err.Error())
+		} else if updated > 0 {
+			p.Log.Infof("set the custom CA on %d replicationsource(s) of volumesnapshotbackup %s", updated, operationID)
 		}
 	}
 
@@ -223,7 +460,392 @@ func (p *VolumeSnapshotContentBackupItemActionV2) Progress(operationID string, b
 	return progress, nil
 }
 
-func (p *VolumeSnapshotContentBackupItemActionV2) Cancel(operationID string, backup *velerov1api.Backup) error {
+// setBytesProgress reports the bytes of the volume moved so far. The restic mover only reports them once it is done,
+// until the data mover controller deletes its replicationsource.
+func (p *VolumeSnapshotContentBackupItemActionV2) setBytesProgress(progress *velero.OperationProgress, vsb *datamoverv1alpha1.VolumeSnapshotBackup) {
+	moved := int64(0)
+	if !progress.Completed {
+		rsList, err := util.GetReplicationSourcesForVSB(vsb.Name)
+		if err != nil {
+			p.Log.Warnf("failed to get replicationsource(s) for volumesnapshotbackup %s/%s: %s", vsb.Namespace, vsb.Name, err.Error())
+		}
+		for _, rs := range rsList.Items {
+			if rs.Status == nil {
+				continue
+			}
+			if processed, ok := util.GetMoverProcessedBytes(rs.Status.LatestMoverStatus); ok {
+				moved += processed
+			}
+		}
+	}
+
+	util.SetBytesProgress(progress, vsb.Status.SourcePVCData.Size, moved)
+}
+
+// addMoverFailures adds the logs of the failed mover runs of the replicationsource(s) of the VSB to the error of its
+// operation
+func (p *VolumeSnapshotContentBackupItemActionV2) addMoverFailures(progress *velero.OperationProgress, vsb *datamoverv1alpha1.VolumeSnapshotBackup) {
+	rsList, err := util.GetReplicationSourcesForVSB(vsb.Name)
+	if err != nil {
+		p.Log.Warnf("failed to get replicationsource(s) for volumesnapshotbackup %s/%s: %s", vsb.Namespace, vsb.Name, err.Error())
+		return
+	}
+
+	for _, rs := range rsList.Items {
+		if rs.Status != nil {
+			util.AddMoverFailure(progress, "replicationsource", rs.Namespace+"/"+rs.Name, rs.Status.LatestMoverStatus)
+		}
+	}
+}
+
+// progressAwaitingApproval reports the progress of a VSB that is not created until its volumesnapshotcontent is approved,
+// and creates the VSB once the approval pending marker has been removed from the volumesnapshotcontent
+func (p *VolumeSnapshotContentBackupItemActionV2) progressAwaitingApproval(vsbNamespace, vsbName, vscName string, backup *velerov1api.Backup) (velero.OperationProgress, error) {
+	progress := velero.OperationProgress{Updated: time.Now()}
+
+	kubeClient, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return progress, errors.WithStack(err)
+	}
+
+	snapCont, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), vscName, metav1.GetOptions{})
+	if err != nil {
+		return progress, errors.Wrapf(err, "error fetching volumesnapshotcontent %s awaiting approval", vscName)
+	}
+
+	if util.IsAwaitingApproval(&snapCont.ObjectMeta) {
+		progress.Description = fmt.Sprintf("Phase: AwaitingApproval Elapsed: %s", time.Since(snapCont.CreationTimestamp.Time).Round(time.Second))
+		p.Log.Infof("current progress description is: %s", progress.Description)
+
+		// time spent awaiting approval counts against the backup's item operation timeout
+		if _, err := util.ApplyOperationTimeout(&progress, "VolumeSnapshotBackup approval", snapCont.CreationTimestamp, backup.Spec.ItemOperationTimeout, ""); err != nil {
+			return progress, errors.WithStack(err)
+		}
+		return progress, nil
+	}
+
+	// approved VSBs wait for a free slot like the ones created by Execute, on the next progress check
+	release, acquired, err := util.TryAcquireVolumeSnapshotBackupSlot(context.TODO(), backup)
+	if err != nil {
+		return progress, errors.WithStack(err)
+	}
+	if !acquired {
+		progress.Description = fmt.Sprintf("Phase: WaitingForSlot Elapsed: %s", time.Since(snapCont.CreationTimestamp.Time).Round(time.Second))
+		p.Log.Infof("current progress description is: %s", progress.Description)
+
+		if _, err := util.ApplyOperationTimeout(&progress, "VolumeSnapshotBackup approval", snapCont.CreationTimestamp, backup.Spec.ItemOperationTimeout, ""); err != nil {
+			return progress, errors.WithStack(err)
+		}
+		return progress, nil
+	}
+	defer release()
+
+	pvc, err := util.GetSourcePVCForVolumeSnapshotContent(snapCont, vsbNamespace, snapshotClient.SnapshotV1(), kubeClient.CoreV1())
+	if err != nil {
+		return progress, errors.WithStack(err)
+	}
+
+	backend, err := p.getMoverBackend(backup, pvc)
+	if err != nil {
+		return progress, errors.WithStack(err)
+	}
+
+	resticSecretName, tenantSecret, err := p.getResticSecret(backup, snapCont, vsbNamespace)
+	if err != nil {
+		return progress, errors.WithStack(err)
+	}
+
+	vsbClient, err := util.GetVolumeSnapshotMoverClient()
+	if err != nil {
+		return progress, errors.Wrapf(err, "error getting volumesnapshotbackup client")
+	}
+
+	timeoutOverride, size := getVolumeTimeout(snapCont, pvc)
+
+	vsbSecretName, err := p.getVolumeSnapshotBackupResticSecret(resticSecretName, tenantSecret, vscName, backup.Namespace)
+	if err != nil {
+		return progress, errors.WithStack(err)
+	}
+
+	vsb := util.NewVolumeSnapshotBackup(vsbName, vsbNamespace, vscName, vsbSecretName, backup)
+	setVolumeTimeout(vsb, timeoutOverride, size)
+	setTenantResticSecret(vsb, tenantSecret)
+	setVaultBaseResticSecret(vsb, resticSecretName)
+	if err := p.setCustomCA(vsb, backup); err != nil {
+		return progress, errors.WithStack(err)
+	}
+	setMoverType(vsb, backend)
+	setVolumeMode(vsb, pvc)
+	if err := util.SyncDataMoverCacheConfig(backup.Namespace, kubeClient.CoreV1()); err != nil {
+		return progress, errors.WithStack(err)
+	}
+	err = vsbClient.Create(context.Background(), vsb)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return progress, errors.Wrapf(err, "error creating volumesnapshotbackup CR")
+	}
+	if err == nil {
+		util.RecordDataMoverCreated("volumesnapshotbackup")
+		util.RecordBackupEvent(backup, corev1api.EventTypeNormal, util.EventReasonDataMoverCreated,
+			fmt.Sprintf("created volumesnapshotbackup %s/%s for approved volumesnapshotcontent %s", vsbNamespace, vsbName, vscName), p.Log)
+	}
+	p.Log.Infof("volumesnapshotcontent %s approved, created volumesnapshotbackup %s/%s", vscName, vsbNamespace, vsbName)
+
+	// placeholder pods only hint the cluster autoscaler, don't fail the backup over them
+	if err := util.CreatePlaceholderPod(vsb.Name, vsb.Spec.ProtectedNamespace, kubeClient.CoreV1(), p.Log); err != nil {
+		p.Log.Warnf("failed to create placeholder pod for volumesnapshotbackup %s/%s: %s", vsbNamespace, vsbName, err.Error())
+	}
+
+	progress.Description = util.DescribeDataMoverProgress("", "", metav1.Now())
+	return progress, nil
+}
+
+// getVolumeTimeout returns the timeout annotation of the volumesnapshotcontent, or else of the PVC it was snapshotted
+// from, if any, along with the requested size of that PVC the waits of the volume are scaled by
+func getVolumeTimeout(snapCont *snapshotv1api.VolumeSnapshotContent, pvc *corev1api.PersistentVolumeClaim) (string, string) {
+	if pvc == nil {
+		return util.GetTimeoutOverride(&snapCont.ObjectMeta), ""
+	}
+
+	size := ""
+	if storage, ok := pvc.Spec.Resources.Requests[corev1api.ResourceStorage]; ok {
+		size = storage.String()
+	}
+
+	return util.GetTimeoutOverride(&snapCont.ObjectMeta, &pvc.ObjectMeta), size
+}
+
+// setVolumeTimeout records the timeout override of the volume on its VSB, for the waits on the VSB and, at restore
+// time, on its VSR, along with the size of the volume until the VSB reports it
+func setVolumeTimeout(vsb *datamoverv1alpha1.VolumeSnapshotBackup, override, size string) {
+	if len(override) > 0 {
+		util.AddAnnotations(&vsb.ObjectMeta, map[string]string{util.TimeoutAnnotation: override})
+	}
+	if len(size) > 0 {
+		util.AddAnnotations(&vsb.ObjectMeta, map[string]string{util.VolumeSnapshotMoverSourcePVCSize: size})
+	}
+}
+
+// getResticSecret returns the restic secret the VSB of the volumesnapshotcontent is created with: the copy of the
+// restic secret of the PVC namespace, along with the <namespace>/<name> of that secret, or else the restic secret of
+// the namespace policy or the backup storage location
+func (p *VolumeSnapshotContentBackupItemActionV2) getResticSecret(backup *velerov1api.Backup, snapCont *snapshotv1api.VolumeSnapshotContent, namespace string) (string, string, error) {
+	kubeClient, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return "", "", err
+	}
+
+	pvc, err := util.GetSourcePVCForVolumeSnapshotContent(snapCont, namespace, snapshotClient.SnapshotV1(), kubeClient.CoreV1())
+	if err != nil {
+		return "", "", err
+	}
+
+	if pvc != nil {
+		tenantSecret, err := util.GetTenantResticSecret(pvc, backup.Spec.StorageLocation, kubeClient.CoreV1())
+		if err != nil {
+			return "", "", err
+		}
+
+		if tenantSecret != nil {
+			// tenant secrets keep their own password, even when the one of the backup storage location is in Vault
+			if err := util.ValidateResticSecret(tenantSecret, false); err != nil {
+				return "", "", err
+			}
+			name, err := util.SyncTenantResticSecret(tenantSecret, backup.Namespace, kubeClient.CoreV1())
+			if err != nil {
+				return "", "", err
+			}
+			p.Log.Infof("using restic secret %s/%s of PVC %s/%s, copied to %s/%s", tenantSecret.Namespace, tenantSecret.Name, pvc.Namespace, pvc.Name, backup.Namespace, name)
+			return name, tenantSecret.Namespace + "/" + tenantSecret.Name, nil
+		}
+	}
+
+	name, err := util.GetVolumeSnapshotBackupResticSecretName(backup, namespace, kubeClient.CoreV1(), p.Log)
+	return name, "", err
+}
+
+// setTenantResticSecret records the restic secret of the PVC namespace the VSB is created with, so restores copy it
+// from the namespace the PVC is restored into
+func setTenantResticSecret(vsb *datamoverv1alpha1.VolumeSnapshotBackup, tenantSecret string) {
+	if len(tenantSecret) > 0 {
+		util.AddAnnotations(&vsb.ObjectMeta, map[string]string{util.VolumeSnapshotMoverTenantResticSecret: tenantSecret})
+	}
+}
+
+// getVolumeSnapshotBackupResticSecret returns the secret the VSB is created with: a short-lived copy of the restic
+// secret holding the repository password resolved from Vault if it is configured, or else the restic secret itself.
+// Tenant restic secrets keep their own password.
+func (p *VolumeSnapshotContentBackupItemActionV2) getVolumeSnapshotBackupResticSecret(resticSecretName, tenantSecret, vscName, namespace string) (string, error) {
+	if len(tenantSecret) > 0 || !util.VaultEnabled() {
+		return resticSecretName, nil
+	}
+
+	kubeClient, _, err := util.GetClients()
+	if err != nil {
+		return "", err
+	}
+
+	name, err := util.MaterializeVaultResticSecret(resticSecretName, vscName, namespace, kubeClient.CoreV1())
+	if err != nil {
+		return "", err
+	}
+	p.Log.Infof("resolved the restic password of volumesnapshotcontent %s from vault into secret %s/%s", vscName, namespace, name)
+
+	return name, nil
+}
+
+// setVaultBaseResticSecret records the restic secret the short-lived secret of the VSB was copied from, which is the
+// one backed up and restored with
+func setVaultBaseResticSecret(vsb *datamoverv1alpha1.VolumeSnapshotBackup, resticSecretName string) {
+	if vsb.Spec.ResticSecretRef.Name != resticSecretName {
+		util.AddAnnotations(&vsb.ObjectMeta, map[string]string{util.VolumeSnapshotMoverVaultBaseResticSecret: resticSecretName})
+	}
+}
+
+func (p *VolumeSnapshotContentBackupItemActionV2) deletePlaceholderPods(vsb *datamoverv1alpha1.VolumeSnapshotBackup) {
+	kubeClient, _, err := util.GetClients()
+	if err == nil {
+		err = util.DeletePlaceholderPods(vsb.Name, vsb.Spec.ProtectedNamespace, kubeClient.CoreV1())
+	}
+	if err != nil {
+		p.Log.Warnf("failed to delete placeholder pods for volumesnapshotbackup %s/%s: %s", vsb.Namespace, vsb.Name, err.Error())
+	}
+}
+
+// getMoverBackend returns the mover backend the data of the volumesnapshotcontent is moved with, selected by the backup
+// or else by the storageclass of the PVC it was snapshotted from
+func (p *VolumeSnapshotContentBackupItemActionV2) getMoverBackend(backup *velerov1api.Backup, pvc *corev1api.PersistentVolumeClaim) (util.MoverBackend, error) {
+	storageClass := ""
+	if pvc != nil && pvc.Spec.StorageClassName != nil {
+		storageClass = *pvc.Spec.StorageClassName
+	}
+
+	backend, err := util.GetMoverBackendForBackup(backup, storageClass)
+	if err != nil {
+		return util.MoverBackend{}, err
+	}
+
+	if err := util.CheckVolumeModeSupported(backend, util.GetVolumeMode(pvc)); err != nil {
+		return util.MoverBackend{}, errors.Wrapf(err, "cannot move the data of PVC %s/%s, annotate it %s=true to keep it to its snapshot",
+			pvc.Namespace, pvc.Name, util.SkipAnnotation)
+	}
+	return backend, nil
+}
+
+// setMoverType records on the VSB the mover backend its data is moved with, so its restore moves the data back with
+// the same one
+func setMoverType(vsb *datamoverv1alpha1.VolumeSnapshotBackup, backend util.MoverBackend) {
+	util.AddAnnotations(&vsb.ObjectMeta, map[string]string{util.MoverTypeAnnotation: backend.Name})
+}
+
+// setVolumeMode records on the VSB the volumeMode of the PVC it backs up, if set
+func setVolumeMode(vsb *datamoverv1alpha1.VolumeSnapshotBackup, pvc *corev1api.PersistentVolumeClaim) {
+	if volumeMode := util.GetVolumeMode(pvc); len(volumeMode) > 0 {
+		util.AddAnnotations(&vsb.ObjectMeta, map[string]string{util.VolumeSnapshotMoverSourcePVCVolumeMode: volumeMode})
+	}
+}
+
+// setCustomCA records on the VSB the secret holding the CA bundle of the backup storage location, if it has one
+func (p *VolumeSnapshotContentBackupItemActionV2) setCustomCA(vsb *datamoverv1alpha1.VolumeSnapshotBackup, backup *velerov1api.Backup) error {
+	secretName, err := util.SyncCustomCASecret(backup.Spec.StorageLocation, backup.Namespace)
+	if err != nil {
+		return err
+	}
+
+	if len(secretName) > 0 {
+		util.AddAnnotations(&vsb.ObjectMeta, map[string]string{util.CustomCASecretAnnotation: secretName})
+	}
+	return nil
+}
+
+// deleteVaultResticSecret deletes the short-lived restic secret of the VSB, if it has one
+func (p *VolumeSnapshotContentBackupItemActionV2) deleteVaultResticSecret(vsb *datamoverv1alpha1.VolumeSnapshotBackup) {
+	kubeClient, _, err := util.GetClients()
+	if err == nil {
+		err = util.DeleteVaultResticSecret(vsb.Spec.ResticSecretRef.Name, vsb.Spec.ProtectedNamespace, kubeClient.CoreV1())
+	}
+	if err != nil {
+		p.Log.Warnf("failed to delete the short-lived restic secret of volumesnapshotbackup %s/%s: %s", vsb.Namespace, vsb.Name, err.Error())
+	}
+}
+
+// Cancel deletes the in-flight VolumeSnapshotBackup of the operation along with its replicationsource(s), the snapshot
+// PVC the mover reads from and its placeholder pods, so a canceled backup does not leave mover resources behind
+func (p *VolumeSnapshotContentBackupItemActionV2) Cancel(operationID string, backup *velerov1api.Backup) (err error) {
+	p = &VolumeSnapshotContentBackupItemActionV2{Log: util.BackupLogger(p.Log, backup).WithField(util.LogFieldOperationID, operationID)}
+
+	_, span := util.StartSpan(context.Background(), p.Name()+".Cancel", attribute.String("operationID", operationID))
+	defer func() { util.EndSpan(span, err) }()
+
+	p.Log.Infof("Canceling volumesnapshotbackup operation %s", operationID)
+
+	// handle empty operationID case
+	if operationID == "" {
+		return biav2.InvalidOperationIDError(operationID)
+	}
+
+	splitOperationID := strings.Split(operationID, "/")
+	if len(splitOperationID) != 2 {
+		return biav2.InvalidOperationIDError(operationID)
+	}
+
+	VSBNamespace := splitOperationID[0]
+	VSBName := splitOperationID[1]
+
+	vsbClient, err := util.GetVolumeSnapshotMoverClient()
+	if err != nil {
+		return errors.Wrapf(err, "error getting volumesnapshotbackup client")
+	}
+
+	volsyncClient, err := util.GetVolsyncClient()
+	if err != nil {
+		return errors.Wrapf(err, "error getting volsync client")
+	}
+
+	kubeClient, _, err := util.GetClients()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	vsb := datamoverv1alpha1.VolumeSnapshotBackup{}
+	err = vsbClient.Get(context.Background(), client.ObjectKey{Namespace: VSBNamespace, Name: VSBName}, &vsb)
+	if apierrors.IsNotFound(err) {
+		// the VSB was already removed, or never created for a volumesnapshotcontent awaiting approval
+		p.Log.Infof("volumesnapshotbackup %s not found, nothing to cancel", operationID)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error fetching volumesnapshotbackup CR for operationID: %s", operationID)
+	}
+
+	// stop the mover first by removing the replicationsource(s) of the VSB
+	rsList, err := util.GetReplicationSourcesForVSB(vsb.Name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get replicationsource(s) for volumesnapshotbackup %s", operationID)
+	}
+
+	for _, rs := range rsList.Items {
+		rs := rs
+		if err := volsyncClient.Delete(context.Background(), &rs); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error deleting replicationsource %s/%s", rs.Namespace, rs.Name)
+		}
+		p.Log.Infof("Deleted replicationsource %s/%s for canceled volumesnapshotbackup %s", rs.Namespace, rs.Name, operationID)
+	}
+
+	pvcName := util.GetVolumeSnapshotBackupPVCName(&vsb)
+	err = kubeClient.CoreV1().PersistentVolumeClaims(vsb.Spec.ProtectedNamespace).Delete(context.Background(), pvcName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "error deleting snapshot PVC %s/%s", vsb.Spec.ProtectedNamespace, pvcName)
+	}
+
+	err = vsbClient.Delete(context.Background(), &vsb)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "error deleting volumesnapshotbackup %s", operationID)
+	}
+
+	p.deletePlaceholderPods(&vsb)
+	p.deleteVaultResticSecret(&vsb)
+
+	p.Log.Infof("Canceled volumesnapshotbackup %s: deleted %d replicationsource(s) and snapshot PVC %s/%s", operationID, len(rsList.Items), vsb.Spec.ProtectedNamespace, pvcName)
 	return nil
 }
 
@@ -8,6 +8,8 @@ import (
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	"k8s.io/apimachinery/pkg/runtime"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -23,18 +25,26 @@ func (p *VolumeSnapshotBackupDeleteItemAction) AppliesTo() (velero.ResourceSelec
 	p.Log.Debug("VolumeSnapshotBackupDeleteItemAction AppliesTo")
 
 	return velero.ResourceSelector{
-		IncludedResources: []string{"volumesnapshotbackups.datamover.oadp.openshift.io"},
+		IncludedResources: []string{util.DataMoverResource("volumesnapshotbackups")},
 	}, nil
 }
 
 func (p *VolumeSnapshotBackupDeleteItemAction) Execute(input *velero.DeleteItemActionExecuteInput) error {
+	p = &VolumeSnapshotBackupDeleteItemAction{Log: util.BackupLogger(p.Log, input.Backup)}
+
 	p.Log.Info("Starting VolumeSnapshotBackupDeleteItemAction for volumeSnapshotbackup")
+	ctx, finished, err := util.StartItemAction("VolumeSnapshotBackupDeleteItemAction")
+	if err != nil {
+		return err
+	}
+	defer finished()
 
 	vsb := datamoverv1alpha1.VolumeSnapshotBackup{}
 
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), &vsb); err != nil {
 		return errors.Wrapf(err, "failed to convert input.Item from unstructured")
 	}
+	p.Log = p.Log.WithField(util.LogFieldVolumeSnapshotBackup, util.NamespacedLogField(vsb.Namespace, vsb.Name))
 
 	// We don't want this DeleteItemAction plugin to delete Volumesnapshotbackup taken outside of Velero.
 	// So skip deleting Volumesnapshotbackup objects that were not created in the process of creating
@@ -55,42 +65,135 @@ func (p *VolumeSnapshotBackupDeleteItemAction) Execute(input *velero.DeleteItemA
 		return err
 	}
 
-	err = snapMoverClient.Delete(context.TODO(), &vsb)
+	// fetch the live VSB, the one in the backup carries a stale resourceVersion
+	liveVSB := datamoverv1alpha1.VolumeSnapshotBackup{}
+	err = snapMoverClient.Get(ctx, client.ObjectKey{Namespace: vsb.Namespace, Name: vsb.Name}, &liveVSB)
 	if err != nil && !apierrors.IsNotFound(err) {
-		return err
+		return errors.Wrapf(err, "failed to get volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+
+	if err == nil {
+		// don't pull the VSB out from under a backup whose async operation is still tracking it
+		if liveVSB.Status.Phase == datamoverv1alpha1.SnapMoverBackupPhaseInProgress {
+			backupInProgress, err := util.IsBackupInProgress(input.Backup.Namespace, input.Backup.Name)
+			if err != nil {
+				return err
+			}
+			if backupInProgress {
+				return errors.Errorf("volumesnapshotbackup %s/%s is still in progress for backup %s, retry the deletion once the backup finishes", vsb.Namespace, vsb.Name, input.Backup.Name)
+			}
+		}
+
+		resourceVersion := liveVSB.ResourceVersion
+		err = snapMoverClient.Delete(ctx, &liveVSB, client.Preconditions{ResourceVersion: &resourceVersion})
+		if apierrors.IsConflict(err) {
+			return errors.Wrapf(err, "volumesnapshotbackup %s/%s changed during deletion, retry the deletion", vsb.Namespace, vsb.Name)
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	// the cleanup below goes on when a step fails, velero is told about all the failures at the end
+	errs := []error{}
+
+	// make sure the VSB is gone rather than stuck on a finalizer
+	if err := p.waitForVSBDeletion(ctx, &vsb); err != nil {
+		errs = append(errs, err)
 	}
 
 	// Delete any associated RS(s) for VSB
+	deletedRS := 0
 	rsList, err := util.GetReplicationSourcesForVSB(vsb.Name)
 	if err != nil {
-		return errors.Wrapf(err, "failed to get ReplicationSource(s) relevant to VSB")
+		errs = append(errs, errors.Wrapf(err, "failed to get ReplicationSource(s) relevant to VSB"))
 	}
-
-	if len(rsList.Items) > 0 {
-		for _, rs := range rsList.Items {
-
-			err = volsyncClient.Delete(context.TODO(), &rs)
-			if err != nil && !apierrors.IsNotFound(err) {
-				return err
-			}
+	for i, rs := range rsList.Items {
+		err = volsyncClient.Delete(ctx, &rs)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to delete replicationsource %s/%s", rs.Namespace, rs.Name))
+			continue
 		}
+		deletedRS++
+		p.Log.Infof("Deleted replicationsource %d/%d for volumesnapshotbackup %s/%s", i+1, len(rsList.Items), vsb.Namespace, vsb.Name)
+	}
+
+	// delete the temporary PVCs, snapshots and other resources the data mover moved the data with
+	kubeClient, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return err
+	}
+	deletedResources, err := util.DeleteVolumeSnapshotBackupResources(&vsb, kubeClient.CoreV1(), snapshotClient.SnapshotV1())
+	if err != nil {
+		errs = append(errs, errors.Wrapf(err, "failed to delete the data mover resources of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name))
 	}
 
 	// delete any associated VSR(s)
+	deletedVSR := 0
 	vsrList, err := util.GetVSRsFromBackup(input.Backup.Name, vsb.Name)
 	if err != nil {
-		return errors.Wrapf(err, "failed to get VSRs from relevant Backup")
+		errs = append(errs, errors.Wrapf(err, "failed to get VSRs from relevant Backup"))
 	}
+	for i, vsr := range vsrList.Items {
+		// the restore-time resources first, they are found by the name of the VSR
+		deleted, err := util.DeleteVolumeSnapshotRestoreResources(&vsr, kubeClient.CoreV1())
+		deletedResources += deleted
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to delete the data mover resources of volumesnapshotrestore %s/%s", vsr.Namespace, vsr.Name))
+		}
 
-	if len(vsrList.Items) > 0 {
-		for _, vsr := range vsrList.Items {
-
-			err = snapMoverClient.Delete(context.TODO(), &vsr)
-			if err != nil && !apierrors.IsNotFound(err) {
-				return err
-			}
+		err = snapMoverClient.Delete(ctx, &vsr)
+		if apierrors.IsNotFound(err) {
+			continue
 		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to delete volumesnapshotrestore %s/%s", vsr.Namespace, vsr.Name))
+			continue
+		}
+		deletedVSR++
+		p.Log.Infof("Deleted volumesnapshotrestore %d/%d for volumesnapshotbackup %s/%s", i+1, len(vsrList.Items), vsb.Namespace, vsb.Name)
+	}
+
+	// reclaim the storage of the restic snapshots of the VSB, a failure to do so doesn't fail the backup deletion
+	if util.PruneOnDeleteEnabled() {
+		p.pruneResticRepository(&vsb)
 	}
 
-	return nil
+	// DeleteItemActions run synchronously, velero has no async operation or progress API for deletion,
+	// so cleanup progress is only reported through the plugin logs.
+	p.Log.Infof("Finished deleting volumesnapshotbackup %s/%s: %d replicationsource(s), %d temporary resource(s), %d volumesnapshotrestore(s) removed",
+		vsb.Namespace, vsb.Name, deletedRS, deletedResources, deletedVSR)
+
+	return kerrors.NewAggregate(errs)
+}
+
+// waitForVSBDeletion waits, up to the delete timeout, for the deleted VSB to be removed
+func (p *VolumeSnapshotBackupDeleteItemAction) waitForVSBDeletion(ctx context.Context, vsb *datamoverv1alpha1.VolumeSnapshotBackup) error {
+	timeout, err := util.GetDeleteTimeout()
+	if err != nil {
+		return err
+	}
+
+	return util.WaitForVolumeSnapshotBackupDeletion(ctx, vsb.Namespace, vsb.Name, timeout, p.Log)
+}
+
+// pruneResticRepository starts the job forgetting and pruning the restic snapshots of the VSB
+func (p *VolumeSnapshotBackupDeleteItemAction) pruneResticRepository(vsb *datamoverv1alpha1.VolumeSnapshotBackup) {
+	kubeClient, _, err := util.GetClients()
+	if err != nil {
+		p.Log.Warnf("failed to prune the restic repository of volumesnapshotbackup %s/%s: %s", vsb.Namespace, vsb.Name, err.Error())
+		return
+	}
+
+	jobName, err := util.CreatePruneJob(vsb, kubeClient.BatchV1(), kubeClient.CoreV1())
+	if err != nil {
+		p.Log.Warnf("failed to prune the restic repository of volumesnapshotbackup %s/%s: %s", vsb.Namespace, vsb.Name, err.Error())
+		return
+	}
+	if len(jobName) > 0 {
+		p.Log.Infof("pruning the restic repository of volumesnapshotbackup %s/%s with job %s/%s", vsb.Namespace, vsb.Name, vsb.Spec.ProtectedNamespace, jobName)
+	}
 }
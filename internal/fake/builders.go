@@ -0,0 +1,170 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fake
+
+import (
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+)
+
+// VolumeSnapshotBackupBuilder builds VolumeSnapshotBackup objects.
+type VolumeSnapshotBackupBuilder struct {
+	object *datamoverv1alpha1.VolumeSnapshotBackup
+}
+
+// ForVolumeSnapshotBackup is the constructor for VolumeSnapshotBackupBuilder.
+func ForVolumeSnapshotBackup(ns, name string) *VolumeSnapshotBackupBuilder {
+	return &VolumeSnapshotBackupBuilder{
+		object: &datamoverv1alpha1.VolumeSnapshotBackup{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: datamoverv1alpha1.GroupVersion.String(),
+				Kind:       "VolumeSnapshotBackup",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ns,
+			},
+		},
+	}
+}
+
+// ObjectMeta applies functional options to the VolumeSnapshotBackup's ObjectMeta.
+func (b *VolumeSnapshotBackupBuilder) ObjectMeta(opts ...builder.ObjectMetaOpt) *VolumeSnapshotBackupBuilder {
+	for _, opt := range opts {
+		opt(b.object)
+	}
+
+	return b
+}
+
+// Backup labels the VolumeSnapshotBackup as created by the backup.
+func (b *VolumeSnapshotBackupBuilder) Backup(name string) *VolumeSnapshotBackupBuilder {
+	return b.ObjectMeta(builder.WithLabels(util.BackupNameLabel, name))
+}
+
+// VolumeSnapshotContent sets the VolumeSnapshotBackup's volumesnapshotcontent.
+func (b *VolumeSnapshotBackupBuilder) VolumeSnapshotContent(name string) *VolumeSnapshotBackupBuilder {
+	b.object.Spec.VolumeSnapshotContent = corev1api.ObjectReference{Name: name}
+	b.ObjectMeta(builder.WithLabels(util.VolumeSnapshotBackupVolumeSnapshotContent, name))
+	return b
+}
+
+// ProtectedNamespace sets the VolumeSnapshotBackup's protected namespace and restic secret.
+func (b *VolumeSnapshotBackupBuilder) ProtectedNamespace(ns, resticSecret string) *VolumeSnapshotBackupBuilder {
+	b.object.Spec.ProtectedNamespace = ns
+	b.object.Spec.ResticSecretRef = corev1api.LocalObjectReference{Name: resticSecret}
+	return b
+}
+
+// SourcePVC records the PVC the VolumeSnapshotBackup backs up in its annotations.
+func (b *VolumeSnapshotBackupBuilder) SourcePVC(name, size, storageClass string) *VolumeSnapshotBackupBuilder {
+	return b.ObjectMeta(builder.WithAnnotations(
+		util.VolumeSnapshotMoverSourcePVCName, name,
+		util.VolumeSnapshotMoverSourcePVCSize, size,
+		util.VolumeSnapshotMoverSourcePVCStorageClass, storageClass,
+	))
+}
+
+// Phase sets the VolumeSnapshotBackup's phase.
+func (b *VolumeSnapshotBackupBuilder) Phase(phase datamoverv1alpha1.VolumeSnapshotBackupPhase) *VolumeSnapshotBackupBuilder {
+	b.object.Status.Phase = phase
+	return b
+}
+
+// Result returns the built VolumeSnapshotBackup.
+func (b *VolumeSnapshotBackupBuilder) Result() *datamoverv1alpha1.VolumeSnapshotBackup {
+	return b.object
+}
+
+// VolumeSnapshotRestoreBuilder builds VolumeSnapshotRestore objects.
+type VolumeSnapshotRestoreBuilder struct {
+	object *datamoverv1alpha1.VolumeSnapshotRestore
+}
+
+// ForVolumeSnapshotRestore is the constructor for VolumeSnapshotRestoreBuilder.
+func ForVolumeSnapshotRestore(ns, name string) *VolumeSnapshotRestoreBuilder {
+	return &VolumeSnapshotRestoreBuilder{
+		object: &datamoverv1alpha1.VolumeSnapshotRestore{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: datamoverv1alpha1.GroupVersion.String(),
+				Kind:       "VolumeSnapshotRestore",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: ns,
+			},
+		},
+	}
+}
+
+// ObjectMeta applies functional options to the VolumeSnapshotRestore's ObjectMeta.
+func (b *VolumeSnapshotRestoreBuilder) ObjectMeta(opts ...builder.ObjectMetaOpt) *VolumeSnapshotRestoreBuilder {
+	for _, opt := range opts {
+		opt(b.object)
+	}
+
+	return b
+}
+
+// Restore labels the VolumeSnapshotRestore as created by the restore for the PVC from the VolumeSnapshotBackup.
+func (b *VolumeSnapshotRestoreBuilder) Restore(name, pvcName, vsbName string) *VolumeSnapshotRestoreBuilder {
+	return b.ObjectMeta(builder.WithLabels(
+		util.RestoreNameLabel, name,
+		util.PersistentVolumeClaimLabel, pvcName,
+		util.VolumeSnapshotBackupLabel, vsbName,
+	))
+}
+
+// Phase sets the VolumeSnapshotRestore's phase.
+func (b *VolumeSnapshotRestoreBuilder) Phase(phase datamoverv1alpha1.VolumeSnapshotRestorePhase) *VolumeSnapshotRestoreBuilder {
+	b.object.Status.Phase = phase
+	return b
+}
+
+// SnapshotHandle sets the snapshot handle and volumesnapshotcontent the VolumeSnapshotRestore restored.
+func (b *VolumeSnapshotRestoreBuilder) SnapshotHandle(handle, vscName string) *VolumeSnapshotRestoreBuilder {
+	b.object.Status.SnapshotHandle = handle
+	b.object.Status.VolumeSnapshotContentName = vscName
+	return b
+}
+
+// Result returns the built VolumeSnapshotRestore.
+func (b *VolumeSnapshotRestoreBuilder) Result() *datamoverv1alpha1.VolumeSnapshotRestore {
+	return b.object
+}
+
+// ReadyVolumeSnapshotContent returns a volumesnapshotcontent of the CSI driver that is ready to use with the snapshot
+// handle, bound to the volumesnapshot.
+func ReadyVolumeSnapshotContent(name, driver, handle, vsNamespace, vsName string) *snapshotv1api.VolumeSnapshotContent {
+	vsc := builder.ForVolumeSnapshotContent(name).
+		DeletionPolicy(snapshotv1api.VolumeSnapshotContentRetain).
+		VolumeSnapshotRef(vsNamespace, vsName).
+		Status().
+		Result()
+
+	ready := true
+	vsc.Spec.Driver = driver
+	vsc.Status.SnapshotHandle = &handle
+	vsc.Status.ReadyToUse = &ready
+
+	return vsc
+}
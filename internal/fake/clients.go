@@ -0,0 +1,75 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fake provides fake clients and fixtures for testing the plugin's item actions without a cluster.
+package fake
+
+import (
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+)
+
+// Clients holds the fake clients the plugin is run against
+type Clients struct {
+	Kube     *k8sfake.Clientset
+	Snapshot *snapshotFake.Clientset
+	// CR serves the data mover, VolSync and velero custom resources
+	CR client.WithWatch
+}
+
+// NewClients returns fake clients pre-loaded with the objects and installs them as the plugin's shared clients until
+// the test finishes. Volumesnapshot objects are served by the snapshot client, the built-in kubernetes types by the
+// kube client and all other types by the controller-runtime client.
+func NewClients(t testing.TB, objs ...runtime.Object) *Clients {
+	t.Helper()
+
+	scheme, err := util.NewScheme()
+	if err != nil {
+		t.Fatalf("error building scheme: %v", err)
+	}
+
+	var kubeObjs, snapshotObjs, crObjs []runtime.Object
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *snapshotv1api.VolumeSnapshot, *snapshotv1api.VolumeSnapshotContent, *snapshotv1api.VolumeSnapshotClass:
+			snapshotObjs = append(snapshotObjs, obj)
+		default:
+			if gvks, _, err := clientgoscheme.Scheme.ObjectKinds(obj); err == nil && len(gvks) > 0 {
+				kubeObjs = append(kubeObjs, obj)
+			} else {
+				crObjs = append(crObjs, obj)
+			}
+		}
+	}
+
+	c := &Clients{
+		Kube:     k8sfake.NewSimpleClientset(kubeObjs...),
+		Snapshot: snapshotFake.NewSimpleClientset(snapshotObjs...),
+		CR:       crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(crObjs...).Build(),
+	}
+	t.Cleanup(util.SetClients(c.Kube, c.Snapshot, c.CR))
+
+	return c
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+// PVCRestoreItemAction is a restore item action plugin that restores the PVCs of data mover backups with the name
+// their VSRs are created for and the access modes the restore asks for
+type PVCRestoreItemAction struct {
+	Log logrus.FieldLogger
+}
+
+// AppliesTo returns information indicating that the PVCRestoreItemAction should be invoked to restore persistentvolumeclaims.
+func (p *PVCRestoreItemAction) AppliesTo() (velero.ResourceSelector, error) {
+	return velero.ResourceSelector{
+		IncludedResources: []string{"persistentvolumeclaims"},
+	}, nil
+}
+
+// Execute renames a PVC restored from a volumesnapshot whose name would collide with a PVC of another namespace the
+// restore maps onto the same namespace, see util.GetRestorePVCName, and overrides its access modes as the restore or
+// the access mode mapping asks for, see util.GetRestoreAccessModes, and its size, see util.GetRestorePVCSize
+func (p *PVCRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
+	p = &PVCRestoreItemAction{Log: util.RestoreLogger(p.Log, input.Restore)}
+
+	p.Log.Info("Starting PVCRestoreItemAction")
+
+	pvc := input.Item.(*unstructured.Unstructured).DeepCopy()
+	p.Log = p.Log.WithField(util.LogFieldPVC, util.NamespacedLogField(pvc.GetNamespace(), pvc.GetName()))
+
+	// only PVCs restored from a volumesnapshot have a VSR
+	if _, ok := pvc.GetLabels()[util.VolumeSnapshotLabel]; !ok {
+		return velero.NewRestoreItemActionExecuteOutput(input.Item), nil
+	}
+
+	name := util.GetRestorePVCName(input.Restore, pvc.GetNamespace(), pvc.GetName())
+
+	accessModes, _, err := unstructured.NestedStringSlice(pvc.Object, "spec", "accessModes")
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading the access modes of persistentvolumeclaim %s/%s", pvc.GetNamespace(), pvc.GetName())
+	}
+	restoreAccessModes, err := util.GetRestoreAccessModes(input.Restore, accessModes)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	size, _, err := unstructured.NestedString(pvc.Object, "spec", "resources", "requests", "storage")
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading the size of persistentvolumeclaim %s/%s", pvc.GetNamespace(), pvc.GetName())
+	}
+	restoreSize, err := util.GetRestorePVCSize(input.Restore, pvc.GetNamespace(), pvc.GetName(), size)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	renamed := name != pvc.GetName()
+	accessModesChanged := !reflect.DeepEqual(accessModes, restoreAccessModes)
+	resized := restoreSize != size
+	if (!renamed && !accessModesChanged && !resized) || !util.DataMoverEnabledForRestore(input.Restore, p.Log) {
+		return velero.NewRestoreItemActionExecuteOutput(input.Item), nil
+	}
+
+	if resized {
+		p.Log.Infof("restoring persistentvolumeclaim %s/%s with size %s instead of %s", pvc.GetNamespace(), pvc.GetName(), restoreSize, size)
+		if err := unstructured.SetNestedField(pvc.Object, restoreSize, "spec", "resources", "requests", "storage"); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if accessModesChanged {
+		p.Log.Infof("restoring persistentvolumeclaim %s/%s with access modes %v instead of %v", pvc.GetNamespace(), pvc.GetName(), restoreAccessModes, accessModes)
+		if err := unstructured.SetNestedStringSlice(pvc.Object, restoreAccessModes, "spec", "accessModes"); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if renamed {
+		p.Log.Infof("restoring persistentvolumeclaim %s/%s as %s, other namespaces the restore maps onto %s may have a PVC of the same name",
+			pvc.GetNamespace(), pvc.GetName(), name, util.GetRestoreNamespace(input.Restore, pvc.GetNamespace()))
+		pvc.SetName(name)
+	}
+
+	return velero.NewRestoreItemActionExecuteOutput(pvc), nil
+}
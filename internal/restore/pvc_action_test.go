@@ -0,0 +1,144 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package restore
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/fake"
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
+	"github.com/vmware-tanzu/velero/pkg/builder"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+func TestPVCRestoreItemActionExecute(t *testing.T) {
+	t.Setenv(util.VolumeSnapshotMoverEnv, "true")
+	fake.NewClients(t, builder.ForBackup("velero", "backup-1").Result())
+
+	testCases := []struct {
+		name         string
+		pvcLabels    []string
+		mappings     []string
+		expectedName string
+	}{
+		{
+			name:         "namespaces mapped onto one namespace",
+			pvcLabels:    []string{util.VolumeSnapshotLabel, "velero-data-abcde"},
+			mappings:     []string{"app-a", "shared", "app-b", "shared"},
+			expectedName: "data-app-a",
+		},
+		{
+			name:         "namespace mapped onto its own namespace",
+			pvcLabels:    []string{util.VolumeSnapshotLabel, "velero-data-abcde"},
+			mappings:     []string{"app-a", "app-restored"},
+			expectedName: "data",
+		},
+		{
+			name:         "PVC not restored from a volumesnapshot",
+			mappings:     []string{"app-a", "shared", "app-b", "shared"},
+			expectedName: "data",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pvc := builder.ForPersistentVolumeClaim("app-a", "data").ObjectMeta(builder.WithLabels(tc.pvcLabels...)).Result()
+			pvcMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pvc)
+			assert.NoError(t, err)
+
+			p := &PVCRestoreItemAction{Log: logrus.New()}
+			output, err := p.Execute(&velero.RestoreItemActionExecuteInput{
+				Item:    &unstructured.Unstructured{Object: pvcMap},
+				Restore: builder.ForRestore("velero", "restore-1").Backup("backup-1").NamespaceMappings(tc.mappings...).Result(),
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedName, output.UpdatedItem.(*unstructured.Unstructured).GetName())
+		})
+	}
+}
+
+func TestPVCRestoreItemActionExecuteAccessModes(t *testing.T) {
+	t.Setenv(util.VolumeSnapshotMoverEnv, "true")
+	mapping := builder.ForConfigMap("velero", "access-modes").
+		ObjectMeta(builder.WithLabels(util.PluginConfigLabel, "", util.VSMAccessModeMappingLabel, "RestoreItemAction")).
+		Data("ReadWriteOnce", "ReadWriteMany").Result()
+	fake.NewClients(t, builder.ForBackup("velero", "backup-1").Result(), mapping)
+
+	testCases := []struct {
+		name                string
+		restoreAnnotations  []string
+		pvcLabels           []string
+		accessModes         []corev1api.PersistentVolumeAccessMode
+		expectedAccessModes []interface{}
+		expectError         bool
+	}{
+		{
+			name:                "access modes mapped by the configmap",
+			pvcLabels:           []string{util.VolumeSnapshotLabel, "velero-data-abcde"},
+			accessModes:         []corev1api.PersistentVolumeAccessMode{corev1api.ReadWriteOnce},
+			expectedAccessModes: []interface{}{"ReadWriteMany"},
+		},
+		{
+			name:                "restore annotation takes precedence",
+			restoreAnnotations:  []string{util.RestoreAccessModeAnnotation, "ReadWriteOncePod"},
+			pvcLabels:           []string{util.VolumeSnapshotLabel, "velero-data-abcde"},
+			accessModes:         []corev1api.PersistentVolumeAccessMode{corev1api.ReadWriteOnce},
+			expectedAccessModes: []interface{}{"ReadWriteOncePod"},
+		},
+		{
+			name:               "invalid restore annotation",
+			restoreAnnotations: []string{util.RestoreAccessModeAnnotation, "ReadWriteSometimes"},
+			pvcLabels:          []string{util.VolumeSnapshotLabel, "velero-data-abcde"},
+			accessModes:        []corev1api.PersistentVolumeAccessMode{corev1api.ReadWriteOnce},
+			expectError:        true,
+		},
+		{
+			name:                "PVC not restored from a volumesnapshot",
+			accessModes:         []corev1api.PersistentVolumeAccessMode{corev1api.ReadWriteOnce},
+			expectedAccessModes: []interface{}{"ReadWriteOnce"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pvc := builder.ForPersistentVolumeClaim("app-a", "data").ObjectMeta(builder.WithLabels(tc.pvcLabels...)).Result()
+			pvc.Spec.AccessModes = tc.accessModes
+			pvcMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pvc)
+			assert.NoError(t, err)
+
+			p := &PVCRestoreItemAction{Log: logrus.New()}
+			output, err := p.Execute(&velero.RestoreItemActionExecuteInput{
+				Item:    &unstructured.Unstructured{Object: pvcMap},
+				Restore: builder.ForRestore("velero", "restore-1").Backup("backup-1").ObjectMeta(builder.WithAnnotations(tc.restoreAnnotations...)).Result(),
+			})
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			accessModes, _, err := unstructured.NestedSlice(output.UpdatedItem.(*unstructured.Unstructured).Object, "spec", "accessModes")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedAccessModes, accessModes)
+		})
+	}
+}
@@ -14,17 +14,20 @@ limitations under the License.
 package restore
 
 import (
-	"context"
 	"fmt"
+	"time"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/kuberesource"
 	"github.com/vmware-tanzu/velero/pkg/label"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	core_v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -57,41 +60,88 @@ func resetVolumeSnapshotAnnotation(vs *snapshotv1api.VolumeSnapshot) {
 // Execute uses the data such as CSI driver name, storage snapshot handle, snapshot deletion secret (if any) from the annotations
 // to recreate a volumesnapshotcontent object and statically bind the Volumesnapshot object being restored.
 func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
+	p = &VolumeSnapshotRestoreItemAction{Log: util.RestoreLogger(p.Log, input.Restore)}
+
 	p.Log.Info("Starting VolumeSnapshotRestoreItemAction")
+	ctx, finished, err := util.StartItemAction("VolumeSnapshotRestoreItemAction")
+	if err != nil {
+		return nil, err
+	}
+	defer finished()
+
 	var vs snapshotv1api.VolumeSnapshot
 
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), &vs); err != nil {
 		return &velero.RestoreItemActionExecuteOutput{}, errors.Wrapf(err, "failed to convert input.Item from unstructured")
 	}
+	p.Log = p.Log.WithField(util.LogFieldVolumeSnapshot, util.NamespacedLogField(vs.Namespace, vs.Name))
 
-	_, snapClient, err := util.GetClients()
+	kubeClient, snapClient, err := util.GetClients()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	var snapHandle string
 	var snapName string
-	if util.DataMoverCase() {
+	var retainedVSC *snapshotv1api.VolumeSnapshotContent
+	if util.DataMoverEnabledForRestore(input.Restore, p.Log) {
+		// the source snapshot retained in this cluster, if any, serves the restore without the data mover
+		retainedVSC, err = p.getRetainedVolumeSnapshotContent(input, snapClient.SnapshotV1())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if retainedVSC != nil {
+		snapHandle = *retainedVSC.Status.SnapshotHandle
+		p.Log.Infof("restoring volumesnapshot %s/%s from retained volumesnapshotcontent %s", vs.Namespace, vs.Name, retainedVSC.Name)
+	} else if util.DataMoverEnabledForRestore(input.Restore, p.Log) {
+		// velero maps the namespace of the volumesnapshot once the restore item actions ran, look the VSR up where
+		// it was created, by the name the PVC is restored with
+		vsrNamespace := util.GetRestoreNamespace(input.Restore, vs.Namespace)
+		pvcName := util.GetRestorePVCName(input.Restore, vs.Namespace, *vs.Spec.Source.PersistentVolumeClaimName)
 
-		err := util.WaitForVolumeSnapshotSourceToBeReady(&vs, p.Log)
+		// no VSR restores a PVC which already exists and is kept, neither does the volumesnapshot
+		pvcExists, err := util.RestorePVCExists(vsrNamespace, pvcName, kubeClient.CoreV1())
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		if pvcExists && !util.ReplacesExistingPVCs(input.Restore) {
+			p.Log.Infof("PVC %s/%s already exists, skipping restore of volumesnapshot %s/%s", vsrNamespace, pvcName, vs.Namespace, vs.Name)
+			return &velero.RestoreItemActionExecuteOutput{SkipRestore: true}, nil
+		}
 
-		vsrList, err := util.GetVolumeSnapshotRestoreWithStatusData(input.Restore.Name, *vs.Spec.Source.PersistentVolumeClaimName, p.Log)
+		timeout, err := util.GetWaitTimeout(input.Restore.Spec.ItemOperationTimeout)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 
-		// this will always be unique
-		if len(vsrList.Items) > 0 {
-			snapHandle = vsrList.Items[0].Status.SnapshotHandle
-			snapName = vsrList.Items[0].Status.VolumeSnapshotContentName
+		err = util.WaitForVolumeSnapshotSourceToBeReady(ctx, &vs, timeout, p.Log)
+		if err != nil {
+			if util.IsWaitTimeout(err) {
+				util.RecordRestoreEvent(input.Restore, core_v1.EventTypeWarning, util.EventReasonDataMoverTimedOut,
+					fmt.Sprintf("volumesnapshot %s/%s had no source PVC within %s", vs.Namespace, vs.Name, timeout), p.Log)
+			}
+			return nil, errors.WithStack(err)
+		}
 
-		} else {
-			return nil, errors.Wrapf(err, fmt.Sprintf("volumesnapshotrestore list is empty for PVC %s", *vs.Spec.Source.PersistentVolumeClaimName))
+		// the VSR of the volume carries its timeout override, if any
+		timeout, err = p.getWaitTimeout(input.Restore, vsrNamespace, pvcName)
+		if err != nil {
+			return nil, errors.WithStack(err)
 		}
 
+		vsr, err := util.GetVolumeSnapshotRestoreWithStatusData(ctx, input.Restore, vsrNamespace, pvcName, timeout, p.Log)
+		if err != nil {
+			if util.IsWaitTimeout(err) {
+				util.RecordRestoreEvent(input.Restore, core_v1.EventTypeWarning, util.EventReasonDataMoverTimedOut,
+					fmt.Sprintf("volumesnapshotrestore for PVC %s/%s had no status data within %s", vsrNamespace, pvcName, timeout), p.Log)
+			}
+			return nil, errors.WithStack(err)
+		}
+
+		snapHandle = vsr.Status.SnapshotHandle
+		snapName = vsr.Status.VolumeSnapshotContentName
 	}
 
 	csiDriverName, exists := vs.Annotations[util.CSIDriverNameAnnotation]
@@ -115,7 +165,7 @@ func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActio
 			Driver:         csiDriverName,
 			VolumeSnapshotRef: core_v1.ObjectReference{
 				Kind:      "VolumeSnapshot",
-				Namespace: vs.Namespace,
+				Namespace: util.GetRestoreNamespace(input.Restore, vs.Namespace),
 				Name:      vs.Name,
 			},
 			Source: snapshotv1api.VolumeSnapshotContentSource{
@@ -124,13 +174,40 @@ func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActio
 		},
 	}
 
+	// carry over the snapshot deletion secret so the restored snapshot can later be deleted in this cluster,
+	// and restore the secret alongside it
+	sourceVSC := retainedVSC
+	if len(snapName) > 0 {
+		sourceVSC, err = snapClient.SnapshotV1().VolumeSnapshotContents().Get(ctx, snapName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			sourceVSC, err = nil, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get volumesnapshotcontent %s", snapName)
+		}
+	}
+
+	additionalItems := []velero.ResourceIdentifier{}
+	if secretName, secretNamespace, found := util.GetRestoreVSCDeleteSecret(sourceVSC, &vs); found {
+		util.AddAnnotations(&vsc.ObjectMeta, map[string]string{
+			util.PrefixedSnapshotterSecretNameKey:      secretName,
+			util.PrefixedSnapshotterSecretNamespaceKey: secretNamespace,
+		})
+
+		additionalItems = append(additionalItems, velero.ResourceIdentifier{
+			GroupResource: kuberesource.Secrets,
+			Name:          secretName,
+			Namespace:     secretNamespace,
+		})
+	}
+
 	// we create the volumesnapshotcontent here instead of relying on the restore flow because we want to statically
 	// bind this volumesnapshot with a volumesnapshotcontent that will be used as its source for pre-populating the
 	// volume that will be created as a result of the restore. To perform this static binding, a bi-didrectional link
 	// between the volumesnapshotcontent and volumesnapshot objects have to be setup.
 	// Further, it is disallowed to convert a dynamically created volumesnapshotcontent for static binding.
 	// See: https://github.com/kubernetes-csi/external-snapshotter/issues/274
-	vscupd, err := snapClient.SnapshotV1().VolumeSnapshotContents().Create(context.TODO(), &vsc, metav1.CreateOptions{})
+	vscupd, err := snapClient.SnapshotV1().VolumeSnapshotContents().Create(ctx, &vsc, metav1.CreateOptions{})
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create volumesnapshotcontents %s", vsc.GenerateName)
 	}
@@ -142,10 +219,12 @@ func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActio
 	// Reset VolumeSnapshot annotation. By now, only change DeletionPolicy to Retain.
 	resetVolumeSnapshotAnnotation(&vs)
 
-	// Delete extra volumeSnapshotContent used for snaphandle
-	err = util.DeleteVolumeSnapshotContent(snapName, snapClient.SnapshotV1(), p.Log)
-	if err != nil {
-		return nil, errors.WithStack(err)
+	// Delete extra volumeSnapshotContent used for snaphandle, the retained one is kept until its retention expires
+	if len(snapName) > 0 {
+		err = util.DeleteVolumeSnapshotContent(snapName, snapClient.SnapshotV1(), p.Log)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 	}
 
 	vsMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&vs)
@@ -153,10 +232,43 @@ func (p *VolumeSnapshotRestoreItemAction) Execute(input *velero.RestoreItemActio
 		return nil, errors.WithStack(err)
 	}
 
-	p.Log.Infof("Returning from VolumeSnapshotRestoreItemAction with no additionalItems")
+	p.Log.Infof("Returning from VolumeSnapshotRestoreItemAction with %d additionalItems", len(additionalItems))
 
 	return &velero.RestoreItemActionExecuteOutput{
 		UpdatedItem:     &unstructured.Unstructured{Object: vsMap},
-		AdditionalItems: []velero.ResourceIdentifier{},
+		AdditionalItems: additionalItems,
 	}, nil
 }
+
+// getWaitTimeout returns the timeout of the wait for the VSR of the PVC, honoring the timeout override of the volume
+// and scaled by its backed up size
+func (p *VolumeSnapshotRestoreItemAction) getWaitTimeout(restore *velerov1api.Restore, namespace, pvcName string) (time.Duration, error) {
+	vsrList, err := util.GetVSRsForRestorePVC(restore.Name, namespace, pvcName)
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to get volumesnapshotrestores for PVC %s/%s", namespace, pvcName)
+	}
+
+	override, size := "", ""
+	if len(vsrList.Items) > 0 {
+		override = util.GetTimeoutOverride(&vsrList.Items[0].ObjectMeta)
+		size = vsrList.Items[0].Spec.VolumeSnapshotMoverBackupref.BackedUpPVCData.Size
+	}
+
+	return util.GetItemWaitTimeout(restore.Spec.ItemOperationTimeout, override, size)
+}
+
+// getRetainedVolumeSnapshotContent returns the source volumesnapshotcontent the volumesnapshot was bound to at backup
+// time if the restore can be served from it, see util.GetRetainedVolumeSnapshotContentForRestore
+func (p *VolumeSnapshotRestoreItemAction) getRetainedVolumeSnapshotContent(input *velero.RestoreItemActionExecuteInput, csiClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshotContent, error) {
+	// velero strips the status before running restore item actions, read it from the backed up item
+	var backedUpVS snapshotv1api.VolumeSnapshot
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.ItemFromBackup.UnstructuredContent(), &backedUpVS); err != nil {
+		return nil, errors.Wrapf(err, "failed to convert input.ItemFromBackup from unstructured")
+	}
+
+	if backedUpVS.Status == nil || backedUpVS.Status.BoundVolumeSnapshotContentName == nil {
+		return nil, nil
+	}
+
+	return util.GetRetainedVolumeSnapshotContentForRestore(input.Restore, *backedUpVS.Status.BoundVolumeSnapshotContentName, csiClient)
+}
@@ -2,19 +2,25 @@ package restore
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
 	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	v1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	riav2 "github.com/vmware-tanzu/velero/pkg/plugin/velero/restoreitemaction/v2"
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -33,20 +39,28 @@ func (p *VolumeSnapshotBackupRestoreItemActionV2) AppliesTo() (velero.ResourceSe
 	p.Log.Info("VolumeSnapshotBackupRestoreItemAction AppliesTo")
 
 	return velero.ResourceSelector{
-		IncludedResources: []string{"volumesnapshotbackups.datamover.oadp.openshift.io"},
+		IncludedResources: []string{util.DataMoverResource("volumesnapshotbackups")},
 	}, nil
 }
 
 // Execute backs up a VolumeSnapshotBackup object with a completely filled status
 func (p *VolumeSnapshotBackupRestoreItemActionV2) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
+	p = &VolumeSnapshotBackupRestoreItemActionV2{Log: util.RestoreLogger(p.Log, input.Restore)}
 
 	p.Log.Infof("Executing VolumeSnapshotBackupRestoreItemActionV2")
-	p.Log.Infof("Executing on item: %v", input.Item)
+	ctx, finished, err := util.StartItemAction(p.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer finished()
+
+	util.TraceObject(p.Log, "Executing on item", input.Item)
 	vsb := datamoverv1alpha1.VolumeSnapshotBackup{}
 
 	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), &vsb); err != nil {
 		return &velero.RestoreItemActionExecuteOutput{}, errors.Wrapf(err, "failed to convert VSB input.Item from unstructured")
 	}
+	p.Log = p.Log.WithField(util.LogFieldVolumeSnapshotBackup, util.NamespacedLogField(vsb.Namespace, vsb.Name))
 
 	operationID := ""
 
@@ -55,6 +69,7 @@ func (p *VolumeSnapshotBackupRestoreItemActionV2) Execute(input *velero.RestoreI
 
 	if !isVSBForCurrentBackup {
 		p.Log.Infof("unrelated volumesnapshotbackup found %s, skipping datamover restore for this VSB", vsb.Name)
+		p.recordSkipped(input.Restore, &vsb, fmt.Sprintf("volumesnapshotbackup belongs to backup %s", vsb.Labels[util.BackupNameLabel]))
 		return velero.NewRestoreItemActionExecuteOutput(input.Item).WithoutRestore(), nil
 	}
 
@@ -64,60 +79,236 @@ func (p *VolumeSnapshotBackupRestoreItemActionV2) Execute(input *velero.RestoreI
 		return nil, err
 	}
 
-	if !VSRExists {
+	if VSRExists {
+		p.recordSkipped(input.Restore, &vsb, "a volumesnapshotrestore already exists for the volumesnapshotbackup")
+	}
+
+	// serve the restore from the source snapshot if it was retained in this cluster, skipping the data mover
+	retainedVSC, err := p.getRetainedVolumeSnapshotContent(input.Restore, &vsb)
+	if err != nil {
+		return nil, err
+	}
+	if !VSRExists && retainedVSC != nil {
+		p.Log.Infof("restoring volumesnapshotbackup %s from retained volumesnapshotcontent %s, skipping data movement", vsb.Name, retainedVSC.Name)
+		p.recordSkipped(input.Restore, &vsb, fmt.Sprintf("restored from retained volumesnapshotcontent %s", retainedVSC.Name))
+	}
+
+	if !VSRExists && retainedVSC == nil {
+		if err := util.CheckRestorePreflight(input.Restore, p.Log); err != nil {
+			return nil, err
+		}
+
+		kubeClient, _, err := util.GetClients()
+		if err != nil {
+			return nil, err
+		}
+
+		// the VSR is created in the namespace the restore maps the VSB namespace to, for the PVC restored there
+		vsrNamespace := util.GetRestoreNamespace(input.Restore, vsb.Namespace)
+		pvcName := util.GetRestorePVCName(input.Restore, vsb.Namespace, vsb.Annotations[util.VolumeSnapshotMoverSourcePVCName])
+		// the data mover of this cluster runs in the velero namespace of the restore, which may not be the one of the
+		// backup
+		protectedNS := input.Restore.Namespace
+
+		// velero retries the item of a restore that was interrupted, reuse the VSR created by an earlier execution
+		existingVSR, err := util.GetExistingVSRForVSB(input.Restore.Name, vsrNamespace, pvcName, vsb.Name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing volumesnapshotrestores for PVC %s/%s", vsrNamespace, pvcName)
+		}
+		if existingVSR != nil {
+			operationID = existingVSR.Namespace + "/" + existingVSR.Name
+			p.Log.Infof("volumesnapshotrestore %s already exists for volumesnapshotbackup %s/%s, reusing it", operationID, vsb.Namespace, vsb.Name)
+			return &velero.RestoreItemActionExecuteOutput{
+				SkipRestore: true, OperationID: operationID,
+			}, nil
+		}
+
+		// a PVC which already exists keeps its data, unless the restore updates existing resources
+		pvcExists, err := util.RestorePVCExists(vsrNamespace, pvcName, kubeClient.CoreV1())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if pvcExists && !util.ReplacesExistingPVCs(input.Restore) {
+			p.Log.Infof("PVC %s/%s already exists, skipping datamover restore for volumesnapshotbackup %s/%s", vsrNamespace, pvcName, vsb.Namespace, vsb.Name)
+			p.recordSkipped(input.Restore, &vsb, fmt.Sprintf("PVC %s/%s already exists", vsrNamespace, pvcName))
+			return &velero.RestoreItemActionExecuteOutput{SkipRestore: true}, nil
+		}
+		if pvcExists {
+			p.Log.Infof("replacing existing PVC %s/%s with the data of volumesnapshotbackup %s/%s", vsrNamespace, pvcName, vsb.Namespace, vsb.Name)
+			if err := util.DeleteExistingRestorePVC(vsrNamespace, pvcName, kubeClient.CoreV1()); err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+
+		if err := p.checkRestorePVCCollision(input.Restore, &vsb, vsrNamespace, pvcName); err != nil {
+			return nil, err
+		}
+
+		// restore with the restic secret of the namespace the PVC is restored into if it was backed up with one
+		resticSecretName, err := p.getTenantResticSecret(input.Restore, &vsb, kubeClient.CoreV1())
+		if err != nil {
+			return nil, err
+		}
+
+		// or else with the secret of the namespace policy, if any, or the one the VSB was backed up with, mapped to
+		// the credentials of the target environment
+		tenantSecret := len(resticSecretName) > 0
+		if !tenantSecret {
+			resticSecretName = vsb.Spec.ResticSecretRef.Name
+			if base, ok := vsb.Annotations[util.VolumeSnapshotMoverVaultBaseResticSecret]; ok && len(base) > 0 {
+				resticSecretName = base
+			}
+			policy, err := util.GetNamespacePolicy(vsrNamespace, kubeClient.CoreV1())
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			if policy != nil && len(policy.ResticSecret) > 0 {
+				p.Log.Infof("using restic secret %s of namespace policy %s for volumesnapshotbackup %s/%s", policy.ResticSecret, policy.Name, vsb.Namespace, vsb.Name)
+				resticSecretName = policy.ResticSecret
+			}
+
+			secretMapping, err := util.GetResticSecretMapping()
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			resticSecretName = util.GetRestoreResticSecretName(resticSecretName, secretMapping)
+		}
+
+		// a new cluster may not have the restic secret yet, recreate it before the VSR is reconciled with it
+		secretExists, err := util.EnsureResticSecret(resticSecretName, protectedNS, input.Restore.Name, kubeClient.CoreV1(), p.Log)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if !secretExists {
+			return nil, errors.Errorf("restic secret %s/%s of volumesnapshotbackup %s/%s does not exist, restore it before the volumesnapshotbackups or set %s to recreate it from",
+				protectedNS, resticSecretName, vsb.Namespace, vsb.Name, util.DatamoverResticSourceSecret)
+		}
+
+		if util.VerifyRepositoryEnabled() {
+			if err := p.checkResticRepository(input.Restore, &vsb, resticSecretName, protectedNS, kubeClient.CoreV1()); err != nil {
+				return nil, err
+			}
+		}
+
+		// hand the VSR a short-lived copy of the restic secret holding the password resolved from Vault, if configured
+		if !tenantSecret && util.VaultEnabled() {
+			resticSecretName, err = util.MaterializeVaultResticSecret(resticSecretName, input.Restore.Name+"-"+vsb.Name, protectedNS, kubeClient.CoreV1())
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			p.Log.Infof("resolved the restic password of volumesnapshotbackup %s/%s from vault into secret %s/%s", vsb.Namespace, vsb.Name, protectedNS, resticSecretName)
+		}
+
 		// create VSR per VSB
 		vsr := datamoverv1alpha1.VolumeSnapshotRestore{
 			ObjectMeta: metav1.ObjectMeta{
 				GenerateName: "vsr-",
-				Namespace:    vsb.Namespace,
+				Namespace:    vsrNamespace,
 				Labels: map[string]string{
 					util.RestoreNameLabel:           input.Restore.Name,
+					util.RestoreUIDLabel:            string(input.Restore.UID),
 					util.BackupNameLabel:            vsb.Labels[util.BackupNameLabel],
-					util.PersistentVolumeClaimLabel: vsb.Annotations[util.VolumeSnapshotMoverSourcePVCName],
+					util.PersistentVolumeClaimLabel: label.GetValidName(pvcName),
 					util.VolumeSnapshotBackupLabel:  vsb.Name,
 				},
 			},
 			Spec: datamoverv1alpha1.VolumeSnapshotRestoreSpec{
 				ResticSecretRef: corev1.LocalObjectReference{
-					Name: vsb.Spec.ResticSecretRef.Name,
+					Name: resticSecretName,
 				},
 				VolumeSnapshotMoverBackupref: datamoverv1alpha1.VSBRef{
 					BackedUpPVCData: datamoverv1alpha1.PVCData{
-						Name:             vsb.Annotations[util.VolumeSnapshotMoverSourcePVCName],
+						Name:             pvcName,
 						Size:             vsb.Annotations[util.VolumeSnapshotMoverSourcePVCSize],
 						StorageClassName: vsb.Annotations[util.VolumeSnapshotMoverSourcePVCStorageClass],
 					},
 					ResticRepository:        vsb.Annotations[util.VolumeSnapshotMoverResticRepository],
-					VolumeSnapshotClassName: vsb.Annotations[util.VolumeSnapshotMoverVolumeSnapshotClass],
+					VolumeSnapshotClassName: util.GetRestoreVolumeSnapshotClass(vsb.Annotations[util.VolumeSnapshotMoverVolumeSnapshotClass]),
 				},
-				ProtectedNamespace: vsb.Spec.ProtectedNamespace,
+				ProtectedNamespace: protectedNS,
 			},
 		}
 
-		vsrClient, err := util.GetVolumeSnapshotMoverClient()
+		// restore into a larger volume if the restore asks for one
+		size, err := util.GetRestorePVCSize(input.Restore, vsb.Namespace, vsb.Annotations[util.VolumeSnapshotMoverSourcePVCName], vsb.Annotations[util.VolumeSnapshotMoverSourcePVCSize])
 		if err != nil {
-			return nil, err
+			return nil, errors.WithStack(err)
 		}
+		vsr.Spec.VolumeSnapshotMoverBackupref.BackedUpPVCData.Size = size
 
-		// if namespace mapping is specified
-		if val, ok := input.Restore.Spec.NamespaceMapping[vsr.GetNamespace()]; ok {
-			vsr.SetNamespace(val)
+		// the storageclass of the backed up PVC is changed like velero changes the one of the restored PVC
+		storageClassMapping, err := util.GetChangeStorageClassMapping()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		sourceStorageClass := vsb.Annotations[util.VolumeSnapshotMoverSourcePVCStorageClass]
+		if mapped, ok := storageClassMapping[sourceStorageClass]; ok && len(mapped) > 0 {
+			p.Log.Infof("restoring volumesnapshotbackup %s/%s with storageclass %s instead of %s", vsb.Namespace, vsb.Name, mapped, sourceStorageClass)
+			sourceStorageClass = mapped
 		}
 
-		err = vsrClient.Create(context.Background(), &vsr)
+		// provision the restored volume with a storageclass in the topology requested on the restore, if any, that
+		// formats it with the backed up fsType
+		storageClassName, err := util.GetRestoreStorageClass(input.Restore, sourceStorageClass, vsb.Annotations[util.VolumeSnapshotMoverSourcePVFSType], kubeClient.StorageV1())
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		vsr.Spec.VolumeSnapshotMoverBackupref.BackedUpPVCData.StorageClassName = storageClassName
+
+		// move the data back with the mover backend it was backed up with
+		backend, err := util.GetMoverBackendForVolumeSnapshotBackup(&vsb)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		util.AddAnnotations(&vsr.ObjectMeta, map[string]string{util.MoverTypeAnnotation: backend.Name})
+		if volumeMode := vsb.Annotations[util.VolumeSnapshotMoverSourcePVCVolumeMode]; len(volumeMode) > 0 {
+			util.AddAnnotations(&vsr.ObjectMeta, map[string]string{util.VolumeSnapshotMoverSourcePVCVolumeMode: volumeMode})
+		}
+
+		// let the mover verify the object storage with the CA bundle of the backup storage location
+		if err := p.setCustomCA(&vsr, input.Restore); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// the data mover sizes the restic cache of the mover from its ConfigMap
+		if err := util.SyncDataMoverCacheConfig(protectedNS, kubeClient.CoreV1()); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// carry over the timeout override of the volume for the waits on the VSR
+		if override := util.GetTimeoutOverride(&vsb.ObjectMeta); len(override) > 0 {
+			util.AddAnnotations(&vsr.ObjectMeta, map[string]string{util.TimeoutAnnotation: override})
+		}
+
+		vsrClient, err := util.GetVolumeSnapshotMoverClient()
+		if err != nil {
+			return nil, err
+		}
+
+		err = vsrClient.Create(ctx, &vsr)
 		if err != nil {
 			return nil, errors.Wrapf(err, "error creating volumesnapshotrestore CR")
 		}
 		p.Log.Infof("[vsb-restore] vsr created: %s", vsr.Name)
+		util.RecordDataMoverCreated("volumesnapshotrestore")
+		util.RecordRestoreEvent(input.Restore, corev1.EventTypeNormal, util.EventReasonDataMoverCreated,
+			fmt.Sprintf("created volumesnapshotrestore %s/%s for volumesnapshotbackup %s", vsr.Namespace, vsr.Name, vsb.Name), p.Log)
 
 		// fetch the VSR so we get the name of the VSR as we use generate name for VSR CR creation
-		err = vsrClient.Get(context.Background(), client.ObjectKey{Namespace: vsr.Namespace, Name: vsr.Name}, &vsr)
+		err = vsrClient.Get(ctx, client.ObjectKey{Namespace: vsr.Namespace, Name: vsr.Name}, &vsr)
 		if err != nil {
 			return nil, errors.Wrapf(err, "error fetching volumesnapshotrestore CR for suppyling operationID")
 		}
 
 		// operationID for our datamover usecase is VSR NamespacedName which will unique per operation
 		operationID = vsr.Namespace + "/" + vsr.Name
+		p.Log = p.Log.WithField(util.LogFieldVolumeSnapshotRestore, operationID)
+
+		// placeholder pods only hint the cluster autoscaler, don't fail the restore over them
+		err = util.CreatePlaceholderPod(vsr.Name, vsr.Spec.ProtectedNamespace, kubeClient.CoreV1(), p.Log)
+		if err != nil {
+			p.Log.Warnf("failed to create placeholder pod for volumesnapshotrestore %s: %s", operationID, err.Error())
+		}
 	}
 
 	p.Log.Info("Returning from VolumeSnapshotBackupRestoreItemActionV2")
@@ -128,7 +319,87 @@ func (p *VolumeSnapshotBackupRestoreItemActionV2) Execute(input *velero.RestoreI
 	}, nil
 }
 
+// getTenantResticSecret copies the restic secret the VSB was backed up with from the namespace its PVC is restored
+// into, and returns the name of the copy. It returns an empty name if the VSB was not backed up with the restic secret
+// of its PVC namespace, or that namespace has no such secret (yet).
+func (p *VolumeSnapshotBackupRestoreItemActionV2) getTenantResticSecret(restore *v1.Restore, vsb *datamoverv1alpha1.VolumeSnapshotBackup, secretsGetter corev1client.SecretsGetter) (string, error) {
+	ref, ok := vsb.Annotations[util.VolumeSnapshotMoverTenantResticSecret]
+	if !ok {
+		return "", nil
+	}
+
+	parts := strings.Split(ref, "/")
+	if len(parts) != 2 {
+		return "", errors.Errorf("invalid %s annotation %q on volumesnapshotbackup %s/%s", util.VolumeSnapshotMoverTenantResticSecret, ref, vsb.Namespace, vsb.Name)
+	}
+
+	namespace := util.GetRestoreNamespace(restore, parts[0])
+	secret, err := secretsGetter.Secrets(namespace).Get(context.TODO(), parts[1], metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		p.Log.Infof("restic secret %s/%s of volumesnapshotbackup %s/%s not found, restoring with %s", namespace, parts[1], vsb.Namespace, vsb.Name, vsb.Spec.ResticSecretRef.Name)
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting restic secret %s/%s", namespace, parts[1])
+	}
+
+	name, err := util.SyncTenantResticSecret(secret, restore.Namespace, secretsGetter)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	p.Log.Infof("using restic secret %s/%s for volumesnapshotbackup %s/%s, copied to %s/%s", namespace, parts[1], vsb.Namespace, vsb.Name, restore.Namespace, name)
+	return name, nil
+}
+
+// checkRestorePVCCollision returns an error if another VSB of the restore already restores a PVC with the same name
+// into the namespace, rather than letting its VSR be found for both PVCs
+func (p *VolumeSnapshotBackupRestoreItemActionV2) checkRestorePVCCollision(restore *v1.Restore, vsb *datamoverv1alpha1.VolumeSnapshotBackup, namespace, pvcName string) error {
+	vsrList, err := util.GetVSRsForRestorePVC(restore.Name, namespace, pvcName)
+	if err != nil {
+		return errors.Wrapf(err, "error listing volumesnapshotrestores for PVC %s/%s", namespace, pvcName)
+	}
+
+	for _, vsr := range vsrList.Items {
+		if vsr.Labels[util.VolumeSnapshotBackupLabel] != vsb.Name {
+			return errors.Errorf("PVC %s/%s of volumesnapshotbackup %s/%s collides with the PVC volumesnapshotrestore %s/%s restores for volumesnapshotbackup %s",
+				namespace, pvcName, vsb.Namespace, vsb.Name, vsr.Namespace, vsr.Name, vsr.Labels[util.VolumeSnapshotBackupLabel])
+		}
+	}
+
+	return nil
+}
+
 func (p *VolumeSnapshotBackupRestoreItemActionV2) Progress(operationID string, restore *v1.Restore) (velero.OperationProgress, error) {
+	p = &VolumeSnapshotBackupRestoreItemActionV2{Log: util.RestoreLogger(p.Log, restore).WithField(util.LogFieldOperationID, operationID)}
+
+	_, span := util.StartSpan(context.Background(), p.Name()+".Progress", attribute.String("operationID", operationID))
+
+	// velero polls the progress of every operation, reuse the recent progress instead of reading the VSR again
+	if progress, ok := util.GetCachedProgress("VolumeSnapshotRestore", operationID); ok {
+		span.SetAttributes(attribute.Bool("cached", true))
+		util.EndSpan(span, nil)
+		return progress, nil
+	}
+	defer util.ObserveProgressDuration("volumesnapshotrestore", time.Now())
+
+	progress, err := p.progress(operationID, restore)
+	if err == nil {
+		util.CacheProgress("VolumeSnapshotRestore", operationID, progress)
+		if progress.Completed {
+			util.RecordDataMoverCompleted("volumesnapshotrestore", len(progress.Err) > 0, progress.NCompleted)
+		}
+		if progress.Completed && len(progress.Err) > 0 {
+			util.RecordRestoreEvent(restore, corev1.EventTypeWarning, util.EventReasonDataMoverFailed,
+				fmt.Sprintf("volumesnapshotrestore %s failed: %s", operationID, progress.Err), p.Log)
+		}
+	}
+	util.EndSpan(span, err)
+	return progress, err
+}
+
+// progress reads the VSR of the operation and returns the progress of its data movement
+func (p *VolumeSnapshotBackupRestoreItemActionV2) progress(operationID string, restore *v1.Restore) (velero.OperationProgress, error) {
 	progress := velero.OperationProgress{}
 
 	// handle empty operationID case
@@ -152,26 +423,56 @@ func (p *VolumeSnapshotBackupRestoreItemActionV2) Progress(operationID string, r
 	VSRName := splitOperationID[1]
 
 	err = vsrClient.Get(context.Background(), client.ObjectKey{Namespace: VSRNamespace, Name: VSRName}, &vsr)
+	if apierrors.IsNotFound(err) {
+		// the VSR was removed, don't leave its operation waiting for it
+		progress = util.GetDeletedRestoreOperationProgress(operationID, restore)
+		p.Log.Warnf("volumesnapshotrestore %s no longer exists, restore %s is %s: %s", operationID, restore.Name, restore.Status.Phase, progress.Description)
+		return progress, nil
+	}
 	if err != nil {
 		return progress, errors.Wrapf(err, "error fetching volumesnapshotrestore CR for operationID: %s", operationID)
 	}
 
 	// update progress status via VSR phases
-	if vsr.Status.Phase != "" && vsr.Status.BatchingStatus != "" {
+	progress.Description = util.DescribeDataMoverProgress(string(vsr.Status.Phase), string(vsr.Status.BatchingStatus), vsr.CreationTimestamp)
 
-		progressDescriptionPhase := string(vsr.Status.Phase)
-		progressDescriptionBatchingStatus := string(vsr.Status.BatchingStatus)
-		progress.Description = "Phase: " + progressDescriptionPhase + " BatchingStatus: " + progressDescriptionBatchingStatus
+	completed, errMsg, known := util.GetVolumeSnapshotRestorePhaseResult(vsr.Status.Phase)
+	if !known {
+		progress.Description = util.DescribeUnknownDataMoverPhase(string(vsr.Status.Phase), vsr.CreationTimestamp)
+	}
+	p.Log.Infof("current progress description is: %s", progress.Description)
 
-		p.Log.Infof("current progress description is: %s", progress.Description)
+	progress.Completed = completed
+	progress.Err = errMsg
 
-		if vsr.Status.Phase == datamoverv1alpha1.SnapMoverRestorePhaseCompleted {
-			progress.Completed = true
-		}
+	// give up on the operation once the restore's item operation timeout has passed
+	timeout, err := util.ApplyOperationTimeout(&progress, "VolumeSnapshotRestore", vsr.CreationTimestamp, restore.Spec.ItemOperationTimeout,
+		vsr.Spec.VolumeSnapshotMoverBackupref.BackedUpPVCData.Size)
+	if err != nil {
+		return progress, errors.WithStack(err)
+	}
+
+	// restic doesn't report the bytes it restored, the volume is only reported as moved once the restore completes
+	util.SetBytesProgress(&progress, vsr.Spec.VolumeSnapshotMoverBackupref.BackedUpPVCData.Size, 0)
 
-		if vsr.Status.Phase == datamoverv1alpha1.SnapMoverRestorePhaseFailed {
-			progress.Err = "VolumeSnapshotRestore has a failed status"
-			progress.Completed = true
+	// tell why the data of a failed VSR was not restored
+	if len(progress.Err) > 0 {
+		p.addMoverFailures(&progress, &vsr)
+	}
+
+	// the mover pod no longer needs the capacity reserved for it
+	// and the mover no longer needs the repository password
+	if progress.Completed {
+		p.deletePlaceholderPods(&vsr)
+		p.deleteVaultResticSecret(&vsr)
+	}
+
+	// the data mover controller creates the replicationdestinations without the CA bundle of the backup storage location
+	if !progress.Completed {
+		if updated, err := util.ApplyCustomCAToReplicationDestinations(&vsr); err != nil {
+			p.Log.Warnf("failed to set the custom CA on the replicationdestinations of volumesnapshotrestore %s: %s", operationID, err.Error())
+		} else if updated > 0 {
+			p.Log.Infof("set the custom CA on %d replicationdestination(s) of volumesnapshotrestore %s", updated, operationID)
 		}
 	}
 
@@ -183,11 +484,184 @@ func (p *VolumeSnapshotBackupRestoreItemActionV2) Progress(operationID string, r
 	// mark updated timestamp
 	progress.Updated = time.Now()
 
+	// once this VSR settles, record a per-volume summary on the restore if all of its VSRs are done
+	if progress.Completed {
+		if err := util.UpdateRestoreDataMoverSummary(restore, timeout, p.Log); err != nil {
+			p.Log.Warnf("failed to update datamover summary for restore %s: %s", restore.Name, err.Error())
+		}
+	}
+
 	return progress, nil
 }
 
-// empty func to satisfy riav2 interface
-func (p *VolumeSnapshotBackupRestoreItemActionV2) Cancel(operationID string, restore *v1.Restore) error {
+// addMoverFailures adds the logs of the failed mover runs of the replicationdestination(s) of the VSR to the error of
+// its operation
+func (p *VolumeSnapshotBackupRestoreItemActionV2) addMoverFailures(progress *velero.OperationProgress, vsr *datamoverv1alpha1.VolumeSnapshotRestore) {
+	rdList, err := util.GetReplicationDestinationsForVSR(vsr.Name)
+	if err != nil {
+		p.Log.Warnf("failed to get replicationdestination(s) for volumesnapshotrestore %s/%s: %s", vsr.Namespace, vsr.Name, err.Error())
+		return
+	}
+
+	for _, rd := range rdList.Items {
+		if rd.Status != nil {
+			util.AddMoverFailure(progress, "replicationdestination", rd.Namespace+"/"+rd.Name, rd.Status.LatestMoverStatus)
+		}
+	}
+}
+
+func (p *VolumeSnapshotBackupRestoreItemActionV2) deletePlaceholderPods(vsr *datamoverv1alpha1.VolumeSnapshotRestore) {
+	kubeClient, _, err := util.GetClients()
+	if err == nil {
+		err = util.DeletePlaceholderPods(vsr.Name, vsr.Spec.ProtectedNamespace, kubeClient.CoreV1())
+	}
+	if err != nil {
+		p.Log.Warnf("failed to delete placeholder pods for volumesnapshotrestore %s/%s: %s", vsr.Namespace, vsr.Name, err.Error())
+	}
+}
+
+// setCustomCA records on the VSR the secret holding the CA bundle of the backup storage location of the restored
+// backup, if it has one
+func (p *VolumeSnapshotBackupRestoreItemActionV2) setCustomCA(vsr *datamoverv1alpha1.VolumeSnapshotRestore, restore *v1.Restore) error {
+	bslName, err := util.GetRestoreBackupStorageLocation(restore)
+	if err != nil {
+		return err
+	}
+
+	secretName, err := util.SyncCustomCASecret(bslName, vsr.Spec.ProtectedNamespace)
+	if err != nil {
+		return err
+	}
+
+	if len(secretName) > 0 {
+		util.AddAnnotations(&vsr.ObjectMeta, map[string]string{util.CustomCASecretAnnotation: secretName})
+	}
+	return nil
+}
+
+// checkResticRepository checks that the restic repository of the VSB is reachable with the credentials of the restic
+// secret the VSR is created with, so an unreachable repository fails the restore of the VSB before its mover pod runs
+func (p *VolumeSnapshotBackupRestoreItemActionV2) checkResticRepository(restore *v1.Restore, vsb *datamoverv1alpha1.VolumeSnapshotBackup, resticSecretName, protectedNS string, secretsGetter corev1client.SecretsGetter) error {
+	secret, err := secretsGetter.Secrets(protectedNS).Get(context.TODO(), resticSecretName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error getting restic secret %s/%s", protectedNS, resticSecretName)
+	}
+
+	bslName, err := util.GetRestoreBackupStorageLocation(restore)
+	if err != nil {
+		return err
+	}
+	veleroClient, err := util.GetVeleroClient()
+	if err != nil {
+		return err
+	}
+	caBundle, err := util.GetCustomCABundle(bslName, protectedNS, veleroClient)
+	if err != nil {
+		return err
+	}
+
+	repository := vsb.Annotations[util.VolumeSnapshotMoverResticRepository]
+	if err := util.CheckResticRepository(context.TODO(), repository, secret, caBundle); err != nil {
+		return errors.Wrapf(err, "error checking the restic repository of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+
+	p.Log.Infof("restic repository %s of volumesnapshotbackup %s/%s is reachable", repository, vsb.Namespace, vsb.Name)
+	return nil
+}
+
+// deleteVaultResticSecret deletes the short-lived restic secret of the VSR, if it has one
+func (p *VolumeSnapshotBackupRestoreItemActionV2) deleteVaultResticSecret(vsr *datamoverv1alpha1.VolumeSnapshotRestore) {
+	kubeClient, _, err := util.GetClients()
+	if err == nil {
+		err = util.DeleteVaultResticSecret(vsr.Spec.ResticSecretRef.Name, vsr.Spec.ProtectedNamespace, kubeClient.CoreV1())
+	}
+	if err != nil {
+		p.Log.Warnf("failed to delete the short-lived restic secret of volumesnapshotrestore %s/%s: %s", vsr.Namespace, vsr.Name, err.Error())
+	}
+}
+
+func (p *VolumeSnapshotBackupRestoreItemActionV2) getRetainedVolumeSnapshotContent(restore *v1.Restore, vsb *datamoverv1alpha1.VolumeSnapshotBackup) (*snapshotv1api.VolumeSnapshotContent, error) {
+	_, snapshotClient, err := util.GetClients()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return util.GetRetainedVolumeSnapshotContentForRestore(restore, vsb.Spec.VolumeSnapshotContent.Name, snapshotClient.SnapshotV1())
+}
+
+// recordSkipped lists the VSB as skipped in the restore summary, failing to do so doesn't fail the restore
+func (p *VolumeSnapshotBackupRestoreItemActionV2) recordSkipped(restore *v1.Restore, vsb *datamoverv1alpha1.VolumeSnapshotBackup, reason string) {
+	if err := util.RecordSkippedVolumeSnapshotBackup(restore, vsb, reason); err != nil {
+		p.Log.Warnf("failed to record skipped volumesnapshotbackup %s on restore %s: %s", vsb.Name, restore.Name, err.Error())
+	}
+}
+
+// Cancel deletes the in-flight VolumeSnapshotRestore of the operation along with its replicationdestination(s) and
+// placeholder pods, so a canceled restore does not leave partially restored data and mover pods behind
+func (p *VolumeSnapshotBackupRestoreItemActionV2) Cancel(operationID string, restore *v1.Restore) (err error) {
+	p = &VolumeSnapshotBackupRestoreItemActionV2{Log: util.RestoreLogger(p.Log, restore).WithField(util.LogFieldOperationID, operationID)}
+
+	_, span := util.StartSpan(context.Background(), p.Name()+".Cancel", attribute.String("operationID", operationID))
+	defer func() { util.EndSpan(span, err) }()
+
+	p.Log.Infof("Canceling volumesnapshotrestore operation %s", operationID)
+
+	// handle empty operationID case
+	if operationID == "" {
+		return riav2.InvalidOperationIDError(operationID)
+	}
+
+	splitOperationID := strings.Split(operationID, "/")
+	if len(splitOperationID) != 2 {
+		return riav2.InvalidOperationIDError(operationID)
+	}
+
+	VSRNamespace := splitOperationID[0]
+	VSRName := splitOperationID[1]
+
+	vsrClient, err := util.GetVolumeSnapshotMoverClient()
+	if err != nil {
+		return errors.Wrapf(err, "error getting volumesnapshotrestore client")
+	}
+
+	volsyncClient, err := util.GetVolsyncClient()
+	if err != nil {
+		return errors.Wrapf(err, "error getting volsync client")
+	}
+
+	vsr := datamoverv1alpha1.VolumeSnapshotRestore{}
+	err = vsrClient.Get(context.Background(), client.ObjectKey{Namespace: VSRNamespace, Name: VSRName}, &vsr)
+	if apierrors.IsNotFound(err) {
+		p.Log.Infof("volumesnapshotrestore %s not found, nothing to cancel", operationID)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error fetching volumesnapshotrestore CR for operationID: %s", operationID)
+	}
+
+	// stop the mover first by removing the replicationdestination(s) of the VSR
+	rdList, err := util.GetReplicationDestinationsForVSR(vsr.Name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get replicationdestination(s) for volumesnapshotrestore %s", operationID)
+	}
+
+	for _, rd := range rdList.Items {
+		rd := rd
+		if err := volsyncClient.Delete(context.Background(), &rd); err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error deleting replicationdestination %s/%s", rd.Namespace, rd.Name)
+		}
+		p.Log.Infof("Deleted replicationdestination %s/%s for canceled volumesnapshotrestore %s", rd.Namespace, rd.Name, operationID)
+	}
+
+	err = vsrClient.Delete(context.Background(), &vsr)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "error deleting volumesnapshotrestore %s", operationID)
+	}
+
+	p.deletePlaceholderPods(&vsr)
+	p.deleteVaultResticSecret(&vsr)
+
+	p.Log.Infof("Canceled volumesnapshotrestore %s: deleted %d replicationdestination(s)", operationID, len(rdList.Items))
 	return nil
 }
 
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sync"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+)
+
+// dataMoverGroupVersion caches the group version discovered for the datamover CRDs. Failed discovery requests are not
+// cached so later calls retry them.
+var dataMoverGroupVersion struct {
+	mu         sync.Mutex
+	discovered *schema.GroupVersion
+}
+
+// parseDataMoverGroupVersion parses a DatamoverAPIGroupVersion value, "<group>/<version>"
+func parseDataMoverGroupVersion(val string) (schema.GroupVersion, error) {
+	gv, err := schema.ParseGroupVersion(val)
+	if err != nil {
+		return schema.GroupVersion{}, errors.WithStack(err)
+	}
+	if len(gv.Group) == 0 || len(gv.Version) == 0 {
+		return schema.GroupVersion{}, errors.Errorf("%q is not of the form <group>/<version>", val)
+	}
+	return gv, nil
+}
+
+// getConfiguredDataMoverGroupVersion returns the configured DatamoverAPIGroupVersion, if set and valid
+func getConfiguredDataMoverGroupVersion() (schema.GroupVersion, bool) {
+	val := getStartupSetting(DatamoverAPIGroupVersion)
+	if len(val) == 0 {
+		return schema.GroupVersion{}, false
+	}
+	gv, err := parseDataMoverGroupVersion(val)
+	if err != nil {
+		return schema.GroupVersion{}, false
+	}
+	return gv, true
+}
+
+// GetDataMoverGroupVersion returns the group version the datamover CRDs are served with: the configured
+// DatamoverAPIGroupVersion, else the one discovered on the cluster, else the one of volume-snapshot-mover
+func GetDataMoverGroupVersion() schema.GroupVersion {
+	if gv, ok := getConfiguredDataMoverGroupVersion(); ok {
+		return gv
+	}
+
+	kubeClient, _, err := GetClients()
+	if err != nil {
+		return datamoverv1alpha1.GroupVersion
+	}
+	return resolveDataMoverGroupVersion(kubeClient.Discovery())
+}
+
+// resolveDataMoverGroupVersion returns the configured DatamoverAPIGroupVersion, else the one discovered with disc,
+// else the one of volume-snapshot-mover
+func resolveDataMoverGroupVersion(disc discovery.DiscoveryInterface) schema.GroupVersion {
+	if gv, ok := getConfiguredDataMoverGroupVersion(); ok {
+		return gv
+	}
+
+	dataMoverGroupVersion.mu.Lock()
+	defer dataMoverGroupVersion.mu.Unlock()
+
+	if dataMoverGroupVersion.discovered != nil {
+		return *dataMoverGroupVersion.discovered
+	}
+
+	gv, err := discoverDataMoverGroupVersion(disc)
+	if err != nil {
+		return datamoverv1alpha1.GroupVersion
+	}
+	dataMoverGroupVersion.discovered = &gv
+	return gv
+}
+
+// discoverDataMoverGroupVersion returns the group version serving volumesnapshotbackups and volumesnapshotrestores at
+// the version of the types the plugin is built with. The volume-snapshot-mover group version is returned when it serves
+// them, when no group does, or when several other groups do and DatamoverAPIGroupVersion must pick one.
+func discoverDataMoverGroupVersion(disc discovery.DiscoveryInterface) (schema.GroupVersion, error) {
+	_, resourceLists, err := disc.ServerGroupsAndResources()
+	// groups of unavailable aggregated APIs fail discovery without affecting the others
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return schema.GroupVersion{}, errors.Wrap(err, "failed to discover the server resources")
+	}
+
+	candidates := []schema.GroupVersion{}
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil || gv.Version != datamoverv1alpha1.GroupVersion.Version {
+			continue
+		}
+
+		kinds := map[string]string{}
+		for _, resource := range resourceList.APIResources {
+			kinds[resource.Name] = resource.Kind
+		}
+		if kinds["volumesnapshotbackups"] == "VolumeSnapshotBackup" && kinds["volumesnapshotrestores"] == "VolumeSnapshotRestore" {
+			if gv == datamoverv1alpha1.GroupVersion {
+				return gv, nil
+			}
+			candidates = append(candidates, gv)
+		}
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return datamoverv1alpha1.GroupVersion, nil
+}
+
+// addDataMoverToScheme registers the datamover types with the scheme under the group version
+func addDataMoverToScheme(scheme *runtime.Scheme, gv schema.GroupVersion) {
+	scheme.AddKnownTypes(gv,
+		&datamoverv1alpha1.VolumeSnapshotBackup{},
+		&datamoverv1alpha1.VolumeSnapshotBackupList{},
+		&datamoverv1alpha1.VolumeSnapshotRestore{},
+		&datamoverv1alpha1.VolumeSnapshotRestoreList{},
+	)
+	metav1.AddToGroupVersion(scheme, gv)
+}
+
+// DataMoverGroupResource returns the group resource of a datamover resource, e.g. volumesnapshotbackups
+func DataMoverGroupResource(resource string) schema.GroupResource {
+	return schema.GroupResource{Group: GetDataMoverGroupVersion().Group, Resource: resource}
+}
+
+// DataMoverResource returns the group qualified name of a datamover resource item actions apply to
+func DataMoverResource(resource string) string {
+	return DataMoverGroupResource(resource).String()
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func dataMoverResourceList(groupVersion string) *metav1.APIResourceList {
+	return &metav1.APIResourceList{
+		GroupVersion: groupVersion,
+		APIResources: []metav1.APIResource{
+			{Name: "volumesnapshotbackups", Kind: "VolumeSnapshotBackup", Namespaced: true},
+			{Name: "volumesnapshotrestores", Kind: "VolumeSnapshotRestore", Namespaced: true},
+		},
+	}
+}
+
+func TestParseDataMoverGroupVersion(t *testing.T) {
+	gv, err := parseDataMoverGroupVersion("datamover.example.com/v1alpha1")
+	assert.NoError(t, err)
+	assert.Equal(t, schema.GroupVersion{Group: "datamover.example.com", Version: "v1alpha1"}, gv)
+
+	for _, val := range []string{"datamover.example.com", "v1", "datamover.example.com/v1/extra"} {
+		_, err := parseDataMoverGroupVersion(val)
+		assert.Error(t, err, val)
+	}
+}
+
+func TestDiscoverDataMoverGroupVersion(t *testing.T) {
+	forkGV := schema.GroupVersion{Group: "datamover.example.com", Version: "v1alpha1"}
+
+	testCases := []struct {
+		name      string
+		resources []*metav1.APIResourceList
+		expected  schema.GroupVersion
+	}{
+		{
+			name:     "no datamover CRDs",
+			expected: datamoverv1alpha1.GroupVersion,
+		},
+		{
+			name:      "volume-snapshot-mover group",
+			resources: []*metav1.APIResourceList{dataMoverResourceList(datamoverv1alpha1.GroupVersion.String())},
+			expected:  datamoverv1alpha1.GroupVersion,
+		},
+		{
+			name:      "fork group",
+			resources: []*metav1.APIResourceList{dataMoverResourceList(forkGV.String())},
+			expected:  forkGV,
+		},
+		{
+			name: "volume-snapshot-mover group preferred over a fork group",
+			resources: []*metav1.APIResourceList{
+				dataMoverResourceList(forkGV.String()),
+				dataMoverResourceList(datamoverv1alpha1.GroupVersion.String()),
+			},
+			expected: datamoverv1alpha1.GroupVersion,
+		},
+		{
+			name: "several fork groups",
+			resources: []*metav1.APIResourceList{
+				dataMoverResourceList(forkGV.String()),
+				dataMoverResourceList("datamover.example.org/v1alpha1"),
+			},
+			expected: datamoverv1alpha1.GroupVersion,
+		},
+		{
+			name:      "unsupported version",
+			resources: []*metav1.APIResourceList{dataMoverResourceList("datamover.example.com/v1")},
+			expected:  datamoverv1alpha1.GroupVersion,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kubeClient := fake.NewSimpleClientset()
+			kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = tc.resources
+
+			gv, err := discoverDataMoverGroupVersion(kubeClient.Discovery())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, gv)
+		})
+	}
+}
+
+func TestGetDataMoverGroupVersion(t *testing.T) {
+	defer func(discovered *schema.GroupVersion) {
+		dataMoverGroupVersion.discovered = discovered
+	}(dataMoverGroupVersion.discovered)
+	dataMoverGroupVersion.discovered = nil
+
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		dataMoverResourceList("datamover.example.com/v1alpha1"),
+	}
+	defer SetClients(kubeClient, nil, nil)()
+
+	t.Setenv(DatamoverAPIGroupVersion, "")
+	assert.Equal(t, "datamover.example.com", GetDataMoverGroupVersion().Group)
+	assert.Equal(t, schema.GroupResource{Group: "datamover.example.com", Resource: "volumesnapshotbackups"}, DataMoverGroupResource("volumesnapshotbackups"))
+	assert.Equal(t, "volumesnapshotbackups.datamover.example.com", DataMoverResource("volumesnapshotbackups"))
+
+	// the discovered group version is cached
+	kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = nil
+	assert.Equal(t, "datamover.example.com", GetDataMoverGroupVersion().Group)
+
+	// the configured group version wins over the discovered one
+	t.Setenv(DatamoverAPIGroupVersion, "datamover.example.org/v1alpha1")
+	assert.Equal(t, schema.GroupVersion{Group: "datamover.example.org", Version: "v1alpha1"}, GetDataMoverGroupVersion())
+
+	scheme, err := NewScheme()
+	assert.NoError(t, err)
+	gvks, _, err := scheme.ObjectKinds(&datamoverv1alpha1.VolumeSnapshotBackup{})
+	assert.NoError(t, err)
+	assert.Equal(t, []schema.GroupVersionKind{{Group: "datamover.example.org", Version: "v1alpha1", Kind: "VolumeSnapshotBackup"}}, gvks)
+}
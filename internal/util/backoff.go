@@ -0,0 +1,126 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// pollJitter is the fraction of the interval randomly added to every wait between polls, so the waits of many
+// concurrent item operations drift apart instead of hitting the API server at the same time
+const pollJitter = 0.5
+
+// newPollBackoff returns the backoff between the polls of a wait: starting at the poll interval, doubled after every
+// poll up to the maximum poll interval, and jittered
+func newPollBackoff() wait.Backoff {
+	interval, maxInterval := GetPollInterval(), GetPollMaxInterval()
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+
+	return wait.Backoff{
+		Duration: interval,
+		Factor:   2,
+		Jitter:   pollJitter,
+		Steps:    math.MaxInt32,
+		Cap:      maxInterval,
+	}
+}
+
+// IsWaitTimeout returns whether err reports that a wait timed out
+func IsWaitTimeout(err error) bool {
+	return errors.Is(err, wait.ErrWaitTimeout)
+}
+
+// pollWithBackoff runs condition right away and then after every step of the poll backoff, until it reports done,
+// returns an error, timeout elapses or ctx is canceled. A timeout or canceled ctx is reported as wait.ErrWaitTimeout,
+// like the wait.Poll functions do.
+func pollWithBackoff(ctx context.Context, timeout time.Duration, condition wait.ConditionWithContextFunc) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := newPollBackoff()
+	for {
+		done, err := condition(ctx)
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+
+		timer := time.NewTimer(backoff.Step())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return wait.ErrWaitTimeout
+		case <-timer.C:
+		}
+	}
+}
+
+// isTransientAPIError returns whether err is an API server or network error a retried request may not run into
+func isTransientAPIError(err error) bool {
+	if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+		apierrors.IsServiceUnavailable(err) || apierrors.IsInternalError(err) || apierrors.IsUnexpectedServerError(err) {
+		return true
+	}
+
+	if utilnet.IsConnectionReset(err) || utilnet.IsConnectionRefused(err) || utilnet.IsProbableEOF(err) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// transientErrors counts the consecutive transient API errors of a wait, so a momentary API server hiccup is retried
+// on the next poll instead of failing the wait
+type transientErrors struct {
+	count int
+	max   int
+}
+
+func newTransientErrors() *transientErrors {
+	return &transientErrors{max: GetWaitMaxErrors()}
+}
+
+// retry returns whether the wait polls again after err: err is transient and no more than the configured maximum of
+// consecutive transient errors has been seen
+func (t *transientErrors) retry(err error) bool {
+	if !isTransientAPIError(err) {
+		return false
+	}
+
+	t.count++
+	return t.count <= t.max
+}
+
+// reset is called after every successful API call of the wait
+func (t *transientErrors) reset() {
+	t.count = 0
+}
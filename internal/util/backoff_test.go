@@ -0,0 +1,156 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNewPollBackoff(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+	configMapData, configMapFetchedAt = map[string]string{DatamoverPollInterval: "1s", DatamoverPollMaxInterval: "4s"}, time.Now()
+
+	backoff := newPollBackoff()
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		step := backoff.Step()
+		assert.GreaterOrEqual(t, step, expected)
+		assert.LessOrEqual(t, step, time.Duration(float64(expected)*(1+pollJitter)))
+	}
+
+	// a cap below the interval doesn't shorten it
+	configMapData = map[string]string{DatamoverPollInterval: "10s", DatamoverPollMaxInterval: "1s"}
+	backoff = newPollBackoff()
+	assert.GreaterOrEqual(t, backoff.Step(), 10*time.Second)
+	assert.GreaterOrEqual(t, backoff.Step(), 10*time.Second)
+}
+
+func TestPollWithBackoff(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+	configMapData, configMapFetchedAt = map[string]string{DatamoverPollInterval: "1ms", DatamoverPollMaxInterval: "5ms"}, time.Now()
+
+	polls := 0
+	err := pollWithBackoff(context.Background(), time.Minute, func(ctx context.Context) (bool, error) {
+		polls++
+		return polls == 3, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, polls)
+
+	err = pollWithBackoff(context.Background(), 20*time.Millisecond, func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	assert.Equal(t, wait.ErrWaitTimeout, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = pollWithBackoff(ctx, time.Minute, func(ctx context.Context) (bool, error) {
+		return false, nil
+	})
+	assert.Equal(t, wait.ErrWaitTimeout, err)
+}
+
+func TestIsTransientAPIError(t *testing.T) {
+	resource := schema.GroupResource{Resource: "volumesnapshotrestores"}
+
+	assert.True(t, isTransientAPIError(apierrors.NewServiceUnavailable("down")))
+	assert.True(t, isTransientAPIError(apierrors.NewTooManyRequests("slow down", 1)))
+	assert.True(t, isTransientAPIError(apierrors.NewTimeoutError("timeout", 1)))
+	assert.True(t, isTransientAPIError(apierrors.NewInternalError(errors.New("etcd"))))
+	assert.True(t, isTransientAPIError(errors.New("read tcp: connection reset by peer")))
+
+	assert.False(t, isTransientAPIError(apierrors.NewNotFound(resource, "vsr-1")))
+	assert.False(t, isTransientAPIError(apierrors.NewForbidden(resource, "vsr-1", errors.New("denied"))))
+	assert.False(t, isTransientAPIError(errors.New("no kind is registered")))
+}
+
+func TestTransientErrors(t *testing.T) {
+	transient := &transientErrors{max: 2}
+	unavailable := apierrors.NewServiceUnavailable("down")
+
+	assert.True(t, transient.retry(unavailable))
+	assert.True(t, transient.retry(unavailable))
+	assert.False(t, transient.retry(unavailable))
+
+	transient.reset()
+	assert.True(t, transient.retry(unavailable))
+	assert.False(t, transient.retry(apierrors.NewNotFound(schema.GroupResource{}, "vsr-1")))
+}
+
+func TestGetVolumeSnapshotContentForVolumeSnapshotRetriesTransientErrors(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+	configMapData, configMapFetchedAt = map[string]string{DatamoverPollInterval: "1ms", DatamoverWaitMaxErrors: "2"}, time.Now()
+
+	vscName := "snapcontent-1"
+	handle := "handle-1"
+	vs := &snapshotv1api.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{Name: "vs-1", Namespace: "app"},
+		Status:     &snapshotv1api.VolumeSnapshotStatus{BoundVolumeSnapshotContentName: &vscName},
+	}
+	vsc := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: vscName},
+		Status:     &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle},
+	}
+
+	for _, tc := range []struct {
+		name        string
+		failures    int
+		expectError bool
+	}{
+		{name: "transient errors up to the maximum are retried", failures: 2},
+		{name: "more consecutive transient errors fail the wait", failures: 3, expectError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := snapshotFake.NewSimpleClientset(vs, vsc)
+			failures := tc.failures
+			client.PrependReactor("get", "volumesnapshots", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if failures > 0 {
+					failures--
+					return true, nil, apierrors.NewServiceUnavailable("down")
+				}
+				return false, nil, nil
+			})
+
+			actual, err := GetVolumeSnapshotContentForVolumeSnapshot(context.TODO(), vs, client.SnapshotV1(), logrus.New().WithField("fake", "test"), true)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, vscName, actual.Name)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// dataMoverConfigMapName is the ConfigMap in the velero namespace the data mover controller configures the volumes of
+// the replicationsources and replicationdestinations it creates with
+const dataMoverConfigMapName = "datamover-config"
+
+// CacheSettings describes the restic cache volumes of the mover pods. Empty fields keep the data mover defaults.
+type CacheSettings struct {
+	Capacity     string
+	StorageClass string
+	AccessMode   string
+}
+
+// GetCacheSettings returns the configured restic cache settings
+func GetCacheSettings() (CacheSettings, error) {
+	settings := CacheSettings{
+		Capacity:     getSetting(DatamoverCacheCapacity),
+		StorageClass: getSetting(DatamoverCacheStorageClass),
+		AccessMode:   getSetting(DatamoverCacheAccessMode),
+	}
+
+	if len(settings.Capacity) > 0 {
+		if _, err := resource.ParseQuantity(settings.Capacity); err != nil {
+			return CacheSettings{}, errors.Wrapf(err, "invalid %s value %q", DatamoverCacheCapacity, settings.Capacity)
+		}
+	}
+	if len(settings.StorageClass) > 0 {
+		if errs := validation.IsDNS1123Subdomain(settings.StorageClass); len(errs) > 0 {
+			return CacheSettings{}, errors.Errorf("invalid %s value %q: %s", DatamoverCacheStorageClass, settings.StorageClass, strings.Join(errs, ", "))
+		}
+	}
+	if len(settings.AccessMode) > 0 && !isValidAccessMode(settings.AccessMode) {
+		return CacheSettings{}, errors.Errorf("invalid %s value %q", DatamoverCacheAccessMode, settings.AccessMode)
+	}
+
+	return settings, nil
+}
+
+// configMapData returns the settings keyed by the data mover ConfigMap entries for both the source and destination
+// cache volumes
+func (s CacheSettings) configMapData() map[string]string {
+	data := map[string]string{}
+	for key, val := range map[string]string{
+		"CacheCapacity":         s.Capacity,
+		"CacheStorageClassName": s.StorageClass,
+		"CacheAccessMode":       s.AccessMode,
+	} {
+		if len(val) > 0 {
+			data["Source"+key] = val
+			data["Destination"+key] = val
+		}
+	}
+
+	return data
+}
+
+// isValidAccessMode returns whether the value is a PVC access mode
+func isValidAccessMode(val string) bool {
+	switch corev1api.PersistentVolumeAccessMode(val) {
+	case corev1api.ReadWriteOnce, corev1api.ReadOnlyMany, corev1api.ReadWriteMany, corev1api.ReadWriteOncePod:
+		return true
+	default:
+		return false
+	}
+}
+
+// SyncDataMoverCacheConfig writes the configured restic cache settings to the data mover ConfigMap in the velero
+// namespace, which has no fields for them on the VSB and VSR. Other entries of the ConfigMap are left alone.
+func SyncDataMoverCacheConfig(protectedNS string, configMaps corev1client.ConfigMapsGetter) error {
+	settings, err := GetCacheSettings()
+	if err != nil {
+		return err
+	}
+
+	data := settings.configMapData()
+	if len(data) == 0 {
+		return nil
+	}
+
+	cm, err := configMaps.ConfigMaps(protectedNS).Get(context.TODO(), dataMoverConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1api.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: dataMoverConfigMapName, Namespace: protectedNS},
+			Data:       data,
+		}
+		_, err = configMaps.ConfigMaps(protectedNS).Create(context.TODO(), cm, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return errors.Wrapf(err, "error creating configmap %s/%s", protectedNS, dataMoverConfigMapName)
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error getting configmap %s/%s", protectedNS, dataMoverConfigMapName)
+	}
+
+	changed := false
+	for key, val := range data {
+		if cm.Data[key] != val {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			cm.Data[key] = val
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	if _, err := configMaps.ConfigMaps(protectedNS).Update(context.TODO(), cm, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "error updating configmap %s/%s", protectedNS, dataMoverConfigMapName)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSyncDataMoverCacheConfig(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	testCases := []struct {
+		name        string
+		settings    map[string]string
+		existing    []runtime.Object
+		expected    map[string]string
+		expectError bool
+	}{
+		{
+			name:     "nothing configured",
+			settings: map[string]string{},
+		},
+		{
+			name:     "configmap is created",
+			settings: map[string]string{DatamoverCacheCapacity: "10Gi", DatamoverCacheAccessMode: "ReadWriteOnce"},
+			expected: map[string]string{
+				"SourceCacheCapacity":        "10Gi",
+				"DestinationCacheCapacity":   "10Gi",
+				"SourceCacheAccessMode":      "ReadWriteOnce",
+				"DestinationCacheAccessMode": "ReadWriteOnce",
+			},
+		},
+		{
+			name:     "other entries of an existing configmap are kept",
+			settings: map[string]string{DatamoverCacheStorageClass: "fast"},
+			existing: []runtime.Object{&corev1api.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: dataMoverConfigMapName, Namespace: "openshift-adp"},
+				Data:       map[string]string{"SourceStorageClassName": "gp3", "SourceCacheStorageClassName": "slow"},
+			}},
+			expected: map[string]string{
+				"SourceStorageClassName":           "gp3",
+				"SourceCacheStorageClassName":      "fast",
+				"DestinationCacheStorageClassName": "fast",
+			},
+		},
+		{
+			name:        "invalid capacity",
+			settings:    map[string]string{DatamoverCacheCapacity: "lots"},
+			expectError: true,
+		},
+		{
+			name:        "invalid access mode",
+			settings:    map[string]string{DatamoverCacheAccessMode: "ReadWriteSometimes"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configMapData, configMapFetchedAt = tc.settings, time.Now()
+			kubeClient := fake.NewSimpleClientset(tc.existing...)
+
+			err := SyncDataMoverCacheConfig("openshift-adp", kubeClient.CoreV1())
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			cm, err := kubeClient.CoreV1().ConfigMaps("openshift-adp").Get(context.TODO(), dataMoverConfigMapName, metav1.GetOptions{})
+			if tc.expected == nil {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, cm.Data)
+		})
+	}
+}
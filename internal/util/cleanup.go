@@ -0,0 +1,315 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+// GetReplicationSourceCachePVCName returns the name of the restic cache PVC VolSync provisions for the
+// replicationsource the data mover creates for the named VSB
+func GetReplicationSourceCachePVCName(vsbName string) string {
+	return "volsync-" + vsbName + "-rep-src-cache"
+}
+
+// GetReplicationDestinationPVCNames returns the names of the restic cache and destination PVCs VolSync provisions for
+// the replicationdestination the data mover creates for the named VSR
+func GetReplicationDestinationPVCNames(vsrName string) []string {
+	return []string{"volsync-" + vsrName + "-rep-dest-cache", "volsync-" + vsrName + "-rep-dest-dest"}
+}
+
+// DeleteVolumeSnapshotBackupResources deletes the temporary resources the data mover created in the protected
+// namespace to move the data of the VSB, which it only cleans up itself while the VSB still exists: the PVCs, including
+// the restic cache PVC of its replicationsource, pods, secrets and volumesnapshots labeled with the VSB, and the
+// cloned volumesnapshotcontents. It returns how many were deleted. A clone shares the snapshot of its source
+// volumesnapshotcontent, which is kept if the source still exists. A failure to delete one resource doesn't stop the
+// others from being deleted.
+func DeleteVolumeSnapshotBackupResources(vsb *datamoverv1alpha1.VolumeSnapshotBackup, corev1 corev1client.CoreV1Interface, snapshotClient snapshotter.SnapshotV1Interface) (int, error) {
+	namespace := vsb.Spec.ProtectedNamespace
+	selector := labels.SelectorFromSet(map[string]string{VSBLabel: vsb.Name}).String()
+	listOptions := metav1.ListOptions{LabelSelector: selector}
+
+	deleted := 0
+	errs := []error{}
+	deleteOne := func(kind, name string, err error) {
+		if apierrors.IsNotFound(err) {
+			return
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to delete %s %s of volumesnapshotbackup %s/%s", kind, name, vsb.Namespace, vsb.Name))
+			return
+		}
+		deleted++
+	}
+
+	pvcList, err := corev1.PersistentVolumeClaims(namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return deleted, errors.Wrapf(err, "failed to list PVCs of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+	pvcNames := []string{GetReplicationSourceCachePVCName(vsb.Name)}
+	for _, pvc := range pvcList.Items {
+		pvcNames = append(pvcNames, pvc.Name)
+	}
+	for _, name := range pvcNames {
+		deleteOne("PVC", name, corev1.PersistentVolumeClaims(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{}))
+	}
+
+	podList, err := corev1.Pods(namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return deleted, errors.Wrapf(err, "failed to list pods of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+	for _, pod := range podList.Items {
+		deleteOne("pod", pod.Name, corev1.Pods(namespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{}))
+	}
+
+	secretList, err := corev1.Secrets(namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return deleted, errors.Wrapf(err, "failed to list secrets of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+	for _, secret := range secretList.Items {
+		deleteOne("secret", secret.Name, corev1.Secrets(namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{}))
+	}
+
+	vsList, err := snapshotClient.VolumeSnapshots(namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return deleted, errors.Wrapf(err, "failed to list volumesnapshots of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+	for _, vs := range vsList.Items {
+		deleteOne("volumesnapshot", vs.Name, snapshotClient.VolumeSnapshots(namespace).Delete(context.TODO(), vs.Name, metav1.DeleteOptions{}))
+	}
+
+	vscList, err := snapshotClient.VolumeSnapshotContents().List(context.TODO(), listOptions)
+	if err != nil {
+		return deleted, errors.Wrapf(err, "failed to list volumesnapshotcontents of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+	if len(vscList.Items) > 0 {
+		sourceExists := false
+		if sourceName := vsb.Spec.VolumeSnapshotContent.Name; len(sourceName) > 0 {
+			_, err := snapshotClient.VolumeSnapshotContents().Get(context.TODO(), sourceName, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return deleted, errors.Wrapf(err, "failed to get volumesnapshotcontent %s", sourceName)
+			}
+			sourceExists = err == nil
+		}
+
+		for _, vsc := range vscList.Items {
+			if sourceExists {
+				pb := []byte(`{"spec":{"deletionPolicy":"Retain"}}`)
+				if _, err := snapshotClient.VolumeSnapshotContents().Patch(context.TODO(), vsc.Name, types.MergePatchType, pb, metav1.PatchOptions{}); err != nil {
+					deleteOne("volumesnapshotcontent", vsc.Name, err)
+					continue
+				}
+			}
+			deleteOne("volumesnapshotcontent", vsc.Name, snapshotClient.VolumeSnapshotContents().Delete(context.TODO(), vsc.Name, metav1.DeleteOptions{}))
+		}
+	}
+
+	return deleted, kerrors.NewAggregate(errs)
+}
+
+// DeleteVolumeSnapshotRestoreResources deletes the resources the data mover and the plugin created in the protected
+// namespace to restore the data of the VSR: its replicationdestinations and their PVCs, the secrets labeled with the
+// VSR, its short-lived restic secret and its placeholder pods. It returns how many were deleted. The snapshot the data
+// was restored to is left alone, the restored PVC may still be provisioned from it. A failure to delete one resource
+// doesn't stop the others from being deleted.
+func DeleteVolumeSnapshotRestoreResources(vsr *datamoverv1alpha1.VolumeSnapshotRestore, corev1 corev1client.CoreV1Interface) (int, error) {
+	namespace := vsr.Spec.ProtectedNamespace
+	deleted := 0
+	errs := []error{}
+
+	volsyncClient, err := GetVolsyncClient()
+	if err != nil {
+		return deleted, err
+	}
+	rdList, err := GetReplicationDestinationsForVSR(vsr.Name)
+	if err != nil {
+		return deleted, errors.Wrapf(err, "failed to list replicationdestinations of volumesnapshotrestore %s/%s", vsr.Namespace, vsr.Name)
+	}
+	for i := range rdList.Items {
+		err := volsyncClient.Delete(context.TODO(), &rdList.Items[i])
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete replicationdestination %s/%s", rdList.Items[i].Namespace, rdList.Items[i].Name))
+			continue
+		}
+		if err == nil {
+			deleted++
+		}
+	}
+
+	for _, name := range GetReplicationDestinationPVCNames(vsr.Name) {
+		err := corev1.PersistentVolumeClaims(namespace).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete PVC %s/%s", namespace, name))
+			continue
+		}
+		if err == nil {
+			deleted++
+		}
+	}
+
+	secretList, err := corev1.Secrets(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{VSRLabel: vsr.Name}).String(),
+	})
+	if err != nil {
+		return deleted, errors.Wrapf(err, "failed to list secrets of volumesnapshotrestore %s/%s", vsr.Namespace, vsr.Name)
+	}
+	for _, secret := range secretList.Items {
+		err := corev1.Secrets(namespace).Delete(context.TODO(), secret.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete secret %s/%s", namespace, secret.Name))
+			continue
+		}
+		if err == nil {
+			deleted++
+		}
+	}
+
+	if err := DeleteVaultResticSecret(vsr.Spec.ResticSecretRef.Name, namespace, corev1); err != nil {
+		errs = append(errs, err)
+	}
+	if err := DeletePlaceholderPods(vsr.Name, namespace, corev1); err != nil {
+		errs = append(errs, err)
+	}
+
+	return deleted, kerrors.NewAggregate(errs)
+}
+
+// DeleteOrphanedDataMoverResources deletes the VSBs and VSRs labeled with a velero backup or restore which no longer
+// exists in the velero namespace, along with the resources the data mover moved their data with. Backups and restores
+// are matched by the valid label value of their name, as velero labels them. A failure to delete one VSB or VSR
+// doesn't stop the others from being deleted.
+func DeleteOrphanedDataMoverResources(veleroNamespace string, log logrus.FieldLogger) error {
+	kubeClient, snapshotClient, crClient, err := clients.get()
+	if err != nil {
+		return err
+	}
+
+	backupList := velerov1api.BackupList{}
+	if err := crClient.List(context.TODO(), &backupList, client.InNamespace(veleroNamespace)); err != nil {
+		return errors.Wrap(err, "failed to list backups")
+	}
+	backups, backupUIDs := map[string]bool{}, map[string]bool{}
+	for _, backup := range backupList.Items {
+		backups[label.GetValidName(backup.Name)] = true
+		backupUIDs[string(backup.UID)] = true
+	}
+
+	restoreList := velerov1api.RestoreList{}
+	if err := crClient.List(context.TODO(), &restoreList, client.InNamespace(veleroNamespace)); err != nil {
+		return errors.Wrap(err, "failed to list restores")
+	}
+	restores, restoreUIDs := map[string]bool{}, map[string]bool{}
+	for _, restore := range restoreList.Items {
+		restores[label.GetValidName(restore.Name)] = true
+		restoreUIDs[string(restore.UID)] = true
+	}
+
+	errs := []error{}
+
+	vsbList := datamoverv1alpha1.VolumeSnapshotBackupList{}
+	if err := crClient.List(context.TODO(), &vsbList, client.HasLabels{BackupNameLabel}); err != nil {
+		return errors.Wrap(err, "failed to list volumesnapshotbackups")
+	}
+	for i := range vsbList.Items {
+		vsb := &vsbList.Items[i]
+		if vsb.Spec.ProtectedNamespace != veleroNamespace || ownerExists(vsb.Labels, BackupNameLabel, BackupUIDLabel, backups, backupUIDs) {
+			continue
+		}
+
+		log.Infof("backup %s of volumesnapshotbackup %s/%s no longer exists, deleting it", vsb.Labels[BackupNameLabel], vsb.Namespace, vsb.Name)
+		rsList, err := GetReplicationSourcesForVSB(vsb.Name)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to list replicationsources of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name))
+			continue
+		}
+		for j := range rsList.Items {
+			if err := crClient.Delete(context.TODO(), &rsList.Items[j]); err != nil && !apierrors.IsNotFound(err) {
+				errs = append(errs, errors.Wrapf(err, "failed to delete replicationsource %s/%s", rsList.Items[j].Namespace, rsList.Items[j].Name))
+			}
+		}
+		if _, err := DeleteVolumeSnapshotBackupResources(vsb, kubeClient.CoreV1(), snapshotClient.SnapshotV1()); err != nil {
+			errs = append(errs, err)
+		}
+		if err := crClient.Delete(context.TODO(), vsb); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name))
+		}
+	}
+
+	vsrList := datamoverv1alpha1.VolumeSnapshotRestoreList{}
+	if err := crClient.List(context.TODO(), &vsrList, client.HasLabels{RestoreNameLabel}); err != nil {
+		return errors.Wrap(err, "failed to list volumesnapshotrestores")
+	}
+	for i := range vsrList.Items {
+		vsr := &vsrList.Items[i]
+		if vsr.Spec.ProtectedNamespace != veleroNamespace || ownerExists(vsr.Labels, RestoreNameLabel, RestoreUIDLabel, restores, restoreUIDs) {
+			continue
+		}
+
+		log.Infof("restore %s of volumesnapshotrestore %s/%s no longer exists, deleting it", vsr.Labels[RestoreNameLabel], vsr.Namespace, vsr.Name)
+		if _, err := DeleteVolumeSnapshotRestoreResources(vsr, kubeClient.CoreV1()); err != nil {
+			errs = append(errs, err)
+		}
+		if err := crClient.Delete(context.TODO(), vsr); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, errors.Wrapf(err, "failed to delete volumesnapshotrestore %s/%s", vsr.Namespace, vsr.Name))
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+// ownerExists returns whether the backup or restore a VSB or VSR was created for still exists. It is matched on its
+// UID, so a backup or restore recreated with the same name doesn't keep the resources of the one before it alive, and
+// on its name for resources created before the UID was recorded.
+func ownerExists(labels map[string]string, nameLabel, uidLabel string, names, uids map[string]bool) bool {
+	if uid, ok := labels[uidLabel]; ok && len(uid) > 0 {
+		return uids[uid]
+	}
+	return names[labels[nameLabel]]
+}
+
+var deleteOrphansOnce sync.Once
+
+// DeleteOrphanedDataMoverResourcesOnce deletes orphaned VSBs and VSRs once per plugin process when DatamoverDeleteOrphans
+// is set. Velero starts a plugin process per backup, so this runs at the start of every backup.
+func DeleteOrphanedDataMoverResourcesOnce(veleroNamespace string, log logrus.FieldLogger) {
+	deleteOrphansOnce.Do(func() {
+		if enabled, _ := strconv.ParseBool(getSetting(DatamoverDeleteOrphans)); !enabled {
+			return
+		}
+
+		if err := DeleteOrphanedDataMoverResources(veleroNamespace, log); err != nil {
+			log.Warnf("failed to delete orphaned volumesnapshotbackups and volumesnapshotrestores: %s", err.Error())
+		}
+	})
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	volsyncv1alpha1 "github.com/backube/volsync/api/v1alpha1"
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+func TestDeleteVolumeSnapshotBackupResources(t *testing.T) {
+	vsbLabels := map[string]string{VSBLabel: "vsb-1"}
+	kubeClient := fake.NewSimpleClientset(
+		&corev1api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-1-pvc", Namespace: "openshift-adp", Labels: vsbLabels}},
+		&corev1api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "volsync-vsb-1-rep-src-cache", Namespace: "openshift-adp"}},
+		&corev1api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-2-pvc", Namespace: "openshift-adp", Labels: map[string]string{VSBLabel: "vsb-2"}}},
+		&corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "vsb-1-pod", Namespace: "openshift-adp", Labels: vsbLabels}},
+		&corev1api.Secret{ObjectMeta: metav1.ObjectMeta{Name: "vsb-1-secret", Namespace: "openshift-adp", Labels: vsbLabels}},
+	)
+	snapshotClient := snapshotFake.NewSimpleClientset(
+		&snapshotv1api.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-1-clone-volumesnapshot", Namespace: "openshift-adp", Labels: vsbLabels}},
+		&snapshotv1api.VolumeSnapshotContent{ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-1"}},
+		&snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-1-clone", Labels: vsbLabels},
+			Spec:       snapshotv1api.VolumeSnapshotContentSpec{DeletionPolicy: snapshotv1api.VolumeSnapshotContentDelete},
+		},
+	)
+
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsb-1", Namespace: "app"},
+		Spec: datamoverv1alpha1.VolumeSnapshotBackupSpec{
+			ProtectedNamespace:    "openshift-adp",
+			VolumeSnapshotContent: corev1api.ObjectReference{Name: "snapcontent-1"},
+		},
+	}
+
+	deleted, err := DeleteVolumeSnapshotBackupResources(vsb, kubeClient.CoreV1(), snapshotClient.SnapshotV1())
+	assert.NoError(t, err)
+	assert.Equal(t, 6, deleted)
+
+	pvcs, err := kubeClient.CoreV1().PersistentVolumeClaims("openshift-adp").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, pvcs.Items, 1)
+	assert.Equal(t, "snapcontent-2-pvc", pvcs.Items[0].Name)
+
+	// the source volumesnapshotcontent is kept
+	vscs, err := snapshotClient.SnapshotV1().VolumeSnapshotContents().List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, vscs.Items, 1)
+	assert.Equal(t, "snapcontent-1", vscs.Items[0].Name)
+
+	// deleting again finds nothing left
+	deleted, err = DeleteVolumeSnapshotBackupResources(vsb, kubeClient.CoreV1(), snapshotClient.SnapshotV1())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deleted)
+}
+
+func TestWaitForVolumeSnapshotBackupDeletion(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{DatamoverPollInterval: "10ms"}
+	configMapFetchedAt = time.Now()
+
+	scheme, err := NewScheme()
+	assert.NoError(t, err)
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{ObjectMeta: metav1.ObjectMeta{Name: "vsb-1", Namespace: "app"}}
+	crClient := crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(vsb).Build()
+	defer SetClients(fake.NewSimpleClientset(), snapshotFake.NewSimpleClientset(), crClient)()
+	log := logrus.New().WithField("fake", "test")
+
+	assert.NoError(t, WaitForVolumeSnapshotBackupDeletion(context.TODO(), "app", "vsb-2", 50*time.Millisecond, log))
+
+	err = WaitForVolumeSnapshotBackupDeletion(context.TODO(), "app", "vsb-1", 50*time.Millisecond, log)
+	assert.EqualError(t, err, "volumesnapshotbackup app/vsb-1 was not removed within 50ms")
+}
+
+func TestDeleteVolumeSnapshotRestoreResources(t *testing.T) {
+	scheme, err := NewScheme()
+	assert.NoError(t, err)
+	crClient := crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		&volsyncv1alpha1.ReplicationDestination{ObjectMeta: metav1.ObjectMeta{Name: "vsr-1-rep-dest", Namespace: "openshift-adp", Labels: map[string]string{VSRLabel: "vsr-1"}}},
+	).Build()
+	kubeClient := fake.NewSimpleClientset(
+		&corev1api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "volsync-vsr-1-rep-dest-cache", Namespace: "openshift-adp"}},
+		&corev1api.Secret{ObjectMeta: metav1.ObjectMeta{Name: "vsr-1-secret", Namespace: "openshift-adp", Labels: map[string]string{VSRLabel: "vsr-1"}}},
+		&corev1api.Secret{ObjectMeta: metav1.ObjectMeta{Name: "restore-1-vsb-1-vault-restic", Namespace: "openshift-adp", Labels: map[string]string{VaultResticSecretLabel: "true"}}},
+		&corev1api.Secret{ObjectMeta: metav1.ObjectMeta{Name: "dpa-1-volsync-restic", Namespace: "openshift-adp"}},
+		&corev1api.Pod{ObjectMeta: metav1.ObjectMeta{Name: "datamover-placeholder-1", Namespace: "openshift-adp", Labels: map[string]string{PlaceholderForLabel: "vsr-1"}}},
+	)
+	defer SetClients(kubeClient, snapshotFake.NewSimpleClientset(), crClient)()
+
+	vsr := &datamoverv1alpha1.VolumeSnapshotRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsr-1", Namespace: "app"},
+		Spec: datamoverv1alpha1.VolumeSnapshotRestoreSpec{
+			ProtectedNamespace: "openshift-adp",
+			ResticSecretRef:    corev1api.LocalObjectReference{Name: "restore-1-vsb-1-vault-restic"},
+		},
+	}
+
+	deleted, err := DeleteVolumeSnapshotRestoreResources(vsr, kubeClient.CoreV1())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, deleted)
+
+	rdList, err := GetReplicationDestinationsForVSR("vsr-1")
+	assert.NoError(t, err)
+	assert.Empty(t, rdList.Items)
+
+	// only the restic secret of the repository is left
+	secrets, err := kubeClient.CoreV1().Secrets("openshift-adp").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, secrets.Items, 1)
+	assert.Equal(t, "dpa-1-volsync-restic", secrets.Items[0].Name)
+
+	pods, err := kubeClient.CoreV1().Pods("openshift-adp").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, pods.Items)
+}
+
+func TestDeleteOrphanedDataMoverResources(t *testing.T) {
+	newVSB := func(name, backupName string) *datamoverv1alpha1.VolumeSnapshotBackup {
+		return &datamoverv1alpha1.VolumeSnapshotBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app", Labels: map[string]string{BackupNameLabel: backupName}},
+			Spec:       datamoverv1alpha1.VolumeSnapshotBackupSpec{ProtectedNamespace: "openshift-adp"},
+		}
+	}
+	newVSR := func(name, restoreName string) *datamoverv1alpha1.VolumeSnapshotRestore {
+		return &datamoverv1alpha1.VolumeSnapshotRestore{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app", Labels: map[string]string{RestoreNameLabel: restoreName}},
+			Spec:       datamoverv1alpha1.VolumeSnapshotRestoreSpec{ProtectedNamespace: "openshift-adp"},
+		}
+	}
+
+	// the VSB of a backup-1 deleted and recreated since
+	recreatedVSB := newVSB("vsb-3", "backup-1")
+	recreatedVSB.Labels[BackupUIDLabel] = "uid-0"
+
+	scheme, err := NewScheme()
+	assert.NoError(t, err)
+	crClient := crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		&velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "openshift-adp", UID: "uid-1"}},
+		&velerov1api.Restore{ObjectMeta: metav1.ObjectMeta{Name: "restore-1", Namespace: "openshift-adp"}},
+		newVSB("vsb-1", "backup-1"),
+		newVSB("vsb-2", "backup-2"),
+		recreatedVSB,
+		newVSR("vsr-1", "restore-1"),
+		newVSR("vsr-2", "restore-2"),
+	).Build()
+	defer SetClients(fake.NewSimpleClientset(), snapshotFake.NewSimpleClientset(), crClient)()
+
+	assert.NoError(t, DeleteOrphanedDataMoverResources("openshift-adp", logrus.New().WithField("fake", "test")))
+
+	vsbList := datamoverv1alpha1.VolumeSnapshotBackupList{}
+	assert.NoError(t, crClient.List(context.TODO(), &vsbList, client.InNamespace("app")))
+	assert.Len(t, vsbList.Items, 1)
+	assert.Equal(t, "vsb-1", vsbList.Items[0].Name)
+
+	vsrList := datamoverv1alpha1.VolumeSnapshotRestoreList{}
+	assert.NoError(t, crClient.List(context.TODO(), &vsrList, client.InNamespace("app")))
+	assert.Len(t, vsrList.Items, 1)
+	assert.Equal(t, "vsr-1", vsrList.Items[0].Name)
+}
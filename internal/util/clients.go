@@ -0,0 +1,219 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"os"
+	"sync"
+
+	volsyncv1alpha1 "github.com/backube/volsync/api/v1alpha1"
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
+	"github.com/pkg/errors"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// clientManager builds the plugin's clients once per plugin process and hands out the shared instances
+type clientManager struct {
+	mu             sync.Mutex
+	kubeClient     kubernetes.Interface
+	snapshotClient snapshotterClientSet.Interface
+	crClient       client.WithWatch
+}
+
+var clients = &clientManager{}
+
+// get returns the shared clients, building them on first use. Failures are not cached so later calls can retry.
+func (m *clientManager) get() (kubernetes.Interface, snapshotterClientSet.Interface, client.WithWatch, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.kubeClient != nil {
+		return m.kubeClient, m.snapshotClient, m.crClient, nil
+	}
+
+	cfg, err := getRestConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, nil, errors.WithStack(err)
+	}
+
+	snapshotClientSet, err := snapshotterClientSet.NewForConfig(cfg)
+	if err != nil {
+		return nil, nil, nil, errors.WithStack(err)
+	}
+
+	// clusters predating the v1 snapshot API are served through v1beta1
+	snapshotClient, err := newSnapshotClientSet(snapshotClientSet)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	scheme, err := newScheme(resolveDataMoverGroupVersion(kubeClient.Discovery()))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	crClient, err := client.NewWithWatch(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, nil, nil, errors.WithStack(err)
+	}
+
+	m.kubeClient, m.snapshotClient, m.crClient = kubeClient, snapshotClient, crClient
+	return m.kubeClient, m.snapshotClient, m.crClient, nil
+}
+
+// NewScheme returns a scheme with all the types the plugin works with registered, the datamover types under the
+// configured DatamoverAPIGroupVersion or else the one of volume-snapshot-mover
+func NewScheme() (*runtime.Scheme, error) {
+	gv, ok := getConfiguredDataMoverGroupVersion()
+	if !ok {
+		gv = datamoverv1alpha1.GroupVersion
+	}
+	return newScheme(gv)
+}
+
+// newScheme returns a scheme with all the types the plugin works with registered, the datamover types under
+// dataMoverGV
+func newScheme(dataMoverGV schema.GroupVersion) (*runtime.Scheme, error) {
+	scheme := runtime.NewScheme()
+	for _, addToScheme := range []func(*runtime.Scheme) error{
+		clientgoscheme.AddToScheme,
+		volsyncv1alpha1.AddToScheme,
+		velerov1api.AddToScheme,
+	} {
+		if err := addToScheme(scheme); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	addDataMoverToScheme(scheme, dataMoverGV)
+
+	return scheme, nil
+}
+
+// SetClients replaces the shared clients, so tests can run the plugin against fake clients. It returns a func that
+// puts the previous clients back.
+func SetClients(kubeClient kubernetes.Interface, snapshotClient snapshotterClientSet.Interface, crClient client.WithWatch) func() {
+	clients.mu.Lock()
+	defer clients.mu.Unlock()
+
+	prevKube, prevSnapshot, prevCR := clients.kubeClient, clients.snapshotClient, clients.crClient
+	clients.kubeClient, clients.snapshotClient, clients.crClient = kubeClient, snapshotClient, crClient
+
+	return func() {
+		clients.mu.Lock()
+		defer clients.mu.Unlock()
+		clients.kubeClient, clients.snapshotClient, clients.crClient = prevKube, prevSnapshot, prevCR
+	}
+}
+
+// getRestConfig returns the config for building clients to the cluster velero runs in
+func getRestConfig() (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	configOverrides := &clientcmd.ConfigOverrides{}
+	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
+	clientConfig, err := kubeConfig.ClientConfig()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// every client the plugin builds shares one API budget instead of each getting its own
+	clientConfig.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(GetClientQPS(), GetClientBurst())
+
+	if err := applyAPITransportSettings(clientConfig); err != nil {
+		return nil, err
+	}
+
+	return clientConfig, nil
+}
+
+// applyAPITransportSettings connects the config through the configured DatamoverAPIProxy and adds the DatamoverAPICA
+// bundle to the CAs it trusts. Without a DatamoverAPIProxy client-go proxies with HTTPS_PROXY and NO_PROXY.
+func applyAPITransportSettings(cfg *rest.Config) error {
+	proxy, err := GetAPIProxy()
+	if err != nil {
+		return err
+	}
+	if proxy != nil {
+		cfg.Proxy = http.ProxyURL(proxy)
+	}
+
+	ca := GetAPICA()
+	if len(ca) == 0 {
+		return nil
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(ca) {
+		return errors.Errorf("invalid %s: no PEM certificates found", DatamoverAPICA)
+	}
+
+	// client-go reads CAFile only when CAData is empty, so the CA of the file goes into the bundle
+	bundle := cfg.CAData
+	if len(bundle) == 0 && len(cfg.CAFile) > 0 {
+		bundle, err = os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return errors.Wrapf(err, "error reading the CA of the API server from %s", cfg.CAFile)
+		}
+	}
+	if len(bundle) > 0 && !bytes.HasSuffix(bundle, []byte("\n")) {
+		bundle = append(bundle, '\n')
+	}
+	cfg.CAData = append(bundle, ca...)
+	cfg.CAFile = ""
+
+	return nil
+}
+
+func GetClients() (kubernetes.Interface, snapshotterClientSet.Interface, error) {
+	kubeClient, snapshotClient, _, err := clients.get()
+	return kubeClient, snapshotClient, err
+}
+
+func GetVolumeSnapshotMoverClient() (client.Client, error) {
+	_, _, crClient, err := clients.get()
+	return crClient, err
+}
+
+func GetVolsyncClient() (client.Client, error) {
+	_, _, crClient, err := clients.get()
+	return crClient, err
+}
+
+func GetVeleroClient() (client.Client, error) {
+	_, _, crClient, err := clients.get()
+	return crClient, err
+}
+
+// getWatchClient returns the shared controller-runtime client for watching the plugin's custom resources
+func getWatchClient() (client.WithWatch, error) {
+	_, _, crClient, err := clients.get()
+	return crClient, err
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+)
+
+// newTestCAPEM returns a PEM encoded self-signed CA certificate
+func newTestCAPEM(t *testing.T, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestApplyAPITransportSettings(t *testing.T) {
+	t.Setenv(DatamoverAPIProxy, "")
+	t.Setenv(DatamoverAPICA, "")
+
+	// unset, the config is left to client-go's HTTPS_PROXY and NO_PROXY handling
+	cfg := &rest.Config{}
+	assert.NoError(t, applyAPITransportSettings(cfg))
+	assert.Nil(t, cfg.Proxy)
+	assert.Empty(t, cfg.CAData)
+
+	t.Setenv(DatamoverAPIProxy, "http://proxy.example.com:3128")
+	assert.NoError(t, applyAPITransportSettings(cfg))
+	req, err := http.NewRequest(http.MethodGet, "https://172.30.0.1:443/api", nil)
+	assert.NoError(t, err)
+	proxy, err := cfg.Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com:3128", proxy.String())
+
+	t.Setenv(DatamoverAPIProxy, "proxy.example.com:3128")
+	assert.Error(t, applyAPITransportSettings(&rest.Config{}))
+	t.Setenv(DatamoverAPIProxy, "")
+
+	// the configured CA is added to the one of the service account
+	serviceAccountCA := newTestCAPEM(t, "kube-apiserver")
+	proxyCA := newTestCAPEM(t, "proxy")
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	assert.NoError(t, os.WriteFile(caFile, serviceAccountCA, 0600))
+
+	t.Setenv(DatamoverAPICA, string(proxyCA))
+	cfg = &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAFile: caFile}}
+	assert.NoError(t, applyAPITransportSettings(cfg))
+	assert.Empty(t, cfg.CAFile)
+	assert.Equal(t, append(append([]byte{}, serviceAccountCA...), proxyCA...), cfg.CAData)
+
+	cfg = &rest.Config{TLSClientConfig: rest.TLSClientConfig{CAData: []byte("kubeconfig CA")}}
+	assert.NoError(t, applyAPITransportSettings(cfg))
+	assert.Equal(t, append([]byte("kubeconfig CA\n"), proxyCA...), cfg.CAData)
+
+	t.Setenv(DatamoverAPICA, "not a certificate")
+	assert.Error(t, applyAPITransportSettings(&rest.Config{}))
+}
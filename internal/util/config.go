@@ -0,0 +1,956 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	DefaultPollInterval    = 5 * time.Second
+	DefaultPollMaxInterval = 1 * time.Minute
+	DefaultWaitMaxErrors   = 5
+
+	// DefaultClientQPS and DefaultClientBurst are the API budget of a plugin process
+	DefaultClientQPS   = 20
+	DefaultClientBurst = 40
+
+	// configMapTTL is how long the plugin ConfigMap is cached before being read again
+	configMapTTL = 30 * time.Second
+	// configMapLoadTimeout bounds reading the plugin ConfigMap
+	configMapLoadTimeout = 10 * time.Second
+)
+
+// PluginConfig holds all plugin settings supplied as a single JSON document in the VSMPluginConfigEnv env var.
+// Individual env vars and the plugin ConfigMap take precedence over the values set here.
+type PluginConfig struct {
+	DataMover                *bool  `json:"dataMover,omitempty"`
+	Timeout                  string `json:"timeout,omitempty"`
+	DeleteTimeout            string `json:"deleteTimeout,omitempty"`
+	TimeoutPer100Gi          string `json:"timeoutPer100Gi,omitempty"`
+	SnapshotRetentionDays    *int   `json:"snapshotRetentionDays,omitempty"`
+	PlaceholderPriorityClass string `json:"placeholderPriorityClass,omitempty"`
+	PlaceholderImage         string `json:"placeholderImage,omitempty"`
+	PlaceholderCPU           string `json:"placeholderCPU,omitempty"`
+	PlaceholderMemory        string `json:"placeholderMemory,omitempty"`
+	PollInterval             string `json:"pollInterval,omitempty"`
+	PollMaxInterval          string `json:"pollMaxInterval,omitempty"`
+	WaitMaxErrors            *int   `json:"waitMaxErrors,omitempty"`
+	MaxInFlightVSBs          *int   `json:"maxInFlightVSBs,omitempty"`
+	VolumeSnapshotClass      string `json:"volumeSnapshotClass,omitempty"`
+	ClientQPS                *int   `json:"clientQPS,omitempty"`
+	ClientBurst              *int   `json:"clientBurst,omitempty"`
+	RequireApproval          *bool  `json:"requireApproval,omitempty"`
+	SnapshotPVCs             *bool  `json:"snapshotPVCs,omitempty"`
+	// ExcludedVolumeSnapshotClasses lists the volumesnapshotclasses whose snapshots are never moved
+	ExcludedVolumeSnapshotClasses []string `json:"excludedVolumeSnapshotClasses,omitempty"`
+	// StorageClasses restricts data movement to the volumes of these storageclasses, the volumes of
+	// ExcludedStorageClasses are never moved
+	StorageClasses         []string `json:"storageClasses,omitempty"`
+	ExcludedStorageClasses []string `json:"excludedStorageClasses,omitempty"`
+	// ResticSourceSecret is the <namespace>/<name> of the secret restic secrets missing on restore are recreated from
+	ResticSourceSecret string `json:"resticSourceSecret,omitempty"`
+	// VaultAddress enables resolving the restic repository password from the HashiCorp Vault at this address
+	VaultAddress    string `json:"vaultAddress,omitempty"`
+	VaultSecretPath string `json:"vaultSecretPath,omitempty"`
+	VaultRole       string `json:"vaultRole,omitempty"`
+	VaultAuthPath   string `json:"vaultAuthPath,omitempty"`
+	// CustomCA is the PEM CA bundle the mover verifies object storage with, for backup storage locations without a caCert
+	CustomCA string `json:"customCA,omitempty"`
+	// MoverType selects the mover backend of backups without a mover type annotation
+	MoverType string `json:"moverType,omitempty"`
+	// StorageClassMoverTypes selects the mover backend of the volumes of storageclasses, keyed by storageclass name
+	StorageClassMoverTypes map[string]string `json:"storageClassMoverTypes,omitempty"`
+	// CacheCapacity, CacheStorageClass and CacheAccessMode configure the restic cache volumes of the mover pods
+	CacheCapacity     string `json:"cacheCapacity,omitempty"`
+	CacheStorageClass string `json:"cacheStorageClass,omitempty"`
+	CacheAccessMode   string `json:"cacheAccessMode,omitempty"`
+	// PruneOnDelete forgets and prunes the restic snapshots of a backup when it is deleted, with jobs running PruneImage
+	PruneOnDelete *bool  `json:"pruneOnDelete,omitempty"`
+	PruneImage    string `json:"pruneImage,omitempty"`
+	// DeleteOrphans deletes VSBs and VSRs whose velero backup or restore no longer exists
+	DeleteOrphans *bool `json:"deleteOrphans,omitempty"`
+	// MetricsAddress is the address the plugin process serves its prometheus metrics on
+	MetricsAddress string `json:"metricsAddress,omitempty"`
+	// APIGroupVersion is the group version the datamover CRDs are served with
+	APIGroupVersion string `json:"apiGroupVersion,omitempty"`
+	// NamespaceOptIn restricts data movement to the namespaces labeled with NamespaceDataMoverEnabledLabel
+	NamespaceOptIn *bool `json:"namespaceOptIn,omitempty"`
+	// SnapshotDedupPolicy selects whose snapshot of a PVC is moved when velero-plugin-for-csi snapshots it as well
+	SnapshotDedupPolicy string `json:"snapshotDedupPolicy,omitempty"`
+	// PopulatedPVCPolicy selects whether the data of PVCs provisioned by volume populators is moved
+	PopulatedPVCPolicy string `json:"populatedPVCPolicy,omitempty"`
+	// FsBackupFallback backs up PVCs without a volumesnapshotclass with file system backup
+	FsBackupFallback *bool `json:"fsBackupFallback,omitempty"`
+	// ControllerDeployment is the name of the volume-snapshot-mover controller deployment in the velero namespace
+	ControllerDeployment string `json:"controllerDeployment,omitempty"`
+	// VerifyRepository checks that the restic repository of a VSB is reachable before restoring it
+	VerifyRepository *bool `json:"verifyRepository,omitempty"`
+	// APIProxy and APICA are the proxy and additional CA bundle of the plugin's connection to the API server
+	APIProxy string `json:"apiProxy,omitempty"`
+	APICA    string `json:"apiCA,omitempty"`
+}
+
+// We expect VSMPluginConfigEnv to be set once when container is started.
+var pluginConfig, pluginConfigErr = ParsePluginConfig(os.Getenv(VSMPluginConfigEnv))
+
+// ParsePluginConfig parses and validates a JSON encoded PluginConfig
+func ParsePluginConfig(raw string) (PluginConfig, error) {
+	cfg := PluginConfig{}
+	if len(raw) == 0 {
+		return cfg, nil
+	}
+
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return PluginConfig{}, errors.Wrapf(err, "error parsing %s", VSMPluginConfigEnv)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return PluginConfig{}, errors.Wrapf(err, "invalid %s", VSMPluginConfigEnv)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the values of the config are usable
+func (c PluginConfig) Validate() error {
+	if len(c.Timeout) > 0 {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return errors.Wrapf(err, "invalid timeout %q", c.Timeout)
+		}
+	}
+
+	if len(c.DeleteTimeout) > 0 {
+		timeout, err := time.ParseDuration(c.DeleteTimeout)
+		if err != nil {
+			return errors.Wrapf(err, "invalid deleteTimeout %q", c.DeleteTimeout)
+		}
+		if timeout <= 0 {
+			return errors.Errorf("deleteTimeout must be positive, got %q", c.DeleteTimeout)
+		}
+	}
+
+	if len(c.TimeoutPer100Gi) > 0 {
+		timeout, err := time.ParseDuration(c.TimeoutPer100Gi)
+		if err != nil {
+			return errors.Wrapf(err, "invalid timeoutPer100Gi %q", c.TimeoutPer100Gi)
+		}
+		if timeout < 0 {
+			return errors.Errorf("timeoutPer100Gi must not be negative, got %q", c.TimeoutPer100Gi)
+		}
+	}
+
+	if len(c.PollInterval) > 0 {
+		interval, err := time.ParseDuration(c.PollInterval)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pollInterval %q", c.PollInterval)
+		}
+		if interval <= 0 {
+			return errors.Errorf("pollInterval must be positive, got %q", c.PollInterval)
+		}
+	}
+
+	if len(c.PollMaxInterval) > 0 {
+		interval, err := time.ParseDuration(c.PollMaxInterval)
+		if err != nil {
+			return errors.Wrapf(err, "invalid pollMaxInterval %q", c.PollMaxInterval)
+		}
+		if interval <= 0 {
+			return errors.Errorf("pollMaxInterval must be positive, got %q", c.PollMaxInterval)
+		}
+	}
+
+	for name, val := range map[string]string{"placeholderPriorityClass": c.PlaceholderPriorityClass, "volumeSnapshotClass": c.VolumeSnapshotClass, "cacheStorageClass": c.CacheStorageClass, "controllerDeployment": c.ControllerDeployment} {
+		if len(val) > 0 {
+			if errs := validation.IsDNS1123Subdomain(val); len(errs) > 0 {
+				return errors.Errorf("invalid %s %q: %s", name, val, strings.Join(errs, ", "))
+			}
+		}
+	}
+
+	for _, val := range c.ExcludedVolumeSnapshotClasses {
+		if errs := validation.IsDNS1123Subdomain(val); len(errs) > 0 {
+			return errors.Errorf("invalid excludedVolumeSnapshotClasses entry %q: %s", val, strings.Join(errs, ", "))
+		}
+	}
+
+	for name, vals := range map[string][]string{"storageClasses": c.StorageClasses, "excludedStorageClasses": c.ExcludedStorageClasses} {
+		for _, val := range vals {
+			if errs := validation.IsDNS1123Subdomain(val); len(errs) > 0 {
+				return errors.Errorf("invalid %s entry %q: %s", name, val, strings.Join(errs, ", "))
+			}
+		}
+	}
+
+	if len(c.ResticSourceSecret) > 0 {
+		if _, _, err := parseSecretRef(c.ResticSourceSecret); err != nil {
+			return errors.Wrap(err, "invalid resticSourceSecret")
+		}
+	}
+
+	if len(c.VaultAddress) > 0 {
+		if _, err := url.ParseRequestURI(c.VaultAddress); err != nil {
+			return errors.Wrapf(err, "invalid vaultAddress %q", c.VaultAddress)
+		}
+		if len(c.VaultSecretPath) == 0 || len(c.VaultRole) == 0 {
+			return errors.New("vaultSecretPath and vaultRole must be set along with vaultAddress")
+		}
+	}
+
+	if len(c.MoverType) > 0 {
+		if _, err := GetMoverBackend(c.MoverType); err != nil {
+			return errors.Wrap(err, "invalid moverType")
+		}
+	}
+
+	for storageClass, moverType := range c.StorageClassMoverTypes {
+		if errs := validation.IsDNS1123Subdomain(storageClass); len(errs) > 0 {
+			return errors.Errorf("invalid storageClassMoverTypes storageclass %q: %s", storageClass, strings.Join(errs, ", "))
+		}
+		if _, err := GetMoverBackend(moverType); err != nil {
+			return errors.Wrapf(err, "invalid storageClassMoverTypes entry for storageclass %s", storageClass)
+		}
+	}
+
+	if len(c.SnapshotDedupPolicy) > 0 {
+		if err := validateSnapshotDedupPolicy(c.SnapshotDedupPolicy); err != nil {
+			return errors.Wrap(err, "invalid snapshotDedupPolicy")
+		}
+	}
+
+	if len(c.PopulatedPVCPolicy) > 0 && c.PopulatedPVCPolicy != PopulatedPVCPolicySkip && c.PopulatedPVCPolicy != PopulatedPVCPolicyMove {
+		return errors.Errorf("invalid populatedPVCPolicy %q, expected %s or %s", c.PopulatedPVCPolicy, PopulatedPVCPolicySkip, PopulatedPVCPolicyMove)
+	}
+
+	if len(c.PlaceholderImage) > 0 && strings.ContainsAny(c.PlaceholderImage, " \t\n") {
+		return errors.Errorf("invalid placeholderImage %q: must not contain whitespace", c.PlaceholderImage)
+	}
+
+	if c.SnapshotRetentionDays != nil && *c.SnapshotRetentionDays < 0 {
+		return errors.Errorf("snapshotRetentionDays must be non-negative, got %d", *c.SnapshotRetentionDays)
+	}
+
+	for name, val := range map[string]string{"placeholderCPU": c.PlaceholderCPU, "placeholderMemory": c.PlaceholderMemory, "cacheCapacity": c.CacheCapacity} {
+		if len(val) > 0 {
+			if _, err := resource.ParseQuantity(val); err != nil {
+				return errors.Wrapf(err, "invalid %s %q", name, val)
+			}
+		}
+	}
+
+	if len(c.CacheAccessMode) > 0 && !isValidAccessMode(c.CacheAccessMode) {
+		return errors.Errorf("invalid cacheAccessMode %q", c.CacheAccessMode)
+	}
+
+	if c.WaitMaxErrors != nil && *c.WaitMaxErrors < 0 {
+		return errors.Errorf("waitMaxErrors must be non-negative, got %d", *c.WaitMaxErrors)
+	}
+
+	if c.MaxInFlightVSBs != nil && *c.MaxInFlightVSBs < 0 {
+		return errors.Errorf("maxInFlightVSBs must be non-negative, got %d", *c.MaxInFlightVSBs)
+	}
+
+	if c.ClientQPS != nil && *c.ClientQPS < 1 {
+		return errors.Errorf("clientQPS must be positive, got %d", *c.ClientQPS)
+	}
+
+	if c.ClientBurst != nil && *c.ClientBurst < 1 {
+		return errors.Errorf("clientBurst must be positive, got %d", *c.ClientBurst)
+	}
+
+	if len(c.MetricsAddress) > 0 {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			return errors.Wrapf(err, "invalid metricsAddress %q", c.MetricsAddress)
+		}
+	}
+
+	if len(c.APIGroupVersion) > 0 {
+		if _, err := parseDataMoverGroupVersion(c.APIGroupVersion); err != nil {
+			return errors.Wrap(err, "invalid apiGroupVersion")
+		}
+	}
+
+	if len(c.APIProxy) > 0 {
+		if _, err := parseAPIProxy(c.APIProxy); err != nil {
+			return errors.Wrap(err, "invalid apiProxy")
+		}
+	}
+
+	if len(c.APICA) > 0 && !x509.NewCertPool().AppendCertsFromPEM([]byte(c.APICA)) {
+		return errors.New("invalid apiCA: no PEM certificates found")
+	}
+
+	return nil
+}
+
+// settings returns the config values keyed by the env var they stand in for
+func (c PluginConfig) settings() map[string]string {
+	vals := map[string]string{}
+
+	if c.DataMover != nil {
+		vals[VolumeSnapshotMoverEnv] = strconv.FormatBool(*c.DataMover)
+	}
+	if len(c.Timeout) > 0 {
+		vals[DatamoverTimeout] = c.Timeout
+	}
+	if len(c.DeleteTimeout) > 0 {
+		vals[DatamoverDeleteTimeout] = c.DeleteTimeout
+	}
+	if len(c.TimeoutPer100Gi) > 0 {
+		vals[DatamoverTimeoutPer100Gi] = c.TimeoutPer100Gi
+	}
+	if c.SnapshotRetentionDays != nil {
+		vals[SnapshotRetentionDays] = strconv.Itoa(*c.SnapshotRetentionDays)
+	}
+	if len(c.PlaceholderPriorityClass) > 0 {
+		vals[DatamoverPlaceholderPriorityClass] = c.PlaceholderPriorityClass
+	}
+	if len(c.PlaceholderImage) > 0 {
+		vals[DatamoverPlaceholderImage] = c.PlaceholderImage
+	}
+	if len(c.PlaceholderCPU) > 0 {
+		vals[DatamoverPlaceholderCPU] = c.PlaceholderCPU
+	}
+	if len(c.PlaceholderMemory) > 0 {
+		vals[DatamoverPlaceholderMemory] = c.PlaceholderMemory
+	}
+	if len(c.PollInterval) > 0 {
+		vals[DatamoverPollInterval] = c.PollInterval
+	}
+	if len(c.PollMaxInterval) > 0 {
+		vals[DatamoverPollMaxInterval] = c.PollMaxInterval
+	}
+	if c.WaitMaxErrors != nil {
+		vals[DatamoverWaitMaxErrors] = strconv.Itoa(*c.WaitMaxErrors)
+	}
+	if c.MaxInFlightVSBs != nil {
+		vals[DatamoverMaxInFlightVSBs] = strconv.Itoa(*c.MaxInFlightVSBs)
+	}
+	if len(c.VolumeSnapshotClass) > 0 {
+		vals[DatamoverVolumeSnapshotClass] = c.VolumeSnapshotClass
+	}
+	if c.ClientQPS != nil {
+		vals[DatamoverClientQPS] = strconv.Itoa(*c.ClientQPS)
+	}
+	if c.ClientBurst != nil {
+		vals[DatamoverClientBurst] = strconv.Itoa(*c.ClientBurst)
+	}
+	if c.RequireApproval != nil {
+		vals[DatamoverRequireApproval] = strconv.FormatBool(*c.RequireApproval)
+	}
+	if c.SnapshotPVCs != nil {
+		vals[DatamoverSnapshotPVCs] = strconv.FormatBool(*c.SnapshotPVCs)
+	}
+	if len(c.ExcludedVolumeSnapshotClasses) > 0 {
+		vals[DatamoverExcludedVolumeSnapshotClasses] = strings.Join(c.ExcludedVolumeSnapshotClasses, ",")
+	}
+	if len(c.StorageClasses) > 0 {
+		vals[DatamoverStorageClasses] = strings.Join(c.StorageClasses, ",")
+	}
+	if len(c.ExcludedStorageClasses) > 0 {
+		vals[DatamoverExcludedStorageClasses] = strings.Join(c.ExcludedStorageClasses, ",")
+	}
+	if len(c.ResticSourceSecret) > 0 {
+		vals[DatamoverResticSourceSecret] = c.ResticSourceSecret
+	}
+	if len(c.VaultAddress) > 0 {
+		vals[DatamoverVaultAddress] = c.VaultAddress
+	}
+	if len(c.VaultSecretPath) > 0 {
+		vals[DatamoverVaultSecretPath] = c.VaultSecretPath
+	}
+	if len(c.VaultRole) > 0 {
+		vals[DatamoverVaultRole] = c.VaultRole
+	}
+	if len(c.VaultAuthPath) > 0 {
+		vals[DatamoverVaultAuthPath] = c.VaultAuthPath
+	}
+	if len(c.CustomCA) > 0 {
+		vals[DatamoverCustomCA] = c.CustomCA
+	}
+	if len(c.MoverType) > 0 {
+		vals[DatamoverMoverType] = c.MoverType
+	}
+	if len(c.CacheCapacity) > 0 {
+		vals[DatamoverCacheCapacity] = c.CacheCapacity
+	}
+	if len(c.CacheStorageClass) > 0 {
+		vals[DatamoverCacheStorageClass] = c.CacheStorageClass
+	}
+	if len(c.CacheAccessMode) > 0 {
+		vals[DatamoverCacheAccessMode] = c.CacheAccessMode
+	}
+	if len(c.StorageClassMoverTypes) > 0 {
+		vals[DatamoverStorageClassMoverTypes] = labels.Set(c.StorageClassMoverTypes).String()
+	}
+	if c.PruneOnDelete != nil {
+		vals[DatamoverPruneOnDelete] = strconv.FormatBool(*c.PruneOnDelete)
+	}
+	if len(c.PruneImage) > 0 {
+		vals[DatamoverPruneImage] = c.PruneImage
+	}
+	if c.DeleteOrphans != nil {
+		vals[DatamoverDeleteOrphans] = strconv.FormatBool(*c.DeleteOrphans)
+	}
+	if len(c.MetricsAddress) > 0 {
+		vals[DatamoverMetricsAddress] = c.MetricsAddress
+	}
+	if len(c.APIGroupVersion) > 0 {
+		vals[DatamoverAPIGroupVersion] = c.APIGroupVersion
+	}
+	if c.NamespaceOptIn != nil {
+		vals[DatamoverNamespaceOptIn] = strconv.FormatBool(*c.NamespaceOptIn)
+	}
+	if len(c.SnapshotDedupPolicy) > 0 {
+		vals[DatamoverSnapshotDedupPolicy] = c.SnapshotDedupPolicy
+	}
+	if len(c.PopulatedPVCPolicy) > 0 {
+		vals[DatamoverPopulatedPVCPolicy] = c.PopulatedPVCPolicy
+	}
+	if c.FsBackupFallback != nil {
+		vals[DatamoverFsBackupFallback] = strconv.FormatBool(*c.FsBackupFallback)
+	}
+	if len(c.ControllerDeployment) > 0 {
+		vals[DatamoverControllerDeployment] = c.ControllerDeployment
+	}
+	if c.VerifyRepository != nil {
+		vals[DatamoverVerifyRepository] = strconv.FormatBool(*c.VerifyRepository)
+	}
+	if len(c.APIProxy) > 0 {
+		vals[DatamoverAPIProxy] = c.APIProxy
+	}
+	if len(c.APICA) > 0 {
+		vals[DatamoverAPICA] = c.APICA
+	}
+
+	return vals
+}
+
+// ValidatePluginConfig returns the error, if any, from parsing VSMPluginConfigEnv at startup
+func ValidatePluginConfig() error {
+	return pluginConfigErr
+}
+
+var (
+	configMapLock      sync.Mutex
+	configMapData      map[string]string
+	configMapFetchedAt time.Time
+
+	// loadConfigMapSettings reads the plugin ConfigMap, tests replace it to avoid talking to a cluster
+	loadConfigMapSettings = loadPluginConfigMap
+)
+
+// getConfigMapSettings returns the data of the plugin ConfigMap, keyed by the env var each entry stands in for.
+// The ConfigMap is read lazily at execution time so admins can change settings without restarting velero.
+func getConfigMapSettings() map[string]string {
+	configMapLock.Lock()
+	if configMapData != nil && time.Since(configMapFetchedAt) < configMapTTL {
+		data := configMapData
+		configMapLock.Unlock()
+		return data
+	}
+	configMapLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), configMapLoadTimeout)
+	defer cancel()
+	data, err := loadConfigMapSettings(ctx)
+
+	configMapLock.Lock()
+	defer configMapLock.Unlock()
+
+	configMapFetchedAt = time.Now()
+	if err != nil {
+		// keep using the last known settings if the ConfigMap can't be read
+		logrus.Warnf("failed to read the plugin configmap, using the last known settings: %s", err.Error())
+		if configMapData == nil {
+			configMapData = map[string]string{}
+		}
+		return configMapData
+	}
+
+	configMapData = data
+	return configMapData
+}
+
+// loadPluginConfigMap reads the ConfigMap labeled with PluginConfigLabel and VSMPluginConfigLabel in the velero namespace
+func loadPluginConfigMap(ctx context.Context) (map[string]string, error) {
+	return loadLabeledConfigMap(ctx, VSMPluginConfigLabel)
+}
+
+// GetResticSecretMapping returns the restic secret mapping restores create VSRs with, read from the ConfigMap
+// labeled with PluginConfigLabel and VSMSecretMappingLabel in the velero namespace. It maps the name of the restic
+// secret recorded on a VSB to the name of the secret to restore its data with.
+func GetResticSecretMapping() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configMapLoadTimeout)
+	defer cancel()
+
+	return loadLabeledConfigMap(ctx, VSMSecretMappingLabel)
+}
+
+// GetChangeStorageClassMapping returns the storageclass mapping of velero's change-storage-class ConfigMap, read from
+// the ConfigMap labeled with PluginConfigLabel and ChangeStorageClassLabel in the velero namespace
+func GetChangeStorageClassMapping() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configMapLoadTimeout)
+	defer cancel()
+
+	return loadLabeledConfigMap(ctx, ChangeStorageClassLabel)
+}
+
+// GetAccessModeMapping returns the access mode mapping restored PVCs are created with, read from the ConfigMap
+// labeled with PluginConfigLabel and VSMAccessModeMappingLabel in the velero namespace
+func GetAccessModeMapping() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configMapLoadTimeout)
+	defer cancel()
+
+	return loadLabeledConfigMap(ctx, VSMAccessModeMappingLabel)
+}
+
+// GetSizeMapping returns the sizes backed up PVCs are restored with, keyed by <namespace>.<pvc>, read from the
+// ConfigMap labeled with PluginConfigLabel and VSMSizeMappingLabel in the velero namespace
+func GetSizeMapping() (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configMapLoadTimeout)
+	defer cancel()
+
+	return loadLabeledConfigMap(ctx, VSMSizeMappingLabel)
+}
+
+// GetRestoreResticSecretName returns the name of the restic secret to restore the data of a VSB backed up with
+// backedUp, honoring the restic secret mapping
+func GetRestoreResticSecretName(backedUp string, mapping map[string]string) string {
+	if val, ok := mapping[backedUp]; ok && len(val) > 0 {
+		return val
+	}
+
+	return backedUp
+}
+
+// loadLabeledConfigMap reads the data of the ConfigMap labeled with PluginConfigLabel and the given label in the
+// velero namespace
+func loadLabeledConfigMap(ctx context.Context, label string) (map[string]string, error) {
+	namespace := os.Getenv(VeleroNamespaceEnv)
+	if len(namespace) == 0 {
+		namespace = "velero"
+	}
+
+	kubeClient, _, err := GetClients()
+	if err != nil {
+		return nil, err
+	}
+
+	cmList, err := kubeClient.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s,%s", PluginConfigLabel, label),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing %s configmaps in namespace %s", label, namespace)
+	}
+
+	switch len(cmList.Items) {
+	case 0:
+		return map[string]string{}, nil
+	case 1:
+		if cmList.Items[0].Data == nil {
+			return map[string]string{}, nil
+		}
+		return cmList.Items[0].Data, nil
+	default:
+		return nil, errors.Errorf("found %d %s configmaps in namespace %s, expected at most one", len(cmList.Items), label, namespace)
+	}
+}
+
+// getSetting returns the value of the named setting from the plugin ConfigMap, falling back to the env var of the
+// same name and then VSMPluginConfigEnv
+func getSetting(name string) string {
+	if val, ok := getConfigMapSettings()[name]; ok && len(val) > 0 {
+		return val
+	}
+
+	return getStartupSetting(name)
+}
+
+// getStartupSetting returns the value of the named setting from the env var of the same name, falling back to
+// VSMPluginConfigEnv. It is used for settings needed to build the clients the plugin ConfigMap is read with.
+func getStartupSetting(name string) string {
+	if val := os.Getenv(name); len(val) > 0 {
+		return val
+	}
+
+	return pluginConfig.settings()[name]
+}
+
+// GetPollInterval returns the configured interval between datamover status checks
+func GetPollInterval() time.Duration {
+	if val := getSetting(DatamoverPollInterval); len(val) > 0 {
+		if interval, err := time.ParseDuration(val); err == nil && interval > 0 {
+			return interval
+		}
+	}
+
+	return DefaultPollInterval
+}
+
+// GetPollMaxInterval returns the configured cap of the interval between datamover status checks
+func GetPollMaxInterval() time.Duration {
+	if val := getSetting(DatamoverPollMaxInterval); len(val) > 0 {
+		if interval, err := time.ParseDuration(val); err == nil && interval > 0 {
+			return interval
+		}
+	}
+
+	return DefaultPollMaxInterval
+}
+
+// GetWaitMaxErrors returns the configured number of consecutive transient API errors a wait retries before failing
+func GetWaitMaxErrors() int {
+	if val, err := strconv.Atoi(getSetting(DatamoverWaitMaxErrors)); err == nil && val >= 0 {
+		return val
+	}
+
+	return DefaultWaitMaxErrors
+}
+
+// GetMaxInFlightVSBs returns the configured number of VSBs of a backup that may move data at the same time, 0 when
+// unlimited or invalid
+func GetMaxInFlightVSBs() int {
+	if val, err := strconv.Atoi(getSetting(DatamoverMaxInFlightVSBs)); err == nil && val > 0 {
+		return val
+	}
+
+	return 0
+}
+
+// clientRateLimits are the QPS and burst of the --kube-api-qps and --kube-api-burst flags of the plugin process, which
+// take precedence over DatamoverClientQPS and DatamoverClientBurst when positive
+var clientRateLimits struct {
+	qps   float32
+	burst int
+}
+
+// SetClientRateLimits sets the QPS and burst of the clients the plugin process builds from then on. Non-positive values
+// leave the configured ones in place.
+func SetClientRateLimits(qps float32, burst int) {
+	clientRateLimits.qps, clientRateLimits.burst = qps, burst
+}
+
+// GetClientQPS returns the queries per second the plugin process may send to the API server: the --kube-api-qps flag,
+// else the configured DatamoverClientQPS. It is not read from the plugin ConfigMap, which is itself read with the rate
+// limited clients.
+func GetClientQPS() float32 {
+	if clientRateLimits.qps > 0 {
+		return clientRateLimits.qps
+	}
+	if val, err := strconv.Atoi(getStartupSetting(DatamoverClientQPS)); err == nil && val > 0 {
+		return float32(val)
+	}
+
+	return DefaultClientQPS
+}
+
+// GetClientBurst returns the burst of API requests the plugin process may send above its QPS: the --kube-api-burst
+// flag, else the configured DatamoverClientBurst. Like GetClientQPS, it is not read from the plugin ConfigMap.
+func GetClientBurst() int {
+	if clientRateLimits.burst > 0 {
+		return clientRateLimits.burst
+	}
+	if val, err := strconv.Atoi(getStartupSetting(DatamoverClientBurst)); err == nil && val > 0 {
+		return val
+	}
+
+	return DefaultClientBurst
+}
+
+// parseAPIProxy parses a DatamoverAPIProxy URL
+func parseAPIProxy(val string) (*url.URL, error) {
+	u, err := url.Parse(val)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || len(u.Host) == 0 {
+		return nil, errors.Errorf("%q is not an http, https or socks5 proxy URL", val)
+	}
+	return u, nil
+}
+
+// GetAPIProxy returns the configured DatamoverAPIProxy, nil if unset. Like GetClientQPS, it is not read from the
+// plugin ConfigMap.
+func GetAPIProxy() (*url.URL, error) {
+	val := getStartupSetting(DatamoverAPIProxy)
+	if len(val) == 0 {
+		return nil, nil
+	}
+
+	u, err := parseAPIProxy(val)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", DatamoverAPIProxy)
+	}
+	return u, nil
+}
+
+// GetAPICA returns the configured DatamoverAPICA bundle, nil if unset. Like GetClientQPS, it is not read from the
+// plugin ConfigMap.
+func GetAPICA() []byte {
+	if val := getStartupSetting(DatamoverAPICA); len(val) > 0 {
+		return []byte(val)
+	}
+	return nil
+}
+
+// GetMetricsAddress returns the configured address the plugin process serves its metrics on, empty when they are
+// disabled. The metrics server starts with the plugin process, so it is not read from the plugin ConfigMap.
+func GetMetricsAddress() string {
+	return getStartupSetting(DatamoverMetricsAddress)
+}
+
+// SnapshotPVCsEnabled returns whether the plugin snapshots the PVCs of data mover backups itself, instead of relying
+// on velero-plugin-for-csi to
+func SnapshotPVCsEnabled() bool {
+	enabled, _ := strconv.ParseBool(getSetting(DatamoverSnapshotPVCs))
+	return enabled
+}
+
+// getListSetting returns the non-empty entries of a setting configured as a comma separated list
+func getListSetting(name string) []string {
+	vals := []string{}
+	for _, val := range strings.Split(getSetting(name), ",") {
+		if val = strings.TrimSpace(val); len(val) > 0 {
+			vals = append(vals, val)
+		}
+	}
+
+	return vals
+}
+
+// GetExcludedVolumeSnapshotClasses returns the volumesnapshotclasses whose snapshots are never moved, configured as a
+// comma separated list
+func GetExcludedVolumeSnapshotClasses() []string {
+	return getListSetting(DatamoverExcludedVolumeSnapshotClasses)
+}
+
+// GetStorageClasses returns the storageclasses data movement is restricted to, empty when it is not restricted
+func GetStorageClasses() []string {
+	return getListSetting(DatamoverStorageClasses)
+}
+
+// GetExcludedStorageClasses returns the storageclasses whose volumes are never moved
+func GetExcludedStorageClasses() []string {
+	return getListSetting(DatamoverExcludedStorageClasses)
+}
+
+// GetResticSourceSecret returns the namespace and name of the secret restic secrets missing on restore are recreated
+// from, or empty strings if none is configured
+func GetResticSourceSecret() (string, string, error) {
+	val := getSetting(DatamoverResticSourceSecret)
+	if len(val) == 0 {
+		return "", "", nil
+	}
+
+	namespace, name, err := parseSecretRef(val)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "invalid %s", DatamoverResticSourceSecret)
+	}
+
+	return namespace, name, nil
+}
+
+// parseSecretRef parses a <namespace>/<name> secret reference
+func parseSecretRef(val string) (string, string, error) {
+	parts := strings.Split(val, "/")
+	if len(parts) != 2 {
+		return "", "", errors.Errorf("%q is not a <namespace>/<name> secret reference", val)
+	}
+
+	if errs := validation.IsDNS1123Label(parts[0]); len(errs) > 0 {
+		return "", "", errors.Errorf("invalid namespace %q: %s", parts[0], strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(parts[1]); len(errs) > 0 {
+		return "", "", errors.Errorf("invalid name %q: %s", parts[1], strings.Join(errs, ", "))
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// GetRestoreVolumeSnapshotClass returns the volumesnapshotclass the data mover snapshots a restored volume with,
+// the configured DatamoverVolumeSnapshotClass when set and the one recorded at backup time otherwise
+func GetRestoreVolumeSnapshotClass(backedUpClass string) string {
+	if name := getSetting(DatamoverVolumeSnapshotClass); len(name) > 0 {
+		return name
+	}
+
+	return backedUpClass
+}
+
+// GetDatamoverTimeout returns the configured timeout for datamover waits
+func GetDatamoverTimeout() (time.Duration, error) {
+	timeoutValue := DefaultDatamoverTimeout
+	if val := getSetting(DatamoverTimeout); len(val) > 0 {
+		timeoutValue = val
+	}
+
+	timeout, err := time.ParseDuration(timeoutValue)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid datamover timeout %q", timeoutValue)
+	}
+
+	return timeout, nil
+}
+
+// GetDeleteTimeout returns how long deleting a backup waits for each of its VSBs to be removed
+func GetDeleteTimeout() (time.Duration, error) {
+	timeoutValue := DefaultDeleteTimeout
+	if val := getSetting(DatamoverDeleteTimeout); len(val) > 0 {
+		timeoutValue = val
+	}
+
+	timeout, err := time.ParseDuration(timeoutValue)
+	if err != nil || timeout <= 0 {
+		return 0, errors.Errorf("invalid %s value %q, expected a positive duration", DatamoverDeleteTimeout, timeoutValue)
+	}
+
+	return timeout, nil
+}
+
+// GetTimeoutPer100Gi returns how much the datamover timeout of a volume is lengthened for every 100Gi of its size
+func GetTimeoutPer100Gi() (time.Duration, error) {
+	val := getSetting(DatamoverTimeoutPer100Gi)
+	if len(val) == 0 {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(val)
+	if err != nil || timeout < 0 {
+		return 0, errors.Errorf("invalid %s value %q, expected a non-negative duration", DatamoverTimeoutPer100Gi, val)
+	}
+
+	return timeout, nil
+}
+
+// ScaleTimeoutBySize lengthens timeout by the configured DatamoverTimeoutPer100Gi for every 100Gi of size, the
+// quantity of a volume's size. Volumes of unknown size keep the timeout.
+func ScaleTimeoutBySize(timeout time.Duration, size string) (time.Duration, error) {
+	if len(size) == 0 {
+		return timeout, nil
+	}
+
+	per100Gi, err := GetTimeoutPer100Gi()
+	if err != nil || per100Gi == 0 {
+		return timeout, err
+	}
+
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid volume size %q", size)
+	}
+
+	return timeout + time.Duration(float64(per100Gi)*quantity.AsApproximateFloat64()/(100*(1<<30))), nil
+}
+
+// GetOperationTimeout returns the timeout of an async item operation on a volume of the given size, the backup or
+// restore ItemOperationTimeout when set and the configured datamover timeout scaled by the size otherwise
+func GetOperationTimeout(itemOperationTimeout metav1.Duration, size string) (time.Duration, error) {
+	if itemOperationTimeout.Duration > 0 {
+		return itemOperationTimeout.Duration, nil
+	}
+
+	timeout, err := GetDatamoverTimeout()
+	if err != nil {
+		return 0, err
+	}
+
+	return ScaleTimeoutBySize(timeout, size)
+}
+
+// GetWaitTimeout returns the timeout of the waits the plugin does synchronously while velero processes an item: the
+// configured datamover timeout, capped by the backup or restore ItemOperationTimeout
+func GetWaitTimeout(itemOperationTimeout metav1.Duration) (time.Duration, error) {
+	return GetItemWaitTimeout(itemOperationTimeout, "", "")
+}
+
+// GetItemWaitTimeout is GetWaitTimeout for the volume of an item: its TimeoutAnnotation, passed as override, replaces
+// the configured datamover timeout, which is then scaled by its size, when known
+func GetItemWaitTimeout(itemOperationTimeout metav1.Duration, override, size string) (time.Duration, error) {
+	var timeout time.Duration
+	var err error
+	if len(override) > 0 {
+		timeout, err = time.ParseDuration(override)
+		if err != nil || timeout <= 0 {
+			return 0, errors.Errorf("invalid %s annotation %q, expected a positive duration", TimeoutAnnotation, override)
+		}
+	} else {
+		timeout, err = GetDatamoverTimeout()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	timeout, err = ScaleTimeoutBySize(timeout, size)
+	if err != nil {
+		return 0, err
+	}
+
+	if itemOperationTimeout.Duration > 0 && itemOperationTimeout.Duration < timeout {
+		return itemOperationTimeout.Duration, nil
+	}
+
+	return timeout, nil
+}
+
+// GetTimeoutOverride returns the TimeoutAnnotation of the first of objs carrying it, skipping nil objects
+func GetTimeoutOverride(objs ...*metav1.ObjectMeta) string {
+	for _, o := range objs {
+		if o == nil {
+			continue
+		}
+		if val := o.Annotations[TimeoutAnnotation]; len(val) > 0 {
+			return val
+		}
+	}
+
+	return ""
+}
+
+// ApplyOperationTimeout completes the progress of an async operation on a volume of the given size created at the
+// given time with an error once the operation timeout has passed, and returns that timeout
+func ApplyOperationTimeout(progress *velero.OperationProgress, kind string, created metav1.Time, itemOperationTimeout metav1.Duration, size string) (time.Duration, error) {
+	timeout, err := GetOperationTimeout(itemOperationTimeout, size)
+	if err != nil {
+		return 0, err
+	}
+
+	if !progress.Completed && time.Since(created.Time) > timeout {
+		progress.Err = fmt.Sprintf("%s did not complete within %s", kind, timeout)
+		progress.Completed = true
+	}
+
+	return timeout, nil
+}
@@ -0,0 +1,480 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMain(m *testing.M) {
+	// keep the tests from reading a plugin ConfigMap from whatever cluster is configured
+	loadConfigMapSettings = func(context.Context) (map[string]string, error) {
+		return map[string]string{}, nil
+	}
+	os.Exit(m.Run())
+}
+
+func TestParsePluginConfig(t *testing.T) {
+	testCases := []struct {
+		name             string
+		raw              string
+		expectError      bool
+		expectedSettings map[string]string
+	}{
+		{
+			name:             "empty config",
+			raw:              "",
+			expectedSettings: map[string]string{},
+		},
+		{
+			name: "all settings",
+			raw:  `{"dataMover":true,"timeout":"1h","snapshotRetentionDays":3}`,
+			expectedSettings: map[string]string{
+				VolumeSnapshotMoverEnv: "true",
+				DatamoverTimeout:       "1h",
+				SnapshotRetentionDays:  "3",
+			},
+		},
+		{
+			name:        "malformed JSON",
+			raw:         `{"dataMover":`,
+			expectError: true,
+		},
+		{
+			name:        "invalid timeout",
+			raw:         `{"timeout":"forever"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid delete timeout",
+			raw:         `{"deleteTimeout":"-1m"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid storageclass",
+			raw:         `{"storageClasses":["gp3","Not_A_Class"]}`,
+			expectError: true,
+		},
+		{
+			name:        "negative max in-flight VSBs",
+			raw:         `{"maxInFlightVSBs":-1}`,
+			expectError: true,
+		},
+		{
+			name:        "negative timeout per 100Gi",
+			raw:         `{"timeoutPer100Gi":"-1m"}`,
+			expectError: true,
+		},
+		{
+			name:        "API group without version",
+			raw:         `{"apiGroupVersion":"datamover.example.com"}`,
+			expectError: true,
+		},
+		{
+			name:        "unknown populated PVC policy",
+			raw:         `{"populatedPVCPolicy":"fail"}`,
+			expectError: true,
+		},
+		{
+			name:        "unknown snapshot dedup policy",
+			raw:         `{"snapshotDedupPolicy":"both"}`,
+			expectError: true,
+		},
+		{
+			name:        "non-positive poll interval",
+			raw:         `{"pollInterval":"0s"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid poll max interval",
+			raw:         `{"pollMaxInterval":"soon"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid placeholder priority class",
+			raw:         `{"placeholderPriorityClass":"Low Priority"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid volumesnapshotclass",
+			raw:         `{"volumeSnapshotClass":"csi_snapclass"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid excluded volumesnapshotclass",
+			raw:         `{"excludedVolumeSnapshotClasses":["csi-snapclass","Appliance Class"]}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid restic source secret",
+			raw:         `{"resticSourceSecret":"dr-restic"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid placeholder image",
+			raw:         `{"placeholderImage":"registry.k8s.io/pause 3.9"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid client QPS",
+			raw:         `{"clientQPS":0}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid placeholder memory",
+			raw:         `{"placeholderMemory":"lots"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid snapshot retention",
+			raw:         `{"snapshotRetentionDays":-1}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid metrics address",
+			raw:         `{"metricsAddress":"8085"}`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := ParsePluginConfig(tc.raw)
+			if tc.expectError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expectedSettings, cfg.settings())
+		})
+	}
+}
+
+func TestGetSetting(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time, cfg PluginConfig) {
+		configMapData, configMapFetchedAt, pluginConfig = data, fetchedAt, cfg
+	}(configMapData, configMapFetchedAt, pluginConfig)
+
+	days := 2
+	pluginConfig = PluginConfig{Timeout: "20m", SnapshotRetentionDays: &days}
+	configMapData = map[string]string{DatamoverTimeout: "1h"}
+	configMapFetchedAt = time.Now()
+	t.Setenv(DatamoverTimeout, "30m")
+	t.Setenv(SnapshotRetentionDays, "3")
+
+	// ConfigMap wins over env var and JSON config
+	assert.Equal(t, "1h", getSetting(DatamoverTimeout))
+	// env var wins over JSON config
+	assert.Equal(t, "3", getSetting(SnapshotRetentionDays))
+
+	t.Setenv(SnapshotRetentionDays, "")
+	assert.Equal(t, "2", getSetting(SnapshotRetentionDays))
+	assert.Equal(t, "", getSetting(DatamoverPlaceholderPriorityClass))
+}
+
+func TestGetConfigMapSettings(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time, load func(context.Context) (map[string]string, error)) {
+		configMapData, configMapFetchedAt, loadConfigMapSettings = data, fetchedAt, load
+	}(configMapData, configMapFetchedAt, loadConfigMapSettings)
+
+	loads := 0
+	loadConfigMapSettings = func(context.Context) (map[string]string, error) {
+		loads++
+		return map[string]string{VolumeSnapshotMoverEnv: "true"}, nil
+	}
+	configMapData = nil
+
+	assert.Equal(t, "true", getConfigMapSettings()[VolumeSnapshotMoverEnv])
+	assert.Equal(t, "true", getConfigMapSettings()[VolumeSnapshotMoverEnv])
+	assert.Equal(t, 1, loads)
+
+	// the last known settings are kept when the ConfigMap can't be read
+	loadConfigMapSettings = func(context.Context) (map[string]string, error) {
+		return nil, errors.New("forbidden")
+	}
+	configMapFetchedAt = time.Time{}
+	assert.Equal(t, "true", getConfigMapSettings()[VolumeSnapshotMoverEnv])
+}
+
+func TestDataMoverCase(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time, cfg PluginConfig) {
+		configMapData, configMapFetchedAt, pluginConfig = data, fetchedAt, cfg
+	}(configMapData, configMapFetchedAt, pluginConfig)
+
+	pluginConfig = PluginConfig{}
+	configMapFetchedAt = time.Now()
+	t.Setenv(VolumeSnapshotMoverEnv, "true")
+
+	configMapData = map[string]string{VolumeSnapshotMoverEnv: "false"}
+	assert.False(t, DataMoverCase())
+
+	// removing the key from the ConfigMap falls back to the env var
+	configMapData = map[string]string{}
+	assert.True(t, DataMoverCase())
+}
+
+func TestGetRestoreVolumeSnapshotClass(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{}
+	configMapFetchedAt = time.Now()
+	assert.Equal(t, "backed-up-class", GetRestoreVolumeSnapshotClass("backed-up-class"))
+
+	configMapData = map[string]string{DatamoverVolumeSnapshotClass: "configured-class"}
+	assert.Equal(t, "configured-class", GetRestoreVolumeSnapshotClass("backed-up-class"))
+}
+
+func TestGetClientQPSAndBurst(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time, cfg PluginConfig) {
+		configMapData, configMapFetchedAt, pluginConfig = data, fetchedAt, cfg
+	}(configMapData, configMapFetchedAt, pluginConfig)
+
+	pluginConfig = PluginConfig{}
+	assert.Equal(t, float32(DefaultClientQPS), GetClientQPS())
+	assert.Equal(t, DefaultClientBurst, GetClientBurst())
+
+	burst := 100
+	pluginConfig = PluginConfig{ClientBurst: &burst}
+	t.Setenv(DatamoverClientQPS, "50")
+	assert.Equal(t, float32(50), GetClientQPS())
+	assert.Equal(t, 100, GetClientBurst())
+
+	// the ConfigMap is read with the rate limited clients, so it can't set their budget
+	configMapData = map[string]string{DatamoverClientQPS: "5"}
+	configMapFetchedAt = time.Now()
+	assert.Equal(t, float32(50), GetClientQPS())
+
+	// the flags of the plugin process take precedence, unless non-positive
+	defer SetClientRateLimits(0, 0)
+	SetClientRateLimits(80.5, 160)
+	assert.Equal(t, float32(80.5), GetClientQPS())
+	assert.Equal(t, 160, GetClientBurst())
+
+	SetClientRateLimits(0, -1)
+	assert.Equal(t, float32(50), GetClientQPS())
+	assert.Equal(t, 100, GetClientBurst())
+}
+
+func TestGetMetricsAddress(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time, cfg PluginConfig) {
+		configMapData, configMapFetchedAt, pluginConfig = data, fetchedAt, cfg
+	}(configMapData, configMapFetchedAt, pluginConfig)
+
+	pluginConfig = PluginConfig{}
+	assert.Empty(t, GetMetricsAddress())
+
+	pluginConfig = PluginConfig{MetricsAddress: ":8085"}
+	assert.Equal(t, ":8085", GetMetricsAddress())
+
+	// the metrics server is started before the ConfigMap is read
+	configMapData = map[string]string{DatamoverMetricsAddress: ":9090"}
+	configMapFetchedAt = time.Now()
+	assert.Equal(t, ":8085", GetMetricsAddress())
+}
+
+func TestGetWaitTimeout(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{DatamoverTimeout: "30m"}
+	configMapFetchedAt = time.Now()
+
+	testCases := []struct {
+		name                 string
+		itemOperationTimeout time.Duration
+		expected             time.Duration
+	}{
+		{name: "no item operation timeout", expected: 30 * time.Minute},
+		{name: "item operation timeout caps the datamover timeout", itemOperationTimeout: 10 * time.Minute, expected: 10 * time.Minute},
+		{name: "longer item operation timeout", itemOperationTimeout: time.Hour, expected: 30 * time.Minute},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := GetWaitTimeout(metav1.Duration{Duration: tc.itemOperationTimeout})
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestGetDeleteTimeout(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{}
+	configMapFetchedAt = time.Now()
+
+	timeout, err := GetDeleteTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, timeout)
+
+	configMapData = map[string]string{DatamoverDeleteTimeout: "5m"}
+	timeout, err = GetDeleteTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, timeout)
+
+	configMapData = map[string]string{DatamoverDeleteTimeout: "0s"}
+	_, err = GetDeleteTimeout()
+	assert.Error(t, err)
+}
+
+func TestGetItemWaitTimeout(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{DatamoverTimeout: "30m", DatamoverTimeoutPer100Gi: "10m"}
+	configMapFetchedAt = time.Now()
+
+	testCases := []struct {
+		name                 string
+		itemOperationTimeout time.Duration
+		override             string
+		size                 string
+		expected             time.Duration
+		wantErr              bool
+	}{
+		{name: "no override", expected: 30 * time.Minute},
+		{name: "override replaces the datamover timeout", override: "4h", expected: 4 * time.Hour},
+		{name: "item operation timeout caps the override", itemOperationTimeout: time.Hour, override: "4h", expected: time.Hour},
+		{name: "invalid override", override: "forever", wantErr: true},
+		{name: "non-positive override", override: "0s", wantErr: true},
+		{name: "size scales the datamover timeout", size: "2Ti", expected: 30*time.Minute + 12288*time.Second},
+		{name: "size scales the override", override: "1h", size: "50Gi", expected: 65 * time.Minute},
+		{name: "item operation timeout caps the scaled timeout", itemOperationTimeout: time.Hour, size: "1Ti", expected: time.Hour},
+		{name: "invalid size", size: "huge", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := GetItemWaitTimeout(metav1.Duration{Duration: tc.itemOperationTimeout}, tc.override, tc.size)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestScaleTimeoutBySize(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{}
+	configMapFetchedAt = time.Now()
+
+	// unset keeps the timeout flat
+	timeout, err := ScaleTimeoutBySize(10*time.Minute, "5Ti")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, timeout)
+
+	configMapData = map[string]string{DatamoverTimeoutPer100Gi: "5m"}
+	timeout, err = ScaleTimeoutBySize(10*time.Minute, "500Gi")
+	assert.NoError(t, err)
+	assert.Equal(t, 35*time.Minute, timeout)
+
+	timeout, err = ScaleTimeoutBySize(10*time.Minute, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, timeout)
+
+	configMapData = map[string]string{DatamoverTimeoutPer100Gi: "-5m"}
+	_, err = ScaleTimeoutBySize(10*time.Minute, "500Gi")
+	assert.Error(t, err)
+}
+
+func TestGetTimeoutOverride(t *testing.T) {
+	vsc := &metav1.ObjectMeta{Annotations: map[string]string{TimeoutAnnotation: "2h"}}
+	pvc := &metav1.ObjectMeta{Annotations: map[string]string{TimeoutAnnotation: "4h"}}
+
+	assert.Equal(t, "2h", GetTimeoutOverride(vsc, pvc))
+	assert.Equal(t, "4h", GetTimeoutOverride(nil, &metav1.ObjectMeta{}, pvc))
+	assert.Equal(t, "", GetTimeoutOverride(&metav1.ObjectMeta{}))
+}
+
+func TestApplyOperationTimeout(t *testing.T) {
+	itemOperationTimeout := metav1.Duration{Duration: time.Hour}
+
+	progress := velero.OperationProgress{}
+	timeout, err := ApplyOperationTimeout(&progress, "VolumeSnapshotBackup", metav1.NewTime(time.Now().Add(-time.Minute)), itemOperationTimeout, "")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, timeout)
+	assert.False(t, progress.Completed)
+
+	progress = velero.OperationProgress{}
+	_, err = ApplyOperationTimeout(&progress, "VolumeSnapshotBackup", metav1.NewTime(time.Now().Add(-2*time.Hour)), itemOperationTimeout, "")
+	assert.Nil(t, err)
+	assert.True(t, progress.Completed)
+	assert.Equal(t, "VolumeSnapshotBackup did not complete within 1h0m0s", progress.Err)
+
+	// operations that completed on their own keep their result
+	progress = velero.OperationProgress{Completed: true}
+	_, err = ApplyOperationTimeout(&progress, "VolumeSnapshotBackup", metav1.NewTime(time.Now().Add(-2*time.Hour)), itemOperationTimeout, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "", progress.Err)
+
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	// without an item operation timeout the datamover timeout applies, scaled by the size of the volume
+	configMapData = map[string]string{DatamoverTimeout: "10m", DatamoverTimeoutPer100Gi: "10m"}
+	configMapFetchedAt = time.Now()
+	progress = velero.OperationProgress{}
+	timeout, err = ApplyOperationTimeout(&progress, "VolumeSnapshotRestore", metav1.NewTime(time.Now().Add(-time.Hour)), metav1.Duration{}, "1000Gi")
+	assert.Nil(t, err)
+	assert.Equal(t, 110*time.Minute, timeout)
+	assert.False(t, progress.Completed)
+}
+
+func TestParsePluginConfigAPITransport(t *testing.T) {
+	_, err := ParsePluginConfig(`{"apiProxy": "https://proxy.example.com:3128", "apiCA": ` + strconv.Quote(string(newTestCAPEM(t, "proxy"))) + `}`)
+	assert.NoError(t, err)
+
+	for _, raw := range []string{`{"apiProxy": "proxy.example.com:3128"}`, `{"apiProxy": "ftp://proxy.example.com"}`, `{"apiCA": "not a certificate"}`} {
+		_, err := ParsePluginConfig(raw)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestGetRestoreResticSecretName(t *testing.T) {
+	mapping := map[string]string{
+		"prod-restic-secret":  "staging-restic-secret",
+		"empty-restic-secret": "",
+	}
+
+	assert.Equal(t, "staging-restic-secret", GetRestoreResticSecretName("prod-restic-secret", mapping))
+	assert.Equal(t, "other-restic-secret", GetRestoreResticSecretName("other-restic-secret", mapping))
+	assert.Equal(t, "empty-restic-secret", GetRestoreResticSecretName("empty-restic-secret", mapping))
+	assert.Equal(t, "prod-restic-secret", GetRestoreResticSecretName("prod-restic-secret", nil))
+}
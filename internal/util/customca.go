@@ -0,0 +1,197 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+// customCAKey is the key of the CA bundle in custom CA secrets
+const customCAKey = "ca.crt"
+
+// GetCustomCABundle returns the CA bundle the mover verifies the object storage of the backup storage location with:
+// the caCert of the backup storage location, or else the DatamoverCustomCA setting. It returns nil if there is none.
+func GetCustomCABundle(bslName, namespace string, veleroClient client.Client) ([]byte, error) {
+	bsl := velerov1api.BackupStorageLocation{}
+	if err := veleroClient.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: bslName}, &bsl); err != nil {
+		return nil, errors.Wrapf(err, "error getting backup storage location %s/%s", namespace, bslName)
+	}
+
+	if bsl.Spec.ObjectStorage != nil && len(bsl.Spec.ObjectStorage.CACert) > 0 {
+		return bsl.Spec.ObjectStorage.CACert, nil
+	}
+
+	if val := getSetting(DatamoverCustomCA); len(val) > 0 {
+		return []byte(val), nil
+	}
+
+	return nil, nil
+}
+
+// GetCustomCASecretName returns the name of the secret holding the CA bundle of a backup storage location
+func GetCustomCASecretName(bslName string) string {
+	return label.GetValidName(bslName + "-vsm-custom-ca")
+}
+
+// SyncCustomCASecret writes the CA bundle of the backup storage location to a secret in the velero namespace, where
+// the mover pods can mount it from, and returns the name of the secret. It returns an empty name if the backup storage
+// location has no CA bundle.
+func SyncCustomCASecret(bslName, protectedNS string) (string, error) {
+	kubeClient, _, crClient, err := clients.get()
+	if err != nil {
+		return "", err
+	}
+
+	bundle, err := GetCustomCABundle(bslName, protectedNS, crClient)
+	if err != nil || len(bundle) == 0 {
+		return "", err
+	}
+
+	name := GetCustomCASecretName(bslName)
+	existing, err := kubeClient.CoreV1().Secrets(protectedNS).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret := &corev1api.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: protectedNS,
+				Labels: map[string]string{
+					velerov1api.StorageLocationLabel: label.GetValidName(bslName),
+				},
+			},
+			Data: map[string][]byte{customCAKey: bundle},
+		}
+		_, err = kubeClient.CoreV1().Secrets(protectedNS).Create(context.TODO(), secret, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return "", errors.Wrapf(err, "error creating custom CA secret %s/%s", protectedNS, name)
+		}
+		return name, nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting custom CA secret %s/%s", protectedNS, name)
+	}
+
+	if !bytes.Equal(existing.Data[customCAKey], bundle) {
+		if existing.Data == nil {
+			existing.Data = map[string][]byte{}
+		}
+		existing.Data[customCAKey] = bundle
+		if _, err := kubeClient.CoreV1().Secrets(protectedNS).Update(context.TODO(), existing, metav1.UpdateOptions{}); err != nil {
+			return "", errors.Wrapf(err, "error updating custom CA secret %s/%s", protectedNS, name)
+		}
+	}
+
+	return name, nil
+}
+
+// GetRestoreBackupStorageLocation returns the backup storage location of the backup being restored
+func GetRestoreBackupStorageLocation(restore *velerov1api.Restore) (string, error) {
+	veleroClient, err := GetVeleroClient()
+	if err != nil {
+		return "", err
+	}
+
+	backup := velerov1api.Backup{}
+	if err := veleroClient.Get(context.TODO(), client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.BackupName}, &backup); err != nil {
+		return "", errors.Wrapf(err, "error getting backup %s of restore %s", restore.Spec.BackupName, restore.Name)
+	}
+
+	return backup.Spec.StorageLocation, nil
+}
+
+// ApplyCustomCAToReplicationSources sets the custom CA secret recorded on the VSB on its replicationsources, which the
+// data mover controller creates without one. It returns the number of replicationsources updated.
+func ApplyCustomCAToReplicationSources(vsb *datamoverv1alpha1.VolumeSnapshotBackup) (int, error) {
+	secretName := vsb.Annotations[CustomCASecretAnnotation]
+	if len(secretName) == 0 {
+		return 0, nil
+	}
+
+	volsyncClient, err := GetVolsyncClient()
+	if err != nil {
+		return 0, err
+	}
+
+	rsList, err := GetReplicationSourcesForVSB(vsb.Name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error listing replicationsources of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+
+	updated := 0
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		if rs.Spec.Restic == nil || rs.Spec.Restic.CustomCA.SecretName == secretName {
+			continue
+		}
+
+		rs.Spec.Restic.CustomCA.SecretName = secretName
+		rs.Spec.Restic.CustomCA.Key = customCAKey
+		if err := volsyncClient.Update(context.TODO(), rs); err != nil {
+			return updated, errors.Wrapf(err, "error setting the custom CA of replicationsource %s/%s", rs.Namespace, rs.Name)
+		}
+		updated++
+	}
+
+	return updated, nil
+}
+
+// ApplyCustomCAToReplicationDestinations sets the custom CA secret recorded on the VSR on its
+// replicationdestinations, which the data mover controller creates without one. It returns the number of
+// replicationdestinations updated.
+func ApplyCustomCAToReplicationDestinations(vsr *datamoverv1alpha1.VolumeSnapshotRestore) (int, error) {
+	secretName := vsr.Annotations[CustomCASecretAnnotation]
+	if len(secretName) == 0 {
+		return 0, nil
+	}
+
+	volsyncClient, err := GetVolsyncClient()
+	if err != nil {
+		return 0, err
+	}
+
+	rdList, err := GetReplicationDestinationsForVSR(vsr.Name)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error listing replicationdestinations of volumesnapshotrestore %s/%s", vsr.Namespace, vsr.Name)
+	}
+
+	updated := 0
+	for i := range rdList.Items {
+		rd := &rdList.Items[i]
+		if rd.Spec.Restic == nil || rd.Spec.Restic.CustomCA.SecretName == secretName {
+			continue
+		}
+
+		rd.Spec.Restic.CustomCA.SecretName = secretName
+		rd.Spec.Restic.CustomCA.Key = customCAKey
+		if err := volsyncClient.Update(context.TODO(), rd); err != nil {
+			return updated, errors.Wrapf(err, "error setting the custom CA of replicationdestination %s/%s", rd.Namespace, rd.Name)
+		}
+		updated++
+	}
+
+	return updated, nil
+}
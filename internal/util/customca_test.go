@@ -0,0 +1,129 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	volsyncv1alpha1 "github.com/backube/volsync/api/v1alpha1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+func TestSyncCustomCASecret(t *testing.T) {
+	newBSL := func(caCert []byte) *velerov1api.BackupStorageLocation {
+		return &velerov1api.BackupStorageLocation{
+			ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "openshift-adp"},
+			Spec: velerov1api.BackupStorageLocationSpec{
+				StorageType: velerov1api.StorageType{ObjectStorage: &velerov1api.ObjectStorageLocation{Bucket: "bucket", CACert: caCert}},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		bsl          *velerov1api.BackupStorageLocation
+		setting      string
+		expectedName string
+		expectedCA   string
+	}{
+		{
+			name:         "caCert of the backup storage location",
+			bsl:          newBSL([]byte("bsl-ca")),
+			setting:      "setting-ca",
+			expectedName: "default-vsm-custom-ca",
+			expectedCA:   "bsl-ca",
+		},
+		{
+			name:         "configured CA for backup storage locations without a caCert",
+			bsl:          newBSL(nil),
+			setting:      "setting-ca",
+			expectedName: "default-vsm-custom-ca",
+			expectedCA:   "setting-ca",
+		},
+		{
+			name: "no CA",
+			bsl:  newBSL(nil),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func(data map[string]string, fetchedAt time.Time) {
+				configMapData, configMapFetchedAt = data, fetchedAt
+			}(configMapData, configMapFetchedAt)
+			configMapData, configMapFetchedAt = map[string]string{DatamoverCustomCA: tc.setting}, time.Now()
+
+			scheme, err := NewScheme()
+			assert.NoError(t, err)
+			kubeClient := fake.NewSimpleClientset()
+			crClient := crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(tc.bsl).Build()
+			defer SetClients(kubeClient, snapshotFake.NewSimpleClientset(), crClient)()
+
+			name, err := SyncCustomCASecret("default", "openshift-adp")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedName, name)
+			if len(name) == 0 {
+				return
+			}
+
+			secret, err := kubeClient.CoreV1().Secrets("openshift-adp").Get(context.TODO(), name, metav1.GetOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedCA, string(secret.Data[customCAKey]))
+		})
+	}
+}
+
+func TestApplyCustomCAToReplicationSources(t *testing.T) {
+	newRS := func(name string, customCA string) runtime.Object {
+		return &volsyncv1alpha1.ReplicationSource{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openshift-adp", Labels: map[string]string{VSBLabel: "vsb-1"}},
+			Spec: volsyncv1alpha1.ReplicationSourceSpec{
+				Restic: &volsyncv1alpha1.ReplicationSourceResticSpec{CustomCA: volsyncv1alpha1.ReplicationSourceResticCA{SecretName: customCA}},
+			},
+		}
+	}
+
+	scheme, err := NewScheme()
+	assert.NoError(t, err)
+	crClient := crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(newRS("rs-1", ""), newRS("rs-2", "default-vsm-custom-ca")).Build()
+	defer SetClients(fake.NewSimpleClientset(), snapshotFake.NewSimpleClientset(), crClient)()
+
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{ObjectMeta: metav1.ObjectMeta{Name: "vsb-1", Namespace: "openshift-adp"}}
+	updated, err := ApplyCustomCAToReplicationSources(vsb)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, updated)
+
+	vsb.Annotations = map[string]string{CustomCASecretAnnotation: "default-vsm-custom-ca"}
+	updated, err = ApplyCustomCAToReplicationSources(vsb)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, updated)
+
+	rs := volsyncv1alpha1.ReplicationSource{}
+	assert.NoError(t, crClient.Get(context.TODO(), client.ObjectKey{Namespace: "openshift-adp", Name: "rs-1"}, &rs))
+	assert.Equal(t, volsyncv1alpha1.ReplicationSourceResticCA{SecretName: "default-vsm-custom-ca", Key: customCAKey}, rs.Spec.Restic.CustomCA)
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	"github.com/pkg/errors"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// SnapshotDedupPolicyNone moves the data of every snapshot of a PVC, the default
+	SnapshotDedupPolicyNone = "none"
+	// SnapshotDedupPolicyCSI leaves snapshotting PVCs to velero-plugin-for-csi: the plugin doesn't snapshot PVCs
+	// velero-plugin-for-csi already snapshotted, and only moves the data of its own snapshot of a PVC when
+	// velero-plugin-for-csi took none
+	SnapshotDedupPolicyCSI = "csi"
+	// SnapshotDedupPolicyVSM moves the data of the snapshot this plugin took of a PVC, the snapshot
+	// velero-plugin-for-csi took of it is backed up as a plain CSI snapshot
+	SnapshotDedupPolicyVSM = "vsm"
+)
+
+func validateSnapshotDedupPolicy(policy string) error {
+	switch policy {
+	case SnapshotDedupPolicyNone, SnapshotDedupPolicyCSI, SnapshotDedupPolicyVSM:
+		return nil
+	}
+	return errors.Errorf("unknown snapshot dedup policy %q, expected one of %s", policy,
+		strings.Join([]string{SnapshotDedupPolicyNone, SnapshotDedupPolicyCSI, SnapshotDedupPolicyVSM}, ", "))
+}
+
+// GetSnapshotDedupPolicy returns the configured DatamoverSnapshotDedupPolicy, SnapshotDedupPolicyNone if unset
+func GetSnapshotDedupPolicy() (string, error) {
+	policy := getSetting(DatamoverSnapshotDedupPolicy)
+	if len(policy) == 0 {
+		return SnapshotDedupPolicyNone, nil
+	}
+	if err := validateSnapshotDedupPolicy(policy); err != nil {
+		return "", errors.Wrapf(err, "invalid %s", DatamoverSnapshotDedupPolicy)
+	}
+	return policy, nil
+}
+
+// IsPluginSnapshot returns whether the volumesnapshot was taken by this plugin rather than velero-plugin-for-csi
+func IsPluginSnapshot(vs *snapshotv1api.VolumeSnapshot) bool {
+	_, ok := vs.Annotations[PluginSnapshotAnnotation]
+	return ok
+}
+
+// GetVolumeSnapshotOfPVCForBackup returns the name of the volumesnapshot a backup item action that ran earlier on the
+// PVC took of it for the backup, or an empty string if none did. velero-plugin-for-csi, like this plugin, records its
+// snapshot on the PVC it returns.
+func GetVolumeSnapshotOfPVCForBackup(pvc *corev1api.PersistentVolumeClaim, backup *velerov1api.Backup) string {
+	if pvc.Annotations[velerov1api.BackupNameLabel] != backup.Name {
+		return ""
+	}
+	return pvc.Annotations[VolumeSnapshotLabel]
+}
+
+// DuplicateSnapshotSkipReason returns why the data of the volumesnapshotcontent is not moved under the configured
+// DatamoverSnapshotDedupPolicy, or an empty string if it is. The data of a snapshot is not moved when the backup holds
+// another snapshot of the same PVC the policy prefers, so that whichever plugin's snapshot is processed first, a
+// single VSB is created per PVC.
+func DuplicateSnapshotSkipReason(snapCont *snapshotv1api.VolumeSnapshotContent, namespace string, backup *velerov1api.Backup, snapshotClient snapshotter.SnapshotV1Interface) (string, error) {
+	policy, err := GetSnapshotDedupPolicy()
+	if err != nil {
+		return "", err
+	}
+	if policy == SnapshotDedupPolicyNone || len(snapCont.Spec.VolumeSnapshotRef.Name) == 0 || len(namespace) == 0 {
+		return "", nil
+	}
+
+	vs, err := snapshotClient.VolumeSnapshots(namespace).Get(context.TODO(), snapCont.Spec.VolumeSnapshotRef.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get volumesnapshot %s/%s", namespace, snapCont.Spec.VolumeSnapshotRef.Name)
+	}
+	if vs.Spec.Source.PersistentVolumeClaimName == nil {
+		return "", nil
+	}
+
+	// the data of the snapshots of the plugin the policy prefers is always moved
+	pluginSnapshot := IsPluginSnapshot(vs)
+	if pluginSnapshot == (policy == SnapshotDedupPolicyVSM) {
+		return "", nil
+	}
+
+	selector := labels.SelectorFromSet(map[string]string{velerov1api.BackupNameLabel: label.GetValidName(backup.Name)})
+	snapshots, err := snapshotClient.VolumeSnapshots(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to list volumesnapshots of backup %s in namespace %s", backup.Name, namespace)
+	}
+
+	for i := range snapshots.Items {
+		other := &snapshots.Items[i]
+		if other.Name == vs.Name || other.DeletionTimestamp != nil || IsPluginSnapshot(other) == pluginSnapshot {
+			continue
+		}
+		if other.Spec.Source.PersistentVolumeClaimName == nil || *other.Spec.Source.PersistentVolumeClaimName != *vs.Spec.Source.PersistentVolumeClaimName {
+			continue
+		}
+
+		owner := "velero-plugin-for-csi"
+		if IsPluginSnapshot(other) {
+			owner = "the data mover plugin"
+		}
+		return fmt.Sprintf("the data of volumesnapshot %s taken of PVC %s/%s by %s is moved instead, per %s=%s",
+			other.Name, namespace, *vs.Spec.Source.PersistentVolumeClaimName, owner, DatamoverSnapshotDedupPolicy, policy), nil
+	}
+
+	return "", nil
+}
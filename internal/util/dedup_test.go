@@ -0,0 +1,123 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetSnapshotDedupPolicy(t *testing.T) {
+	t.Setenv(DatamoverSnapshotDedupPolicy, "")
+	policy, err := GetSnapshotDedupPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, SnapshotDedupPolicyNone, policy)
+
+	t.Setenv(DatamoverSnapshotDedupPolicy, SnapshotDedupPolicyCSI)
+	policy, err = GetSnapshotDedupPolicy()
+	assert.NoError(t, err)
+	assert.Equal(t, SnapshotDedupPolicyCSI, policy)
+
+	t.Setenv(DatamoverSnapshotDedupPolicy, "both")
+	_, err = GetSnapshotDedupPolicy()
+	assert.Error(t, err)
+}
+
+func TestGetVolumeSnapshotOfPVCForBackup(t *testing.T) {
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1"}}
+
+	pvc := &corev1api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"}}
+	assert.Equal(t, "", GetVolumeSnapshotOfPVCForBackup(pvc, backup))
+
+	pvc.Annotations = map[string]string{VolumeSnapshotLabel: "velero-pvc-1-abcde", velerov1api.BackupNameLabel: "backup-0"}
+	assert.Equal(t, "", GetVolumeSnapshotOfPVCForBackup(pvc, backup))
+
+	pvc.Annotations[velerov1api.BackupNameLabel] = "backup-1"
+	assert.Equal(t, "velero-pvc-1-abcde", GetVolumeSnapshotOfPVCForBackup(pvc, backup))
+}
+
+func TestDuplicateSnapshotSkipReason(t *testing.T) {
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "openshift-adp"}}
+	snapshot := func(name, pvc, backupName string, plugin bool) *snapshotv1api.VolumeSnapshot {
+		vs := &snapshotv1api.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "app-ns",
+				Labels:    map[string]string{velerov1api.BackupNameLabel: backupName},
+			},
+			Spec: snapshotv1api.VolumeSnapshotSpec{
+				Source: snapshotv1api.VolumeSnapshotSource{PersistentVolumeClaimName: &pvc},
+			},
+		}
+		if plugin {
+			vs.Annotations = map[string]string{PluginSnapshotAnnotation: "true"}
+		}
+		return vs
+	}
+	snapshotClient := snapshotFake.NewSimpleClientset(
+		snapshot("csi-pvc-1", "pvc-1", "backup-1", false),
+		snapshot("vsm-pvc-1", "pvc-1", "backup-1", true),
+		snapshot("csi-pvc-2", "pvc-2", "backup-1", false),
+		snapshot("vsm-pvc-3", "pvc-3", "backup-1", true),
+		snapshot("csi-pvc-3", "pvc-3", "backup-0", false),
+	)
+	vscFor := func(vs string) *snapshotv1api.VolumeSnapshotContent {
+		return &snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-" + vs},
+			Spec: snapshotv1api.VolumeSnapshotContentSpec{
+				VolumeSnapshotRef: corev1api.ObjectReference{Name: vs, Namespace: "app-ns"},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name           string
+		policy         string
+		volumeSnapshot string
+		skipped        bool
+		wantErr        bool
+	}{
+		{name: "no policy", volumeSnapshot: "vsm-pvc-1"},
+		{name: "csi policy skips the plugin snapshot", policy: SnapshotDedupPolicyCSI, volumeSnapshot: "vsm-pvc-1", skipped: true},
+		{name: "csi policy moves the csi snapshot", policy: SnapshotDedupPolicyCSI, volumeSnapshot: "csi-pvc-1"},
+		{name: "vsm policy skips the csi snapshot", policy: SnapshotDedupPolicyVSM, volumeSnapshot: "csi-pvc-1", skipped: true},
+		{name: "vsm policy moves the plugin snapshot", policy: SnapshotDedupPolicyVSM, volumeSnapshot: "vsm-pvc-1"},
+		{name: "csi snapshot without duplicate", policy: SnapshotDedupPolicyVSM, volumeSnapshot: "csi-pvc-2"},
+		{name: "duplicate of another backup", policy: SnapshotDedupPolicyCSI, volumeSnapshot: "vsm-pvc-3"},
+		{name: "missing volumesnapshot", policy: SnapshotDedupPolicyCSI, volumeSnapshot: "missing"},
+		{name: "invalid policy", policy: "both", volumeSnapshot: "vsm-pvc-1", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(DatamoverSnapshotDedupPolicy, tc.policy)
+			reason, err := DuplicateSnapshotSkipReason(vscFor(tc.volumeSnapshot), "app-ns", backup, snapshotClient.SnapshotV1())
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.skipped, len(reason) > 0)
+		})
+	}
+}
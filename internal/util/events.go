@@ -0,0 +1,95 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Reasons of the events the plugin records on backups and restores
+const (
+	EventReasonDataMoverCreated  = "DataMoverCreated"
+	EventReasonDataMoverFailed   = "DataMoverFailed"
+	EventReasonDataMoverTimedOut = "DataMoverTimedOut"
+	// EventReasonPreflightFailed is recorded once per backup or restore the data mover can't serve
+	EventReasonPreflightFailed = "DataMoverPreflightFailed"
+)
+
+// eventSourceComponent is the component the events of the plugin are reported from
+const eventSourceComponent = "velero-plugin-for-vsm"
+
+// RecordBackupEvent records an event on the backup. Events only surface data mover problems, so failing to record one
+// is logged and otherwise ignored.
+func RecordBackupEvent(backup *velerov1api.Backup, eventType, reason, message string, log logrus.FieldLogger) {
+	recordEvent(corev1api.ObjectReference{
+		APIVersion:      velerov1api.SchemeGroupVersion.String(),
+		Kind:            "Backup",
+		Namespace:       backup.Namespace,
+		Name:            backup.Name,
+		UID:             backup.UID,
+		ResourceVersion: backup.ResourceVersion,
+	}, eventType, reason, message, log)
+}
+
+// RecordRestoreEvent records an event on the restore, like RecordBackupEvent
+func RecordRestoreEvent(restore *velerov1api.Restore, eventType, reason, message string, log logrus.FieldLogger) {
+	recordEvent(corev1api.ObjectReference{
+		APIVersion:      velerov1api.SchemeGroupVersion.String(),
+		Kind:            "Restore",
+		Namespace:       restore.Namespace,
+		Name:            restore.Name,
+		UID:             restore.UID,
+		ResourceVersion: restore.ResourceVersion,
+	}, eventType, reason, message, log)
+}
+
+// recordEvent creates the event right away rather than through an event broadcaster, whose queued events would be lost
+// when velero stops the plugin process
+func recordEvent(ref corev1api.ObjectReference, eventType, reason, message string, log logrus.FieldLogger) {
+	kubeClient, _, err := GetClients()
+	if err != nil {
+		log.Warnf("failed to record %s event on %s %s/%s: %s", reason, ref.Kind, ref.Namespace, ref.Name, err.Error())
+		return
+	}
+
+	now := metav1.NewTime(time.Now())
+	event := &corev1api.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%v.%x", ref.Name, now.UnixNano()),
+			Namespace: ref.Namespace,
+		},
+		InvolvedObject: ref,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1api.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	if _, err := kubeClient.CoreV1().Events(ref.Namespace).Create(context.Background(), event, metav1.CreateOptions{}); err != nil {
+		log.Warnf("failed to record %s event on %s %s/%s: %s", reason, ref.Kind, ref.Namespace, ref.Name, err.Error())
+	}
+}
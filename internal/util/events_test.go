@@ -0,0 +1,63 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRecordBackupAndRestoreEvents(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	defer SetClients(kubeClient, snapshotFake.NewSimpleClientset(), crfake.NewClientBuilder().Build())()
+
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "openshift-adp", UID: "backup-uid"}}
+	RecordBackupEvent(backup, corev1api.EventTypeWarning, EventReasonDataMoverFailed, "volumesnapshotbackup openshift-adp/vsb-1 failed", logrus.New())
+
+	restore := &velerov1api.Restore{ObjectMeta: metav1.ObjectMeta{Name: "restore-1", Namespace: "openshift-adp", UID: "restore-uid"}}
+	RecordRestoreEvent(restore, corev1api.EventTypeNormal, EventReasonDataMoverCreated, "created volumesnapshotrestore openshift-adp/vsr-1", logrus.New())
+
+	events, err := kubeClient.CoreV1().Events("openshift-adp").List(context.Background(), metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, events.Items, 2)
+
+	for _, event := range events.Items {
+		switch event.InvolvedObject.Kind {
+		case "Backup":
+			assert.Equal(t, "backup-1", event.InvolvedObject.Name)
+			assert.Equal(t, backup.UID, event.InvolvedObject.UID)
+			assert.Equal(t, corev1api.EventTypeWarning, event.Type)
+			assert.Equal(t, EventReasonDataMoverFailed, event.Reason)
+		case "Restore":
+			assert.Equal(t, "restore-1", event.InvolvedObject.Name)
+			assert.Equal(t, corev1api.EventTypeNormal, event.Type)
+			assert.Equal(t, EventReasonDataMoverCreated, event.Reason)
+		default:
+			t.Errorf("unexpected event on %s", event.InvolvedObject.Kind)
+		}
+		assert.Equal(t, eventSourceComponent, event.Source.Component)
+	}
+}
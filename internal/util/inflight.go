@@ -0,0 +1,119 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// vsbSlotLock makes counting the in-flight VSBs of a backup and creating the next one atomic among the item actions
+// of the plugin process, so they don't exceed the limit together
+var vsbSlotLock sync.Mutex
+
+// countInFlightVolumeSnapshotBackups returns the number of VSBs of the backup still moving data
+func countInFlightVolumeSnapshotBackups(ctx context.Context, backup *velerov1api.Backup) (int, error) {
+	snapMoverClient, err := GetVolumeSnapshotMoverClient()
+	if err != nil {
+		return 0, err
+	}
+
+	vsbList := datamoverv1alpha1.VolumeSnapshotBackupList{}
+	err = snapMoverClient.List(ctx, &vsbList, client.MatchingLabels{BackupUIDLabel: string(backup.UID)})
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to list volumesnapshotbackups of backup %s", backup.Name)
+	}
+
+	inFlight := 0
+	for i := range vsbList.Items {
+		if vsbList.Items[i].DeletionTimestamp != nil {
+			continue
+		}
+		if completed, _, _ := GetVolumeSnapshotBackupPhaseResult(vsbList.Items[i].Status.Phase); !completed {
+			inFlight++
+		}
+	}
+
+	return inFlight, nil
+}
+
+// AcquireVolumeSnapshotBackupSlot waits until the backup has fewer in-flight VSBs than the configured
+// DatamoverMaxInFlightVSBs, or timeout elapses, and returns a func to call once the next VSB is created. The created VSB
+// itself then holds the slot until it completes. Without a limit it returns right away.
+func AcquireVolumeSnapshotBackupSlot(ctx context.Context, backup *velerov1api.Backup, timeout time.Duration, log logrus.FieldLogger) (func(), error) {
+	limit := GetMaxInFlightVSBs()
+	if limit == 0 {
+		return func() {}, nil
+	}
+
+	ctx, done := startWait(ctx, "volumesnapshotbackup_slot")
+	defer done()
+
+	vsbSlotLock.Lock()
+	transient := newTransientErrors()
+	err := pollWithBackoff(ctx, timeout, func(ctx context.Context) (bool, error) {
+		inFlight, err := countInFlightVolumeSnapshotBackups(ctx, backup)
+		if err != nil && transient.retry(err) {
+			log.Warnf("failed to count the in-flight volumesnapshotbackups, retrying: %s", err.Error())
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		transient.reset()
+
+		if inFlight >= limit {
+			log.Infof("Waiting for fewer than %d in-flight volumesnapshotbackups of backup %s, %d are in flight", limit, backup.Name, inFlight)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		vsbSlotLock.Unlock()
+		return nil, err
+	}
+
+	return vsbSlotLock.Unlock, nil
+}
+
+// TryAcquireVolumeSnapshotBackupSlot is AcquireVolumeSnapshotBackupSlot without waiting, for callers that must not
+// block. It reports whether a slot was free, in which case the returned func is to be called once the VSB is created.
+func TryAcquireVolumeSnapshotBackupSlot(ctx context.Context, backup *velerov1api.Backup) (func(), bool, error) {
+	limit := GetMaxInFlightVSBs()
+	if limit == 0 {
+		return func() {}, true, nil
+	}
+
+	// another item action is already waiting for a slot
+	if !vsbSlotLock.TryLock() {
+		return nil, false, nil
+	}
+	inFlight, err := countInFlightVolumeSnapshotBackups(ctx, backup)
+	if err != nil || inFlight >= limit {
+		vsbSlotLock.Unlock()
+		return nil, false, err
+	}
+
+	return vsbSlotLock.Unlock, true, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+func TestAcquireVolumeSnapshotBackupSlot(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{DatamoverPollInterval: "10ms", DatamoverMaxInFlightVSBs: "2"}
+	configMapFetchedAt = time.Now()
+
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "openshift-adp", UID: "backup-uid"}}
+	newVSB := func(name string, uid string, phase datamoverv1alpha1.VolumeSnapshotBackupPhase) *datamoverv1alpha1.VolumeSnapshotBackup {
+		return &datamoverv1alpha1.VolumeSnapshotBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "app", Labels: map[string]string{BackupUIDLabel: uid}},
+			Status:     datamoverv1alpha1.VolumeSnapshotBackupStatus{Phase: phase},
+		}
+	}
+
+	scheme, err := NewScheme()
+	assert.NoError(t, err)
+	crClient := crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		newVSB("vsb-1", "backup-uid", datamoverv1alpha1.SnapMoverBackupPhaseInProgress),
+		newVSB("vsb-2", "backup-uid", datamoverv1alpha1.SnapMoverBackupPhaseCompleted),
+		newVSB("vsb-3", "backup-uid", datamoverv1alpha1.SnapMoverBackupPhaseFailed),
+		newVSB("vsb-4", "other-backup-uid", datamoverv1alpha1.SnapMoverBackupPhaseInProgress),
+	).Build()
+	defer SetClients(fake.NewSimpleClientset(), snapshotFake.NewSimpleClientset(), crClient)()
+	log := logrus.New().WithField("fake", "test")
+
+	// only vsb-1 is in flight
+	release, err := AcquireVolumeSnapshotBackupSlot(context.TODO(), backup, time.Second, log)
+	assert.NoError(t, err)
+
+	// the slot is held until released
+	_, acquired, err := TryAcquireVolumeSnapshotBackupSlot(context.TODO(), backup)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	assert.NoError(t, crClient.Create(context.TODO(), newVSB("vsb-5", "backup-uid", "")))
+	release()
+
+	// vsb-1 and the new vsb-5 fill both slots
+	_, acquired, err = TryAcquireVolumeSnapshotBackupSlot(context.TODO(), backup)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	_, err = AcquireVolumeSnapshotBackupSlot(context.TODO(), backup, 50*time.Millisecond, log)
+	assert.Equal(t, wait.ErrWaitTimeout, err)
+
+	// a failed wait doesn't keep the slot
+	configMapData = map[string]string{DatamoverPollInterval: "10ms", DatamoverMaxInFlightVSBs: "3"}
+	release, acquired, err = TryAcquireVolumeSnapshotBackupSlot(context.TODO(), backup)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+	release()
+
+	// no limit
+	configMapData = map[string]string{}
+	release, err = AcquireVolumeSnapshotBackupSlot(context.TODO(), backup, time.Millisecond, log)
+	assert.NoError(t, err)
+	release()
+}
@@ -25,6 +25,8 @@ const (
 	CSIDeleteSnapshotSecretNamespace = "velero.io/csi-deletesnapshotsecret-namespace"
 	CSIVSCDeletionPolicy             = "velero.io/csi-vsc-deletion-policy"
 	VolumeSnapshotClassSelectorLabel = "velero.io/csi-volumesnapshot-class"
+	// VolumeSnapshotClassNameAnnotation records on a backed up volumesnapshot the volumesnapshotclass it was taken with
+	VolumeSnapshotClassNameAnnotation = "velero.io/csi-volumesnapshot-class-name"
 
 	// There is no release w/ these constants exported. Using the strings for now.
 	// CSI Labels volumesnapshotclass
@@ -36,6 +38,9 @@ const (
 	PrefixedSnapshotterSecretNameKey      = "csi.storage.k8s.io/snapshotter-secret-name"
 	PrefixedSnapshotterSecretNamespaceKey = "csi.storage.k8s.io/snapshotter-secret-namespace"
 
+	// VSMEnabledAnnotation set to false on a backup makes this plugin skip data movement for it
+	VSMEnabledAnnotation = "velero.io/vsm-enabled"
+
 	// VolumeSnapshotMover annotation keys
 	VolumeSnapshotMoverResticRepository       = "datamover.io/restic-repository"
 	VolumeSnapshotMoverSourcePVCName          = "datamover.io/source-pvc-name"
@@ -44,16 +49,221 @@ const (
 	VolumeSnapshotMoverVolumeSnapshotClass    = "datamover.io/source-pvc-volumesnapshotclass"
 	WaitVolumeSnapshotBackup                  = "datamover.io/wait-for-vsb"
 	VolumeSnapshotBackupVolumeSnapshotContent = "datamover.io/vsb-volumesnapshotcontent"
+	// VolumeSnapshotMoverSourcePVFSType records the fsType of the backed up volume, so it is restored with the same
+	VolumeSnapshotMoverSourcePVFSType = "datamover.io/source-pv-fstype"
+	// VolumeSnapshotMoverSourcePVCVolumeMode records on a VSB, and the VSR restoring it, the volumeMode of the backed
+	// up PVC
+	VolumeSnapshotMoverSourcePVCVolumeMode = "datamover.io/source-pvc-volume-mode"
+	// VolumeSnapshotMoverTenantResticSecret records on a VSB the <namespace>/<name> of the restic secret of the PVC
+	// namespace it was backed up with
+	VolumeSnapshotMoverTenantResticSecret = "datamover.io/tenant-restic-secret"
+
+	// ResticSecretAnnotation on a PVC names the restic secret in its namespace its data is moved with
+	ResticSecretAnnotation = "datamover.io/restic-secret"
+	// TenantResticSecretNamespaceLabel is set on the copy of a tenant restic secret in the velero namespace, with the
+	// namespace of the tenant secret
+	TenantResticSecretNamespaceLabel = "datamover.io/restic-secret-namespace"
+
+	// VaultResticSecretLabel marks the short-lived restic secrets holding a password resolved from Vault, which are
+	// deleted once their VSB/VSR is done. VolumeSnapshotMoverVaultBaseResticSecret records on a VSB the restic secret
+	// its short-lived secret was copied from.
+	VaultResticSecretLabel                   = "datamover.io/vault-restic-secret"
+	VolumeSnapshotMoverVaultBaseResticSecret = "datamover.io/vault-base-restic-secret"
+
+	// CustomCASecretAnnotation is set on a VSB/VSR with the secret in the velero namespace holding the CA bundle of its
+	// backup storage location, which is set on the replicationsources/replicationdestinations of the VSB/VSR
+	CustomCASecretAnnotation = "datamover.io/custom-ca-secret"
+
+	// MoverTypeAnnotation set on a backup selects the mover backend its data is moved with. It is recorded on the VSBs
+	// of the backup and carried over to their VSRs.
+	MoverTypeAnnotation = "datamover.io/mover-type"
+
+	// CSIFSTypeParameter, or the legacyFSTypeParameter, on a storageclass sets the fsType its volumes are formatted with
+	CSIFSTypeParameter    = "csi.storage.k8s.io/fstype"
+	legacyFSTypeParameter = "fstype"
+
+	// DataMoverSkippedReasonAnnotation is set on a backed up VSC whose data movement was skipped, with the reason why
+	DataMoverSkippedReasonAnnotation = "datamover.io/skipped-reason"
+
+	// PlaceholderForLabel is set on placeholder pods with the name of the VSB/VSR they reserve capacity for
+	PlaceholderForLabel = "datamover.io/placeholder-for"
+	// PruneForLabel is set on restic prune jobs with the name of the VSB whose repository they prune
+	PruneForLabel = "datamover.io/prune-for"
+
+	// ApprovalPendingAnnotation on a volumesnapshotcontent holds back the creation of its VSB until an operator removes
+	// it; RequireApprovalAnnotation set on a backup marks its volumesnapshotcontents
+	ApprovalPendingAnnotation = "datamover.io/approval-pending"
+	RequireApprovalAnnotation = "datamover.io/require-approval"
+
+	// TimeoutAnnotation set on a PVC or volumesnapshotcontent overrides the datamover timeout of the waits for that
+	// volume. It is carried over to the VSB and VSR of the volume.
+	TimeoutAnnotation = "datamover.io/timeout"
+
+	// SkipAnnotation set to true on a PVC keeps its data to its snapshot, no VSB is created for it
+	SkipAnnotation = "datamover.io/skip"
+
+	// PluginSnapshotAnnotation marks the volumesnapshots this plugin takes of PVCs, telling them apart from the ones of
+	// velero-plugin-for-csi
+	PluginSnapshotAnnotation = "datamover.io/plugin-snapshot"
+
+	// RestoreTopologyAnnotation set on a restore ("key=value,...") selects the storageclass the data mover provisions
+	// restored volumes with, see GetRestoreStorageClass
+	RestoreTopologyAnnotation = "datamover.io/restore-topology"
+	// RestoreAccessModeAnnotation set on a restore selects the access mode the PVCs restored by the data mover are
+	// created with, see GetRestoreAccessModes
+	RestoreAccessModeAnnotation = "datamover.io/restore-access-mode"
+	// RestoreSizeAnnotation set on a restore ("<namespace>/<pvc>=<size>,...") restores the PVCs of data mover backups
+	// into larger volumes, see GetRestorePVCSize
+	RestoreSizeAnnotation = "datamover.io/restore-sizes"
+
+	// VolumeSnapshotRetainedLabel marks a source snapshot retained after data movement,
+	// VolumeSnapshotRetainUntilAnnotation records when its retention expires
+	VolumeSnapshotRetainedLabel         = "datamover.io/snapshot-retained"
+	VolumeSnapshotRetainUntilAnnotation = "datamover.io/snapshot-retain-until"
+	// SnapshotRetentionAnnotation set on a backup overrides the SnapshotRetentionDays setting for its source snapshots
+	SnapshotRetentionAnnotation = "datamover.io/snapshot-retention-days"
+
+	// DataMoverRestoreSummaryAnnotation is set on the Restore once all of its VolumeSnapshotRestores have settled
+	DataMoverRestoreSummaryAnnotation = "datamover.io/restore-summary"
+	// DataMoverRestoreSkippedAnnotation lists the VSBs of a restore no VolumeSnapshotRestore was created for
+	DataMoverRestoreSkippedAnnotation = "datamover.io/restore-skipped"
+	// RepositoryStatsAnnotation is set on the Backup at its start with the statistics of the repositories the plugin
+	// moved data to, derived from the VSBs tracked in the cluster
+	RepositoryStatsAnnotation = "datamover.io/repository-stats"
+
+	// MustIncludeAdditionalItemAnnotation tells velero to back up the additional items returned for an item even if
+	// their resource is excluded from the backup
+	MustIncludeAdditionalItemAnnotation = "backup.velero.io/must-include-additional-items"
 
 	// Env vars
 	VolumeSnapshotMoverEnv = "VOLUME_SNAPSHOT_MOVER"
 	DatamoverTimeout       = "DATAMOVER_TIMEOUT"
+	DatamoverDeleteTimeout = "DATAMOVER_DELETE_TIMEOUT"
+	SnapshotRetentionDays  = "DATAMOVER_SNAPSHOT_RETENTION_DAYS"
+	VSMPluginConfigEnv     = "VSM_PLUGIN_CONFIG"
+	DatamoverPollInterval  = "DATAMOVER_POLL_INTERVAL"
+	// DatamoverPollMaxInterval caps the interval between polls, which doubles after every poll
+	DatamoverPollMaxInterval = "DATAMOVER_POLL_MAX_INTERVAL"
+	// DatamoverWaitMaxErrors is the number of consecutive transient API errors a wait retries before failing
+	DatamoverWaitMaxErrors = "DATAMOVER_WAIT_MAX_ERRORS"
+	// DatamoverTimeoutPer100Gi lengthens the datamover timeout of a volume by this duration for every 100Gi of its size
+	DatamoverTimeoutPer100Gi = "DATAMOVER_TIMEOUT_PER_100GI"
+	// DatamoverMaxInFlightVSBs limits the VSBs of a backup moving data at the same time, further VSBs are only created
+	// once earlier ones complete
+	DatamoverMaxInFlightVSBs = "DATAMOVER_MAX_IN_FLIGHT_VSBS"
+	// DatamoverPlaceholderPriorityClass enables placeholder pods for mover pods, the other placeholder settings
+	// override their image and resource requests
+	DatamoverPlaceholderPriorityClass = "DATAMOVER_PLACEHOLDER_PRIORITY_CLASS"
+	DatamoverPlaceholderImage         = "DATAMOVER_PLACEHOLDER_IMAGE"
+	DatamoverPlaceholderCPU           = "DATAMOVER_PLACEHOLDER_CPU"
+	DatamoverPlaceholderMemory        = "DATAMOVER_PLACEHOLDER_MEMORY"
+	// DatamoverVolumeSnapshotClass names the volumesnapshotclass restored volumes are snapshotted with, instead of
+	// the one recorded at backup time
+	DatamoverVolumeSnapshotClass = "DATAMOVER_VOLUMESNAPSHOTCLASS"
+	VeleroNamespaceEnv           = "VELERO_NAMESPACE"
+	DatamoverClientQPS           = "DATAMOVER_CLIENT_QPS"
+	DatamoverClientBurst         = "DATAMOVER_CLIENT_BURST"
+	DatamoverRequireApproval     = "DATAMOVER_REQUIRE_APPROVAL"
+	// DatamoverSnapshotPVCs makes the plugin snapshot PVCs itself, for running without velero-plugin-for-csi
+	DatamoverSnapshotPVCs = "DATAMOVER_SNAPSHOT_PVCS"
+	// DatamoverExcludedVolumeSnapshotClasses lists the volumesnapshotclasses whose snapshots are never moved, for
+	// storage that replicates its snapshots itself
+	DatamoverExcludedVolumeSnapshotClasses = "DATAMOVER_EXCLUDED_VOLUMESNAPSHOTCLASSES"
+	// DatamoverStorageClasses restricts data movement to the volumes of these storageclasses, the volumes of
+	// DatamoverExcludedStorageClasses are never moved. Both are comma separated lists.
+	DatamoverStorageClasses         = "DATAMOVER_STORAGECLASSES"
+	DatamoverExcludedStorageClasses = "DATAMOVER_EXCLUDED_STORAGECLASSES"
+	// DatamoverResticSourceSecret is the <namespace>/<name> of the secret restic secrets missing on restore are
+	// recreated from
+	DatamoverResticSourceSecret = "DATAMOVER_RESTIC_SOURCE_SECRET"
+	// DatamoverVaultAddress enables resolving the restic repository password from HashiCorp Vault, the other Vault
+	// settings configure how it is read
+	DatamoverVaultAddress    = "DATAMOVER_VAULT_ADDR"
+	DatamoverVaultSecretPath = "DATAMOVER_VAULT_SECRET_PATH"
+	DatamoverVaultRole       = "DATAMOVER_VAULT_ROLE"
+	DatamoverVaultAuthPath   = "DATAMOVER_VAULT_AUTH_PATH"
+	// DatamoverCustomCA is the PEM CA bundle the mover verifies object storage with, for backup storage locations
+	// without a caCert
+	DatamoverCustomCA = "DATAMOVER_CUSTOM_CA"
+	// DatamoverMoverType selects the mover backend of backups without a MoverTypeAnnotation, for volumes of
+	// storageclasses without one in DatamoverStorageClassMoverTypes ("storageclass=type,...")
+	DatamoverMoverType              = "DATAMOVER_MOVER_TYPE"
+	DatamoverStorageClassMoverTypes = "DATAMOVER_STORAGECLASS_MOVER_TYPES"
+	// DatamoverCacheCapacity, DatamoverCacheStorageClass and DatamoverCacheAccessMode configure the restic cache
+	// volumes of the mover pods
+	DatamoverCacheCapacity     = "DATAMOVER_CACHE_CAPACITY"
+	DatamoverCacheStorageClass = "DATAMOVER_CACHE_STORAGECLASS"
+	DatamoverCacheAccessMode   = "DATAMOVER_CACHE_ACCESS_MODE"
+	// DatamoverPruneOnDelete makes deleting a backup forget and prune the restic snapshots of its VSBs, with jobs
+	// running the restic binary of DatamoverPruneImage
+	DatamoverPruneOnDelete = "DATAMOVER_PRUNE_ON_DELETE"
+	DatamoverPruneImage    = "DATAMOVER_PRUNE_IMAGE"
+	// DatamoverDeleteOrphans deletes VSBs and VSRs whose velero backup or restore no longer exists, at backup start
+	DatamoverDeleteOrphans = "DATAMOVER_DELETE_ORPHANS"
+	// DatamoverMetricsAddress is the address the plugin process serves its prometheus metrics on, unset disables them
+	DatamoverMetricsAddress = "DATAMOVER_METRICS_ADDRESS"
+	// DatamoverAPIGroupVersion is the "<group>/<version>" the datamover CRDs are served with, for forks of
+	// volume-snapshot-mover serving them with another group. Unset, it is discovered on the cluster.
+	DatamoverAPIGroupVersion = "DATAMOVER_API_GROUP_VERSION"
+	// DatamoverNamespaceOptIn restricts data movement to the namespaces labeled with NamespaceDataMoverEnabledLabel
+	DatamoverNamespaceOptIn = "DATAMOVER_NAMESPACE_OPT_IN"
+	// DatamoverSnapshotDedupPolicy selects, when both this plugin and velero-plugin-for-csi snapshot the PVCs of a
+	// backup, whose snapshot of a PVC the data mover moves, see SnapshotDedupPolicyCSI and SnapshotDedupPolicyVSM
+	DatamoverSnapshotDedupPolicy = "DATAMOVER_SNAPSHOT_DEDUP_POLICY"
+	// DatamoverPopulatedPVCPolicy selects whether the data of PVCs provisioned by volume populators is moved, see
+	// PopulatedPVCPolicySkip and PopulatedPVCPolicyMove
+	DatamoverPopulatedPVCPolicy = "DATAMOVER_POPULATED_PVC_POLICY"
+	// DatamoverFsBackupFallback makes the plugin, when it snapshots PVCs itself, opt the volumes of PVCs whose
+	// provisioner has no volumesnapshotclass into velero's file system backup instead of failing
+	DatamoverFsBackupFallback = "DATAMOVER_FS_BACKUP_FALLBACK"
+	// DatamoverControllerDeployment is the name of the volume-snapshot-mover controller deployment in the velero
+	// namespace, which the preflight checks of backups and restores verify is available
+	DatamoverControllerDeployment = "DATAMOVER_CONTROLLER_DEPLOYMENT"
+	// DatamoverVerifyRepository makes restores check that the restic repository of a VSB is reachable with the
+	// credentials of its restic secret before creating its VSR
+	DatamoverVerifyRepository = "DATAMOVER_VERIFY_REPOSITORY"
+	// DatamoverAPIProxy is the URL of the proxy the plugin connects to the Kubernetes API server through, in place of
+	// the HTTPS_PROXY and NO_PROXY env vars
+	DatamoverAPIProxy = "DATAMOVER_API_PROXY"
+	// DatamoverAPICA is a PEM CA bundle the plugin trusts the Kubernetes API server, or the proxy fronting it, with on
+	// top of the CA of its kubeconfig or service account
+	DatamoverAPICA = "DATAMOVER_API_CA"
+
+	// PluginConfigLabel and VSMPluginConfigLabel identify the ConfigMap holding the plugin configuration
+	PluginConfigLabel    = "velero.io/plugin-config"
+	VSMPluginConfigLabel = "velero.io/vsm"
+	// VSMSecretMappingLabel identifies, along with PluginConfigLabel, the ConfigMap mapping restic secret names
+	// recorded at backup time to the secrets restores use
+	VSMSecretMappingLabel = "velero.io/vsm-secret-mapping"
+	// VSMNamespacePolicyLabel identifies, along with PluginConfigLabel, the ConfigMap holding the data mover settings
+	// of namespaces
+	VSMNamespacePolicyLabel = "velero.io/vsm-namespace-policy"
+	// ChangeStorageClassLabel identifies, along with PluginConfigLabel, velero's ConfigMap mapping the storageclasses of
+	// backed up PVCs to the storageclasses they are restored with
+	ChangeStorageClassLabel = "velero.io/change-storage-class"
+	// VSMAccessModeMappingLabel identifies, along with PluginConfigLabel, the ConfigMap mapping the access modes of
+	// backed up PVCs to the access modes the data mover restores them with
+	VSMAccessModeMappingLabel = "velero.io/vsm-access-mode-mapping"
+	// VSMSizeMappingLabel identifies, along with PluginConfigLabel, the ConfigMap mapping backed up PVCs, keyed by
+	// <namespace>.<pvc>, to the size they are restored with
+	VSMSizeMappingLabel = "velero.io/vsm-size-mapping"
 
 	// BackupNameLabel is the label key used to identify a backup by name.
 	BackupNameLabel = "velero.io/backup-name"
+	// BackupUIDLabel is the label key used to identify a backup by uid.
+	BackupUIDLabel = "velero.io/backup-uid"
 	// RestoreNameLabel is the label key used to identify a restore by name.
-	RestoreNameLabel           = "velero.io/restore-name"
+	RestoreNameLabel = "velero.io/restore-name"
+	// RestoreUIDLabel is the label key used to identify a restore by uid.
+	RestoreUIDLabel            = "velero.io/restore-uid"
 	PersistentVolumeClaimLabel = "velero.io/persistent-volume-claim-name"
 	VolumeSnapshotBackupLabel  = "velero.io/vsb-name"
 	VSBLabel                   = "datamover.oadp.openshift.io/vsb"
+	VSRLabel                   = "datamover.oadp.openshift.io/vsr"
+	// ResticSecretLabel, along with velero's storage location label, marks the restic secret of a backup storage
+	// location that doesn't follow the <bsl>-volsync-restic naming convention
+	ResticSecretLabel = "datamover.oadp.openshift.io/restic-secret"
+	// NamespaceDataMoverEnabledLabel set to true on a namespace opts its volumes in to data movement, see
+	// DatamoverNamespaceOptIn
+	NamespaceDataMoverEnabledLabel = "datamover.oadp.openshift.io/enabled"
 )
@@ -0,0 +1,61 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+)
+
+// Log fields correlating the messages of the item operations velero runs in parallel
+const (
+	LogFieldBackup                = "backup"
+	LogFieldBackupUID             = "backupUID"
+	LogFieldRestore               = "restore"
+	LogFieldRestoreUID            = "restoreUID"
+	LogFieldPVC                   = "pvc"
+	LogFieldVolumeSnapshot        = "volumesnapshot"
+	LogFieldVolumeSnapshotContent = "volumesnapshotcontent"
+	LogFieldVolumeSnapshotBackup  = "volumesnapshotbackup"
+	LogFieldVolumeSnapshotRestore = "volumesnapshotrestore"
+	LogFieldOperationID           = "operationID"
+)
+
+// BackupLogger returns log with the fields of the backup, named like velero logs it. Velero shares an item action
+// between its calls, so actions log through a copy of themselves holding this logger rather than replacing theirs.
+func BackupLogger(log logrus.FieldLogger, backup *velerov1api.Backup) logrus.FieldLogger {
+	return log.WithFields(logrus.Fields{
+		LogFieldBackup:    backup.Namespace + "/" + backup.Name,
+		LogFieldBackupUID: string(backup.UID),
+	})
+}
+
+// RestoreLogger returns log with the fields of the restore, named like velero logs it
+func RestoreLogger(log logrus.FieldLogger, restore *velerov1api.Restore) logrus.FieldLogger {
+	return log.WithFields(logrus.Fields{
+		LogFieldRestore:    restore.Namespace + "/" + restore.Name,
+		LogFieldRestoreUID: string(restore.UID),
+	})
+}
+
+// NamespacedLogField returns the value of a log field naming a namespaced object
+func NamespacedLogField(namespace, name string) string {
+	if len(namespace) == 0 {
+		return name
+	}
+	return namespace + "/" + name
+}
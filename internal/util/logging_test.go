@@ -0,0 +1,47 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBackupAndRestoreLogger(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "openshift-adp", UID: "backup-uid"}}
+	BackupLogger(logger, backup).WithField(LogFieldPVC, NamespacedLogField("app", "data")).Info("snapshotting")
+	assert.Equal(t, logrus.Fields{
+		LogFieldBackup:    "openshift-adp/backup-1",
+		LogFieldBackupUID: "backup-uid",
+		LogFieldPVC:       "app/data",
+	}, hook.LastEntry().Data)
+
+	restore := &velerov1api.Restore{ObjectMeta: metav1.ObjectMeta{Name: "restore-1", Namespace: "openshift-adp", UID: "restore-uid"}}
+	RestoreLogger(logger, restore).WithField(LogFieldVolumeSnapshotContent, NamespacedLogField("", "snapcontent-1")).Info("restoring")
+	assert.Equal(t, logrus.Fields{
+		LogFieldRestore:               "openshift-adp/restore-1",
+		LogFieldRestoreUID:            "restore-uid",
+		LogFieldVolumeSnapshotContent: "snapcontent-1",
+	}, hook.LastEntry().Data)
+}
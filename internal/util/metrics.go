@@ -0,0 +1,100 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+)
+
+const metricsNamespace = "velero_vsm"
+
+var (
+	dataMoverCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "datamover_created_total",
+		Help:      "VolumeSnapshotBackups and VolumeSnapshotRestores created by the plugin",
+	}, []string{"kind"})
+	dataMoverFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "datamover_failures_total",
+		Help:      "Data mover operations that completed with an error",
+	}, []string{"kind"})
+	dataMoverMovedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "datamover_moved_bytes_total",
+		Help:      "Bytes of the volumes of the data mover operations that completed successfully",
+	}, []string{"kind"})
+	waitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "wait_duration_seconds",
+		Help:      "Duration of the synchronous waits of the item actions",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	}, []string{"wait"})
+	progressDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "progress_duration_seconds",
+		Help:      "Latency of the progress polls of data mover operations",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind"})
+
+	metricsRegistry = prometheus.NewRegistry()
+)
+
+func init() {
+	metricsRegistry.MustRegister(dataMoverCreated, dataMoverFailures, dataMoverMovedBytes, waitDuration, progressDuration)
+}
+
+// RecordDataMoverCreated counts a VSB or VSR created by the plugin
+func RecordDataMoverCreated(kind string) {
+	dataMoverCreated.WithLabelValues(kind).Inc()
+}
+
+// RecordDataMoverCompleted counts a data mover operation that completed, along with the bytes it moved when it
+// succeeded
+func RecordDataMoverCompleted(kind string, failed bool, movedBytes int64) {
+	if failed {
+		dataMoverFailures.WithLabelValues(kind).Inc()
+		return
+	}
+	dataMoverMovedBytes.WithLabelValues(kind).Add(float64(movedBytes))
+}
+
+// observeWaitDuration records how long the wait started at start took, meant to be deferred by the wait
+func observeWaitDuration(wait string, start time.Time) {
+	waitDuration.WithLabelValues(wait).Observe(time.Since(start).Seconds())
+}
+
+// ObserveProgressDuration records how long the progress poll of an operation started at start took
+func ObserveProgressDuration(kind string, start time.Time) {
+	progressDuration.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
+
+// ServeMetrics serves the plugin metrics at /metrics on the address until the plugin process exits. It returns the
+// error of listening on the address, e.g. when another plugin process already serves its metrics on it.
+func ServeMetrics(address string, log logrus.FieldLogger) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{ErrorLog: log}))
+
+	server := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return errors.WithStack(server.ListenAndServe())
+}
@@ -0,0 +1,50 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordDataMoverCompleted(t *testing.T) {
+	failures := testutil.ToFloat64(dataMoverFailures.WithLabelValues("volumesnapshotbackup"))
+	moved := testutil.ToFloat64(dataMoverMovedBytes.WithLabelValues("volumesnapshotbackup"))
+
+	RecordDataMoverCompleted("volumesnapshotbackup", false, 1<<30)
+	assert.Equal(t, moved+(1<<30), testutil.ToFloat64(dataMoverMovedBytes.WithLabelValues("volumesnapshotbackup")))
+	assert.Equal(t, failures, testutil.ToFloat64(dataMoverFailures.WithLabelValues("volumesnapshotbackup")))
+
+	// the bytes of failed operations were not moved
+	RecordDataMoverCompleted("volumesnapshotbackup", true, 1<<30)
+	assert.Equal(t, moved+(1<<30), testutil.ToFloat64(dataMoverMovedBytes.WithLabelValues("volumesnapshotbackup")))
+	assert.Equal(t, failures+1, testutil.ToFloat64(dataMoverFailures.WithLabelValues("volumesnapshotbackup")))
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	RecordDataMoverCreated("volumesnapshotrestore")
+	observeWaitDuration("volumesnapshotrestore_status", time.Now().Add(-time.Second))
+	ObserveProgressDuration("volumesnapshotrestore", time.Now())
+
+	count, err := testutil.GatherAndCount(metricsRegistry,
+		"velero_vsm_datamover_created_total", "velero_vsm_wait_duration_seconds", "velero_vsm_progress_duration_seconds")
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, count, 3)
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	corev1api "k8s.io/api/core/v1"
+	storagev1api "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	storagev1client "k8s.io/client-go/kubernetes/typed/storage/v1"
+)
+
+const (
+	DefaultPlaceholderImage  = "registry.k8s.io/pause:3.9"
+	DefaultPlaceholderCPU    = "500m"
+	DefaultPlaceholderMemory = "512Mi"
+)
+
+// PlaceholderSettings describes the low priority pods reserving node capacity for the mover pods of a VSB/VSR.
+// Placeholder pods are only created when PriorityClass is set.
+type PlaceholderSettings struct {
+	PriorityClass string
+	Image         string
+	Requests      corev1api.ResourceList
+}
+
+// GetPlaceholderSettings returns the configured placeholder pod settings
+func GetPlaceholderSettings() (PlaceholderSettings, error) {
+	settings := PlaceholderSettings{
+		PriorityClass: getSetting(DatamoverPlaceholderPriorityClass),
+		Image:         DefaultPlaceholderImage,
+		Requests:      corev1api.ResourceList{},
+	}
+
+	if image := getSetting(DatamoverPlaceholderImage); len(image) > 0 {
+		settings.Image = image
+	}
+
+	for name, setting := range map[corev1api.ResourceName]string{
+		corev1api.ResourceCPU:    DatamoverPlaceholderCPU,
+		corev1api.ResourceMemory: DatamoverPlaceholderMemory,
+	} {
+		val := getSetting(setting)
+		if len(val) == 0 {
+			val = map[corev1api.ResourceName]string{
+				corev1api.ResourceCPU:    DefaultPlaceholderCPU,
+				corev1api.ResourceMemory: DefaultPlaceholderMemory,
+			}[name]
+		}
+
+		quantity, err := resource.ParseQuantity(val)
+		if err != nil {
+			return PlaceholderSettings{}, errors.Wrapf(err, "invalid %s value %q", setting, val)
+		}
+		settings.Requests[name] = quantity
+	}
+
+	return settings, nil
+}
+
+// NewPlaceholderPod returns a pause pod in the protected namespace requesting the capacity a mover pod for the named
+// VSB/VSR needs. Its priority class is expected to have a negative value, so the pod stays pending and makes the
+// cluster autoscaler add a node, and the mover pod preempts it once scheduled.
+func NewPlaceholderPod(ownerName, protectedNamespace string, settings PlaceholderSettings) *corev1api.Pod {
+	gracePeriod := int64(0)
+	enabled, disabled := true, false
+
+	return &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "datamover-placeholder-",
+			Namespace:    protectedNamespace,
+			Labels: map[string]string{
+				PlaceholderForLabel: label.GetValidName(ownerName),
+			},
+		},
+		Spec: corev1api.PodSpec{
+			PriorityClassName:             settings.PriorityClass,
+			TerminationGracePeriodSeconds: &gracePeriod,
+			AutomountServiceAccountToken:  &disabled,
+			SecurityContext: &corev1api.PodSecurityContext{
+				RunAsNonRoot: &enabled,
+				SeccompProfile: &corev1api.SeccompProfile{
+					Type: corev1api.SeccompProfileTypeRuntimeDefault,
+				},
+			},
+			Containers: []corev1api.Container{
+				{
+					Name:  "placeholder",
+					Image: settings.Image,
+					Resources: corev1api.ResourceRequirements{
+						Requests: settings.Requests,
+					},
+					SecurityContext: &corev1api.SecurityContext{
+						AllowPrivilegeEscalation: &disabled,
+						Capabilities: &corev1api.Capabilities{
+							Drop: []corev1api.Capability{"ALL"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// CreatePlaceholderPod creates the placeholder pod for the named VSB/VSR when a placeholder priority class is configured
+func CreatePlaceholderPod(ownerName, protectedNamespace string, pods corev1client.PodsGetter, log logrus.FieldLogger) error {
+	settings, err := GetPlaceholderSettings()
+	if err != nil {
+		return err
+	}
+
+	if len(settings.PriorityClass) == 0 {
+		return nil
+	}
+
+	pod, err := pods.Pods(protectedNamespace).Create(context.TODO(), NewPlaceholderPod(ownerName, protectedNamespace, settings), metav1.CreateOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error creating placeholder pod for %s", ownerName)
+	}
+
+	log.Infof("Created placeholder pod %s/%s for %s", pod.Namespace, pod.Name, ownerName)
+	return nil
+}
+
+// DeletePlaceholderPods deletes the placeholder pods of the named VSB/VSR
+func DeletePlaceholderPods(ownerName, protectedNamespace string, pods corev1client.PodsGetter) error {
+	podList, err := pods.Pods(protectedNamespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(map[string]string{PlaceholderForLabel: label.GetValidName(ownerName)}).String(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error listing placeholder pods for %s", ownerName)
+	}
+
+	for _, pod := range podList.Items {
+		err := pods.Pods(protectedNamespace).Delete(context.TODO(), pod.Name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return errors.Wrapf(err, "error deleting placeholder pod %s/%s", pod.Namespace, pod.Name)
+		}
+	}
+
+	return nil
+}
+
+// GetRestoreStorageClass returns the storageclass the data mover provisions a restored volume with. Without a
+// RestoreTopologyAnnotation on the restore and a recorded fsType this is the storageclass of the backed up PVC.
+// Otherwise it is the first storageclass, by name, with the same provisioner whose allowed topologies include every
+// key=value of the annotation and that formats volumes with fsType, so the restored filesystem matches the backed up
+// one.
+func GetRestoreStorageClass(restore *velerov1api.Restore, sourceStorageClass, fsType string, storageClasses storagev1client.StorageClassesGetter) (string, error) {
+	topology := restore.Annotations[RestoreTopologyAnnotation]
+	if len(topology) == 0 && len(fsType) == 0 {
+		return sourceStorageClass, nil
+	}
+
+	requested, err := labels.ConvertSelectorToLabelsMap(topology)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid %s annotation %q on restore %s", RestoreTopologyAnnotation, topology, restore.Name)
+	}
+
+	source, err := storageClasses.StorageClasses().Get(context.TODO(), sourceStorageClass, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting storageclass %s to resolve its provisioner", sourceStorageClass)
+	}
+
+	if len(topology) == 0 && storageClassFSType(source) == fsType {
+		return source.Name, nil
+	}
+
+	scList, err := storageClasses.StorageClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "error listing storageclasses")
+	}
+
+	candidates := []storagev1api.StorageClass{}
+	for _, sc := range scList.Items {
+		if sc.Provisioner == source.Provisioner && (len(topology) == 0 || storageClassAllowsTopology(&sc, requested)) {
+			candidates = append(candidates, sc)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+
+	if len(candidates) == 0 {
+		return "", errors.Errorf("no storageclass with provisioner %s allows topology %q requested by restore %s", source.Provisioner, topology, restore.Name)
+	}
+
+	if len(fsType) == 0 {
+		return candidates[0].Name, nil
+	}
+
+	// storageclasses that don't set the fsType format volumes with the driver default, which may differ between
+	// clusters, so prefer one that explicitly matches the backed up fsType
+	for _, sc := range candidates {
+		if storageClassFSType(&sc) == fsType {
+			return sc.Name, nil
+		}
+	}
+	if len(topology) == 0 && len(storageClassFSType(source)) == 0 {
+		return source.Name, nil
+	}
+	for _, sc := range candidates {
+		if len(storageClassFSType(&sc)) == 0 {
+			return sc.Name, nil
+		}
+	}
+
+	return "", errors.Errorf("no storageclass with provisioner %s formats volumes with fsType %s recorded at backup time", source.Provisioner, fsType)
+}
+
+// storageClassFSType returns the fsType the storageclass formats its volumes with, empty for the driver default
+func storageClassFSType(sc *storagev1api.StorageClass) string {
+	if val := sc.Parameters[CSIFSTypeParameter]; len(val) > 0 {
+		return val
+	}
+
+	return sc.Parameters[legacyFSTypeParameter]
+}
+
+// storageClassAllowsTopology returns whether one of the allowed topology terms of the storageclass admits every
+// requested key=value. Storageclasses without allowed topologies are not restricted to any topology, so they are not
+// considered a match.
+func storageClassAllowsTopology(sc *storagev1api.StorageClass, requested labels.Set) bool {
+	for _, term := range sc.AllowedTopologies {
+		matched := 0
+		for _, expr := range term.MatchLabelExpressions {
+			if val, ok := requested[expr.Key]; ok {
+				for _, allowed := range expr.Values {
+					if allowed == val {
+						matched++
+						break
+					}
+				}
+			}
+		}
+		if matched == len(requested) {
+			return true
+		}
+	}
+
+	return false
+}
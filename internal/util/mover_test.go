@@ -0,0 +1,233 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	storagev1api "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetPlaceholderSettings(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{}
+	configMapFetchedAt = time.Now()
+
+	settings, err := GetPlaceholderSettings()
+	assert.Nil(t, err)
+	assert.Equal(t, "", settings.PriorityClass)
+	assert.Equal(t, DefaultPlaceholderImage, settings.Image)
+	assert.Equal(t, resource.MustParse(DefaultPlaceholderCPU), settings.Requests[corev1api.ResourceCPU])
+	assert.Equal(t, resource.MustParse(DefaultPlaceholderMemory), settings.Requests[corev1api.ResourceMemory])
+
+	t.Setenv(DatamoverPlaceholderPriorityClass, "datamover-placeholder")
+	t.Setenv(DatamoverPlaceholderCPU, "2")
+	settings, err = GetPlaceholderSettings()
+	assert.Nil(t, err)
+	assert.Equal(t, "datamover-placeholder", settings.PriorityClass)
+	assert.Equal(t, resource.MustParse("2"), settings.Requests[corev1api.ResourceCPU])
+
+	t.Setenv(DatamoverPlaceholderMemory, "lots")
+	_, err = GetPlaceholderSettings()
+	assert.NotNil(t, err)
+}
+
+func TestNewPlaceholderPod(t *testing.T) {
+	settings := PlaceholderSettings{
+		PriorityClass: "datamover-placeholder",
+		Image:         DefaultPlaceholderImage,
+		Requests: corev1api.ResourceList{
+			corev1api.ResourceCPU: resource.MustParse("1"),
+		},
+	}
+
+	pod := NewPlaceholderPod("vsb-abcde", "openshift-adp", settings)
+	assert.Equal(t, "openshift-adp", pod.Namespace)
+	assert.Equal(t, "vsb-abcde", pod.Labels[PlaceholderForLabel])
+	assert.Equal(t, "datamover-placeholder", pod.Spec.PriorityClassName)
+	assert.Len(t, pod.Spec.Containers, 1)
+	assert.Equal(t, DefaultPlaceholderImage, pod.Spec.Containers[0].Image)
+	assert.Equal(t, settings.Requests, pod.Spec.Containers[0].Resources.Requests)
+}
+
+func TestCreateAndDeletePlaceholderPods(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{}
+	configMapFetchedAt = time.Now()
+
+	otherPod := &corev1api.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "datamover-placeholder-other",
+			Namespace: "openshift-adp",
+			Labels:    map[string]string{PlaceholderForLabel: "vsb-other"},
+		},
+	}
+	fakeClient := fake.NewSimpleClientset(otherPod)
+
+	// no priority class configured, no placeholder
+	assert.Nil(t, CreatePlaceholderPod("vsb-1", "openshift-adp", fakeClient.CoreV1(), logrus.New()))
+	pods, err := fakeClient.CoreV1().Pods("openshift-adp").List(context.TODO(), metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, pods.Items, 1)
+
+	t.Setenv(DatamoverPlaceholderPriorityClass, "datamover-placeholder")
+	assert.Nil(t, CreatePlaceholderPod("vsb-1", "openshift-adp", fakeClient.CoreV1(), logrus.New()))
+	pods, err = fakeClient.CoreV1().Pods("openshift-adp").List(context.TODO(), metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, pods.Items, 2)
+
+	assert.Nil(t, DeletePlaceholderPods("vsb-1", "openshift-adp", fakeClient.CoreV1()))
+	pods, err = fakeClient.CoreV1().Pods("openshift-adp").List(context.TODO(), metav1.ListOptions{})
+	assert.Nil(t, err)
+	assert.Len(t, pods.Items, 1)
+	assert.Equal(t, "datamover-placeholder-other", pods.Items[0].Name)
+}
+
+func TestGetRestoreStorageClass(t *testing.T) {
+	newStorageClass := func(name, provisioner, fsType string, zones ...string) *storagev1api.StorageClass {
+		sc := &storagev1api.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: name},
+			Provisioner: provisioner,
+		}
+		if len(fsType) > 0 {
+			sc.Parameters = map[string]string{CSIFSTypeParameter: fsType}
+		}
+		if len(zones) > 0 {
+			sc.AllowedTopologies = []corev1api.TopologySelectorTerm{
+				{
+					MatchLabelExpressions: []corev1api.TopologySelectorLabelRequirement{
+						{Key: "topology.kubernetes.io/zone", Values: zones},
+					},
+				},
+			}
+		}
+		return sc
+	}
+
+	fakeClient := fake.NewSimpleClientset(
+		newStorageClass("gp3", "ebs.csi.aws.com", ""),
+		newStorageClass("gp3-ext4", "ebs.csi.aws.com", "ext4"),
+		newStorageClass("gp3-us-east-1a", "ebs.csi.aws.com", "", "us-east-1a"),
+		newStorageClass("gp3-us-east-1b", "ebs.csi.aws.com", "", "us-east-1b", "us-east-1c"),
+		newStorageClass("gp3-xfs", "ebs.csi.aws.com", "xfs"),
+		newStorageClass("other-us-east-1d", "other.csi.example.com", "", "us-east-1d"),
+	)
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		source      string
+		fsType      string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "restore without topology override keeps the source storageclass",
+			source:   "gp3",
+			expected: "gp3",
+		},
+		{
+			name:        "restore with topology override",
+			annotations: map[string]string{RestoreTopologyAnnotation: "topology.kubernetes.io/zone=us-east-1c"},
+			source:      "gp3",
+			expected:    "gp3-us-east-1b",
+		},
+		{
+			name:        "no storageclass with the same provisioner allows the topology",
+			annotations: map[string]string{RestoreTopologyAnnotation: "topology.kubernetes.io/zone=us-east-1d"},
+			source:      "gp3",
+			expectError: true,
+		},
+		{
+			name:        "source storageclass does not exist",
+			annotations: map[string]string{RestoreTopologyAnnotation: "topology.kubernetes.io/zone=us-east-1a"},
+			source:      "missing",
+			expectError: true,
+		},
+		{
+			name:     "restore with an fsType picks the storageclass explicitly formatting with it",
+			source:   "gp3",
+			fsType:   "xfs",
+			expected: "gp3-xfs",
+		},
+		{
+			name:     "source storageclass formatting with the fsType is kept",
+			source:   "gp3-xfs",
+			fsType:   "xfs",
+			expected: "gp3-xfs",
+		},
+		{
+			name:     "source storageclass with the driver default is kept when none sets the fsType",
+			source:   "gp3",
+			fsType:   "btrfs",
+			expected: "gp3",
+		},
+		{
+			name:     "storageclass with the driver default replaces one formatting with another fsType",
+			source:   "gp3-xfs",
+			fsType:   "btrfs",
+			expected: "gp3",
+		},
+		{
+			name:        "restore with topology override and fsType",
+			annotations: map[string]string{RestoreTopologyAnnotation: "topology.kubernetes.io/zone=us-east-1c"},
+			source:      "gp3",
+			fsType:      "xfs",
+			expected:    "gp3-us-east-1b",
+		},
+		{
+			name:        "restore with malformed topology override",
+			annotations: map[string]string{RestoreTopologyAnnotation: "us-east-1b"},
+			source:      "gp3",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			restore := &velerov1api.Restore{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "restore-1",
+					Namespace:   "velero",
+					Annotations: tc.annotations,
+				},
+			}
+			actual, err := GetRestoreStorageClass(restore, tc.source, tc.fsType, fakeClient.StorageV1())
+			if tc.expectError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+const (
+	// MoverTypeRestic, MoverTypeKopia, MoverTypeRclone and MoverTypeSyncthing are the mover types a backup can select
+	MoverTypeRestic    = "restic"
+	MoverTypeKopia     = "kopia"
+	MoverTypeRclone    = "rclone"
+	MoverTypeSyncthing = "syncthing"
+)
+
+// MoverBackend describes a mover the data of volumes can be moved with
+type MoverBackend struct {
+	// Name is the mover type selecting the backend
+	Name string
+	// Supported is false for movers the data mover controller can't run yet, which are rejected instead of silently
+	// moving the data with another mover
+	Supported bool
+	// UsesResticSecret is true for movers configured with the repository and password of a restic secret
+	UsesResticSecret bool
+	// SupportsBlock is true for movers that can move the data of raw block volumes, the VolSync restic mover only
+	// mounts filesystem volumes
+	SupportsBlock bool
+}
+
+// moverBackends are the mover backends known to the plugin, keyed by mover type
+var moverBackends = map[string]MoverBackend{
+	MoverTypeRestic:    {Name: MoverTypeRestic, Supported: true, UsesResticSecret: true},
+	MoverTypeKopia:     {Name: MoverTypeKopia},
+	MoverTypeRclone:    {Name: MoverTypeRclone},
+	MoverTypeSyncthing: {Name: MoverTypeSyncthing},
+}
+
+// GetMoverBackend returns the mover backend of the mover type, or an error if the type is unknown or the data mover
+// can't run it
+func GetMoverBackend(moverType string) (MoverBackend, error) {
+	backend, ok := moverBackends[moverType]
+	if !ok {
+		known := make([]string, 0, len(moverBackends))
+		for name := range moverBackends {
+			known = append(known, name)
+		}
+		sort.Strings(known)
+		return MoverBackend{}, errors.Errorf("unknown mover type %q, expected one of %s", moverType, strings.Join(known, ", "))
+	}
+
+	if !backend.Supported {
+		return MoverBackend{}, errors.Errorf("mover type %s is not supported by the data mover yet", moverType)
+	}
+
+	return backend, nil
+}
+
+// GetStorageClassMoverTypes returns the configured mover types of storageclasses, keyed by storageclass name
+func GetStorageClassMoverTypes() (map[string]string, error) {
+	val := getSetting(DatamoverStorageClassMoverTypes)
+	if len(val) == 0 {
+		return map[string]string{}, nil
+	}
+
+	moverTypes, err := labels.ConvertSelectorToLabelsMap(val)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s %q", DatamoverStorageClassMoverTypes, val)
+	}
+
+	return moverTypes, nil
+}
+
+// GetMoverBackendForBackup returns the mover backend the data of a volume of the backup, provisioned with the given
+// storageclass, is moved with: the one the MoverTypeAnnotation of the backup selects, or else the one configured for
+// the storageclass, or else the configured DatamoverMoverType, defaulting to restic
+func GetMoverBackendForBackup(backup *velerov1api.Backup, storageClass string) (MoverBackend, error) {
+	moverType := backup.Annotations[MoverTypeAnnotation]
+	if len(moverType) == 0 && len(storageClass) > 0 {
+		moverTypes, err := GetStorageClassMoverTypes()
+		if err != nil {
+			return MoverBackend{}, err
+		}
+		moverType = moverTypes[storageClass]
+	}
+	if len(moverType) == 0 {
+		moverType = getSetting(DatamoverMoverType)
+	}
+	if len(moverType) == 0 {
+		moverType = MoverTypeRestic
+	}
+
+	backend, err := GetMoverBackend(moverType)
+	if err != nil {
+		return MoverBackend{}, errors.Wrapf(err, "invalid mover type of backup %s", backup.Name)
+	}
+	return backend, nil
+}
+
+// GetMoverBackendForVolumeSnapshotBackup returns the mover backend the VSB was backed up with, restic for VSBs
+// backed up before the mover type was recorded
+func GetMoverBackendForVolumeSnapshotBackup(vsb *datamoverv1alpha1.VolumeSnapshotBackup) (MoverBackend, error) {
+	moverType, ok := vsb.Annotations[MoverTypeAnnotation]
+	if !ok || len(moverType) == 0 {
+		moverType = MoverTypeRestic
+	}
+
+	backend, err := GetMoverBackend(moverType)
+	if err != nil {
+		return MoverBackend{}, errors.Wrapf(err, "invalid mover type of volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+	if err := CheckVolumeModeSupported(backend, vsb.Annotations[VolumeSnapshotMoverSourcePVCVolumeMode]); err != nil {
+		return MoverBackend{}, errors.Wrapf(err, "cannot restore volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+	return backend, nil
+}
+
+// CheckVolumeModeSupported returns an error if the mover backend can't move the data of volumes of the volumeMode. An
+// empty volumeMode stands for Filesystem, the default of PVCs.
+func CheckVolumeModeSupported(backend MoverBackend, volumeMode string) error {
+	if corev1api.PersistentVolumeMode(volumeMode) == corev1api.PersistentVolumeBlock && !backend.SupportsBlock {
+		return errors.Errorf("mover type %s can't move the data of volumes with volumeMode %s", backend.Name, volumeMode)
+	}
+	return nil
+}
+
+// GetVolumeMode returns the volumeMode of the PVC, empty if it is not set
+func GetVolumeMode(pvc *corev1api.PersistentVolumeClaim) string {
+	if pvc == nil || pvc.Spec.VolumeMode == nil {
+		return ""
+	}
+	return string(*pvc.Spec.VolumeMode)
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+func TestGetMoverBackendForBackup(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	testCases := []struct {
+		name         string
+		annotations  map[string]string
+		storageClass string
+		settings     map[string]string
+		expected     string
+		expectError  bool
+	}{
+		{
+			name:     "defaults to restic",
+			expected: MoverTypeRestic,
+		},
+		{
+			name:     "configured mover type",
+			settings: map[string]string{DatamoverMoverType: MoverTypeRestic},
+			expected: MoverTypeRestic,
+		},
+		{
+			name:         "storageclass mover type takes precedence over the configured one",
+			storageClass: "fast",
+			settings:     map[string]string{DatamoverMoverType: MoverTypeRestic, DatamoverStorageClassMoverTypes: "fast=rclone"},
+			expectError:  true,
+		},
+		{
+			name:         "storageclass without a mover type",
+			storageClass: "slow",
+			settings:     map[string]string{DatamoverStorageClassMoverTypes: "fast=rclone"},
+			expected:     MoverTypeRestic,
+		},
+		{
+			name:         "backup annotation takes precedence",
+			annotations:  map[string]string{MoverTypeAnnotation: MoverTypeKopia},
+			storageClass: "fast",
+			settings:     map[string]string{DatamoverStorageClassMoverTypes: "fast=restic"},
+			expectError:  true,
+		},
+		{
+			name:        "unknown mover type",
+			settings:    map[string]string{DatamoverMoverType: "rsync"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configMapData, configMapFetchedAt = tc.settings, time.Now()
+			if configMapData == nil {
+				configMapData = map[string]string{}
+			}
+			backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Annotations: tc.annotations}}
+
+			backend, err := GetMoverBackendForBackup(backup, tc.storageClass)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, backend.Name)
+			assert.True(t, backend.Supported)
+		})
+	}
+}
+
+func TestCheckVolumeModeSupported(t *testing.T) {
+	restic, err := GetMoverBackend(MoverTypeRestic)
+	assert.NoError(t, err)
+
+	assert.NoError(t, CheckVolumeModeSupported(restic, ""))
+	assert.NoError(t, CheckVolumeModeSupported(restic, string(corev1api.PersistentVolumeFilesystem)))
+	assert.Error(t, CheckVolumeModeSupported(restic, string(corev1api.PersistentVolumeBlock)))
+	assert.NoError(t, CheckVolumeModeSupported(MoverBackend{Name: "block-mover", Supported: true, SupportsBlock: true}, string(corev1api.PersistentVolumeBlock)))
+
+	block := corev1api.PersistentVolumeBlock
+	assert.Equal(t, "", GetVolumeMode(nil))
+	assert.Equal(t, "", GetVolumeMode(&corev1api.PersistentVolumeClaim{}))
+	assert.Equal(t, "Block", GetVolumeMode(&corev1api.PersistentVolumeClaim{Spec: corev1api.PersistentVolumeClaimSpec{VolumeMode: &block}}))
+
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{ObjectMeta: metav1.ObjectMeta{
+		Name:        "vsb-1",
+		Namespace:   "app-ns",
+		Annotations: map[string]string{VolumeSnapshotMoverSourcePVCVolumeMode: "Block"},
+	}}
+	_, err = GetMoverBackendForVolumeSnapshotBackup(vsb)
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// NamespacePolicy holds the data mover settings of the namespaces it applies to, either listed by name or selected
+// by their labels
+type NamespacePolicy struct {
+	Name              string   `json:"-"`
+	Namespaces        []string `json:"namespaces,omitempty"`
+	NamespaceSelector string   `json:"namespaceSelector,omitempty"`
+	// ResticSecret is the restic secret, in the velero namespace, VSBs and VSRs of the namespaces are created with
+	ResticSecret string `json:"resticSecret,omitempty"`
+}
+
+// Validate returns an error if the policy selects no namespaces or holds an invalid setting
+func (p NamespacePolicy) Validate() error {
+	if len(p.Namespaces) == 0 && len(p.NamespaceSelector) == 0 {
+		return errors.Errorf("namespace policy %s selects no namespaces", p.Name)
+	}
+	if _, err := labels.Parse(p.NamespaceSelector); err != nil {
+		return errors.Wrapf(err, "invalid namespaceSelector in namespace policy %s", p.Name)
+	}
+	if len(p.ResticSecret) > 0 {
+		if errs := validation.IsDNS1123Subdomain(p.ResticSecret); len(errs) > 0 {
+			return errors.Errorf("invalid resticSecret %q in namespace policy %s: %s", p.ResticSecret, p.Name, strings.Join(errs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// matches returns whether the policy applies to the namespace with the given name and labels
+func (p NamespacePolicy) matches(namespace string, nsLabels map[string]string) bool {
+	if Contains(p.Namespaces, namespace) {
+		return true
+	}
+	if len(p.NamespaceSelector) == 0 {
+		return false
+	}
+
+	// the selector was validated when the policies were parsed
+	selector, _ := labels.Parse(p.NamespaceSelector)
+	return selector.Matches(labels.Set(nsLabels))
+}
+
+// ParseNamespacePolicies parses the namespace policies from the data of the ConfigMap labeled with PluginConfigLabel
+// and VSMNamespacePolicyLabel, one JSON encoded policy per key, ordered by key
+func ParseNamespacePolicies(data map[string]string) ([]NamespacePolicy, error) {
+	policies := []NamespacePolicy{}
+	for name, raw := range data {
+		policy := NamespacePolicy{}
+		if err := json.Unmarshal([]byte(raw), &policy); err != nil {
+			return nil, errors.Wrapf(err, "error parsing namespace policy %s", name)
+		}
+		policy.Name = name
+		if err := policy.Validate(); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Name < policies[j].Name })
+	return policies, nil
+}
+
+// selectNamespacePolicy returns the first of the policies that applies to the namespace, or nil if none does. The
+// namespace is only fetched when a policy selects namespaces by their labels.
+func selectNamespacePolicy(policies []NamespacePolicy, namespace string, nsGetter corev1client.NamespacesGetter) (*NamespacePolicy, error) {
+	var nsLabels map[string]string
+	for i := range policies {
+		if len(policies[i].NamespaceSelector) > 0 && nsLabels == nil {
+			ns, err := nsGetter.Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+			if err != nil {
+				return nil, errors.Wrapf(err, "error getting namespace %s", namespace)
+			}
+			nsLabels = ns.Labels
+			if nsLabels == nil {
+				nsLabels = map[string]string{}
+			}
+		}
+
+		if policies[i].matches(namespace, nsLabels) {
+			return &policies[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetNamespacePolicy returns the namespace policy that applies to the namespace, or nil if none does
+func GetNamespacePolicy(namespace string, nsGetter corev1client.NamespacesGetter) (*NamespacePolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configMapLoadTimeout)
+	defer cancel()
+
+	data, err := loadLabeledConfigMap(ctx, VSMNamespacePolicyLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := ParseNamespacePolicies(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return selectNamespacePolicy(policies, namespace, nsGetter)
+}
+
+// GetVolumeSnapshotBackupResticSecretName returns the restic secret a VSB in the namespace is created with: the
+// secret of the namespace policy that applies to it, if any, or the VolSync restic secret of the backup storage location
+func GetVolumeSnapshotBackupResticSecretName(backup *velerov1api.Backup, namespace string, nsGetter corev1client.NamespacesGetter, log logrus.FieldLogger) (string, error) {
+	policy, err := GetNamespacePolicy(namespace, nsGetter)
+	if err != nil {
+		return "", err
+	}
+
+	if policy == nil || len(policy.ResticSecret) == 0 {
+		return GetDataMoverCredName(backup, backup.Namespace, log)
+	}
+
+	secretClient, _, err := GetClients()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	secret, err := secretClient.CoreV1().Secrets(backup.Namespace).Get(context.TODO(), policy.ResticSecret, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting restic secret %s of namespace policy %s", policy.ResticSecret, policy.Name)
+	}
+	if err := ValidateResticSecret(secret, VaultEnabled()); err != nil {
+		return "", errors.Wrapf(err, "invalid restic secret of namespace policy %s", policy.Name)
+	}
+
+	log.Infof("using restic secret %s of namespace policy %s for namespace %s", policy.ResticSecret, policy.Name, namespace)
+	return policy.ResticSecret, nil
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseNamespacePolicies(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          map[string]string
+		expectError   bool
+		expectedNames []string
+	}{
+		{
+			name:          "no policies",
+			data:          map[string]string{},
+			expectedNames: []string{},
+		},
+		{
+			name: "policies are ordered by key",
+			data: map[string]string{
+				"tenant-b": `{"namespaces":["app-b"],"resticSecret":"tenant-b-restic"}`,
+				"tenant-a": `{"namespaceSelector":"tenant=a","resticSecret":"tenant-a-restic"}`,
+			},
+			expectedNames: []string{"tenant-a", "tenant-b"},
+		},
+		{
+			name:        "malformed JSON",
+			data:        map[string]string{"tenant-a": `{"namespaces":`},
+			expectError: true,
+		},
+		{
+			name:        "no namespaces selected",
+			data:        map[string]string{"tenant-a": `{"resticSecret":"tenant-a-restic"}`},
+			expectError: true,
+		},
+		{
+			name:        "invalid namespace selector",
+			data:        map[string]string{"tenant-a": `{"namespaceSelector":"tenant in (a"}`},
+			expectError: true,
+		},
+		{
+			name:        "invalid restic secret",
+			data:        map[string]string{"tenant-a": `{"namespaces":["app-a"],"resticSecret":"Tenant A"}`},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policies, err := ParseNamespacePolicies(tc.data)
+			if tc.expectError {
+				assert.NotNil(t, err)
+				return
+			}
+
+			assert.Nil(t, err)
+			names := []string{}
+			for _, policy := range policies {
+				names = append(names, policy.Name)
+			}
+			assert.Equal(t, tc.expectedNames, names)
+		})
+	}
+}
+
+func TestSelectNamespacePolicy(t *testing.T) {
+	policies, err := ParseNamespacePolicies(map[string]string{
+		"tenant-a": `{"namespaceSelector":"tenant=a","resticSecret":"tenant-a-restic"}`,
+		"tenant-b": `{"namespaces":["app-b"],"resticSecret":"tenant-b-restic"}`,
+	})
+	assert.Nil(t, err)
+
+	client := fake.NewSimpleClientset(
+		&corev1api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app-a", Labels: map[string]string{"tenant": "a"}}},
+		&corev1api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app-b"}},
+		&corev1api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "app-c"}},
+	)
+
+	policy, err := selectNamespacePolicy(policies, "app-a", client.CoreV1())
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant-a-restic", policy.ResticSecret)
+
+	policy, err = selectNamespacePolicy(policies, "app-b", client.CoreV1())
+	assert.Nil(t, err)
+	assert.Equal(t, "tenant-b-restic", policy.ResticSecret)
+
+	policy, err = selectNamespacePolicy(policies, "app-c", client.CoreV1())
+	assert.Nil(t, err)
+	assert.Nil(t, policy)
+
+	_, err = selectNamespacePolicy(policies, "missing", client.CoreV1())
+	assert.NotNil(t, err)
+
+	// namespaces are only fetched for policies selecting them by labels
+	policy, err = selectNamespacePolicy(policies[1:], "missing", client.CoreV1())
+	assert.Nil(t, err)
+	assert.Nil(t, policy)
+}
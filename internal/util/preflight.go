@@ -0,0 +1,163 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultControllerDeployment is the name of the deployment the volume-snapshot-mover controller runs in
+const DefaultControllerDeployment = "volume-snapshot-mover"
+
+var preflight struct {
+	mu sync.Mutex
+	// the backup or restore the preflight checks last ran for, and their result
+	uid types.UID
+	err error
+}
+
+// GetControllerDeployment returns the configured DatamoverControllerDeployment, DefaultControllerDeployment if unset
+func GetControllerDeployment() string {
+	if name := getSetting(DatamoverControllerDeployment); len(name) > 0 {
+		return name
+	}
+	return DefaultControllerDeployment
+}
+
+// CheckBackupPreflight verifies, on the first item of the backup, that the data mover can serve it, see
+// runPreflight. A failure is recorded as an event on the backup once, and returned for every item of the backup.
+func CheckBackupPreflight(backup *velerov1api.Backup, log logrus.FieldLogger) error {
+	fresh, err := runPreflight(backup.UID, backup.Namespace)
+	if err != nil && fresh {
+		log.Errorf("data mover preflight checks failed for backup %s: %s", backup.Name, err.Error())
+		RecordBackupEvent(backup, corev1api.EventTypeWarning, EventReasonPreflightFailed, err.Error(), log)
+	}
+	return err
+}
+
+// CheckRestorePreflight is CheckBackupPreflight for restores
+func CheckRestorePreflight(restore *velerov1api.Restore, log logrus.FieldLogger) error {
+	fresh, err := runPreflight(restore.UID, restore.Namespace)
+	if err != nil && fresh {
+		log.Errorf("data mover preflight checks failed for restore %s: %s", restore.Name, err.Error())
+		RecordRestoreEvent(restore, corev1api.EventTypeWarning, EventReasonPreflightFailed, err.Error(), log)
+	}
+	return err
+}
+
+// runPreflight checks that the datamover CRDs are served at the version the plugin is built with and that the
+// volume-snapshot-mover controller is available in the velero namespace. The checks run once per backup or restore
+// uid, the later items of it get the first result, so that they fail at once instead of each waiting for the
+// datamover timeout. fresh reports whether the checks ran for this call.
+func runPreflight(uid types.UID, namespace string) (fresh bool, err error) {
+	preflight.mu.Lock()
+	defer preflight.mu.Unlock()
+
+	if len(uid) > 0 && preflight.uid == uid {
+		return false, preflight.err
+	}
+
+	kubeClient, _, err := GetClients()
+	if err != nil {
+		return true, err
+	}
+
+	err = checkDataMoverCRDs(kubeClient.Discovery())
+	if err == nil {
+		err = checkControllerDeployment(kubeClient, namespace, GetControllerDeployment())
+	}
+
+	preflight.uid, preflight.err = uid, err
+	return true, err
+}
+
+// checkDataMoverCRDs checks that volumesnapshotbackups and volumesnapshotrestores are served at the datamover group
+// version
+func checkDataMoverCRDs(disc discovery.DiscoveryInterface) error {
+	gv := resolveDataMoverGroupVersion(disc)
+
+	groups, err := disc.ServerGroups()
+	if err != nil {
+		return errors.Wrap(err, "failed to discover the server groups")
+	}
+
+	var served []string
+	for _, group := range groups.Groups {
+		if group.Name != gv.Group {
+			continue
+		}
+		for _, version := range group.Versions {
+			served = append(served, version.Version)
+		}
+	}
+	if len(served) == 0 {
+		return errors.Errorf("the VolumeSnapshotBackup and VolumeSnapshotRestore CRDs are not installed: the cluster does not serve the %s API group, install volume-snapshot-mover or set %s to the group version of its CRDs",
+			gv.Group, DatamoverAPIGroupVersion)
+	}
+
+	resources, err := disc.ServerResourcesForGroupVersion(gv.String())
+	if apierrors.IsNotFound(err) {
+		return errors.Errorf("the installed volume-snapshot-mover is not compatible with the plugin: its CRDs are served at %s %s, the plugin requires %s",
+			gv.Group, strings.Join(served, ", "), gv.Version)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to discover the resources of %s", gv)
+	}
+
+	found := map[string]bool{}
+	for _, resource := range resources.APIResources {
+		found[resource.Name] = true
+	}
+	for _, resource := range []string{"volumesnapshotbackups", "volumesnapshotrestores"} {
+		if !found[resource] {
+			return errors.Errorf("the %s CRD is not installed at %s, install volume-snapshot-mover", resource, gv)
+		}
+	}
+
+	return nil
+}
+
+// checkControllerDeployment checks that the volume-snapshot-mover controller deployment has an available replica
+func checkControllerDeployment(kubeClient kubernetes.Interface, namespace, name string) error {
+	deployment, err := kubeClient.AppsV1().Deployments(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return errors.Errorf("the volume-snapshot-mover controller is not running: deployment %s/%s does not exist, enable the data mover or set %s to the name of its deployment",
+			namespace, name, DatamoverControllerDeployment)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to get the volume-snapshot-mover controller deployment %s/%s", namespace, name)
+	}
+
+	if deployment.Status.AvailableReplicas == 0 {
+		return errors.Errorf("the volume-snapshot-mover controller is not running: deployment %s/%s has no available replicas, check its pods",
+			namespace, name)
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCheckDataMoverCRDs(t *testing.T) {
+	t.Setenv(DatamoverAPIGroupVersion, "")
+	defer func(discovered *schema.GroupVersion) {
+		dataMoverGroupVersion.discovered = discovered
+	}(dataMoverGroupVersion.discovered)
+
+	testCases := []struct {
+		name          string
+		resources     []*metav1.APIResourceList
+		expectedError string
+	}{
+		{
+			name:      "CRDs served",
+			resources: []*metav1.APIResourceList{dataMoverResourceList("datamover.oadp.openshift.io/v1alpha1")},
+		},
+		{
+			name:          "CRDs not installed",
+			expectedError: "the VolumeSnapshotBackup and VolumeSnapshotRestore CRDs are not installed",
+		},
+		{
+			name:          "incompatible version",
+			resources:     []*metav1.APIResourceList{dataMoverResourceList("datamover.oadp.openshift.io/v1alpha2")},
+			expectedError: "its CRDs are served at datamover.oadp.openshift.io v1alpha2, the plugin requires v1alpha1",
+		},
+		{
+			name: "VolumeSnapshotRestore CRD missing",
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "datamover.oadp.openshift.io/v1alpha1",
+				APIResources: []metav1.APIResource{{Name: "volumesnapshotbackups", Kind: "VolumeSnapshotBackup", Namespaced: true}},
+			}},
+			expectedError: "the volumesnapshotrestores CRD is not installed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dataMoverGroupVersion.discovered = nil
+			kubeClient := fake.NewSimpleClientset()
+			kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = tc.resources
+
+			err := checkDataMoverCRDs(kubeClient.Discovery())
+			if len(tc.expectedError) > 0 {
+				assert.ErrorContains(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckControllerDeployment(t *testing.T) {
+	available := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultControllerDeployment, Namespace: "velero"},
+		Status:     appsv1.DeploymentStatus{AvailableReplicas: 1},
+	}
+	unavailable := available.DeepCopy()
+	unavailable.Status.AvailableReplicas = 0
+
+	assert.NoError(t, checkControllerDeployment(fake.NewSimpleClientset(available), "velero", DefaultControllerDeployment))
+	assert.ErrorContains(t, checkControllerDeployment(fake.NewSimpleClientset(unavailable), "velero", DefaultControllerDeployment), "has no available replicas")
+	assert.ErrorContains(t, checkControllerDeployment(fake.NewSimpleClientset(), "velero", DefaultControllerDeployment), "deployment velero/volume-snapshot-mover does not exist")
+}
+
+func TestRunPreflightOncePerUID(t *testing.T) {
+	t.Setenv(DatamoverAPIGroupVersion, "")
+	t.Setenv(DatamoverControllerDeployment, "")
+	defer func(discovered *schema.GroupVersion) {
+		dataMoverGroupVersion.discovered = discovered
+	}(dataMoverGroupVersion.discovered)
+	dataMoverGroupVersion.discovered = nil
+	defer func(uid types.UID, err error) {
+		preflight.uid, preflight.err = uid, err
+	}(preflight.uid, preflight.err)
+	preflight.uid, preflight.err = "", nil
+
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{dataMoverResourceList("datamover.oadp.openshift.io/v1alpha1")}
+	defer SetClients(kubeClient, snapshotFake.NewSimpleClientset(), crfake.NewClientBuilder().Build())()
+
+	fresh, err := runPreflight("backup-1", "velero")
+	assert.True(t, fresh)
+	assert.ErrorContains(t, err, "does not exist")
+
+	// the later items of the backup get the first result without checking again
+	_, err = kubeClient.AppsV1().Deployments("velero").Create(context.TODO(), &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultControllerDeployment, Namespace: "velero"},
+		Status:     appsv1.DeploymentStatus{AvailableReplicas: 1},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+	fresh, err = runPreflight("backup-1", "velero")
+	assert.False(t, fresh)
+	assert.Error(t, err)
+
+	// the next backup checks again
+	fresh, err = runPreflight("backup-2", "velero")
+	assert.True(t, fresh)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	volsyncv1alpha1 "github.com/backube/volsync/api/v1alpha1"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// resticProcessedRegex matches the summary restic logs once a backup is done, e.g. "processed 12 files, 1.234 GiB in 0:05"
+var resticProcessedRegex = regexp.MustCompile(`processed \d+ files, ([\d.]+) (B|KiB|MiB|GiB|TiB)`)
+
+var resticByteUnits = map[string]float64{
+	"B":   1,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+}
+
+// GetMoverProcessedBytes returns the bytes the restic mover reported processing in the logs of its latest run, and
+// false if it reported none. VolSync only records the logs of a mover run once it succeeded.
+func GetMoverProcessedBytes(status *volsyncv1alpha1.MoverStatus) (int64, bool) {
+	if status == nil || status.Result != volsyncv1alpha1.MoverResultSuccessful {
+		return 0, false
+	}
+
+	match := resticProcessedRegex.FindStringSubmatch(status.Logs)
+	if match == nil {
+		return 0, false
+	}
+
+	val, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return int64(val * resticByteUnits[match[2]]), true
+}
+
+// SetBytesProgress reports the data movement of a volume in bytes: NTotal is the size of its PVC, and NCompleted the
+// bytes moved so far, capped at NTotal. The size of volumes not reconciled yet is unknown, their progress is not set.
+// Completed operations report all of the volume as moved.
+func SetBytesProgress(progress *velero.OperationProgress, size string, movedBytes int64) {
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return
+	}
+
+	progress.OperationUnits = "bytes"
+	progress.NTotal = quantity.Value()
+	progress.NCompleted = movedBytes
+	if progress.Completed && len(progress.Err) == 0 || progress.NCompleted > progress.NTotal {
+		progress.NCompleted = progress.NTotal
+	}
+}
+
+const (
+	// moverLogExcerptLines and moverLogExcerptLength bound the excerpt of the logs of a failed mover run added to the
+	// error of its operation
+	moverLogExcerptLines  = 5
+	moverLogExcerptLength = 1024
+)
+
+// GetMoverFailureExcerpt returns the last lines of the logs VolSync recorded for the latest mover run if it failed,
+// and an empty string otherwise
+func GetMoverFailureExcerpt(status *volsyncv1alpha1.MoverStatus) string {
+	if status == nil || status.Result != volsyncv1alpha1.MoverResultFailed {
+		return ""
+	}
+
+	lines := strings.Split(strings.TrimSpace(status.Logs), "\n")
+	if len(lines) > moverLogExcerptLines {
+		lines = lines[len(lines)-moverLogExcerptLines:]
+	}
+
+	excerpt := strings.TrimSpace(strings.Join(lines, "; "))
+	if len(excerpt) > moverLogExcerptLength {
+		excerpt = "..." + excerpt[len(excerpt)-moverLogExcerptLength:]
+	}
+	return excerpt
+}
+
+// AddMoverFailure adds the excerpt of the logs of the failed mover run of the replicationsource or
+// replicationdestination to the error of the operation
+func AddMoverFailure(progress *velero.OperationProgress, kind, name string, status *volsyncv1alpha1.MoverStatus) {
+	if excerpt := GetMoverFailureExcerpt(status); len(excerpt) > 0 {
+		progress.Err = fmt.Sprintf("%s, %s %s mover failed: %s", progress.Err, kind, name, excerpt)
+	}
+}
+
+// isBackupMovingData returns whether a backup in the phase may still be waiting for its data mover operations
+func isBackupMovingData(phase velerov1api.BackupPhase) bool {
+	switch phase {
+	case "", velerov1api.BackupPhaseNew, velerov1api.BackupPhaseInProgress,
+		velerov1api.BackupPhaseWaitingForPluginOperations, velerov1api.BackupPhaseWaitingForPluginOperationsPartiallyFailed:
+		return true
+	}
+	return false
+}
+
+// isRestoreMovingData returns whether a restore in the phase may still be waiting for its data mover operations
+func isRestoreMovingData(phase velerov1api.RestorePhase) bool {
+	switch phase {
+	case "", velerov1api.RestorePhaseNew, velerov1api.RestorePhaseInProgress,
+		velerov1api.RestorePhaseWaitingForPluginOperations, velerov1api.RestorePhaseWaitingForPluginOperationsPartiallyFailed:
+		return true
+	}
+	return false
+}
+
+// deletedOperationProgress returns the terminal progress of an operation whose VSB or VSR no longer exists. Once the
+// backup or restore stopped waiting for its data mover operations, the CR was removed after the operation completed.
+// Before that, it was deleted while its data was moving, and the operation failed.
+func deletedOperationProgress(kind, operationID string, movingData bool) velero.OperationProgress {
+	progress := velero.OperationProgress{Completed: true, Updated: time.Now()}
+	if movingData {
+		progress.Err = fmt.Sprintf("%s %s was deleted before its data movement completed", kind, operationID)
+		progress.Description = "Phase: Deleted"
+	} else {
+		progress.Description = "Phase: Completed"
+	}
+	return progress
+}
+
+// GetDeletedBackupOperationProgress returns the terminal progress of the operation of a VSB that no longer exists
+func GetDeletedBackupOperationProgress(operationID string, backup *velerov1api.Backup) velero.OperationProgress {
+	return deletedOperationProgress("VolumeSnapshotBackup", operationID, isBackupMovingData(backup.Status.Phase))
+}
+
+// GetDeletedRestoreOperationProgress returns the terminal progress of the operation of a VSR that no longer exists
+func GetDeletedRestoreOperationProgress(operationID string, restore *velerov1api.Restore) velero.OperationProgress {
+	return deletedOperationProgress("VolumeSnapshotRestore", operationID, isRestoreMovingData(restore.Status.Phase))
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+// progressCacheTTL is how long the progress of an operation is reused before its CR is read again
+const progressCacheTTL = 10 * time.Second
+
+type cachedProgress struct {
+	progress velero.OperationProgress
+	cachedAt time.Time
+}
+
+var (
+	progressCacheLock sync.Mutex
+	progressCache     = map[string]cachedProgress{}
+)
+
+// GetCachedProgress returns the progress of the operation of the kind if it was reported less than progressCacheTTL ago
+func GetCachedProgress(kind, operationID string) (velero.OperationProgress, bool) {
+	progressCacheLock.Lock()
+	defer progressCacheLock.Unlock()
+
+	cached, ok := progressCache[kind+"/"+operationID]
+	if !ok || time.Since(cached.cachedAt) >= progressCacheTTL {
+		return velero.OperationProgress{}, false
+	}
+	return cached.progress, true
+}
+
+// CacheProgress keeps the progress of the operation of the kind for progressCacheTTL, and drops the expired progress
+// of the other operations
+func CacheProgress(kind, operationID string, progress velero.OperationProgress) {
+	progressCacheLock.Lock()
+	defer progressCacheLock.Unlock()
+
+	for key, cached := range progressCache {
+		if time.Since(cached.cachedAt) >= progressCacheTTL {
+			delete(progressCache, key)
+		}
+	}
+	progressCache[kind+"/"+operationID] = cachedProgress{progress: progress, cachedAt: time.Now()}
+}
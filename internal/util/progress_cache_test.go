@@ -0,0 +1,51 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+func TestProgressCache(t *testing.T) {
+	defer func() { progressCache = map[string]cachedProgress{} }()
+
+	_, ok := GetCachedProgress("VolumeSnapshotBackup", "openshift-adp/vsb-1")
+	assert.False(t, ok)
+
+	progress := velero.OperationProgress{Description: "Phase: InProgress", NTotal: 1 << 30}
+	CacheProgress("VolumeSnapshotBackup", "openshift-adp/vsb-1", progress)
+
+	cached, ok := GetCachedProgress("VolumeSnapshotBackup", "openshift-adp/vsb-1")
+	assert.True(t, ok)
+	assert.Equal(t, progress, cached)
+
+	// operations of another kind don't share the progress
+	_, ok = GetCachedProgress("VolumeSnapshotRestore", "openshift-adp/vsb-1")
+	assert.False(t, ok)
+
+	// expired progress is read again, and dropped once other progress is cached
+	progressCache["VolumeSnapshotBackup/openshift-adp/vsb-1"] = cachedProgress{progress: progress, cachedAt: time.Now().Add(-progressCacheTTL)}
+	_, ok = GetCachedProgress("VolumeSnapshotBackup", "openshift-adp/vsb-1")
+	assert.False(t, ok)
+
+	CacheProgress("VolumeSnapshotRestore", "openshift-adp/vsr-1", progress)
+	assert.Len(t, progressCache, 1)
+}
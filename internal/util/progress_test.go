@@ -0,0 +1,132 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"strings"
+	"testing"
+
+	volsyncv1alpha1 "github.com/backube/volsync/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+)
+
+func TestGetMoverProcessedBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   *volsyncv1alpha1.MoverStatus
+		expected int64
+		found    bool
+	}{
+		{
+			name: "no mover status",
+		},
+		{
+			name:   "failed mover",
+			status: &volsyncv1alpha1.MoverStatus{Result: volsyncv1alpha1.MoverResultFailed, Logs: "processed 3 files, 1.000 GiB in 0:05"},
+		},
+		{
+			name: "successful mover",
+			status: &volsyncv1alpha1.MoverStatus{
+				Result: volsyncv1alpha1.MoverResultSuccessful,
+				Logs:   "using parent snapshot 1a2b3c4d\nAdded to the repository: 12.000 MiB (4.000 MiB stored)\nprocessed 3 files, 1.500 GiB in 0:05\nsnapshot 5e6f7a8b saved",
+			},
+			expected: 1610612736,
+			found:    true,
+		},
+		{
+			name:     "bytes",
+			status:   &volsyncv1alpha1.MoverStatus{Result: volsyncv1alpha1.MoverResultSuccessful, Logs: "processed 1 files, 512 B in 0:00"},
+			expected: 512,
+			found:    true,
+		},
+		{
+			name:   "restore without summary",
+			status: &volsyncv1alpha1.MoverStatus{Result: volsyncv1alpha1.MoverResultSuccessful, Logs: "restoring <Snapshot 1a2b3c4d> to /data"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			bytes, found := GetMoverProcessedBytes(tc.status)
+			assert.Equal(t, tc.found, found)
+			assert.Equal(t, tc.expected, bytes)
+		})
+	}
+}
+
+func TestSetBytesProgress(t *testing.T) {
+	progress := velero.OperationProgress{}
+	SetBytesProgress(&progress, "", 0)
+	assert.Equal(t, velero.OperationProgress{}, progress)
+
+	SetBytesProgress(&progress, "2Gi", 1<<30)
+	assert.Equal(t, "bytes", progress.OperationUnits)
+	assert.Equal(t, int64(2<<30), progress.NTotal)
+	assert.Equal(t, int64(1<<30), progress.NCompleted)
+
+	// a volume using more than its requested size is not reported beyond it
+	SetBytesProgress(&progress, "2Gi", 3<<30)
+	assert.Equal(t, int64(2<<30), progress.NCompleted)
+
+	progress = velero.OperationProgress{Completed: true}
+	SetBytesProgress(&progress, "2Gi", 0)
+	assert.Equal(t, int64(2<<30), progress.NCompleted)
+
+	progress = velero.OperationProgress{Completed: true, Err: "VolumeSnapshotBackup has a failed status"}
+	SetBytesProgress(&progress, "2Gi", 0)
+	assert.Equal(t, int64(0), progress.NCompleted)
+}
+
+func TestAddMoverFailure(t *testing.T) {
+	progress := velero.OperationProgress{Err: "VolumeSnapshotBackup has a failed status"}
+
+	AddMoverFailure(&progress, "replicationsource", "openshift-adp/vsb-1-rep-src", &volsyncv1alpha1.MoverStatus{Result: volsyncv1alpha1.MoverResultSuccessful, Logs: "processed 1 files, 512 B in 0:00"})
+	assert.Equal(t, "VolumeSnapshotBackup has a failed status", progress.Err)
+
+	AddMoverFailure(&progress, "replicationsource", "openshift-adp/vsb-1-rep-src", &volsyncv1alpha1.MoverStatus{
+		Result: volsyncv1alpha1.MoverResultFailed,
+		Logs:   "line 1\nline 2\nline 3\nline 4\nline 5\nFatal: unable to open config file: Stat: Access Denied.\n",
+	})
+	assert.Equal(t, "VolumeSnapshotBackup has a failed status, replicationsource openshift-adp/vsb-1-rep-src mover failed: line 2; line 3; line 4; line 5; Fatal: unable to open config file: Stat: Access Denied.", progress.Err)
+
+	excerpt := GetMoverFailureExcerpt(&volsyncv1alpha1.MoverStatus{Result: volsyncv1alpha1.MoverResultFailed, Logs: strings.Repeat("x", 2*moverLogExcerptLength)})
+	assert.Len(t, excerpt, len("...")+moverLogExcerptLength)
+}
+
+func TestGetDeletedOperationProgress(t *testing.T) {
+	backup := &velerov1api.Backup{Status: velerov1api.BackupStatus{Phase: velerov1api.BackupPhaseWaitingForPluginOperations}}
+	progress := GetDeletedBackupOperationProgress("openshift-adp/vsb-1", backup)
+	assert.True(t, progress.Completed)
+	assert.Equal(t, "VolumeSnapshotBackup openshift-adp/vsb-1 was deleted before its data movement completed", progress.Err)
+
+	backup.Status.Phase = velerov1api.BackupPhaseFinalizing
+	progress = GetDeletedBackupOperationProgress("openshift-adp/vsb-1", backup)
+	assert.True(t, progress.Completed)
+	assert.Empty(t, progress.Err)
+
+	restore := &velerov1api.Restore{Status: velerov1api.RestoreStatus{Phase: velerov1api.RestorePhaseWaitingForPluginOperationsPartiallyFailed}}
+	progress = GetDeletedRestoreOperationProgress("openshift-adp/vsr-1", restore)
+	assert.True(t, progress.Completed)
+	assert.Equal(t, "VolumeSnapshotRestore openshift-adp/vsr-1 was deleted before its data movement completed", progress.Err)
+
+	restore.Status.Phase = velerov1api.RestorePhaseCompleted
+	progress = GetDeletedRestoreOperationProgress("openshift-adp/vsr-1", restore)
+	assert.True(t, progress.Completed)
+	assert.Empty(t, progress.Err)
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	batchv1api "k8s.io/api/batch/v1"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+const (
+	// DefaultPruneImage is the VolSync image, which ships the restic binary its restic mover runs
+	DefaultPruneImage = "quay.io/backube/volsync:0.7.0"
+
+	// pruneKeepTag is never set on snapshots, keeping only snapshots with this tag forgets all of them
+	pruneKeepTag = "datamover.io/never-set"
+	// pruneJobTTL is how long finished prune jobs are kept for their logs to be read
+	pruneJobTTL = int32(24 * 60 * 60)
+	// pruneJobBackoffLimit is how many times a failed prune is retried
+	pruneJobBackoffLimit = int32(3)
+	// pruneCustomCAPath is where the CA bundle of the backup storage location is mounted in prune jobs
+	pruneCustomCAPath = "/customca"
+)
+
+// PruneOnDeleteEnabled returns whether deleting a backup prunes the restic snapshots of its VSBs
+func PruneOnDeleteEnabled() bool {
+	enabled, _ := strconv.ParseBool(getSetting(DatamoverPruneOnDelete))
+	return enabled
+}
+
+// GetPruneJobName returns the name of the job pruning the restic repository of the named VSB
+func GetPruneJobName(vsbName string) string {
+	return label.GetValidName("vsm-prune-" + vsbName)
+}
+
+// NewPruneJob returns a job in the protected namespace forgetting all the snapshots of the restic repository of the VSB
+// and pruning their data. The data mover moves each VSB into a repository of its own, so this only removes the data of
+// that VSB.
+func NewPruneJob(vsb *datamoverv1alpha1.VolumeSnapshotBackup, repository, resticSecretName, image string) *batchv1api.Job {
+	ttl, backoffLimit := pruneJobTTL, pruneJobBackoffLimit
+	enabled, disabled := true, false
+
+	args := []string{"forget", "--prune", "--no-cache", "--keep-tag", pruneKeepTag}
+	volumes := []corev1api.Volume{}
+	volumeMounts := []corev1api.VolumeMount{}
+	if secretName := vsb.Annotations[CustomCASecretAnnotation]; len(secretName) > 0 {
+		args = append(args, "--cacert", pruneCustomCAPath+"/"+customCAKey)
+		volumes = append(volumes, corev1api.Volume{
+			Name:         "custom-ca",
+			VolumeSource: corev1api.VolumeSource{Secret: &corev1api.SecretVolumeSource{SecretName: secretName}},
+		})
+		volumeMounts = append(volumeMounts, corev1api.VolumeMount{Name: "custom-ca", MountPath: pruneCustomCAPath, ReadOnly: true})
+	}
+
+	return &batchv1api.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      GetPruneJobName(vsb.Name),
+			Namespace: vsb.Spec.ProtectedNamespace,
+			Labels: map[string]string{
+				PruneForLabel: label.GetValidName(vsb.Name),
+			},
+		},
+		Spec: batchv1api.JobSpec{
+			TTLSecondsAfterFinished: &ttl,
+			BackoffLimit:            &backoffLimit,
+			Template: corev1api.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{
+						PruneForLabel: label.GetValidName(vsb.Name),
+					},
+				},
+				Spec: corev1api.PodSpec{
+					RestartPolicy:                corev1api.RestartPolicyNever,
+					AutomountServiceAccountToken: &disabled,
+					SecurityContext: &corev1api.PodSecurityContext{
+						RunAsNonRoot: &enabled,
+						SeccompProfile: &corev1api.SeccompProfile{
+							Type: corev1api.SeccompProfileTypeRuntimeDefault,
+						},
+					},
+					Volumes: volumes,
+					Containers: []corev1api.Container{
+						{
+							Name:    "restic",
+							Image:   image,
+							Command: []string{"restic"},
+							Args:    args,
+							EnvFrom: []corev1api.EnvFromSource{
+								{SecretRef: &corev1api.SecretEnvSource{LocalObjectReference: corev1api.LocalObjectReference{Name: resticSecretName}}},
+							},
+							// the restic secret holds the base of the repositories, point restic at the one of the VSB
+							Env: []corev1api.EnvVar{
+								{Name: "RESTIC_REPOSITORY", Value: repository},
+							},
+							VolumeMounts: volumeMounts,
+							SecurityContext: &corev1api.SecurityContext{
+								AllowPrivilegeEscalation: &disabled,
+								Capabilities: &corev1api.Capabilities{
+									Drop: []corev1api.Capability{"ALL"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// CreatePruneJob starts the job pruning the restic repository of the VSB, and returns its name. A VSB without a
+// repository, which never moved data, has nothing to prune and an empty name is returned. The job is named after the
+// VSB, so retrying a backup deletion doesn't start a second one.
+func CreatePruneJob(vsb *datamoverv1alpha1.VolumeSnapshotBackup, jobs batchv1client.JobsGetter, secretsGetter corev1client.SecretsGetter) (string, error) {
+	repository := vsb.Status.ResticRepository
+	if len(repository) == 0 {
+		repository = vsb.Annotations[VolumeSnapshotMoverResticRepository]
+	}
+	if len(repository) == 0 {
+		return "", nil
+	}
+
+	image := getSetting(DatamoverPruneImage)
+	if len(image) == 0 {
+		image = DefaultPruneImage
+	}
+
+	// the password of repositories moved with a password from Vault is resolved into a secret of the job
+	resticSecretName := vsb.Spec.ResticSecretRef.Name
+	baseSecretName, fromVault := vsb.Annotations[VolumeSnapshotMoverVaultBaseResticSecret]
+	if fromVault {
+		resticSecretName = GetVaultResticSecretName(GetPruneJobName(vsb.Name))
+	}
+
+	job := NewPruneJob(vsb, repository, resticSecretName, image)
+	created, err := jobs.Jobs(job.Namespace).Create(context.TODO(), job, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		created, err = jobs.Jobs(job.Namespace).Get(context.TODO(), job.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating restic prune job for volumesnapshotbackup %s/%s", vsb.Namespace, vsb.Name)
+	}
+
+	if fromVault {
+		if err := materializePruneResticSecret(created, baseSecretName, secretsGetter); err != nil {
+			return "", err
+		}
+	}
+
+	return created.Name, nil
+}
+
+// materializePruneResticSecret resolves the repository password from Vault into the restic secret of the prune job,
+// owned by the job so that it is deleted along with it
+func materializePruneResticSecret(job *batchv1api.Job, baseSecretName string, secretsGetter corev1client.SecretsGetter) error {
+	name, err := MaterializeVaultResticSecret(baseSecretName, job.Name, job.Namespace, secretsGetter)
+	if err != nil {
+		return err
+	}
+
+	secret, err := secretsGetter.Secrets(job.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error getting restic secret %s/%s", job.Namespace, name)
+	}
+
+	secret.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(job, batchv1api.SchemeGroupVersion.WithKind("Job"))}
+	if _, err := secretsGetter.Secrets(job.Namespace).Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+		return errors.Wrapf(err, "error updating restic secret %s/%s", job.Namespace, name)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+)
+
+func TestCreatePruneJob(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{}
+	configMapFetchedAt = time.Now()
+	assert.False(t, PruneOnDeleteEnabled())
+
+	configMapData = map[string]string{DatamoverPruneOnDelete: "true"}
+	assert.True(t, PruneOnDeleteEnabled())
+
+	client := fake.NewSimpleClientset()
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "vsb-1",
+			Namespace:   "app",
+			Annotations: map[string]string{CustomCASecretAnnotation: "default-vsm-custom-ca"},
+		},
+		Spec: datamoverv1alpha1.VolumeSnapshotBackupSpec{
+			ProtectedNamespace: "openshift-adp",
+			ResticSecretRef:    corev1api.LocalObjectReference{Name: "dpa-1-volsync-restic"},
+		},
+	}
+
+	// a VSB which never moved data has no repository to prune
+	name, err := CreatePruneJob(vsb, client.BatchV1(), client.CoreV1())
+	assert.NoError(t, err)
+	assert.Empty(t, name)
+
+	vsb.Status.ResticRepository = "s3:s3.amazonaws.com/bucket/openshift-adp/snapcontent-1-pvc"
+	name, err = CreatePruneJob(vsb, client.BatchV1(), client.CoreV1())
+	assert.NoError(t, err)
+	assert.Equal(t, "vsm-prune-vsb-1", name)
+
+	job, err := client.BatchV1().Jobs("openshift-adp").Get(context.TODO(), name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	container := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, DefaultPruneImage, container.Image)
+	assert.Equal(t, []string{"forget", "--prune", "--no-cache", "--keep-tag", pruneKeepTag, "--cacert", "/customca/ca.crt"}, container.Args)
+	assert.Equal(t, "dpa-1-volsync-restic", container.EnvFrom[0].SecretRef.Name)
+	assert.Equal(t, []corev1api.EnvVar{{Name: "RESTIC_REPOSITORY", Value: vsb.Status.ResticRepository}}, container.Env)
+	assert.Equal(t, "default-vsm-custom-ca", job.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+
+	// retrying the deletion reuses the job
+	name, err = CreatePruneJob(vsb, client.BatchV1(), client.CoreV1())
+	assert.NoError(t, err)
+	assert.Equal(t, "vsm-prune-vsb-1", name)
+	jobs, err := client.BatchV1().Jobs("openshift-adp").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, jobs.Items, 1)
+}
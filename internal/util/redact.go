@@ -0,0 +1,116 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// RedactedValue replaces the restic repositories and secret names of the objects this plugin logs
+const RedactedValue = "<redacted>"
+
+// redactedAnnotations are the annotations of VSBs and VSRs naming restic repositories and secrets
+var redactedAnnotations = []string{
+	VolumeSnapshotMoverResticRepository,
+	VolumeSnapshotMoverTenantResticSecret,
+	VolumeSnapshotMoverVaultBaseResticSecret,
+	ResticSecretAnnotation,
+	CustomCASecretAnnotation,
+}
+
+// redactedFields are the paths of the restic repositories and secret names in unstructured VSBs and VSRs
+var redactedFields = [][]string{
+	{"spec", "resticSecretRef", "name"},
+	{"spec", "volumeSnapshotMoverBackupRef", "resticrepository"},
+	{"status", "resticrepository"},
+}
+
+// redactAnnotations replaces the values of the redacted annotations set in annotations
+func redactAnnotations(annotations map[string]string) {
+	for _, key := range redactedAnnotations {
+		if _, ok := annotations[key]; ok {
+			annotations[key] = RedactedValue
+		}
+	}
+}
+
+// RedactVolumeSnapshotBackup returns a copy of the VSB without its restic repository and secret names
+func RedactVolumeSnapshotBackup(vsb *datamoverv1alpha1.VolumeSnapshotBackup) *datamoverv1alpha1.VolumeSnapshotBackup {
+	redacted := vsb.DeepCopy()
+	redactAnnotations(redacted.Annotations)
+	if len(redacted.Spec.ResticSecretRef.Name) > 0 {
+		redacted.Spec.ResticSecretRef.Name = RedactedValue
+	}
+	if len(redacted.Status.ResticRepository) > 0 {
+		redacted.Status.ResticRepository = RedactedValue
+	}
+	return redacted
+}
+
+// RedactVolumeSnapshotRestore returns a copy of the VSR without its restic repository and secret names
+func RedactVolumeSnapshotRestore(vsr *datamoverv1alpha1.VolumeSnapshotRestore) *datamoverv1alpha1.VolumeSnapshotRestore {
+	redacted := vsr.DeepCopy()
+	redactAnnotations(redacted.Annotations)
+	if len(redacted.Spec.ResticSecretRef.Name) > 0 {
+		redacted.Spec.ResticSecretRef.Name = RedactedValue
+	}
+	if len(redacted.Spec.VolumeSnapshotMoverBackupref.ResticRepository) > 0 {
+		redacted.Spec.VolumeSnapshotMoverBackupref.ResticRepository = RedactedValue
+	}
+	return redacted
+}
+
+// RedactUnstructured returns a copy of the item without the restic repository and secret names it has as a VSB or VSR
+func RedactUnstructured(item runtime.Unstructured) *unstructured.Unstructured {
+	redacted := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(item.UnstructuredContent())}
+	if annotations := redacted.GetAnnotations(); len(annotations) > 0 {
+		redactAnnotations(annotations)
+		redacted.SetAnnotations(annotations)
+	}
+	for _, field := range redactedFields {
+		if value, found, err := unstructured.NestedString(redacted.Object, field...); err == nil && found && len(value) > 0 {
+			_ = unstructured.SetNestedField(redacted.Object, RedactedValue, field...)
+		}
+	}
+	return redacted
+}
+
+// traceLogger is implemented by the logrus loggers velero hands to plugins; logrus.FieldLogger predates the trace level
+type traceLogger interface {
+	Tracef(format string, args ...interface{})
+}
+
+// TraceObject logs obj with its restic repository and secret names redacted. Objects are only dumped at trace level,
+// or at debug level through loggers without one.
+func TraceObject(log logrus.FieldLogger, msg string, obj interface{}) {
+	switch o := obj.(type) {
+	case *datamoverv1alpha1.VolumeSnapshotBackup:
+		obj = RedactVolumeSnapshotBackup(o)
+	case *datamoverv1alpha1.VolumeSnapshotRestore:
+		obj = RedactVolumeSnapshotRestore(o)
+	case runtime.Unstructured:
+		obj = RedactUnstructured(o)
+	}
+	if tracer, ok := log.(traceLogger); ok {
+		tracer.Tracef("%s: %v", msg, obj)
+		return
+	}
+	log.Debugf("%s: %v", msg, obj)
+}
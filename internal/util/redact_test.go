@@ -0,0 +1,119 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRedactVolumeSnapshotBackup(t *testing.T) {
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "vsb",
+			Namespace: "app",
+			Annotations: map[string]string{
+				VolumeSnapshotMoverResticRepository:   "s3:s3.amazonaws.com/bucket/app",
+				VolumeSnapshotMoverTenantResticSecret: "app/restic",
+				MoverTypeAnnotation:                   "restic",
+			},
+		},
+		Spec:   datamoverv1alpha1.VolumeSnapshotBackupSpec{ResticSecretRef: corev1api.LocalObjectReference{Name: "restic-secret"}},
+		Status: datamoverv1alpha1.VolumeSnapshotBackupStatus{ResticRepository: "s3:s3.amazonaws.com/bucket/app"},
+	}
+
+	redacted := RedactVolumeSnapshotBackup(vsb)
+	assert.Equal(t, RedactedValue, redacted.Annotations[VolumeSnapshotMoverResticRepository])
+	assert.Equal(t, RedactedValue, redacted.Annotations[VolumeSnapshotMoverTenantResticSecret])
+	assert.Equal(t, "restic", redacted.Annotations[MoverTypeAnnotation])
+	assert.Equal(t, RedactedValue, redacted.Spec.ResticSecretRef.Name)
+	assert.Equal(t, RedactedValue, redacted.Status.ResticRepository)
+	assert.Equal(t, "restic-secret", vsb.Spec.ResticSecretRef.Name, "the logged object must not be modified")
+	assert.Equal(t, "s3:s3.amazonaws.com/bucket/app", vsb.Annotations[VolumeSnapshotMoverResticRepository])
+}
+
+func TestRedactVolumeSnapshotRestore(t *testing.T) {
+	vsr := &datamoverv1alpha1.VolumeSnapshotRestore{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsr", Namespace: "app"},
+		Spec: datamoverv1alpha1.VolumeSnapshotRestoreSpec{
+			ResticSecretRef:              corev1api.LocalObjectReference{Name: "restic-secret"},
+			VolumeSnapshotMoverBackupref: datamoverv1alpha1.VSBRef{ResticRepository: "s3:s3.amazonaws.com/bucket/app"},
+		},
+	}
+
+	redacted := RedactVolumeSnapshotRestore(vsr)
+	assert.Nil(t, redacted.Annotations)
+	assert.Equal(t, RedactedValue, redacted.Spec.ResticSecretRef.Name)
+	assert.Equal(t, RedactedValue, redacted.Spec.VolumeSnapshotMoverBackupref.ResticRepository)
+	assert.Equal(t, "restic-secret", vsr.Spec.ResticSecretRef.Name)
+}
+
+func TestRedactUnstructured(t *testing.T) {
+	item := &unstructured.Unstructured{Object: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "vsb",
+			"annotations": map[string]interface{}{
+				CustomCASecretAnnotation: "ca-bundle",
+			},
+		},
+		"spec": map[string]interface{}{
+			"resticSecretRef": map[string]interface{}{"name": "restic-secret"},
+		},
+		"status": map[string]interface{}{
+			"resticrepository": "s3:s3.amazonaws.com/bucket/app",
+			"phase":            "Completed",
+		},
+	}}
+
+	redacted := RedactUnstructured(item)
+	assert.Equal(t, map[string]string{CustomCASecretAnnotation: RedactedValue}, redacted.GetAnnotations())
+	name, _, _ := unstructured.NestedString(redacted.Object, "spec", "resticSecretRef", "name")
+	assert.Equal(t, RedactedValue, name)
+	repository, _, _ := unstructured.NestedString(redacted.Object, "status", "resticrepository")
+	assert.Equal(t, RedactedValue, repository)
+	phase, _, _ := unstructured.NestedString(redacted.Object, "status", "phase")
+	assert.Equal(t, "Completed", phase)
+	_, found, _ := unstructured.NestedString(redacted.Object, "spec", "volumeSnapshotMoverBackupRef", "resticrepository")
+	assert.False(t, found, "absent fields must not be added")
+	name, _, _ = unstructured.NestedString(item.Object, "spec", "resticSecretRef", "name")
+	assert.Equal(t, "restic-secret", name)
+}
+
+func TestTraceObject(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsb", Namespace: "app"},
+		Status:     datamoverv1alpha1.VolumeSnapshotBackupStatus{ResticRepository: "s3:s3.amazonaws.com/bucket/app"},
+	}
+
+	TraceObject(logger, "Converted Item to VSB", vsb)
+	assert.Empty(t, hook.AllEntries(), "objects must not be logged at info level")
+
+	logger.SetLevel(logrus.TraceLevel)
+	TraceObject(logger.WithField(LogFieldBackup, "openshift-adp/backup-1"), "Converted Item to VSB", vsb)
+	entry := hook.LastEntry()
+	assert.Equal(t, logrus.TraceLevel, entry.Level)
+	assert.Contains(t, entry.Message, RedactedValue)
+	assert.NotContains(t, entry.Message, "s3:s3.amazonaws.com")
+}
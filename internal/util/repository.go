@@ -0,0 +1,263 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+	corev1api "k8s.io/api/core/v1"
+)
+
+const (
+	// repositoryRequestTimeout bounds the request checking a restic repository, including getting a token for it
+	repositoryRequestTimeout = 10 * time.Second
+	// azureStorageVersion is the version of the Azure Blob Storage API the repository check is sent with
+	azureStorageVersion = "2020-10-02"
+	// defaultAzureEndpointSuffix is the endpoint suffix of storage accounts of the Azure public cloud
+	defaultAzureEndpointSuffix = "core.windows.net"
+	// gcsReadOnlyScope is the OAuth scope the repository check reads Google Cloud Storage with
+	gcsReadOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+	// gcsEndpoint is the Google Cloud Storage XML API endpoint
+	gcsEndpoint = "https://storage.googleapis.com"
+)
+
+// VerifyRepositoryEnabled returns whether restores check that the restic repository of a VSB is reachable before
+// creating its VSR
+func VerifyRepositoryEnabled() bool {
+	enabled, _ := strconv.ParseBool(getSetting(DatamoverVerifyRepository))
+	return enabled
+}
+
+// resticRepository is the location of a restic repository on object storage
+type resticRepository struct {
+	// provider is the ResticSecretProviderLabel value of the repository's object storage
+	provider string
+	// endpoint is the scheme and host of s3 repositories
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+}
+
+// parseResticRepository parses the s3, azure and gs restic repository URLs the data mover writes to, as restic does:
+// s3:[<scheme>://]<host>/<bucket>[/<prefix>], azure:<container>:/[<prefix>] and gs:<bucket>:/[<prefix>]
+func parseResticRepository(repository string) (resticRepository, error) {
+	switch {
+	case strings.HasPrefix(repository, "s3:"):
+		location := strings.TrimPrefix(repository, "s3:")
+		if !strings.Contains(location, "://") {
+			location = "https://" + location
+		}
+		u, err := url.Parse(location)
+		if err != nil {
+			return resticRepository{}, errors.Wrapf(err, "invalid restic repository %q", repository)
+		}
+		bucket, prefix, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+		if len(u.Host) == 0 || len(bucket) == 0 {
+			return resticRepository{}, errors.Errorf("invalid restic repository %q, expected s3:<host>/<bucket>[/<prefix>]", repository)
+		}
+		return resticRepository{provider: "aws", endpoint: &url.URL{Scheme: u.Scheme, Host: u.Host}, bucket: bucket, prefix: prefix}, nil
+
+	case strings.HasPrefix(repository, "azure:"), strings.HasPrefix(repository, "gs:"):
+		scheme, location, _ := strings.Cut(repository, ":")
+		bucket, prefix, ok := strings.Cut(location, ":")
+		if !ok || len(bucket) == 0 {
+			return resticRepository{}, errors.Errorf("invalid restic repository %q, expected %s:<bucket>:/[<prefix>]", repository, scheme)
+		}
+		provider := "azure"
+		if scheme == "gs" {
+			provider = "gcp"
+		}
+		return resticRepository{provider: provider, bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+	}
+
+	return resticRepository{}, errors.Errorf("unsupported restic repository %q, expected an s3, azure or gs repository", repository)
+}
+
+// configKey returns the key of the config object every restic repository holds
+func (r resticRepository) configKey() string {
+	return path.Join(r.prefix, "config")
+}
+
+// CheckResticRepository checks that the restic repository is reachable with the credentials of the restic secret, by
+// requesting the head of the config object of the repository from its object storage. caBundle, if set, is the CA
+// bundle the object storage is verified with. The repository password is not needed and not checked.
+func CheckResticRepository(ctx context.Context, repository string, secret *corev1api.Secret, caBundle []byte) error {
+	repo, err := parseResticRepository(repository)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, repositoryRequestTimeout)
+	defer cancel()
+
+	var req *http.Request
+	switch repo.provider {
+	case "aws":
+		req, err = newS3HeadRequest(ctx, repo, secret.Data, time.Now())
+	case "azure":
+		req, err = newAzureHeadRequest(ctx, repo, secret.Data, time.Now())
+	default:
+		req, err = newGCSHeadRequest(ctx, repo, secret.Data)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error building the request checking restic repository %s", repository)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if len(caBundle) > 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBundle) {
+			return errors.New("the CA bundle of the backup storage location holds no PEM certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "restic repository %s is not reachable", repository)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return errors.Errorf("object storage denied access to restic repository %s with the credentials of restic secret %s/%s: %s",
+			repository, secret.Namespace, secret.Name, resp.Status)
+	case resp.StatusCode == http.StatusNotFound:
+		return errors.Errorf("restic repository %s does not exist, its bucket or config object %s was not found", repository, repo.configKey())
+	}
+	return errors.Errorf("unexpected response checking restic repository %s: %s", repository, resp.Status)
+}
+
+// newS3HeadRequest returns the HEAD request of the config object of an s3 repository, signed with AWS signature
+// version 4 with the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, in the AWS_DEFAULT_REGION or else us-east-1
+func newS3HeadRequest(ctx context.Context, repo resticRepository, creds map[string][]byte, now time.Time) (*http.Request, error) {
+	region := string(creds["AWS_DEFAULT_REGION"])
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+
+	u := *repo.endpoint
+	u.Path = "/" + repo.bucket + "/" + repo.configKey()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	emptyHash := sha256.Sum256(nil)
+	payloadHash := hex.EncodeToString(emptyHash[:])
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	canonicalHeaders := "host:" + u.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	if token := string(creds["AWS_SESSION_TOKEN"]); len(token) > 0 {
+		req.Header.Set("X-Amz-Security-Token", token)
+		canonicalHeaders += "x-amz-security-token:" + token + "\n"
+		signedHeaders += ";x-amz-security-token"
+	}
+
+	canonicalRequest := strings.Join([]string{http.MethodHead, u.EscapedPath(), "", canonicalHeaders, signedHeaders, payloadHash}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + string(creds["AWS_SECRET_ACCESS_KEY"]))
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+string(creds["AWS_ACCESS_KEY_ID"])+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+	return req, nil
+}
+
+// newAzureHeadRequest returns the HEAD request of the config blob of an azure repository, signed with the shared key
+// AZURE_ACCOUNT_KEY of the storage account AZURE_ACCOUNT_NAME, at AZURE_ENDPOINT_SUFFIX or else the public cloud
+func newAzureHeadRequest(ctx context.Context, repo resticRepository, creds map[string][]byte, now time.Time) (*http.Request, error) {
+	account := string(creds["AZURE_ACCOUNT_NAME"])
+	accountKey, err := base64.StdEncoding.DecodeString(string(creds["AZURE_ACCOUNT_KEY"]))
+	if err != nil {
+		return nil, errors.Wrap(err, "AZURE_ACCOUNT_KEY is not base64 encoded")
+	}
+	suffix := string(creds["AZURE_ENDPOINT_SUFFIX"])
+	if len(suffix) == 0 {
+		suffix = defaultAzureEndpointSuffix
+	}
+
+	u := url.URL{Scheme: "https", Host: account + ".blob." + suffix, Path: "/" + repo.bucket + "/" + repo.configKey()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	msDate := now.UTC().Format(http.TimeFormat)
+	req.Header.Set("X-Ms-Date", msDate)
+	req.Header.Set("X-Ms-Version", azureStorageVersion)
+
+	// the verb, eleven empty standard headers, the x-ms- headers and the resource
+	stringToSign := http.MethodHead + strings.Repeat("\n", 12) +
+		"x-ms-date:" + msDate + "\nx-ms-version:" + azureStorageVersion + "\n" +
+		"/" + account + u.EscapedPath()
+	signature := base64.StdEncoding.EncodeToString(hmacSHA256(accountKey, stringToSign))
+
+	req.Header.Set("Authorization", "SharedKey "+account+":"+signature)
+	return req, nil
+}
+
+// newGCSHeadRequest returns the HEAD request of the config object of a gs repository, authorized with a token of the
+// service account key GOOGLE_APPLICATION_CREDENTIALS
+func newGCSHeadRequest(ctx context.Context, repo resticRepository, creds map[string][]byte) (*http.Request, error) {
+	credentials, err := google.CredentialsFromJSON(ctx, creds["GOOGLE_APPLICATION_CREDENTIALS"], gcsReadOnlyScope)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	token, err := credentials.TokenSource.Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting a token for GOOGLE_APPLICATION_CREDENTIALS")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, gcsEndpoint+"/"+repo.bucket+"/"+repo.configKey(), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	token.SetAuthHeader(req)
+	return req, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseResticRepository(t *testing.T) {
+	testCases := []struct {
+		repository  string
+		expected    resticRepository
+		expectError bool
+	}{
+		{
+			repository: "s3:s3.amazonaws.com/bucket/prefix/app/data",
+			expected:   resticRepository{provider: "aws", endpoint: &url.URL{Scheme: "https", Host: "s3.amazonaws.com"}, bucket: "bucket", prefix: "prefix/app/data"},
+		},
+		{
+			repository: "s3:http://minio.minio.svc:9000/bucket",
+			expected:   resticRepository{provider: "aws", endpoint: &url.URL{Scheme: "http", Host: "minio.minio.svc:9000"}, bucket: "bucket"},
+		},
+		{
+			repository: "azure:container:/prefix/app/data",
+			expected:   resticRepository{provider: "azure", bucket: "container", prefix: "prefix/app/data"},
+		},
+		{
+			repository: "gs:bucket:/",
+			expected:   resticRepository{provider: "gcp", bucket: "bucket"},
+		},
+		{repository: "s3:s3.amazonaws.com", expectError: true},
+		{repository: "gs:bucket", expectError: true},
+		{repository: "rest:https://restic.example.com/", expectError: true},
+		{repository: "", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.repository, func(t *testing.T) {
+			repo, err := parseResticRepository(tc.repository)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, repo)
+		})
+	}
+}
+
+func TestCheckResticRepository(t *testing.T) {
+	status := http.StatusOK
+	var received *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	secret := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "restic", Namespace: "openshift-adp"},
+		Data: map[string][]byte{
+			"AWS_ACCESS_KEY_ID":     []byte("AKIDEXAMPLE"),
+			"AWS_SECRET_ACCESS_KEY": []byte("secret"),
+			"AWS_DEFAULT_REGION":    []byte("eu-west-1"),
+		},
+	}
+	repository := "s3:" + server.URL + "/bucket/prefix/app/data"
+
+	assert.NoError(t, CheckResticRepository(context.TODO(), repository, secret, nil))
+	assert.Equal(t, http.MethodHead, received.Method)
+	assert.Equal(t, "/bucket/prefix/app/data/config", received.URL.Path)
+	assert.True(t, strings.HasPrefix(received.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+	assert.Contains(t, received.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=")
+
+	status = http.StatusForbidden
+	assert.ErrorContains(t, CheckResticRepository(context.TODO(), repository, secret, nil), "denied access to restic repository")
+
+	status = http.StatusNotFound
+	assert.ErrorContains(t, CheckResticRepository(context.TODO(), repository, secret, nil), "does not exist")
+
+	server.Close()
+	assert.ErrorContains(t, CheckResticRepository(context.TODO(), repository, secret, nil), "is not reachable")
+
+	assert.ErrorContains(t, CheckResticRepository(context.TODO(), repository, secret, []byte("not a certificate")), "holds no PEM certificates")
+}
+
+func TestNewS3HeadRequest(t *testing.T) {
+	repo, err := parseResticRepository("s3:s3.amazonaws.com/bucket/prefix")
+	assert.NoError(t, err)
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	req, err := newS3HeadRequest(context.TODO(), repo, map[string][]byte{
+		"AWS_ACCESS_KEY_ID":     []byte("AKIDEXAMPLE"),
+		"AWS_SECRET_ACCESS_KEY": []byte("secret"),
+		"AWS_SESSION_TOKEN":     []byte("token"),
+	}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://s3.amazonaws.com/bucket/prefix/config", req.URL.String())
+	assert.Equal(t, "20230501T120000Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+	assert.Contains(t, req.Header.Get("Authorization"), "Credential=AKIDEXAMPLE/20230501/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature=")
+
+	// the signature covers the request time
+	later, err := newS3HeadRequest(context.TODO(), repo, map[string][]byte{"AWS_ACCESS_KEY_ID": []byte("AKIDEXAMPLE"), "AWS_SECRET_ACCESS_KEY": []byte("secret")}, now.Add(time.Second))
+	assert.NoError(t, err)
+	assert.NotEqual(t, req.Header.Get("Authorization"), later.Header.Get("Authorization"))
+}
+
+func TestNewAzureHeadRequest(t *testing.T) {
+	repo, err := parseResticRepository("azure:container:/prefix")
+	assert.NoError(t, err)
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	req, err := newAzureHeadRequest(context.TODO(), repo, map[string][]byte{
+		"AZURE_ACCOUNT_NAME": []byte("account"),
+		"AZURE_ACCOUNT_KEY":  []byte("a2V5"),
+	}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://account.blob.core.windows.net/container/prefix/config", req.URL.String())
+	assert.Equal(t, "Mon, 01 May 2023 12:00:00 GMT", req.Header.Get("X-Ms-Date"))
+	assert.True(t, strings.HasPrefix(req.Header.Get("Authorization"), "SharedKey account:"))
+
+	_, err = newAzureHeadRequest(context.TODO(), repo, map[string][]byte{
+		"AZURE_ACCOUNT_NAME": []byte("account"),
+		"AZURE_ACCOUNT_KEY":  []byte("not base64!"),
+	}, now)
+	assert.Error(t, err)
+}
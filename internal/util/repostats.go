@@ -0,0 +1,130 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// RepositoryStats are the statistics of a single repository the data mover moved volume data to, as far as they can be
+// derived from the VSBs still tracked in the cluster. The repository itself is not queried, so MovedCapacityBytes is the
+// summed capacity of the source PVCs moved into it, not its size on the storage backend, and prune history isn't known
+// because the controller deletes the ReplicationSources holding it once a VSB completes.
+type RepositoryStats struct {
+	Repository         string       `json:"repository"`
+	SnapshotCount      int          `json:"snapshotCount"`
+	MovedCapacityBytes int64        `json:"movedCapacityBytes"`
+	LastCompletion     *metav1.Time `json:"lastCompletion,omitempty"`
+}
+
+var (
+	repositoryStatsLock sync.Mutex
+	// the backup the repository stats were last collected for, collection runs once at backup start
+	repositoryStatsBackup string
+)
+
+// CollectRepositoryStats aggregates completed VSBs per repository
+func CollectRepositoryStats(vsbList datamoverv1alpha1.VolumeSnapshotBackupList) []RepositoryStats {
+	byRepository := map[string]*RepositoryStats{}
+	for _, vsb := range vsbList.Items {
+		if vsb.Status.Phase != datamoverv1alpha1.SnapMoverBackupPhaseCompleted || len(vsb.Status.ResticRepository) == 0 {
+			continue
+		}
+
+		stats, ok := byRepository[vsb.Status.ResticRepository]
+		if !ok {
+			stats = &RepositoryStats{Repository: vsb.Status.ResticRepository}
+			byRepository[vsb.Status.ResticRepository] = stats
+		}
+
+		stats.SnapshotCount++
+		if size, err := resource.ParseQuantity(vsb.Status.SourcePVCData.Size); err == nil {
+			stats.MovedCapacityBytes += size.Value()
+		}
+		if completion := vsb.Status.CompletionTimestamp; completion != nil && (stats.LastCompletion == nil || stats.LastCompletion.Before(completion)) {
+			stats.LastCompletion = completion
+		}
+	}
+
+	collected := []RepositoryStats{}
+	for _, stats := range byRepository {
+		collected = append(collected, *stats)
+	}
+	sort.Slice(collected, func(i, j int) bool {
+		return collected[i].Repository < collected[j].Repository
+	})
+
+	return collected
+}
+
+// RecordRepositoryStats collects the repository stats once per backup, when its first VSC is processed, and annotates
+// the backup with them
+func RecordRepositoryStats(backup *velerov1api.Backup, log logrus.FieldLogger) error {
+	repositoryStatsLock.Lock()
+	if repositoryStatsBackup == string(backup.UID) {
+		repositoryStatsLock.Unlock()
+		return nil
+	}
+	repositoryStatsBackup = string(backup.UID)
+	repositoryStatsLock.Unlock()
+
+	snapMoverClient, err := GetVolumeSnapshotMoverClient()
+	if err != nil {
+		return err
+	}
+
+	vsbList := datamoverv1alpha1.VolumeSnapshotBackupList{}
+	if err := snapMoverClient.List(context.TODO(), &vsbList); err != nil {
+		return errors.Wrap(err, "failed to list volumesnapshotbackups")
+	}
+
+	stats := CollectRepositoryStats(vsbList)
+
+	statsJSON, err := json.Marshal(stats)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal repository stats for backup %s", backup.Name)
+	}
+
+	veleroClient, err := GetVeleroClient()
+	if err != nil {
+		return err
+	}
+
+	backupNew := backup.DeepCopy()
+	AddAnnotations(&backupNew.ObjectMeta, map[string]string{
+		RepositoryStatsAnnotation: string(statsJSON),
+	})
+
+	err = veleroClient.Patch(context.TODO(), backupNew, client.MergeFrom(backup))
+	if err != nil {
+		return errors.Wrapf(err, "failed to patch backup %s with repository stats", backup.Name)
+	}
+
+	log.Infof("patched backup %s with stats for %d repositories", backup.Name, len(stats))
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCollectRepositoryStats(t *testing.T) {
+	earlier := metav1.NewTime(time.Unix(1700000000, 0))
+	later := metav1.NewTime(time.Unix(1700003600, 0))
+
+	newVSB := func(name, repo, size string, phase datamoverv1alpha1.VolumeSnapshotBackupPhase, completion *metav1.Time) datamoverv1alpha1.VolumeSnapshotBackup {
+		vsb := datamoverv1alpha1.VolumeSnapshotBackup{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		vsb.Status.Phase = phase
+		vsb.Status.ResticRepository = repo
+		vsb.Status.SourcePVCData.Size = size
+		vsb.Status.CompletionTimestamp = completion
+		return vsb
+	}
+
+	vsbList := datamoverv1alpha1.VolumeSnapshotBackupList{
+		Items: []datamoverv1alpha1.VolumeSnapshotBackup{
+			newVSB("vsb-1", "s3:bucket/ns-a", "1Gi", datamoverv1alpha1.SnapMoverBackupPhaseCompleted, &later),
+			newVSB("vsb-2", "s3:bucket/ns-a", "1Gi", datamoverv1alpha1.SnapMoverBackupPhaseCompleted, &earlier),
+			newVSB("vsb-3", "s3:bucket/ns-b", "512Mi", datamoverv1alpha1.SnapMoverBackupPhaseCompleted, nil),
+			newVSB("vsb-4", "s3:bucket/ns-b", "512Mi", datamoverv1alpha1.SnapMoverBackupPhaseInProgress, nil),
+		},
+	}
+
+	stats := CollectRepositoryStats(vsbList)
+
+	assert.Equal(t, []RepositoryStats{
+		{Repository: "s3:bucket/ns-a", SnapshotCount: 2, MovedCapacityBytes: 2 << 30, LastCompletion: &later},
+		{Repository: "s3:bucket/ns-b", SnapshotCount: 1, MovedCapacityBytes: 512 << 20},
+	}, stats)
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// resticRepositoryKey is the key of the base restic repository URL in restic secrets, the data mover appends the
+	// namespace and name of the PVC to it
+	resticRepositoryKey = "RESTIC_REPOSITORY"
+	// ResticSecretProviderLabel names the provider of the backup storage location of a restic secret, which selects
+	// the credentials the data mover hands the mover
+	ResticSecretProviderLabel = "openshift.io/oadp-bsl-provider"
+)
+
+// resticSecretProviderKeys are the credential keys the data mover requires in the restic secrets of each provider
+var resticSecretProviderKeys = map[string][]string{
+	"aws":   {"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"},
+	"azure": {"AZURE_ACCOUNT_NAME", "AZURE_ACCOUNT_KEY"},
+	"gcp":   {"GOOGLE_APPLICATION_CREDENTIALS"},
+}
+
+// copyResticSecretProvider sets the ResticSecretProviderLabel of the copy of a restic secret to the one of the secret,
+// the data mover reads it from the copy. It returns true if the label changed.
+func copyResticSecretProvider(secret *corev1api.Secret, copied *metav1.ObjectMeta) bool {
+	provider, ok := secret.Labels[ResticSecretProviderLabel]
+	if !ok || copied.Labels[ResticSecretProviderLabel] == provider {
+		return false
+	}
+	AddLabels(copied, map[string]string{ResticSecretProviderLabel: provider})
+	return true
+}
+
+// ValidateResticSecret checks that the restic secret holds everything the data mover builds the secret of the mover
+// from: the ResticSecretProviderLabel, the repository, the credentials of the provider and, unless withoutPassword, the
+// repository password. Keys with empty values count as missing. The error lists all that is missing, so a malformed
+// secret fails the item creating a VSB with it rather than the mover pod.
+func ValidateResticSecret(secret *corev1api.Secret, withoutPassword bool) error {
+	missing := []string{}
+
+	required := []string{resticRepositoryKey}
+	if !withoutPassword {
+		required = append(required, vaultPasswordKey)
+	}
+
+	provider := secret.Labels[ResticSecretProviderLabel]
+	if providerKeys, ok := resticSecretProviderKeys[provider]; ok {
+		required = append(required, providerKeys...)
+	} else {
+		providers := []string{}
+		for p := range resticSecretProviderKeys {
+			providers = append(providers, p)
+		}
+		sort.Strings(providers)
+		missing = append(missing, "label "+ResticSecretProviderLabel+" set to one of "+strings.Join(providers, ", "))
+	}
+
+	for _, key := range required {
+		if len(secret.Data[key]) == 0 {
+			missing = append(missing, "key "+key)
+		}
+	}
+
+	if len(missing) > 0 {
+		return errors.Errorf("restic secret %s/%s is missing %s", secret.Namespace, secret.Name, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateResticSecret(t *testing.T) {
+	testCases := []struct {
+		name            string
+		labels          map[string]string
+		data            map[string]string
+		withoutPassword bool
+		expectedError   string
+	}{
+		{
+			name:   "aws",
+			labels: map[string]string{ResticSecretProviderLabel: "aws"},
+			data: map[string]string{
+				"RESTIC_REPOSITORY": "s3:s3.amazonaws.com/bucket", "RESTIC_PASSWORD": "password",
+				"AWS_ACCESS_KEY_ID": "id", "AWS_SECRET_ACCESS_KEY": "key",
+			},
+		},
+		{
+			name:   "azure",
+			labels: map[string]string{ResticSecretProviderLabel: "azure"},
+			data: map[string]string{
+				"RESTIC_REPOSITORY": "azure:container:/", "RESTIC_PASSWORD": "password",
+				"AZURE_ACCOUNT_NAME": "account", "AZURE_ACCOUNT_KEY": "key",
+			},
+		},
+		{
+			name:            "gcp with the password resolved from vault",
+			labels:          map[string]string{ResticSecretProviderLabel: "gcp"},
+			data:            map[string]string{"RESTIC_REPOSITORY": "gs:bucket:/", "GOOGLE_APPLICATION_CREDENTIALS": "{}"},
+			withoutPassword: true,
+		},
+		{
+			name:          "missing and empty keys are listed",
+			labels:        map[string]string{ResticSecretProviderLabel: "aws"},
+			data:          map[string]string{"RESTIC_REPOSITORY": "s3:s3.amazonaws.com/bucket", "RESTIC_PASSWORD": "", "AWS_ACCESS_KEY_ID": "id"},
+			expectedError: "restic secret openshift-adp/restic is missing key RESTIC_PASSWORD, key AWS_SECRET_ACCESS_KEY",
+		},
+		{
+			name:          "no provider",
+			data:          map[string]string{"RESTIC_PASSWORD": "password"},
+			expectedError: "restic secret openshift-adp/restic is missing label openshift.io/oadp-bsl-provider set to one of aws, azure, gcp, key RESTIC_REPOSITORY",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			secret := &corev1api.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "restic", Namespace: "openshift-adp", Labels: tc.labels},
+				Data:       map[string][]byte{},
+			}
+			for k, v := range tc.data {
+				secret.Data[k] = []byte(v)
+			}
+
+			err := ValidateResticSecret(secret, tc.withoutPassword)
+			if len(tc.expectedError) > 0 {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
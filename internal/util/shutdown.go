@@ -0,0 +1,136 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultShutdownGracePeriod is how long in-flight item actions may run once the plugin is asked to shut down. It is
+// kept below the default pod termination grace period so the drain summary is logged before the pod is killed.
+const DefaultShutdownGracePeriod = 20 * time.Second
+
+// drainer tracks the item actions in flight in the plugin process so a shutdown can wait for them
+type drainer struct {
+	mu       sync.Mutex
+	draining bool
+	inFlight map[string]int
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+func newDrainer() *drainer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &drainer{inFlight: map[string]int{}, ctx: ctx, cancel: cancel}
+}
+
+var shutdown = newDrainer()
+
+// StartItemAction registers an item action execution, named after its action, and returns the context its client
+// calls and waits run with along with the func to call once it returns. The context carries the span of the execution,
+// and is canceled once the plugin starts shutting down or the item action returns. It fails once the plugin is shutting down, so no new item actions are
+// started.
+func StartItemAction(name string) (context.Context, func(), error) {
+	finished, err := shutdown.start(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(shutdown.ctx)
+	ctx, span := StartSpan(ctx, name+".Execute")
+	return ctx, func() {
+		span.End()
+		cancel()
+		finished()
+	}, nil
+}
+
+// ShutdownContext returns a context canceled once the plugin starts shutting down, for in-flight item actions to give
+// up waiting and return quickly
+func ShutdownContext() context.Context {
+	return shutdown.ctx
+}
+
+// Drain stops accepting new item actions, asks the in-flight ones to return and waits up to gracePeriod for them. It
+// returns the number of item actions that finished and, by action name, the ones still running.
+func Drain(gracePeriod time.Duration) (int, map[string]int) {
+	return shutdown.drain(gracePeriod)
+}
+
+func (d *drainer) start(name string) (func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return nil, errors.Errorf("plugin is shutting down, not starting %s", name)
+	}
+
+	d.inFlight[name]++
+	d.wg.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			d.inFlight[name]--
+			if d.inFlight[name] == 0 {
+				delete(d.inFlight, name)
+			}
+			d.mu.Unlock()
+			d.wg.Done()
+		})
+	}, nil
+}
+
+func (d *drainer) drain(gracePeriod time.Duration) (int, map[string]int) {
+	d.mu.Lock()
+	d.draining = true
+	total := 0
+	for _, n := range d.inFlight {
+		total += n
+	}
+	d.mu.Unlock()
+
+	d.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(gracePeriod):
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	remaining := map[string]int{}
+	for name, n := range d.inFlight {
+		remaining[name] = n
+		total -= n
+	}
+
+	return total, remaining
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainer(t *testing.T) {
+	d := newDrainer()
+
+	finishFirst, err := d.start("first")
+	assert.Nil(t, err)
+	_, err = d.start("second")
+	assert.Nil(t, err)
+
+	go func() {
+		<-d.ctx.Done()
+		finishFirst()
+		// calling it again must not count it twice
+		finishFirst()
+	}()
+
+	finished, remaining := d.drain(100 * time.Millisecond)
+	assert.Equal(t, 1, finished)
+	assert.Equal(t, map[string]int{"second": 1}, remaining)
+
+	_, err = d.start("third")
+	assert.Error(t, err)
+}
+
+func TestDrainerWithoutItemActions(t *testing.T) {
+	d := newDrainer()
+
+	finished, remaining := d.drain(time.Minute)
+	assert.Equal(t, 0, finished)
+	assert.Empty(t, remaining)
+}
+
+func TestStartItemAction(t *testing.T) {
+	ctx, finished, err := StartItemAction("test")
+	assert.Nil(t, err)
+	assert.NoError(t, ctx.Err())
+
+	// the client calls and waits of the item action stop once it returns
+	finished()
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
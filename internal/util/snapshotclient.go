@@ -0,0 +1,291 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotv1beta1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1beta1"
+	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
+	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
+	snapshotterv1beta1 "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1beta1"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/rest"
+)
+
+// newSnapshotClientSet returns the snapshot clientset the plugin works with: cs itself on clusters serving the
+// snapshot.storage.k8s.io/v1 API, or on clusters predating it but serving v1beta1, a clientset whose SnapshotV1 client
+// talks to the v1beta1 API
+func newSnapshotClientSet(cs snapshotterClientSet.Interface) (snapshotterClientSet.Interface, error) {
+	_, err := cs.Discovery().ServerResourcesForGroupVersion(snapshotv1api.SchemeGroupVersion.String())
+	if err == nil {
+		return cs, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, errors.Wrapf(err, "failed to discover %s", snapshotv1api.SchemeGroupVersion)
+	}
+
+	_, err = cs.Discovery().ServerResourcesForGroupVersion(snapshotv1beta1api.SchemeGroupVersion.String())
+	if apierrors.IsNotFound(err) {
+		// no snapshot API is served, requests fail as they would without the fallback
+		return cs, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to discover %s", snapshotv1beta1api.SchemeGroupVersion)
+	}
+
+	return &v1beta1SnapshotClientSet{Interface: cs}, nil
+}
+
+// v1beta1SnapshotClientSet serves SnapshotV1 from the v1beta1 snapshot API
+type v1beta1SnapshotClientSet struct {
+	snapshotterClientSet.Interface
+}
+
+func (c *v1beta1SnapshotClientSet) SnapshotV1() snapshotter.SnapshotV1Interface {
+	return &v1beta1SnapshotClient{beta: c.SnapshotV1beta1()}
+}
+
+// v1beta1SnapshotClient implements the v1 snapshot client with the v1beta1 one. The v1beta1 snapshot types have the
+// same fields as the v1 ones, objects are converted through their unstructured form.
+type v1beta1SnapshotClient struct {
+	beta snapshotterv1beta1.SnapshotV1beta1Interface
+}
+
+func (c *v1beta1SnapshotClient) RESTClient() rest.Interface {
+	return c.beta.RESTClient()
+}
+
+func (c *v1beta1SnapshotClient) VolumeSnapshots(namespace string) snapshotter.VolumeSnapshotInterface {
+	return &v1beta1VolumeSnapshots{beta: c.beta.VolumeSnapshots(namespace)}
+}
+
+func (c *v1beta1SnapshotClient) VolumeSnapshotContents() snapshotter.VolumeSnapshotContentInterface {
+	return &v1beta1VolumeSnapshotContents{beta: c.beta.VolumeSnapshotContents()}
+}
+
+func (c *v1beta1SnapshotClient) VolumeSnapshotClasses() snapshotter.VolumeSnapshotClassInterface {
+	return &v1beta1VolumeSnapshotClasses{beta: c.beta.VolumeSnapshotClasses()}
+}
+
+// convertSnapshotObject converts a snapshot object between its v1 and v1beta1 types
+func convertSnapshotObject[Out any](in interface{}) (*Out, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(in)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// the typed clients set the apiVersion and kind of the objects they send
+	delete(content, "apiVersion")
+	delete(content, "kind")
+
+	out := new(Out)
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(content, out); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return out, nil
+}
+
+// convertSnapshotResult converts the object returned by a v1beta1 client call to its v1 type
+func convertSnapshotResult[Out any, In any](in *In, err error) (*Out, error) {
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotObject[Out](in)
+}
+
+// convertSnapshotWatch converts the v1beta1 objects of the events of a watch to their v1 types
+func convertSnapshotWatch(w watch.Interface, err error) (watch.Interface, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+		var converted runtime.Object
+		var err error
+		switch obj := event.Object.(type) {
+		case *snapshotv1beta1api.VolumeSnapshot:
+			converted, err = convertSnapshotObject[snapshotv1api.VolumeSnapshot](obj)
+		case *snapshotv1beta1api.VolumeSnapshotContent:
+			converted, err = convertSnapshotObject[snapshotv1api.VolumeSnapshotContent](obj)
+		case *snapshotv1beta1api.VolumeSnapshotClass:
+			converted, err = convertSnapshotObject[snapshotv1api.VolumeSnapshotClass](obj)
+		default:
+			// error events carry a status
+			return event, true
+		}
+		if err != nil {
+			status := apierrors.NewInternalError(err).ErrStatus
+			return watch.Event{Type: watch.Error, Object: &status}, true
+		}
+		event.Object = converted
+		return event, true
+	}), nil
+}
+
+type v1beta1VolumeSnapshots struct {
+	beta snapshotterv1beta1.VolumeSnapshotInterface
+}
+
+func (c *v1beta1VolumeSnapshots) Create(ctx context.Context, vs *snapshotv1api.VolumeSnapshot, opts metav1.CreateOptions) (*snapshotv1api.VolumeSnapshot, error) {
+	in, err := convertSnapshotObject[snapshotv1beta1api.VolumeSnapshot](vs)
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshot](c.beta.Create(ctx, in, opts))
+}
+
+func (c *v1beta1VolumeSnapshots) Update(ctx context.Context, vs *snapshotv1api.VolumeSnapshot, opts metav1.UpdateOptions) (*snapshotv1api.VolumeSnapshot, error) {
+	in, err := convertSnapshotObject[snapshotv1beta1api.VolumeSnapshot](vs)
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshot](c.beta.Update(ctx, in, opts))
+}
+
+func (c *v1beta1VolumeSnapshots) UpdateStatus(ctx context.Context, vs *snapshotv1api.VolumeSnapshot, opts metav1.UpdateOptions) (*snapshotv1api.VolumeSnapshot, error) {
+	in, err := convertSnapshotObject[snapshotv1beta1api.VolumeSnapshot](vs)
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshot](c.beta.UpdateStatus(ctx, in, opts))
+}
+
+func (c *v1beta1VolumeSnapshots) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.beta.Delete(ctx, name, opts)
+}
+
+func (c *v1beta1VolumeSnapshots) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	return c.beta.DeleteCollection(ctx, opts, listOpts)
+}
+
+func (c *v1beta1VolumeSnapshots) Get(ctx context.Context, name string, opts metav1.GetOptions) (*snapshotv1api.VolumeSnapshot, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshot](c.beta.Get(ctx, name, opts))
+}
+
+func (c *v1beta1VolumeSnapshots) List(ctx context.Context, opts metav1.ListOptions) (*snapshotv1api.VolumeSnapshotList, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotList](c.beta.List(ctx, opts))
+}
+
+func (c *v1beta1VolumeSnapshots) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return convertSnapshotWatch(c.beta.Watch(ctx, opts))
+}
+
+func (c *v1beta1VolumeSnapshots) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*snapshotv1api.VolumeSnapshot, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshot](c.beta.Patch(ctx, name, pt, data, opts, subresources...))
+}
+
+type v1beta1VolumeSnapshotContents struct {
+	beta snapshotterv1beta1.VolumeSnapshotContentInterface
+}
+
+func (c *v1beta1VolumeSnapshotContents) Create(ctx context.Context, vsc *snapshotv1api.VolumeSnapshotContent, opts metav1.CreateOptions) (*snapshotv1api.VolumeSnapshotContent, error) {
+	in, err := convertSnapshotObject[snapshotv1beta1api.VolumeSnapshotContent](vsc)
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotContent](c.beta.Create(ctx, in, opts))
+}
+
+func (c *v1beta1VolumeSnapshotContents) Update(ctx context.Context, vsc *snapshotv1api.VolumeSnapshotContent, opts metav1.UpdateOptions) (*snapshotv1api.VolumeSnapshotContent, error) {
+	in, err := convertSnapshotObject[snapshotv1beta1api.VolumeSnapshotContent](vsc)
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotContent](c.beta.Update(ctx, in, opts))
+}
+
+func (c *v1beta1VolumeSnapshotContents) UpdateStatus(ctx context.Context, vsc *snapshotv1api.VolumeSnapshotContent, opts metav1.UpdateOptions) (*snapshotv1api.VolumeSnapshotContent, error) {
+	in, err := convertSnapshotObject[snapshotv1beta1api.VolumeSnapshotContent](vsc)
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotContent](c.beta.UpdateStatus(ctx, in, opts))
+}
+
+func (c *v1beta1VolumeSnapshotContents) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.beta.Delete(ctx, name, opts)
+}
+
+func (c *v1beta1VolumeSnapshotContents) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	return c.beta.DeleteCollection(ctx, opts, listOpts)
+}
+
+func (c *v1beta1VolumeSnapshotContents) Get(ctx context.Context, name string, opts metav1.GetOptions) (*snapshotv1api.VolumeSnapshotContent, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotContent](c.beta.Get(ctx, name, opts))
+}
+
+func (c *v1beta1VolumeSnapshotContents) List(ctx context.Context, opts metav1.ListOptions) (*snapshotv1api.VolumeSnapshotContentList, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotContentList](c.beta.List(ctx, opts))
+}
+
+func (c *v1beta1VolumeSnapshotContents) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return convertSnapshotWatch(c.beta.Watch(ctx, opts))
+}
+
+func (c *v1beta1VolumeSnapshotContents) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*snapshotv1api.VolumeSnapshotContent, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotContent](c.beta.Patch(ctx, name, pt, data, opts, subresources...))
+}
+
+type v1beta1VolumeSnapshotClasses struct {
+	beta snapshotterv1beta1.VolumeSnapshotClassInterface
+}
+
+func (c *v1beta1VolumeSnapshotClasses) Create(ctx context.Context, vsClass *snapshotv1api.VolumeSnapshotClass, opts metav1.CreateOptions) (*snapshotv1api.VolumeSnapshotClass, error) {
+	in, err := convertSnapshotObject[snapshotv1beta1api.VolumeSnapshotClass](vsClass)
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotClass](c.beta.Create(ctx, in, opts))
+}
+
+func (c *v1beta1VolumeSnapshotClasses) Update(ctx context.Context, vsClass *snapshotv1api.VolumeSnapshotClass, opts metav1.UpdateOptions) (*snapshotv1api.VolumeSnapshotClass, error) {
+	in, err := convertSnapshotObject[snapshotv1beta1api.VolumeSnapshotClass](vsClass)
+	if err != nil {
+		return nil, err
+	}
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotClass](c.beta.Update(ctx, in, opts))
+}
+
+func (c *v1beta1VolumeSnapshotClasses) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.beta.Delete(ctx, name, opts)
+}
+
+func (c *v1beta1VolumeSnapshotClasses) DeleteCollection(ctx context.Context, opts metav1.DeleteOptions, listOpts metav1.ListOptions) error {
+	return c.beta.DeleteCollection(ctx, opts, listOpts)
+}
+
+func (c *v1beta1VolumeSnapshotClasses) Get(ctx context.Context, name string, opts metav1.GetOptions) (*snapshotv1api.VolumeSnapshotClass, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotClass](c.beta.Get(ctx, name, opts))
+}
+
+func (c *v1beta1VolumeSnapshotClasses) List(ctx context.Context, opts metav1.ListOptions) (*snapshotv1api.VolumeSnapshotClassList, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotClassList](c.beta.List(ctx, opts))
+}
+
+func (c *v1beta1VolumeSnapshotClasses) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	return convertSnapshotWatch(c.beta.Watch(ctx, opts))
+}
+
+func (c *v1beta1VolumeSnapshotClasses) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts metav1.PatchOptions, subresources ...string) (*snapshotv1api.VolumeSnapshotClass, error) {
+	return convertSnapshotResult[snapshotv1api.VolumeSnapshotClass](c.beta.Patch(ctx, name, pt, data, opts, subresources...))
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
+	snapshotv1beta1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1beta1"
+	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+)
+
+func TestNewSnapshotClientSet(t *testing.T) {
+	testCases := []struct {
+		name          string
+		groupVersions []string
+		fallback      bool
+	}{
+		{name: "v1 served", groupVersions: []string{"snapshot.storage.k8s.io/v1", "snapshot.storage.k8s.io/v1beta1"}},
+		{name: "v1beta1 served only", groupVersions: []string{"snapshot.storage.k8s.io/v1beta1"}, fallback: true},
+		{name: "no snapshot API served"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := snapshotFake.NewSimpleClientset()
+			for _, gv := range tc.groupVersions {
+				cs.Discovery().(*fakediscovery.FakeDiscovery).Resources = append(cs.Discovery().(*fakediscovery.FakeDiscovery).Resources,
+					&metav1.APIResourceList{GroupVersion: gv})
+			}
+
+			snapshotClient, err := newSnapshotClientSet(cs)
+			assert.NoError(t, err)
+			_, isFallback := snapshotClient.(*v1beta1SnapshotClientSet)
+			assert.Equal(t, tc.fallback, isFallback)
+		})
+	}
+}
+
+func TestV1beta1SnapshotClient(t *testing.T) {
+	className := "csi-snapclass"
+	pvcName := "pvc-1"
+	cs := snapshotFake.NewSimpleClientset(
+		&snapshotv1beta1api.VolumeSnapshot{
+			ObjectMeta: metav1.ObjectMeta{Name: "vs-1", Namespace: "app-ns"},
+			Spec: snapshotv1beta1api.VolumeSnapshotSpec{
+				Source:                  snapshotv1beta1api.VolumeSnapshotSource{PersistentVolumeClaimName: &pvcName},
+				VolumeSnapshotClassName: &className,
+			},
+		},
+		&snapshotv1beta1api.VolumeSnapshotClass{ObjectMeta: metav1.ObjectMeta{Name: className}, Driver: "ebs.csi.aws.com"},
+	)
+	snapshotClient := (&v1beta1SnapshotClientSet{Interface: cs}).SnapshotV1()
+	ctx := context.TODO()
+
+	vs, err := snapshotClient.VolumeSnapshots("app-ns").Get(ctx, "vs-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, pvcName, *vs.Spec.Source.PersistentVolumeClaimName)
+	assert.Equal(t, className, *vs.Spec.VolumeSnapshotClassName)
+
+	classes, err := snapshotClient.VolumeSnapshotClasses().List(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, classes.Items, 1)
+	assert.Equal(t, "ebs.csi.aws.com", classes.Items[0].Driver)
+
+	w, err := snapshotClient.VolumeSnapshotContents().Watch(ctx, metav1.ListOptions{})
+	assert.NoError(t, err)
+	defer w.Stop()
+
+	handle := "snap-0123"
+	_, err = snapshotClient.VolumeSnapshotContents().Create(ctx, &snapshotv1api.VolumeSnapshotContent{
+		TypeMeta:   metav1.TypeMeta{APIVersion: snapshotv1api.SchemeGroupVersion.String(), Kind: "VolumeSnapshotContent"},
+		ObjectMeta: metav1.ObjectMeta{Name: "vsc-1"},
+		Spec: snapshotv1api.VolumeSnapshotContentSpec{
+			Driver: "ebs.csi.aws.com",
+			Source: snapshotv1api.VolumeSnapshotContentSource{SnapshotHandle: &handle},
+		},
+	}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	event := <-w.ResultChan()
+	assert.Equal(t, watch.Added, event.Type)
+	vsc, ok := event.Object.(*snapshotv1api.VolumeSnapshotContent)
+	assert.True(t, ok)
+	assert.Equal(t, handle, *vsc.Spec.Source.SnapshotHandle)
+
+	stored, err := cs.SnapshotV1beta1().VolumeSnapshotContents().Get(ctx, "vsc-1", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ebs.csi.aws.com", stored.Spec.Driver)
+
+	patched, err := snapshotClient.VolumeSnapshots("app-ns").Patch(ctx, "vs-1", types.MergePatchType,
+		[]byte(`{"metadata":{"labels":{"velero.io/backup-name":"backup-1"}}}`), metav1.PatchOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "backup-1", patched.Labels["velero.io/backup-name"])
+
+	assert.NoError(t, snapshotClient.VolumeSnapshots("app-ns").Delete(ctx, "vs-1", metav1.DeleteOptions{}))
+	_, err = cs.SnapshotV1beta1().VolumeSnapshots("app-ns").Get(ctx, "vs-1", metav1.GetOptions{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// GetTenantResticSecret returns the restic secret of the PVC namespace the PVC's data is moved with: the secret its
+// ResticSecretAnnotation names, or else the secret of the namespace labeled with ResticSecretLabel and the backup
+// storage location. It returns nil if the namespace has no restic secret.
+func GetTenantResticSecret(pvc *corev1api.PersistentVolumeClaim, bslName string, secretsGetter corev1client.SecretsGetter) (*corev1api.Secret, error) {
+	if name, ok := pvc.Annotations[ResticSecretAnnotation]; ok && len(name) > 0 {
+		secret, err := secretsGetter.Secrets(pvc.Namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting restic secret %s/%s of PVC %s", pvc.Namespace, name, pvc.Name)
+		}
+		return secret, nil
+	}
+
+	secretList, err := secretsGetter.Secrets(pvc.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true,%s=%s", ResticSecretLabel, velerov1api.StorageLocationLabel, label.GetValidName(bslName)),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing restic secrets in namespace %s", pvc.Namespace)
+	}
+
+	switch len(secretList.Items) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &secretList.Items[0], nil
+	default:
+		return nil, errors.Errorf("found %d restic secrets labeled for backup storage location %s in namespace %s, expected at most one", len(secretList.Items), bslName, pvc.Namespace)
+	}
+}
+
+// GetTenantResticSecretCopyName returns the name of the copy of a tenant restic secret in the velero namespace
+func GetTenantResticSecretCopyName(namespace, name string) string {
+	return label.GetValidName(fmt.Sprintf("%s-%s", namespace, name))
+}
+
+// SyncTenantResticSecret copies the tenant restic secret into the velero namespace, where the data mover reads restic
+// secrets from, and returns the name of the copy. An existing copy is updated to the data and provider of the tenant
+// secret.
+func SyncTenantResticSecret(secret *corev1api.Secret, protectedNS string, secretsGetter corev1client.SecretsGetter) (string, error) {
+	name := GetTenantResticSecretCopyName(secret.Namespace, secret.Name)
+
+	existing, err := secretsGetter.Secrets(protectedNS).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		copied := &corev1api.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: protectedNS,
+				Labels: map[string]string{
+					TenantResticSecretNamespaceLabel: secret.Namespace,
+				},
+			},
+			Type: secret.Type,
+			Data: secret.Data,
+		}
+		copyResticSecretProvider(secret, &copied.ObjectMeta)
+		_, err = secretsGetter.Secrets(protectedNS).Create(context.TODO(), copied, metav1.CreateOptions{})
+		if err != nil && !apierrors.IsAlreadyExists(err) {
+			return "", errors.Wrapf(err, "error copying restic secret %s/%s to %s/%s", secret.Namespace, secret.Name, protectedNS, name)
+		}
+		return name, nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting restic secret %s/%s", protectedNS, name)
+	}
+
+	if existing.Labels[TenantResticSecretNamespaceLabel] != secret.Namespace {
+		return "", errors.Errorf("secret %s/%s is not a copy of a restic secret of namespace %s", protectedNS, name, secret.Namespace)
+	}
+
+	providerChanged := copyResticSecretProvider(secret, &existing.ObjectMeta)
+	if providerChanged || !reflect.DeepEqual(existing.Data, secret.Data) {
+		existing.Data = secret.Data
+		if _, err := secretsGetter.Secrets(protectedNS).Update(context.TODO(), existing, metav1.UpdateOptions{}); err != nil {
+			return "", errors.Wrapf(err, "error updating restic secret %s/%s", protectedNS, name)
+		}
+	}
+
+	return name, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetTenantResticSecret(t *testing.T) {
+	newSecret := func(name string, labels map[string]string) *corev1api.Secret {
+		return &corev1api.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "tenant-a", Labels: labels}}
+	}
+	bslLabels := map[string]string{ResticSecretLabel: "true", velerov1api.StorageLocationLabel: "default"}
+
+	testCases := []struct {
+		name           string
+		pvcAnnotations map[string]string
+		secrets        []runtime.Object
+		expected       string
+		expectError    bool
+	}{
+		{
+			name:    "no tenant secret",
+			secrets: []runtime.Object{newSecret("other", nil)},
+		},
+		{
+			name:           "secret named by the PVC",
+			pvcAnnotations: map[string]string{ResticSecretAnnotation: "restic"},
+			secrets:        []runtime.Object{newSecret("restic", nil), newSecret("labeled", bslLabels)},
+			expected:       "restic",
+		},
+		{
+			name:           "secret named by the PVC is missing",
+			pvcAnnotations: map[string]string{ResticSecretAnnotation: "restic"},
+			expectError:    true,
+		},
+		{
+			name:     "secret labeled for the backup storage location",
+			secrets:  []runtime.Object{newSecret("labeled", bslLabels)},
+			expected: "labeled",
+		},
+		{
+			name:        "several labeled secrets",
+			secrets:     []runtime.Object{newSecret("labeled", bslLabels), newSecret("labeled-2", bslLabels)},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pvc := &corev1api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "tenant-a", Annotations: tc.pvcAnnotations}}
+
+			secret, err := GetTenantResticSecret(pvc, "default", fake.NewSimpleClientset(tc.secrets...).CoreV1())
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			if len(tc.expected) == 0 {
+				assert.Nil(t, secret)
+				return
+			}
+			assert.Equal(t, tc.expected, secret.Name)
+		})
+	}
+}
+
+func TestSyncTenantResticSecret(t *testing.T) {
+	tenantSecret := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "restic", Namespace: "tenant-a"},
+		Data:       map[string][]byte{"RESTIC_PASSWORD": []byte("first")},
+	}
+	client := fake.NewSimpleClientset(
+		&corev1api.Secret{ObjectMeta: metav1.ObjectMeta{Name: "tenant-b-restic", Namespace: "openshift-adp"}},
+	)
+
+	name, err := SyncTenantResticSecret(tenantSecret, "openshift-adp", client.CoreV1())
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-a-restic", name)
+
+	tenantSecret.Data["RESTIC_PASSWORD"] = []byte("second")
+	tenantSecret.Labels = map[string]string{ResticSecretProviderLabel: "aws"}
+	_, err = SyncTenantResticSecret(tenantSecret, "openshift-adp", client.CoreV1())
+	assert.NoError(t, err)
+
+	copied, err := client.CoreV1().Secrets("openshift-adp").Get(context.TODO(), name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("second"), copied.Data["RESTIC_PASSWORD"])
+	assert.Equal(t, "tenant-a", copied.Labels[TenantResticSecretNamespaceLabel])
+	assert.Equal(t, "aws", copied.Labels[ResticSecretProviderLabel])
+
+	// secrets in the velero namespace that aren't copies of the tenant secret are left alone
+	_, err = SyncTenantResticSecret(&corev1api.Secret{ObjectMeta: metav1.ObjectMeta{Name: "restic", Namespace: "tenant-b"}}, "openshift-adp", client.CoreV1())
+	assert.Error(t, err)
+}
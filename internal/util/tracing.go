@@ -0,0 +1,101 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName is the instrumentation scope of the spans of the plugin
+	tracerName = "github.com/vmware-tanzu/velero-plugin-for-csi"
+	// tracingServiceName is the service the spans are reported from, unless OTEL_SERVICE_NAME says otherwise
+	tracingServiceName = "velero-plugin-for-vsm"
+	// tracingBatchTimeout bounds how long finished spans are held before being exported, velero stops plugin
+	// processes without waiting for them to flush
+	tracingBatchTimeout = time.Second
+
+	otlpEndpointEnv       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	otlpTracesEndpointEnv = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+)
+
+// InitTracing exports the spans of the plugin over OTLP when the standard OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT env var is set, configured by the other OTEL_EXPORTER_OTLP_* env vars. Otherwise
+// spans are not recorded. It returns the func flushing the spans not exported yet.
+func InitTracing(ctx context.Context) (func(context.Context) error, error) {
+	if len(os.Getenv(otlpEndpointEnv)) == 0 && len(os.Getenv(otlpTracesEndpointEnv)) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating OTLP trace exporter")
+	}
+
+	res, err := resource.Merge(
+		resource.NewSchemaless(attribute.String("service.name", tracingServiceName)),
+		resource.Default(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating trace resource")
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter, sdktrace.WithBatchTimeout(tracingBatchTimeout)),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// StartSpan starts a span named after the operation, child of the span in ctx if any, and returns the context carrying
+// it. The span is a no-op unless InitTracing enabled tracing.
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// EndSpan ends the span, marking it failed with err if there is one
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// startWait starts the span of the named wait, and returns the context the wait runs with along with the func to call
+// once it returns, which also records how long it took
+func startWait(ctx context.Context, name string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := StartSpan(ctx, "wait "+name)
+	return ctx, func() {
+		span.End()
+		observeWaitDuration(name, start)
+	}
+}
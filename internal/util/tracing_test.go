@@ -0,0 +1,62 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestInitTracingDisabled(t *testing.T) {
+	t.Setenv(otlpEndpointEnv, "")
+	t.Setenv(otlpTracesEndpointEnv, "")
+
+	flush, err := InitTracing(context.Background())
+	assert.Nil(t, err)
+	assert.Nil(t, flush(context.Background()))
+}
+
+func TestItemActionSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prevProvider := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(prevProvider)
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, finished, err := StartItemAction("VolumeSnapshotBackupItemAction")
+	assert.Nil(t, err)
+	_, done := startWait(ctx, "volumesnapshotcontent_ready")
+	done()
+	finished()
+
+	_, span := StartSpan(context.Background(), "VolumeSnapshotContentBackupItemActionV2.Cancel")
+	EndSpan(span, errors.New("volumesnapshotbackup not found"))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	assert.Equal(t, "wait volumesnapshotcontent_ready", spans[0].Name())
+	assert.Equal(t, "VolumeSnapshotBackupItemAction.Execute", spans[1].Name())
+	// waits are children of the item action they run in
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+}
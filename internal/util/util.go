@@ -18,35 +18,45 @@ package util
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	volsyncv1alpha1 "github.com/backube/volsync/api/v1alpha1"
-	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
-	snapshotterClientSet "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned"
 	snapshotter "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/typed/volumesnapshot/v1"
 	corev1api "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/util/wait"
-	"k8s.io/client-go/kubernetes"
+	"k8s.io/apimachinery/pkg/watch"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 
 	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
 	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	"github.com/vmware-tanzu/velero/pkg/label"
+	"github.com/vmware-tanzu/velero/pkg/podvolume"
 )
 
 const (
@@ -56,7 +66,9 @@ const (
 	ConditionReconciled   = "Reconciled"
 
 	// Timeout consts
-	DefaultVSRTimeout = "10m"
+	DefaultVSRTimeout       = "10m"
+	DefaultDatamoverTimeout = "10m"
+	DefaultDeleteTimeout    = "2m"
 )
 
 func GetPVForPVC(pvc *corev1api.PersistentVolumeClaim, corev1 corev1client.PersistentVolumesGetter) (*corev1api.PersistentVolume, error) {
@@ -75,22 +87,34 @@ func GetPVForPVC(pvc *corev1api.PersistentVolumeClaim, corev1 corev1client.Persi
 	return pv, nil
 }
 
+// podListPageSize is the number of pods GetPodsUsingPVC lists at a time
+const podListPageSize = 500
+
+// GetPodsUsingPVC returns the pods of the namespace mounting the PVC. The pods are listed in pages of podListPageSize,
+// so only the pods of one page and those mounting the PVC are held at a time, however many pods the namespace has.
+// Pods can't be selected by the volumes they mount server side.
 func GetPodsUsingPVC(pvcNamespace, pvcName string, corev1 corev1client.PodsGetter) ([]corev1api.Pod, error) {
 	podsUsingPVC := []corev1api.Pod{}
-	podList, err := corev1.Pods(pvcNamespace).List(context.TODO(), metav1.ListOptions{})
-	if err != nil {
-		return nil, err
-	}
+	listOptions := metav1.ListOptions{Limit: podListPageSize}
+	for {
+		podList, err := corev1.Pods(pvcNamespace).List(context.TODO(), listOptions)
+		if err != nil {
+			return nil, err
+		}
 
-	for _, p := range podList.Items {
-		for _, v := range p.Spec.Volumes {
-			if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == pvcName {
-				podsUsingPVC = append(podsUsingPVC, p)
+		for _, p := range podList.Items {
+			for _, v := range p.Spec.Volumes {
+				if v.PersistentVolumeClaim != nil && v.PersistentVolumeClaim.ClaimName == pvcName {
+					podsUsingPVC = append(podsUsingPVC, p)
+				}
 			}
 		}
-	}
 
-	return podsUsingPVC, nil
+		if len(podList.Continue) == 0 {
+			return podsUsingPVC, nil
+		}
+		listOptions.Continue = podList.Continue
+	}
 }
 
 func GetPodVolumeNameForPVC(pod corev1api.Pod, pvcName string) (string, error) {
@@ -111,6 +135,37 @@ func Contains(slice []string, key string) bool {
 	return false
 }
 
+// IsPVCBackedUpByFsBackup returns whether a pod using the PVC has its volume backed up with pod volume file system
+// backup, in which case the PVC must not be snapshotted
+func IsPVCBackedUpByFsBackup(pvcNamespace, pvcName string, podClient corev1client.PodsGetter, defaultVolumesToFsBackup bool) (bool, error) {
+	pods, err := GetPodsUsingPVC(pvcNamespace, pvcName, podClient)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for _, p := range pods {
+		pod := p
+		fsBackupVols := podvolume.GetVolumesByPod(&pod, defaultVolumesToFsBackup)
+		if len(fsBackupVols) == 0 {
+			continue
+		}
+
+		volName, err := GetPodVolumeNameForPVC(pod, pvcName)
+		if err != nil {
+			return false, err
+		}
+		if Contains(fsBackupVols, volName) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ErrNoVolumeSnapshotClass is returned by GetVolumeSnapshotClassForStorageClass when no volumesnapshotclass of the
+// provisioner is labeled for velero
+var ErrNoVolumeSnapshotClass = errors.New("no volumesnapshotclass labeled for velero")
+
 // GetVolumeSnapshotClassForStorageClass returns a VolumeSnapshotClass for the supplied volume provisioner/ driver name.
 func GetVolumeSnapshotClassForStorageClass(provisioner string, snapshotClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshotClass, error) {
 	snapshotClasses, err := snapshotClient.VolumeSnapshotClasses().List(context.TODO(), metav1.ListOptions{})
@@ -127,50 +182,107 @@ func GetVolumeSnapshotClassForStorageClass(provisioner string, snapshotClient sn
 			return &sc, nil
 		}
 	}
-	return nil, errors.Errorf("failed to get volumesnapshotclass for provisioner %s, ensure that the desired volumesnapshot class has the %s label", provisioner, VolumeSnapshotClassSelectorLabel)
+	return nil, errors.Wrapf(ErrNoVolumeSnapshotClass, "failed to get volumesnapshotclass for provisioner %s, ensure that the desired volumesnapshot class has the %s label", provisioner, VolumeSnapshotClassSelectorLabel)
+}
+
+// FsBackupFallbackEnabled returns whether PVCs whose provisioner has no volumesnapshotclass are backed up with file
+// system backup instead of failing
+func FsBackupFallbackEnabled() bool {
+	enabled, _ := strconv.ParseBool(getSetting(DatamoverFsBackupFallback))
+	return enabled
+}
+
+// AnnotatePodsForFsBackup adds the volume of the PVC to the volumes velero backs up with file system backup of the pods
+// mounting it, and returns those pods
+func AnnotatePodsForFsBackup(pvcNamespace, pvcName string, podClient corev1client.PodsGetter) ([]corev1api.Pod, error) {
+	pods, err := GetPodsUsingPVC(pvcNamespace, pvcName, podClient)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		volName, err := GetPodVolumeNameForPVC(*pod, pvcName)
+		if err != nil {
+			return nil, err
+		}
+
+		volumes := podvolume.GetVolumesToBackup(pod)
+		if Contains(volumes, volName) {
+			continue
+		}
+		volumes = append(volumes, volName)
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{podvolume.VolumesToBackupAnnotation: strings.Join(volumes, ",")},
+			},
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if _, err := podClient.Pods(pvcNamespace).Patch(context.TODO(), pod.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return nil, errors.Wrapf(err, "failed to annotate pod %s/%s for file system backup", pvcNamespace, pod.Name)
+		}
+	}
+
+	return pods, nil
 }
 
 // GetVolumeSnapshotContentForVolumeSnapshot returns the volumesnapshotcontent object associated with the volumesnapshot
-func GetVolumeSnapshotContentForVolumeSnapshot(volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger, shouldWait bool) (*snapshotv1api.VolumeSnapshotContent, error) {
+func GetVolumeSnapshotContentForVolumeSnapshot(ctx context.Context, volSnap *snapshotv1api.VolumeSnapshot, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger, shouldWait bool) (*snapshotv1api.VolumeSnapshotContent, error) {
 	if !shouldWait {
 		if volSnap.Status == nil || volSnap.Status.BoundVolumeSnapshotContentName == nil {
 			// volumesnapshot hasn't been reconciled and we're not waiting for it.
 			return nil, nil
 		}
-		vsc, err := snapshotClient.VolumeSnapshotContents().Get(context.TODO(), *volSnap.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+		vsc, err := snapshotClient.VolumeSnapshotContents().Get(ctx, *volSnap.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
 		if err != nil {
 			return nil, errors.Wrap(err, "error getting volume snapshot content from API")
 		}
 		return vsc, nil
 	}
 
-	// We'll wait 10m for the VSC to be reconciled polling every 5s
+	ctx, done := startWait(ctx, "volumesnapshotcontent_bound")
+	defer done()
+
+	// We'll wait 10m for the VSC to be reconciled, polling with backoff
 	// TODO: make this timeout configurable.
 	timeout := 10 * time.Minute
-	interval := 5 * time.Second
 	var snapshotContent *snapshotv1api.VolumeSnapshotContent
+	transient := newTransientErrors()
 
-	err := wait.PollImmediate(interval, timeout, func() (bool, error) {
-		vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(context.TODO(), volSnap.Name, metav1.GetOptions{})
+	err := pollWithBackoff(ctx, timeout, func(ctx context.Context) (bool, error) {
+		vs, err := snapshotClient.VolumeSnapshots(volSnap.Namespace).Get(ctx, volSnap.Name, metav1.GetOptions{})
+		if err != nil && transient.retry(err) {
+			log.Warnf("failed to get volumesnapshot %s/%s, retrying: %s", volSnap.Namespace, volSnap.Name, err.Error())
+			return false, nil
+		}
 		if err != nil {
 			return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name))
 		}
+		transient.reset()
 
 		if vs.Status == nil || vs.Status.BoundVolumeSnapshotContentName == nil {
-			log.Infof("Waiting for CSI driver to reconcile volumesnapshot %s/%s. Retrying in %ds", volSnap.Namespace, volSnap.Name, interval/time.Second)
+			log.Infof("Waiting for CSI driver to reconcile volumesnapshot %s/%s", volSnap.Namespace, volSnap.Name)
 			return false, nil
 		}
 
-		snapshotContent, err = snapshotClient.VolumeSnapshotContents().Get(context.TODO(), *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+		snapshotContent, err = snapshotClient.VolumeSnapshotContents().Get(ctx, *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+		if err != nil && transient.retry(err) {
+			log.Warnf("failed to get volumesnapshotcontent %s, retrying: %s", *vs.Status.BoundVolumeSnapshotContentName, err.Error())
+			return false, nil
+		}
 		if err != nil {
 			return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshotcontent %s for volumesnapshot %s/%s", *vs.Status.BoundVolumeSnapshotContentName, vs.Namespace, vs.Name))
 		}
+		transient.reset()
 
 		// we need to wait for the VolumeSnaphotContent to have a snapshot handle because during restore,
 		// we'll use that snapshot handle as the source for the VolumeSnapshotContent so it's statically
 		// bound to the existing snapshot.
 		if snapshotContent.Status == nil || snapshotContent.Status.SnapshotHandle == nil {
-			log.Infof("Waiting for volumesnapshotcontents %s to have snapshot handle. Retrying in %ds", snapshotContent.Name, interval/time.Second)
+			log.Infof("Waiting for volumesnapshotcontents %s to have snapshot handle", snapshotContent.Name)
 			return false, nil
 		}
 
@@ -187,26 +299,82 @@ func GetVolumeSnapshotContentForVolumeSnapshot(volSnap *snapshotv1api.VolumeSnap
 	return snapshotContent, nil
 }
 
-func GetClients() (*kubernetes.Clientset, *snapshotterClientSet.Clientset, error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	configOverrides := &clientcmd.ConfigOverrides{}
-	kubeConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, configOverrides)
-	clientConfig, err := kubeConfig.ClientConfig()
+var (
+	// pvNamespaceByHandle maps CSI volume handles to the namespace of the PVC bound to the PV,
+	// so resolving a namespace does not list every PV for each volumesnapshotcontent
+	pvNamespaceByHandle = map[string]string{}
+	pvNamespaceLock     sync.Mutex
+)
+
+// ResolveVolumeSnapshotContentNamespace returns the namespace of the volumesnapshot bound to the volumesnapshotcontent,
+// falling back to the namespace of the PVC claiming its source volume when the reference has no namespace
+func ResolveVolumeSnapshotContentNamespace(snapCont *snapshotv1api.VolumeSnapshotContent, corev1 corev1client.PersistentVolumesGetter) (string, error) {
+	if len(snapCont.Spec.VolumeSnapshotRef.Namespace) > 0 {
+		return snapCont.Spec.VolumeSnapshotRef.Namespace, nil
+	}
+
+	if snapCont.Spec.Source.VolumeHandle == nil {
+		return "", errors.Errorf("volumesnapshotcontent %s has no volumesnapshot namespace and no source volume handle to resolve it from", snapCont.Name)
+	}
+
+	volumeHandle := *snapCont.Spec.Source.VolumeHandle
+
+	pvNamespaceLock.Lock()
+	defer pvNamespaceLock.Unlock()
+
+	if ns, ok := pvNamespaceByHandle[volumeHandle]; ok {
+		return ns, nil
+	}
+
+	// refresh the cache once on a miss; a new PV may have been bound since the last listing
+	pvList, err := corev1.PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
-		return nil, nil, errors.WithStack(err)
+		return "", errors.Wrap(err, "error listing persistentvolumes")
+	}
+
+	pvNamespaceByHandle = map[string]string{}
+	for _, pv := range pvList.Items {
+		if pv.Spec.CSI != nil && pv.Spec.ClaimRef != nil {
+			pvNamespaceByHandle[pv.Spec.CSI.VolumeHandle] = pv.Spec.ClaimRef.Namespace
+		}
+	}
+
+	if ns, ok := pvNamespaceByHandle[volumeHandle]; ok {
+		return ns, nil
+	}
+
+	return "", errors.Errorf("volumesnapshotcontent %s has no volumesnapshot namespace and no bound PV matches its source volume handle %s", snapCont.Name, *snapCont.Spec.Source.VolumeHandle)
+}
+
+// GetSourcePVCForVolumeSnapshotContent returns the PVC the volumesnapshotcontent was snapshotted from, looked up through
+// its volumesnapshot in namespace. It returns nil if the volumesnapshot or PVC can't be found, as for pre-provisioned
+// snapshots.
+func GetSourcePVCForVolumeSnapshotContent(snapCont *snapshotv1api.VolumeSnapshotContent, namespace string, snapshotClient snapshotter.SnapshotV1Interface, corev1 corev1client.PersistentVolumeClaimsGetter) (*corev1api.PersistentVolumeClaim, error) {
+	if len(snapCont.Spec.VolumeSnapshotRef.Name) == 0 || len(namespace) == 0 {
+		return nil, nil
 	}
 
-	client, err := kubernetes.NewForConfig(clientConfig)
+	vs, err := snapshotClient.VolumeSnapshots(namespace).Get(context.TODO(), snapCont.Spec.VolumeSnapshotRef.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, nil, errors.WithStack(err)
+		return nil, errors.Wrapf(err, "failed to get volumesnapshot %s/%s", namespace, snapCont.Spec.VolumeSnapshotRef.Name)
+	}
+
+	if vs.Spec.Source.PersistentVolumeClaimName == nil {
+		return nil, nil
 	}
 
-	snapshotterClient, err := snapshotterClientSet.NewForConfig(clientConfig)
+	pvc, err := corev1.PersistentVolumeClaims(namespace).Get(context.TODO(), *vs.Spec.Source.PersistentVolumeClaimName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, nil, errors.WithStack(err)
+		return nil, errors.Wrapf(err, "failed to get persistentvolumeclaim %s/%s", namespace, *vs.Spec.Source.PersistentVolumeClaimName)
 	}
 
-	return client, snapshotterClient, nil
+	return pvc, nil
 }
 
 // IsVolumeSnapshotClassHasListerSecret returns whether a volumesnapshotclass has a snapshotlister secret
@@ -235,6 +403,20 @@ func IsVolumeSnapshotHasVSCDeleteSecret(vs *snapshotv1api.VolumeSnapshot) bool {
 	return nameExists && nsExists
 }
 
+// GetRestoreVSCDeleteSecret returns the deletesnapshot secret for the static volumesnapshotcontent that is created
+// on restore, taken from the volumesnapshotcontent the VSR produced or, failing that, from the backed up volumesnapshot
+func GetRestoreVSCDeleteSecret(sourceVSC *snapshotv1api.VolumeSnapshotContent, vs *snapshotv1api.VolumeSnapshot) (name, namespace string, found bool) {
+	if sourceVSC != nil && IsVolumeSnapshotContentHasDeleteSecret(sourceVSC) {
+		return sourceVSC.Annotations[PrefixedSnapshotterSecretNameKey], sourceVSC.Annotations[PrefixedSnapshotterSecretNamespaceKey], true
+	}
+
+	if IsVolumeSnapshotHasVSCDeleteSecret(vs) {
+		return vs.Annotations[CSIDeleteSnapshotSecretName], vs.Annotations[CSIDeleteSnapshotSecretNamespace], true
+	}
+
+	return "", "", false
+}
+
 // AddAnnotations adds the supplied key-values to the annotations on the object
 func AddAnnotations(o *metav1.ObjectMeta, vals map[string]string) {
 	if o.Annotations == nil {
@@ -275,6 +457,142 @@ func SetVolumeSnapshotContentDeletionPolicy(vscName string, csiClient snapshotte
 	return err
 }
 
+// RetainVolumeSnapshotContent keeps the snapshot behind a volumesnapshotcontent until the supplied time
+func RetainVolumeSnapshotContent(vscName string, retainUntil time.Time, csiClient snapshotter.SnapshotV1Interface) error {
+	pb := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:"true"},"annotations":{%q:%q}},"spec":{"deletionPolicy":"Retain"}}`,
+		VolumeSnapshotRetainedLabel, VolumeSnapshotRetainUntilAnnotation, retainUntil.UTC().Format(time.RFC3339)))
+	_, err := csiClient.VolumeSnapshotContents().Patch(context.TODO(), vscName, types.MergePatchType, pb, metav1.PatchOptions{})
+
+	return err
+}
+
+// GetRetainedVolumeSnapshotContentForRestore returns the named volumesnapshotcontent if it was retained after data
+// movement and the restore can be served from its snapshot: it has not expired, is ready to use and the restore does
+// not ask for a different topology. It returns nil otherwise, for the data to be restored by the data mover.
+func GetRetainedVolumeSnapshotContentForRestore(restore *velerov1api.Restore, vscName string, csiClient snapshotter.SnapshotV1Interface) (*snapshotv1api.VolumeSnapshotContent, error) {
+	if len(vscName) == 0 || len(restore.Annotations[RestoreTopologyAnnotation]) > 0 {
+		return nil, nil
+	}
+
+	vsc, err := csiClient.VolumeSnapshotContents().Get(context.TODO(), vscName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get volumesnapshotcontent %s", vscName)
+	}
+
+	if _, ok := vsc.Labels[VolumeSnapshotRetainedLabel]; !ok {
+		return nil, nil
+	}
+
+	retainUntil, err := time.Parse(time.RFC3339, vsc.Annotations[VolumeSnapshotRetainUntilAnnotation])
+	if err != nil || !time.Now().Before(retainUntil) {
+		return nil, nil
+	}
+
+	if vsc.Status == nil || vsc.Status.SnapshotHandle == nil || vsc.Status.ReadyToUse == nil || !*vsc.Status.ReadyToUse {
+		return nil, nil
+	}
+
+	return vsc, nil
+}
+
+// ExpireRetainedVolumeSnapshotContents deletes retained volumesnapshotcontents, and their snapshots, past their retention.
+// A failure to expire one volumesnapshotcontent doesn't stop the others from being expired.
+func ExpireRetainedVolumeSnapshotContents(csiClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) error {
+	vscList, err := csiClient.VolumeSnapshotContents().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: VolumeSnapshotRetainedLabel,
+	})
+	if err != nil {
+		return errors.Wrap(err, "error listing retained volumesnapshotcontents")
+	}
+
+	now := time.Now()
+	errs := []error{}
+	for _, vsc := range vscList.Items {
+		val, ok := vsc.Annotations[VolumeSnapshotRetainUntilAnnotation]
+		if !ok {
+			continue
+		}
+
+		retainUntil, err := time.Parse(time.RFC3339, val)
+		if err != nil {
+			log.Warnf("volumesnapshotcontent %s has invalid %s annotation %q, skipping", vsc.Name, VolumeSnapshotRetainUntilAnnotation, val)
+			continue
+		}
+
+		if now.Before(retainUntil) {
+			continue
+		}
+
+		log.Infof("retention of volumesnapshotcontent %s expired at %s, deleting", vsc.Name, val)
+
+		// switch back to Delete so the snapshot on the storage provider goes away with the VSC
+		err = SetVolumeSnapshotContentDeletionPolicy(vsc.Name, csiClient)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "failed to set deletion policy on volumesnapshotcontent %s", vsc.Name))
+			continue
+		}
+
+		err = DeleteVolumeSnapshotContent(vsc.Name, csiClient, log)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+var expireRetainedOnce sync.Once
+
+// ExpireRetainedVolumeSnapshotContentsOnce expires retained volumesnapshotcontents once per plugin process. Velero starts
+// a plugin process per backup, so expiry runs at the start of every backup whatever the current retention setting is.
+func ExpireRetainedVolumeSnapshotContentsOnce(log logrus.FieldLogger) {
+	expireRetainedOnce.Do(func() {
+		_, snapshotClient, err := GetClients()
+		if err != nil {
+			log.Warnf("failed to expire retained volumesnapshotcontents: %s", err.Error())
+			return
+		}
+
+		if err := ExpireRetainedVolumeSnapshotContents(snapshotClient.SnapshotV1(), log); err != nil {
+			log.Warnf("failed to expire retained volumesnapshotcontents: %s", err.Error())
+		}
+	})
+}
+
+// SnapshotRetention returns how long source snapshots are kept after a successful data movement, zero if disabled
+func SnapshotRetention() (time.Duration, error) {
+	val := getSetting(SnapshotRetentionDays)
+	if len(val) == 0 {
+		return 0, nil
+	}
+
+	days, err := strconv.Atoi(val)
+	if err != nil || days < 0 {
+		return 0, errors.Errorf("invalid %s value %q, must be a non-negative number of days", SnapshotRetentionDays, val)
+	}
+
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// SnapshotRetentionForBackup returns how long the source snapshots of the backup are kept after a successful data
+// movement: the days of its SnapshotRetentionAnnotation, or else the SnapshotRetentionDays setting
+func SnapshotRetentionForBackup(backup *velerov1api.Backup) (time.Duration, error) {
+	val, ok := backup.Annotations[SnapshotRetentionAnnotation]
+	if !ok || len(val) == 0 {
+		return SnapshotRetention()
+	}
+
+	days, err := strconv.Atoi(val)
+	if err != nil || days < 0 {
+		return 0, errors.Errorf("invalid %s annotation %q on backup %s, must be a non-negative number of days", SnapshotRetentionAnnotation, val, backup.Name)
+	}
+
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
 func HasBackupLabel(o *metav1.ObjectMeta, backupName string) bool {
 	if o.Labels == nil || len(strings.TrimSpace(backupName)) == 0 {
 		return false
@@ -282,33 +600,26 @@ func HasBackupLabel(o *metav1.ObjectMeta, backupName string) bool {
 	return o.Labels[velerov1api.BackupNameLabel] == label.GetValidName(backupName)
 }
 
-// Get VolumeSnapshotBackup CR with status data
-func GetVolumeSnapshotbackupWithStatusData(volumeSnapshotbackupNS string, volumeSnapshotName string, log logrus.FieldLogger) (datamoverv1alpha1.VolumeSnapshotBackup, error) {
+// Get VolumeSnapshotBackup CR with status data. The VSB is watched rather than polled so the plugin sees the status
+// as soon as the controller writes it.
+func GetVolumeSnapshotbackupWithStatusData(ctx context.Context, volumeSnapshotbackupNS string, volumeSnapshotName string, timeout time.Duration, log logrus.FieldLogger) (datamoverv1alpha1.VolumeSnapshotBackup, error) {
+	ctx, done := startWait(ctx, "volumesnapshotbackup_status")
+	defer done()
 
 	vsb := datamoverv1alpha1.VolumeSnapshotBackup{}
-	// default timeout value is 10
-	timeoutValue := "10m"
-	// use timeout value if configured
-	if len(os.Getenv(DatamoverTimeout)) > 0 {
-		timeoutValue = os.Getenv(DatamoverTimeout)
-	}
 
-	timeout, err := time.ParseDuration(timeoutValue)
-	if err != nil {
-		return vsb, errors.Wrapf(err, "error parsing the datamover timeout")
-	}
-	interval := 5 * time.Second
-
-	snapMoverClient, err := GetVolumeSnapshotMoverClient()
+	snapMoverClient, err := getWatchClient()
 	if err != nil {
 		return vsb, err
 	}
 
-	err = wait.PollImmediate(interval, timeout, func() (bool, error) {
-		err := snapMoverClient.Get(context.TODO(), client.ObjectKey{Namespace: volumeSnapshotbackupNS, Name: volumeSnapshotName}, &vsb)
-		if err != nil {
-			return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshotbackup %s/%s", volumeSnapshotbackupNS, volumeSnapshotName))
+	lw := newNamedListWatch(ctx, snapMoverClient, &datamoverv1alpha1.VolumeSnapshotBackupList{}, volumeSnapshotbackupNS, volumeSnapshotName)
+	err = waitForObject(ctx, lw, &datamoverv1alpha1.VolumeSnapshotBackup{}, timeout, func(obj runtime.Object) (bool, error) {
+		current, ok := obj.(*datamoverv1alpha1.VolumeSnapshotBackup)
+		if !ok || current.Name != volumeSnapshotName {
+			return false, nil
 		}
+		vsb = *current
 
 		if len(vsb.Status.Conditions) == 0 {
 			if vsb.Status.Phase == datamoverv1alpha1.SnapMoverBackupPhaseFailed ||
@@ -318,7 +629,7 @@ func GetVolumeSnapshotbackupWithStatusData(volumeSnapshotbackupNS string, volume
 			if vsb.Status.Phase == datamoverv1alpha1.SnapMoverBackupPhaseCompleted {
 				return false, errors.Errorf("volumesnapshotbackup %v completed without conditions", vsb.Name)
 			}
-			log.Infof("Waiting for volumesnapshotbackup %s to have conditions. Retrying in %ds", vsb.Name, interval/time.Second)
+			log.Infof("Waiting for volumesnapshotbackup %s to have conditions", vsb.Name)
 			return false, nil
 		}
 
@@ -330,42 +641,34 @@ func GetVolumeSnapshotbackupWithStatusData(volumeSnapshotbackupNS string, volume
 		}
 
 		if len(vsb.Status.ResticRepository) == 0 || len(vsb.Status.SourcePVCData.Name) == 0 || len(vsb.Status.SourcePVCData.Size) == 0 || len(vsb.Status.SourcePVCData.StorageClassName) == 0 || len(vsb.Status.VolumeSnapshotClassName) == 0 {
-			log.Infof("Waiting for volumesnapshotbackup %s/%s to have status data. Retrying in %ds", volumeSnapshotbackupNS, volumeSnapshotName, interval/time.Second)
+			log.Infof("Waiting for volumesnapshotbackup %s/%s to have status data", volumeSnapshotbackupNS, volumeSnapshotName)
 			return false, nil
 		}
 
 		return true, nil
-
 	})
 
 	if err != nil {
 		if err == wait.ErrWaitTimeout {
 			log.Errorf("Timed out awaiting reconciliation of volumesnapshotbackup %s/%s", volumeSnapshotbackupNS, volumeSnapshotName)
+			return vsb, err
 		}
-		return vsb, err
+		return vsb, errors.Wrapf(err, "failed to wait for volumesnapshotbackup %s/%s", volumeSnapshotbackupNS, volumeSnapshotName)
 	}
-	log.Infof("Return VSB from GetVolumeSnapshotbackupWithInProgressStatus: %v", vsb)
+	TraceObject(log, "Return VSB from GetVolumeSnapshotbackupWithStatusData", &vsb)
 	return vsb, nil
 }
 
-// Get VolumeSnapshotBackup CR with status data
-func GetVolumeSnapshotRestoreWithStatusData(restoreName string, PVCName string, log logrus.FieldLogger) (datamoverv1alpha1.VolumeSnapshotRestoreList, error) {
+// GetVolumeSnapshotRestoreWithStatusData waits for the VSR of the restore restoring the PVC into the namespace to have
+// status data, and returns it. It fails if the restore has no VSR for the PVC.
+func GetVolumeSnapshotRestoreWithStatusData(ctx context.Context, restore *velerov1api.Restore, namespace string, PVCName string, timeout time.Duration, log logrus.FieldLogger) (*datamoverv1alpha1.VolumeSnapshotRestore, error) {
+	ctx, done := startWait(ctx, "volumesnapshotrestore_status")
+	defer done()
 
-	vsrList := datamoverv1alpha1.VolumeSnapshotRestoreList{}
-	// default timeout value is 10
-	timeoutValue := DefaultVSRTimeout
-	// use timeout value if configured
-	if len(os.Getenv(DatamoverTimeout)) > 0 {
-		timeoutValue = os.Getenv(DatamoverTimeout)
-	}
-
-	timeout, err := time.ParseDuration(timeoutValue)
-	if err != nil {
-		return vsrList, errors.Wrapf(err, "error parsing the datamover timeout")
-	}
-	interval := 5 * time.Second
+	var vsr *datamoverv1alpha1.VolumeSnapshotRestore
+	transient := newTransientErrors()
 
-	err = wait.PollImmediate(interval, timeout, func() (bool, error) {
+	err := pollWithBackoff(ctx, timeout, func(ctx context.Context) (bool, error) {
 
 		snapMoverClient, err := GetVolumeSnapshotMoverClient()
 		if err != nil {
@@ -373,24 +676,36 @@ func GetVolumeSnapshotRestoreWithStatusData(restoreName string, PVCName string,
 		}
 
 		VSRListOptions := client.MatchingLabels(map[string]string{
-			velerov1api.RestoreNameLabel: restoreName,
-			PersistentVolumeClaimLabel:   PVCName,
+			velerov1api.RestoreNameLabel: restore.Name,
+			PersistentVolumeClaimLabel:   label.GetValidName(PVCName),
 		})
 
-		err = snapMoverClient.List(context.TODO(), &vsrList, VSRListOptions)
+		vsrList := datamoverv1alpha1.VolumeSnapshotRestoreList{}
+		err = snapMoverClient.List(ctx, &vsrList, client.InNamespace(namespace), VSRListOptions)
+		if err != nil && transient.retry(err) {
+			log.Warnf("failed to list volumesnapshotrestores for PVC %s/%s, retrying: %s", namespace, PVCName, err.Error())
+			return false, nil
+		}
 		if err != nil {
-			return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshotrestoreList for PVC %s", PVCName))
+			return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshotrestoreList for PVC %s/%s", namespace, PVCName))
 		}
+		transient.reset()
 
-		if len(vsrList.Items) > 0 {
-			if vsrList.Items[0].Status.Phase == "Failed" || vsrList.Items[0].Status.Phase == "PartiallyFailed" {
-				return false, errors.Errorf("volumesnapshotrestore %v has failed status", vsrList.Items[0].Name)
-			}
+		vsr, err = selectRestoreVSR(vsrList.Items, restore, log)
+		if err != nil {
+			return false, err
+		}
+		if vsr == nil {
+			return false, errors.Errorf("volumesnapshotrestore list is empty for PVC %s/%s", namespace, PVCName)
+		}
 
-			if len(vsrList.Items[0].Status.SnapshotHandle) == 0 || len(vsrList.Items[0].Status.Phase) == 0 {
-				log.Infof("Waiting for volumesnapshotrestore %s to have status data. Retrying in %ds", vsrList.Items[0].Name, interval/time.Second)
-				return false, nil
-			}
+		if vsr.Status.Phase == "Failed" || vsr.Status.Phase == "PartiallyFailed" {
+			return false, errors.Errorf("volumesnapshotrestore %v has failed status", vsr.Name)
+		}
+
+		if len(vsr.Status.SnapshotHandle) == 0 || len(vsr.Status.Phase) == 0 {
+			log.Infof("Waiting for volumesnapshotrestore %s to have status data", vsr.Name)
+			return false, nil
 		}
 
 		return true, nil
@@ -398,37 +713,125 @@ func GetVolumeSnapshotRestoreWithStatusData(restoreName string, PVCName string,
 
 	if err != nil {
 		if err == wait.ErrWaitTimeout {
-			log.Errorf("Timed out awaiting reconciliation of volumesnapshotrestoreList")
+			log.Errorf("Timed out awaiting reconciliation of volumesnapshotrestore for PVC %s/%s", namespace, PVCName)
 		}
-		return vsrList, err
+		return nil, err
 	}
-	log.Debugf("Return VSR from GetVolumeSnapshotrestoreWithInProgressStatus: %v", vsrList)
-	return vsrList, nil
+	TraceObject(log, "Return VSR from GetVolumeSnapshotRestoreWithStatusData", vsr)
+	return vsr, nil
 }
 
-// Check if volumesnapshotbackup CR exists for a given volumesnapshotcontent
-func VSBExistsForVSC(snapCont *snapshotv1api.VolumeSnapshotContent, log logrus.FieldLogger) (bool, error) {
+// selectRestoreVSR returns the VSR of the restore among the VSRs labeled with its name for a PVC: the ones labeled with
+// the UID of the restore, or else, for VSRs created before the UID was recorded, the unlabeled ones. VSRs of a restore
+// deleted and recreated with the same name, and VSRs being deleted, are ignored. Duplicate VSRs retried for the same
+// VSB restore the same data, the oldest is used; VSRs for different VSBs are reported as an error. It returns nil if
+// there is none.
+func selectRestoreVSR(vsrs []datamoverv1alpha1.VolumeSnapshotRestore, restore *velerov1api.Restore, log logrus.FieldLogger) (*datamoverv1alpha1.VolumeSnapshotRestore, error) {
+	owned, unlabeled := []*datamoverv1alpha1.VolumeSnapshotRestore{}, []*datamoverv1alpha1.VolumeSnapshotRestore{}
+	for i := range vsrs {
+		vsr := &vsrs[i]
+		if vsr.DeletionTimestamp != nil {
+			continue
+		}
+		switch uid, ok := vsr.Labels[RestoreUIDLabel]; {
+		case !ok || len(uid) == 0:
+			unlabeled = append(unlabeled, vsr)
+		case uid == string(restore.UID):
+			owned = append(owned, vsr)
+		}
+	}
+
+	candidates := owned
+	if len(candidates) == 0 {
+		candidates = unlabeled
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	selected := candidates[0]
+	for _, vsr := range candidates[1:] {
+		if vsr.Labels[VolumeSnapshotBackupLabel] != selected.Labels[VolumeSnapshotBackupLabel] {
+			return nil, errors.Errorf("volumesnapshotrestores %s/%s and %s/%s of restore %s restore the same PVC from volumesnapshotbackups %s and %s",
+				selected.Namespace, selected.Name, vsr.Namespace, vsr.Name, restore.Name, selected.Labels[VolumeSnapshotBackupLabel], vsr.Labels[VolumeSnapshotBackupLabel])
+		}
+		if vsr.CreationTimestamp.Before(&selected.CreationTimestamp) {
+			selected = vsr
+		}
+	}
+	if len(candidates) > 1 {
+		log.Warnf("found %d volumesnapshotrestores of restore %s for volumesnapshotbackup %s, using the oldest one %s/%s",
+			len(candidates), restore.Name, selected.Labels[VolumeSnapshotBackupLabel], selected.Namespace, selected.Name)
+	}
+
+	return selected, nil
+}
 
+// GetVSBForVSC returns the VSB the backup already created for the volumesnapshotcontent, matched on the
+// volumesnapshotcontent name and backup UID labels, or nil if there is none. VSBs being deleted don't cover the
+// volumesnapshotcontent anymore and are ignored.
+func GetVSBForVSC(snapCont *snapshotv1api.VolumeSnapshotContent, backup *velerov1api.Backup, log logrus.FieldLogger) (*datamoverv1alpha1.VolumeSnapshotBackup, error) {
 	snapMoverClient, err := GetVolumeSnapshotMoverClient()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
+
 	vsbList := datamoverv1alpha1.VolumeSnapshotBackupList{}
 	VSBListOptions := client.MatchingLabels(map[string]string{
 		VolumeSnapshotBackupVolumeSnapshotContent: snapCont.Name,
+		BackupUIDLabel: string(backup.UID),
 	})
 
 	err = snapMoverClient.List(context.TODO(), &vsbList, VSBListOptions)
 	if err != nil {
-		if apierrors.IsAlreadyExists(err) {
-			log.Infof("found volumesnapshotbackup for the given volumesnapshotcontent")
-			return true, nil
+		return nil, errors.Wrapf(err, "failed to list volumesnapshotbackups for volumesnapshotcontent %s", snapCont.Name)
+	}
+
+	for i := range vsbList.Items {
+		vsb := &vsbList.Items[i]
+		if vsb.DeletionTimestamp == nil {
+			log.Infof("found volumesnapshotbackup %s/%s for volumesnapshotcontent %s", vsb.Namespace, vsb.Name, snapCont.Name)
+			return vsb, nil
 		}
-		return false, err
 	}
 
 	log.Infof("did not find volumesnapshotbackup for the given volumesnapshotcontent %v", snapCont.Name)
-	return false, nil
+	return nil, nil
+}
+
+// WaitForVolumeSnapshotBackupDeletion waits for the deleted VSB to be removed. Errors reading the VSB are retried
+// until the timeout, and a VSB still there after it is reported along with the finalizers holding it.
+func WaitForVolumeSnapshotBackupDeletion(ctx context.Context, namespace, name string, timeout time.Duration, log logrus.FieldLogger) error {
+	ctx, done := startWait(ctx, "volumesnapshotbackup_deletion")
+	defer done()
+
+	snapMoverClient, err := GetVolumeSnapshotMoverClient()
+	if err != nil {
+		return err
+	}
+
+	vsb := datamoverv1alpha1.VolumeSnapshotBackup{}
+	err = pollWithBackoff(ctx, timeout, func(ctx context.Context) (bool, error) {
+		err := snapMoverClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, &vsb)
+		if apierrors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			log.Warnf("failed to get volumesnapshotbackup %s/%s, retrying: %s", namespace, name, err.Error())
+			return false, nil
+		}
+		log.Debugf("waiting for volumesnapshotbackup %s/%s to be removed, finalizers: %v", namespace, name, vsb.Finalizers)
+		return false, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		if vsb.DeletionTimestamp != nil && len(vsb.Finalizers) > 0 {
+			return errors.Errorf("volumesnapshotbackup %s/%s was not removed within %s, it is held by finalizers %s since %s",
+				namespace, name, timeout, strings.Join(vsb.Finalizers, ", "), vsb.DeletionTimestamp.UTC().Format(time.RFC3339))
+		}
+		return errors.Errorf("volumesnapshotbackup %s/%s was not removed within %s", namespace, name, timeout)
+	}
+	return err
 }
 
 // Check if volumesnapshotrestore CR exists for a given volumesnapshotbackup
@@ -457,27 +860,30 @@ func VSRExistsForVSB(vsb *datamoverv1alpha1.VolumeSnapshotBackup, log logrus.Fie
 	return false, nil
 }
 
-// Waits for volumesnapshotcontent to be in ready state
-func WaitForVolumeSnapshotContentToBeReady(snapCont snapshotv1api.VolumeSnapshotContent, snapshotClient snapshotter.SnapshotV1Interface, log logrus.FieldLogger) (bool, error) {
-	timeoutValue := "10m"
-	interval := 5 * time.Second
-
-	if len(os.Getenv(DatamoverTimeout)) > 0 {
-		timeoutValue = os.Getenv(DatamoverTimeout)
-	}
-
-	timeout, err := time.ParseDuration(timeoutValue)
-	if err != nil {
-		return false, errors.Wrapf(err, "error parsing the datamover timeout")
+// Waits for volumesnapshotcontent to be in ready state, watching it so readiness is seen as soon as it is reported
+func WaitForVolumeSnapshotContentToBeReady(ctx context.Context, snapCont snapshotv1api.VolumeSnapshotContent, snapshotClient snapshotter.SnapshotV1Interface, timeout time.Duration, log logrus.FieldLogger) (bool, error) {
+	ctx, done := startWait(ctx, "volumesnapshotcontent_ready")
+	defer done()
+
+	selector := fields.OneTermEqualSelector("metadata.name", snapCont.Name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return snapshotClient.VolumeSnapshotContents().List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return snapshotClient.VolumeSnapshotContents().Watch(ctx, options)
+		},
 	}
 
-	err = wait.PollImmediate(interval, timeout, func() (bool, error) {
-		updatedVSC, err := snapshotClient.VolumeSnapshotContents().Get(context.TODO(), snapCont.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshotcontent %s", updatedVSC.Name))
+	err := waitForObject(ctx, lw, &snapshotv1api.VolumeSnapshotContent{}, timeout, func(obj runtime.Object) (bool, error) {
+		updatedVSC, ok := obj.(*snapshotv1api.VolumeSnapshotContent)
+		if !ok || updatedVSC.Name != snapCont.Name {
+			return false, nil
 		}
-		if updatedVSC.Status == nil || updatedVSC.Status.SnapshotHandle == nil || *updatedVSC.Status.ReadyToUse != true {
-			log.Infof("Waiting for volumesnapshotcontents %s to have snapshot handle and be ready. Retrying in %ds", snapCont.Name, interval/time.Second)
+		if updatedVSC.Status == nil || updatedVSC.Status.SnapshotHandle == nil || updatedVSC.Status.ReadyToUse == nil || !*updatedVSC.Status.ReadyToUse {
+			log.Infof("Waiting for volumesnapshotcontents %s to have snapshot handle and be ready", snapCont.Name)
 			return false, nil
 		}
 
@@ -486,87 +892,441 @@ func WaitForVolumeSnapshotContentToBeReady(snapCont snapshotv1api.VolumeSnapshot
 	if err != nil {
 		if err == wait.ErrWaitTimeout {
 			log.Errorf("Timed out awaiting reconciliation of volumesnapshotcontent %s", snapCont.Name)
+			return false, err
 		}
-		return false, err
+		return false, errors.Wrapf(err, "failed to wait for volumesnapshotcontent %s", snapCont.Name)
 	}
 	return true, nil
 }
 
-func GetVolumeSnapshotMoverClient() (client.Client, error) {
-	client2, err := client.New(config.GetConfigOrDie(), client.Options{})
-	if err != nil {
-		return nil, err
-	}
-	datamoverv1alpha1.AddToScheme(client2.Scheme())
-
-	return client2, err
+// DataMoverCase returns whether VolumeSnapshotMoverEnv enables the csi data-mover code path. It is read on every call
+// so the plugin ConfigMap can toggle the data mover without restarting velero.
+func DataMoverCase() bool {
+	enabled, _ := strconv.ParseBool(getSetting(VolumeSnapshotMoverEnv))
+	return enabled
 }
 
-func GetVolsyncClient() (client.Client, error) {
-	client2, err := client.New(config.GetConfigOrDie(), client.Options{})
+// snapshotMoveData caches the spec.snapshotMoveData read by GetSnapshotMoveData, keyed by backup namespace, name and UID
+var (
+	snapshotMoveDataLock sync.Mutex
+	snapshotMoveData     = map[string]*bool{}
+)
+
+// GetSnapshotMoveData returns the spec.snapshotMoveData of the backup, or nil if it isn't set. The velero API this
+// plugin is built with predates the field, so it is dropped from the backups velero hands to plugins and read from
+// the Backup in the cluster instead. A Backup that can't be read is treated as not setting it.
+func GetSnapshotMoveData(backup *velerov1api.Backup) *bool {
+	key := backup.Namespace + "/" + backup.Name + "/" + string(backup.UID)
+
+	snapshotMoveDataLock.Lock()
+	defer snapshotMoveDataLock.Unlock()
+
+	if moveData, ok := snapshotMoveData[key]; ok {
+		return moveData
+	}
+
+	veleroClient, err := GetVeleroClient()
 	if err != nil {
-		return nil, err
+		return nil
 	}
-	volsyncv1alpha1.AddToScheme(client2.Scheme())
 
-	return client2, err
-}
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(velerov1api.SchemeGroupVersion.WithKind("Backup"))
+	if err := veleroClient.Get(context.TODO(), client.ObjectKey{Namespace: backup.Namespace, Name: backup.Name}, obj); err != nil {
+		return nil
+	}
 
-// We expect VolumeSnapshotMoverEnv to be set once when container is started.
-// When true, we will use the csi data-mover code path.
-var dataMoverCase, _ = strconv.ParseBool(os.Getenv(VolumeSnapshotMoverEnv))
+	var moveData *bool
+	if val, found, err := unstructured.NestedBool(obj.Object, "spec", "snapshotMoveData"); err == nil && found {
+		moveData = &val
+	}
+	snapshotMoveData[key] = moveData
 
-// DataMoverCase use getter to avoid changing bool in other packages
-func DataMoverCase() bool {
-	return dataMoverCase
+	return moveData
 }
 
-func GetDataMoverCredName(backup *velerov1api.Backup, protectedNS string, log logrus.FieldLogger) (string, error) {
+// dataMoverEnabledForBackup returns whether the data-mover code path applies to the backup, along with why not when
+// it doesn't
+func dataMoverEnabledForBackup(backup *velerov1api.Backup) (bool, string) {
+	if val, ok := backup.Annotations[VSMEnabledAnnotation]; ok {
+		if enabled, err := strconv.ParseBool(val); err == nil && !enabled {
+			return false, fmt.Sprintf("the backup opted out with %s=false", VSMEnabledAnnotation)
+		}
+	}
 
-	bslName := backup.Spec.StorageLocation
-	resticSecretName := fmt.Sprintf("%v-volsync-restic", bslName)
+	if moveData := GetSnapshotMoveData(backup); moveData != nil {
+		if !*moveData {
+			return false, "the backup opted out with snapshotMoveData=false"
+		}
+		return true, ""
+	}
 
-	secretClient, _, err := GetClients()
-	if err != nil {
-		return "", errors.WithStack(err)
+	if !DataMoverCase() {
+		return false, "the data mover is disabled"
 	}
 
-	// check this secret exists
-	if _, err := secretClient.CoreV1().Secrets(protectedNS).Get(context.TODO(), resticSecretName, metav1.GetOptions{}); err != nil {
-		return "", errors.WithStack(err)
+	return true, ""
+}
+
+// describeDataMoverCase returns whether VolumeSnapshotMoverEnv enables the data mover, for logging
+func describeDataMoverCase() string {
+	if DataMoverCase() {
+		return "enabled"
 	}
+	return "disabled"
+}
 
-	return resticSecretName, nil
+// DataMoverEnabledForBackup returns whether the data-mover code path applies to the backup. Backups annotated with
+// VSMEnabledAnnotation=false opt out even when the data mover is enabled for the plugin. Otherwise the
+// spec.snapshotMoveData of the backup, when set, enables or disables it regardless of VolumeSnapshotMoverEnv.
+func DataMoverEnabledForBackup(backup *velerov1api.Backup) bool {
+	enabled, _ := dataMoverEnabledForBackup(backup)
+	return enabled
 }
 
-func CheckIfVolumeSnapshotRestoresAreComplete(ctx context.Context, volumesnapshotrestores datamoverv1alpha1.VolumeSnapshotRestoreList, log logrus.FieldLogger) error {
-	eg, _ := errgroup.WithContext(ctx)
-	timeoutValue := "10m"
+// DataMoverSkipReason returns why the data of a volumesnapshotcontent is not moved for the backup, or an empty string
+// when it is
+func DataMoverSkipReason(backup *velerov1api.Backup, snapCont *snapshotv1api.VolumeSnapshotContent, log logrus.FieldLogger) string {
+	if enabled, reason := dataMoverEnabledForBackup(backup); !enabled {
+		return reason
+	}
 
-	// use timeout value if configured
-	if len(os.Getenv(DatamoverTimeout)) > 0 {
-		timeoutValue = os.Getenv(DatamoverTimeout)
+	if !VSCBelongsToBackup(backup, snapCont, log) {
+		return "the volumesnapshotcontent belongs to another backup"
 	}
-	timeout, err := time.ParseDuration(timeoutValue)
-	if err != nil {
-		return errors.Wrapf(err, "error parsing datamover timeout")
+
+	if class := snapCont.Spec.VolumeSnapshotClassName; class != nil && Contains(GetExcludedVolumeSnapshotClasses(), *class) {
+		return fmt.Sprintf("volumesnapshotclass %s is excluded from data movement", *class)
 	}
-	interval := 5 * time.Second
 
-	volumeSnapMoverClient, err := GetVolumeSnapshotMoverClient()
-	if err != nil {
-		return err
+	return ""
+}
+
+// VolumeSkipReason returns why the data of the PVC a volumesnapshotcontent was snapshotted from is not moved, or an
+// empty string if it is. The storageclass of a volumesnapshotcontent without a PVC, as for pre-provisioned snapshots,
+// is unknown: such volumes are only moved while data movement isn't restricted to some storageclasses.
+func VolumeSkipReason(pvc *corev1api.PersistentVolumeClaim) string {
+	if pvc != nil {
+		if skip, err := strconv.ParseBool(pvc.Annotations[SkipAnnotation]); err == nil && skip {
+			return fmt.Sprintf("the PVC opted out with %s=true", SkipAnnotation)
+		}
+	}
+
+	if populator := GetVolumePopulator(pvc); len(populator) > 0 && GetPopulatedPVCPolicy() == PopulatedPVCPolicySkip {
+		return fmt.Sprintf("the PVC was provisioned by volume populator %s, set %s=%s to move its data", populator,
+			DatamoverPopulatedPVCPolicy, PopulatedPVCPolicyMove)
+	}
+
+	storageClass := ""
+	if pvc != nil && pvc.Spec.StorageClassName != nil {
+		storageClass = *pvc.Spec.StorageClassName
+	}
+
+	if allowed := GetStorageClasses(); len(allowed) > 0 && !Contains(allowed, storageClass) {
+		if len(storageClass) == 0 {
+			return "the storageclass of the volume is unknown and data movement is restricted to some storageclasses"
+		}
+		return fmt.Sprintf("storageclass %s is not among the storageclasses data movement is restricted to", storageClass)
+	}
+
+	if len(storageClass) > 0 && Contains(GetExcludedStorageClasses(), storageClass) {
+		return fmt.Sprintf("storageclass %s is excluded from data movement", storageClass)
+	}
+
+	return ""
+}
+
+const (
+	// PopulatedPVCPolicySkip keeps the volumes of PVCs provisioned by volume populators to their snapshots, the default
+	PopulatedPVCPolicySkip = "skip"
+	// PopulatedPVCPolicyMove moves the data of PVCs provisioned by volume populators like the one of any other PVC
+	PopulatedPVCPolicyMove = "move"
+)
+
+// GetPopulatedPVCPolicy returns the configured DatamoverPopulatedPVCPolicy, PopulatedPVCPolicySkip if unset or invalid
+func GetPopulatedPVCPolicy() string {
+	if policy := getSetting(DatamoverPopulatedPVCPolicy); policy == PopulatedPVCPolicyMove {
+		return policy
+	}
+	return PopulatedPVCPolicySkip
+}
+
+// GetVolumePopulator returns the <kind>.<group>/<name> of the volume populator the PVC was provisioned by, or an empty
+// string if none. PVCs cloned from a PVC or restored from a volumesnapshot are provisioned by their CSI driver.
+func GetVolumePopulator(pvc *corev1api.PersistentVolumeClaim) string {
+	if pvc == nil {
+		return ""
+	}
+
+	var apiGroup *string
+	var kind, name string
+	switch {
+	case pvc.Spec.DataSourceRef != nil:
+		apiGroup, kind, name = pvc.Spec.DataSourceRef.APIGroup, pvc.Spec.DataSourceRef.Kind, pvc.Spec.DataSourceRef.Name
+	case pvc.Spec.DataSource != nil:
+		apiGroup, kind, name = pvc.Spec.DataSource.APIGroup, pvc.Spec.DataSource.Kind, pvc.Spec.DataSource.Name
+	default:
+		return ""
+	}
+
+	group := ""
+	if apiGroup != nil {
+		group = *apiGroup
+	}
+	if (len(group) == 0 && kind == "PersistentVolumeClaim") || (group == snapshotv1api.GroupName && kind == "VolumeSnapshot") {
+		return ""
+	}
+
+	return schema.GroupKind{Group: group, Kind: kind}.String() + "/" + name
+}
+
+// NamespaceOptInEnabled returns whether data movement is restricted to the namespaces opted in with
+// NamespaceDataMoverEnabledLabel
+func NamespaceOptInEnabled() bool {
+	enabled, _ := strconv.ParseBool(getSetting(DatamoverNamespaceOptIn))
+	return enabled
+}
+
+// NamespaceSkipReason returns why the data of the volumes of the namespace is not moved, or an empty string if it is.
+// The namespace is only fetched in opt-in mode.
+func NamespaceSkipReason(namespace string, nsGetter corev1client.NamespacesGetter) (string, error) {
+	if !NamespaceOptInEnabled() {
+		return "", nil
+	}
+
+	ns, err := nsGetter.Namespaces().Get(context.TODO(), namespace, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting namespace %s", namespace)
+	}
+
+	if enabled, err := strconv.ParseBool(ns.Labels[NamespaceDataMoverEnabledLabel]); err == nil && enabled {
+		return "", nil
+	}
+
+	return fmt.Sprintf("namespace %s did not opt in with %s=true", namespace, NamespaceDataMoverEnabledLabel), nil
+}
+
+// SetDataMoverSkippedReason records on the object why its data was not moved
+func SetDataMoverSkippedReason(o *metav1.ObjectMeta, reason string) {
+	AddAnnotations(o, map[string]string{
+		DataMoverSkippedReasonAnnotation: reason,
+	})
+}
+
+// ApprovalRequiredForBackup returns whether VSBs of the backup must wait for manual approval before moving data
+func ApprovalRequiredForBackup(backup *velerov1api.Backup) bool {
+	if val, ok := backup.Annotations[RequireApprovalAnnotation]; ok {
+		if required, err := strconv.ParseBool(val); err == nil {
+			return required
+		}
+	}
+
+	required, _ := strconv.ParseBool(getSetting(DatamoverRequireApproval))
+	return required
+}
+
+// IsAwaitingApproval returns whether the object still carries the approval pending marker
+func IsAwaitingApproval(o *metav1.ObjectMeta) bool {
+	_, ok := o.Annotations[ApprovalPendingAnnotation]
+	return ok
+}
+
+// MarkVolumeSnapshotContentAwaitingApproval sets the approval pending marker on the volumesnapshotcontent
+func MarkVolumeSnapshotContentAwaitingApproval(vscName string, csiClient snapshotter.SnapshotV1Interface) error {
+	pb := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:"true"}}}`, ApprovalPendingAnnotation))
+	_, err := csiClient.VolumeSnapshotContents().Patch(context.TODO(), vscName, types.MergePatchType, pb, metav1.PatchOptions{})
+
+	return err
+}
+
+// approvalVSBPrefix prefixes the name of the VSB created for a volumesnapshotcontent once it is approved
+const approvalVSBPrefix = "vsb-"
+
+// GetApprovalVolumeSnapshotBackupName returns the name of the VSB created for the volumesnapshotcontent once it is
+// approved. The name is known before the VSB exists, so it can be used in the operationID.
+func GetApprovalVolumeSnapshotBackupName(vscName string) string {
+	return approvalVSBPrefix + vscName
+}
+
+// GetApprovalVolumeSnapshotContentName returns the name of the volumesnapshotcontent a VSB named with
+// GetApprovalVolumeSnapshotBackupName is created for
+func GetApprovalVolumeSnapshotContentName(vsbName string) (string, bool) {
+	if !strings.HasPrefix(vsbName, approvalVSBPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(vsbName, approvalVSBPrefix), true
+}
+
+// volumeSnapshotBackupNameHashLength is the number of hex characters of the hash that names a VSB
+const volumeSnapshotBackupNameHashLength = 16
+
+// GetVolumeSnapshotBackupName returns the name of the VSB moving the data of the volumesnapshotcontent for the backup.
+// It is derived from the backup UID and the volumesnapshotcontent name, so a retried execution for the same item finds
+// the VSB created before instead of creating another one, and reports the same operationID.
+func GetVolumeSnapshotBackupName(backup *velerov1api.Backup, vscName string) string {
+	sum := sha256.Sum256([]byte(string(backup.UID) + "/" + vscName))
+	return "vsb-" + hex.EncodeToString(sum[:])[:volumeSnapshotBackupNameHashLength]
+}
+
+// NewVolumeSnapshotBackup returns the VSB moving the data of the volumesnapshotcontent for the backup. An empty name
+// lets the API server generate one.
+func NewVolumeSnapshotBackup(name, namespace, vscName, resticSecretName string, backup *velerov1api.Backup) *datamoverv1alpha1.VolumeSnapshotBackup {
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels: map[string]string{
+				BackupNameLabel: backup.Name,
+				BackupUIDLabel:  string(backup.UID),
+				VolumeSnapshotBackupVolumeSnapshotContent: vscName,
+			},
+		},
+		Spec: datamoverv1alpha1.VolumeSnapshotBackupSpec{
+			VolumeSnapshotContent: corev1api.ObjectReference{
+				Name: vscName,
+			},
+			ProtectedNamespace: backup.Namespace,
+			ResticSecretRef: corev1api.LocalObjectReference{
+				Name: resticSecretName,
+			},
+		},
+	}
+
+	if len(name) == 0 {
+		vsb.GenerateName = "vsb-"
+	}
+
+	return vsb
+}
+
+// DataMoverEnabledForRestore returns whether the data-mover code path applies to the backup being restored. If the
+// backup can't be read, it applies whenever VolumeSnapshotMoverEnv enables it.
+func DataMoverEnabledForRestore(restore *velerov1api.Restore, log logrus.FieldLogger) bool {
+	veleroClient, err := GetVeleroClient()
+	if err != nil {
+		log.Warnf("failed to get velero client, assuming data mover is %s for restore %s: %s", describeDataMoverCase(), restore.Name, err.Error())
+		return DataMoverCase()
+	}
+
+	backup := velerov1api.Backup{}
+	err = veleroClient.Get(context.TODO(), client.ObjectKey{Namespace: restore.Namespace, Name: restore.Spec.BackupName}, &backup)
+	if err != nil {
+		log.Warnf("failed to get backup %s, assuming data mover is %s for restore %s: %s", restore.Spec.BackupName, describeDataMoverCase(), restore.Name, err.Error())
+		return DataMoverCase()
+	}
+
+	return DataMoverEnabledForBackup(&backup)
+}
+
+// GetDataMoverCredName returns the restic secret of the backup storage location of the backup: the secret in the
+// protected namespace labeled with ResticSecretLabel and the storage location, or else <bsl>-volsync-restic. The secret
+// must pass ValidateResticSecret, without the password when it is resolved from Vault.
+func GetDataMoverCredName(backup *velerov1api.Backup, protectedNS string, log logrus.FieldLogger) (string, error) {
+
+	bslName := backup.Spec.StorageLocation
+	resticSecretName := fmt.Sprintf("%v-volsync-restic", bslName)
+
+	secretClient, _, err := GetClients()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	secretList, err := secretClient.CoreV1().Secrets(protectedNS).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true,%s=%s", ResticSecretLabel, velerov1api.StorageLocationLabel, label.GetValidName(bslName)),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "error listing restic secrets of backup storage location %s", bslName)
+	}
+
+	switch len(secretList.Items) {
+	case 0:
+	case 1:
+		log.Debugf("found restic secret %s for backup storage location %s by label", secretList.Items[0].Name, bslName)
+		if err := ValidateResticSecret(&secretList.Items[0], VaultEnabled()); err != nil {
+			return "", err
+		}
+		return secretList.Items[0].Name, nil
+	default:
+		return "", errors.Errorf("found %d restic secrets labeled for backup storage location %s in namespace %s, expected at most one", len(secretList.Items), bslName, protectedNS)
+	}
+
+	secret, err := secretClient.CoreV1().Secrets(protectedNS).Get(context.TODO(), resticSecretName, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := ValidateResticSecret(secret, VaultEnabled()); err != nil {
+		return "", err
+	}
+
+	return resticSecretName, nil
+}
+
+// EnsureResticSecret makes sure the restic secret a VSR is created with exists in the protected namespace, recreating
+// it from the configured restic source secret if it is missing. It returns false if the secret is missing and no
+// source secret is configured.
+func EnsureResticSecret(name, protectedNS, restoreName string, secretsGetter corev1client.SecretsGetter, log logrus.FieldLogger) (bool, error) {
+	_, err := secretsGetter.Secrets(protectedNS).Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		return true, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return false, errors.Wrapf(err, "error getting restic secret %s/%s", protectedNS, name)
+	}
+
+	sourceNS, sourceName, err := GetResticSourceSecret()
+	if err != nil {
+		return false, err
+	}
+	if len(sourceName) == 0 {
+		return false, nil
+	}
+
+	source, err := secretsGetter.Secrets(sourceNS).Get(context.TODO(), sourceName, metav1.GetOptions{})
+	if err != nil {
+		return false, errors.Wrapf(err, "error getting restic source secret %s/%s", sourceNS, sourceName)
+	}
+
+	secret := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: protectedNS,
+			Labels: map[string]string{
+				RestoreNameLabel: label.GetValidName(restoreName),
+			},
+		},
+		Type: source.Type,
+		Data: source.Data,
+	}
+
+	_, err = secretsGetter.Secrets(protectedNS).Create(context.TODO(), secret, metav1.CreateOptions{})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return false, errors.Wrapf(err, "error recreating restic secret %s/%s", protectedNS, name)
+	}
+
+	log.Infof("recreated missing restic secret %s/%s from %s/%s", protectedNS, name, sourceNS, sourceName)
+	return true, nil
+}
+
+// CheckIfVolumeSnapshotRestoresAreComplete watches each VSR in the list until all of them complete, one fails, or
+// timeout elapses
+func CheckIfVolumeSnapshotRestoresAreComplete(ctx context.Context, volumesnapshotrestores datamoverv1alpha1.VolumeSnapshotRestoreList, timeout time.Duration, log logrus.FieldLogger) error {
+	eg, ctx := errgroup.WithContext(ctx)
+
+	volumeSnapMoverClient, err := getWatchClient()
+	if err != nil {
+		return err
 	}
 
 	for _, vsr := range volumesnapshotrestores.Items {
 		volumesnapshotrestore := vsr
 		eg.Go(func() error {
 
-			err := wait.PollImmediate(interval, timeout, func() (bool, error) {
-				tmpVSR := datamoverv1alpha1.VolumeSnapshotRestore{}
-				err := volumeSnapMoverClient.Get(ctx, client.ObjectKey{Namespace: volumesnapshotrestore.Namespace, Name: volumesnapshotrestore.Name}, &tmpVSR)
-				if err != nil {
-					return false, errors.Wrapf(err, fmt.Sprintf("failed to get volumesnapshotrestore %s/%s", volumesnapshotrestore.Namespace, volumesnapshotrestore.Name))
+			lw := newNamedListWatch(ctx, volumeSnapMoverClient, &datamoverv1alpha1.VolumeSnapshotRestoreList{}, volumesnapshotrestore.Namespace, volumesnapshotrestore.Name)
+			err := waitForObject(ctx, lw, &datamoverv1alpha1.VolumeSnapshotRestore{}, timeout, func(obj runtime.Object) (bool, error) {
+				tmpVSR, ok := obj.(*datamoverv1alpha1.VolumeSnapshotRestore)
+				if !ok || tmpVSR.Name != volumesnapshotrestore.Name {
+					return false, nil
 				}
 
 				// check for a failed VSR
@@ -576,7 +1336,7 @@ func CheckIfVolumeSnapshotRestoresAreComplete(ctx context.Context, volumesnapsho
 
 				// current VSR in list is still in progress
 				if len(tmpVSR.Status.SnapshotHandle) == 0 || len(tmpVSR.Status.Phase) == 0 || tmpVSR.Status.Phase != datamoverv1alpha1.SnapMoverRestorePhaseCompleted {
-					log.Infof("Waiting for volumesnapshotrestore to complete %s/%s. Retrying in %ds", volumesnapshotrestore.Namespace, volumesnapshotrestore.Name, interval/time.Second)
+					log.Infof("Waiting for volumesnapshotrestore to complete %s/%s", volumesnapshotrestore.Namespace, volumesnapshotrestore.Name)
 					return false, nil
 				}
 
@@ -587,14 +1347,20 @@ func CheckIfVolumeSnapshotRestoresAreComplete(ctx context.Context, volumesnapsho
 
 			if err == wait.ErrWaitTimeout {
 				log.Errorf("Timed out awaiting reconciliation of volumesnapshotrestore %s/%s", volumesnapshotrestore.Namespace, volumesnapshotrestore.Name)
+				return err
 			}
-			return err
+			if err != nil {
+				return errors.Wrapf(err, "failed to wait for volumesnapshotrestore %s/%s", volumesnapshotrestore.Namespace, volumesnapshotrestore.Name)
+			}
+			return nil
 		})
 	}
 	return eg.Wait()
 }
 
-func WaitForDataMoverRestoreToComplete(restoreName string, log logrus.FieldLogger) error {
+func WaitForDataMoverRestoreToComplete(ctx context.Context, restoreName string, timeout time.Duration, log logrus.FieldLogger) error {
+	ctx, done := startWait(ctx, "datamover_restore_complete")
+	defer done()
 
 	//wait for all the VSRs to be complete
 	volumeSnapMoverClient, err := GetVolumeSnapshotMoverClient()
@@ -608,7 +1374,7 @@ func WaitForDataMoverRestoreToComplete(restoreName string, log logrus.FieldLogge
 		velerov1api.RestoreNameLabel: restoreName,
 	})
 
-	err = volumeSnapMoverClient.List(context.TODO(), &VSRList, VSRListOptions)
+	err = volumeSnapMoverClient.List(ctx, &VSRList, VSRListOptions)
 	if err != nil {
 		log.Errorf(err.Error())
 		return err
@@ -617,7 +1383,7 @@ func WaitForDataMoverRestoreToComplete(restoreName string, log logrus.FieldLogge
 	//Wait for all VSRs to complete
 	if len(VSRList.Items) > 0 {
 
-		err = CheckIfVolumeSnapshotRestoresAreComplete(context.Background(), VSRList, log)
+		err = CheckIfVolumeSnapshotRestoresAreComplete(ctx, VSRList, timeout, log)
 		if err != nil {
 			log.Errorf("failed to wait for VolumeSnapshotRestores to be completed: %s", err.Error())
 			return err
@@ -652,26 +1418,16 @@ func VSBBelongsToBackup(backupName string, vsb *datamoverv1alpha1.VolumeSnapshot
 	return true
 }
 
-func WaitForVolumeSnapshotSourceToBeReady(volSnap *snapshotv1api.VolumeSnapshot, log logrus.FieldLogger) error {
+func WaitForVolumeSnapshotSourceToBeReady(ctx context.Context, volSnap *snapshotv1api.VolumeSnapshot, timeout time.Duration, log logrus.FieldLogger) error {
 	if volSnap == nil {
 		return errors.New("nil volumeSnapshot in WaitForVolumeSnapshotSourceToBeReady")
 	}
+	ctx, done := startWait(ctx, "volumesnapshot_source")
+	defer done()
 
-	timeoutValue := "10m"
-
-	// use timeout value if configured
-	if len(os.Getenv(DatamoverTimeout)) > 0 {
-		timeoutValue = os.Getenv(DatamoverTimeout)
-	}
-	timeout, err := time.ParseDuration(timeoutValue)
-	if err != nil {
-		return errors.Wrapf(err, "error parsing datamover timeout")
-	}
-	interval := 5 * time.Second
-
-	err = wait.PollImmediate(interval, timeout, func() (bool, error) {
+	err := pollWithBackoff(ctx, timeout, func(ctx context.Context) (bool, error) {
 		if volSnap.Spec.Source.PersistentVolumeClaimName == nil {
-			log.Infof("Waiting for volumesnapshot %s to have source PVC data. Retrying in %ds", volSnap.Name, interval/time.Second)
+			log.Infof("Waiting for volumesnapshot %s to have source PVC data", volSnap.Name)
 			return false, nil
 		}
 		return true, nil
@@ -717,6 +1473,201 @@ func GetVSRsFromBackup(backupName string, vsbName string) (datamoverv1alpha1.Vol
 	return vsrList, nil
 }
 
+// GetVSRsForRestorePVC returns the VSR(s) the restore created for the PVC restored into the namespace
+func GetVSRsForRestorePVC(restoreName string, namespace string, pvcName string) (datamoverv1alpha1.VolumeSnapshotRestoreList, error) {
+
+	vsrList := datamoverv1alpha1.VolumeSnapshotRestoreList{}
+	snapMoverClient, err := GetVolumeSnapshotMoverClient()
+	if err != nil {
+		return vsrList, err
+	}
+
+	vsrListOptions := client.MatchingLabels(map[string]string{
+		velerov1api.RestoreNameLabel: restoreName,
+		PersistentVolumeClaimLabel:   label.GetValidName(pvcName),
+	})
+
+	err = snapMoverClient.List(context.TODO(), &vsrList, client.InNamespace(namespace), vsrListOptions)
+	if err != nil {
+		return vsrList, err
+	}
+
+	return vsrList, nil
+}
+
+// GetExistingVSRForVSB returns the VSR the restore already created for the VSB and the PVC restored into the
+// namespace, so a retried restore item reuses it instead of moving the data a second time. VSRs being deleted are
+// not reused.
+func GetExistingVSRForVSB(restoreName, namespace, pvcName, vsbName string) (*datamoverv1alpha1.VolumeSnapshotRestore, error) {
+	vsrList, err := GetVSRsForRestorePVC(restoreName, namespace, pvcName)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range vsrList.Items {
+		vsr := &vsrList.Items[i]
+		if vsr.Labels[VolumeSnapshotBackupLabel] == vsbName && vsr.DeletionTimestamp == nil {
+			return vsr, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// GetRestoreNamespace returns the namespace the restore maps the backed up namespace to
+func GetRestoreNamespace(restore *velerov1api.Restore, namespace string) string {
+	if target, ok := restore.Spec.NamespaceMapping[namespace]; ok && len(target) > 0 {
+		return target
+	}
+
+	return namespace
+}
+
+// GetRestoreAccessModes returns the access modes a PVC backed up with accessModes is restored with: the one the
+// RestoreAccessModeAnnotation of the restore selects, or else the access modes mapped by the access mode mapping
+// ConfigMap, or else the backed up ones
+func GetRestoreAccessModes(restore *velerov1api.Restore, accessModes []string) ([]string, error) {
+	if mode, ok := restore.Annotations[RestoreAccessModeAnnotation]; ok && len(mode) > 0 {
+		if !isValidAccessMode(mode) {
+			return nil, errors.Errorf("invalid %s annotation %q on restore %s", RestoreAccessModeAnnotation, mode, restore.Name)
+		}
+		return []string{mode}, nil
+	}
+
+	mapping, err := GetAccessModeMapping()
+	if err != nil {
+		return nil, err
+	}
+
+	var restored []string
+	for _, mode := range accessModes {
+		if mapped, ok := mapping[mode]; ok && len(mapped) > 0 {
+			if !isValidAccessMode(mapped) {
+				return nil, errors.Errorf("invalid access mode %q mapped from %s", mapped, mode)
+			}
+			mode = mapped
+		}
+		if !Contains(restored, mode) {
+			restored = append(restored, mode)
+		}
+	}
+
+	return restored, nil
+}
+
+// GetRestorePVCSize returns the size the PVC backed up in namespace with backedUpSize is restored with: the size the
+// RestoreSizeAnnotation of the restore sets for <namespace>/<pvc>, or else the one the size mapping ConfigMap sets
+// for <namespace>.<pvc>, or else the backed up size. Sizes below the backed up one are rejected, restoring into them
+// would truncate the data.
+func GetRestorePVCSize(restore *velerov1api.Restore, namespace, pvcName, backedUpSize string) (string, error) {
+	size := ""
+	if val, ok := restore.Annotations[RestoreSizeAnnotation]; ok && len(val) > 0 {
+		for _, entry := range strings.Split(val, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+			if len(parts) != 2 {
+				return "", errors.Errorf("invalid %s annotation %q on restore %s: expected <namespace>/<pvc>=<size> entries", RestoreSizeAnnotation, val, restore.Name)
+			}
+			if parts[0] == namespace+"/"+pvcName {
+				size = parts[1]
+			}
+		}
+	}
+
+	if len(size) == 0 {
+		mapping, err := GetSizeMapping()
+		if err != nil {
+			return "", err
+		}
+		size = mapping[namespace+"."+pvcName]
+	}
+
+	if len(size) == 0 {
+		return backedUpSize, nil
+	}
+
+	requested, err := resource.ParseQuantity(size)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid restore size %q of PVC %s/%s", size, namespace, pvcName)
+	}
+
+	if len(backedUpSize) > 0 {
+		backedUp, err := resource.ParseQuantity(backedUpSize)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid backed up size %q of PVC %s/%s", backedUpSize, namespace, pvcName)
+		}
+		if requested.Cmp(backedUp) < 0 {
+			return "", errors.Errorf("restore size %s of PVC %s/%s is smaller than its backed up size %s", size, namespace, pvcName, backedUpSize)
+		}
+	}
+
+	return requested.String(), nil
+}
+
+// GetRestorePVCName returns the name the PVC backed up in namespace is restored with. When the restore maps several
+// namespaces onto one, PVCs of the namespaces other than the target itself are suffixed with their backed up
+// namespace, so same-named PVCs don't collide. The name only depends on the namespace mapping, so the VSR, the
+// volumesnapshot and the PVC of a volume all agree on it whatever order they are restored in.
+func GetRestorePVCName(restore *velerov1api.Restore, namespace, pvcName string) string {
+	target := GetRestoreNamespace(restore, namespace)
+	if target == namespace {
+		return pvcName
+	}
+
+	sources := 0
+	for source := range restore.Spec.NamespaceMapping {
+		if GetRestoreNamespace(restore, source) == target {
+			sources++
+		}
+	}
+	if sources < 2 {
+		return pvcName
+	}
+
+	name := fmt.Sprintf("%s-%s", pvcName, namespace)
+	if len(name) > validation.DNS1123SubdomainMaxLength {
+		name = label.GetValidName(name)
+	}
+
+	return name
+}
+
+// RestorePVCExists returns whether the PVC a data mover restore restores into already exists in the cluster
+func RestorePVCExists(namespace, pvcName string, pvcGetter corev1client.PersistentVolumeClaimsGetter) (bool, error) {
+	_, err := pvcGetter.PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to get PVC %s/%s", namespace, pvcName)
+	}
+	return true, nil
+}
+
+// ReplacesExistingPVCs returns whether the restore replaces the data of PVCs which already exist in the cluster, as
+// velero updates other existing resources with the update existing resource policy
+func ReplacesExistingPVCs(restore *velerov1api.Restore) bool {
+	return restore.Spec.ExistingResourcePolicy == velerov1api.PolicyTypeUpdate
+}
+
+// DeleteExistingRestorePVC deletes the existing PVC a data mover restore replaces, so velero restores it anew from
+// the restored snapshot. A PVC still mounted by pods is not deleted, its deletion would not complete before velero
+// restores it.
+func DeleteExistingRestorePVC(namespace, pvcName string, corev1 corev1client.CoreV1Interface) error {
+	pods, err := GetPodsUsingPVC(namespace, pvcName, corev1)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list pods using PVC %s/%s", namespace, pvcName)
+	}
+	if len(pods) > 0 {
+		return errors.Errorf("PVC %s/%s can't be replaced, it is used by pod %s", namespace, pvcName, pods[0].Name)
+	}
+
+	err = corev1.PersistentVolumeClaims(namespace).Delete(context.TODO(), pvcName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "failed to delete PVC %s/%s", namespace, pvcName)
+	}
+	return nil
+}
+
 func GetReplicationSourcesForVSB(vsbName string) (volsyncv1alpha1.ReplicationSourceList, error) {
 
 	rsList := volsyncv1alpha1.ReplicationSourceList{}
@@ -737,3 +1688,306 @@ func GetReplicationSourcesForVSB(vsbName string) (volsyncv1alpha1.ReplicationSou
 
 	return rsList, nil
 }
+
+func GetReplicationDestinationsForVSR(vsrName string) (volsyncv1alpha1.ReplicationDestinationList, error) {
+
+	rdList := volsyncv1alpha1.ReplicationDestinationList{}
+	volsyncClient, err := GetVolsyncClient()
+	if err != nil {
+		return rdList, err
+	}
+
+	// get RD(s) associated with specific VSR
+	rdListOptions := client.MatchingLabels(map[string]string{
+		VSRLabel: vsrName,
+	})
+
+	err = volsyncClient.List(context.TODO(), &rdList, rdListOptions)
+	if err != nil {
+		return rdList, err
+	}
+
+	return rdList, nil
+}
+
+// GetVolumeSnapshotBackupPVCName returns the name of the PVC the data mover controller provisions in the protected
+// namespace from the snapshot of the VSB, for the mover pod to read the data from
+func GetVolumeSnapshotBackupPVCName(vsb *datamoverv1alpha1.VolumeSnapshotBackup) string {
+	return vsb.Spec.VolumeSnapshotContent.Name + "-pvc"
+}
+
+// DescribeDataMoverProgress returns the progress description for a VSB/VSR phase and batching status. CRs without a phase,
+// because the controller has not reconciled them yet or does not serve their version, are reported as pending.
+func DescribeDataMoverProgress(phase, batchingStatus string, created metav1.Time) string {
+	if len(phase) == 0 {
+		return fmt.Sprintf("Phase: Pending Elapsed: %s", time.Since(created.Time).Round(time.Second))
+	}
+
+	if len(batchingStatus) == 0 {
+		return "Phase: " + phase
+	}
+
+	return "Phase: " + phase + " BatchingStatus: " + batchingStatus
+}
+
+// GetVolumeSnapshotBackupPhaseResult maps a VSB phase to the state of its async operation. PartiallyFailed is terminal
+// and reported as an error. known is false for phases this plugin does not recognize, which are left running until
+// the operation timeout so a newer controller's intermediate phases don't fail the backup.
+func GetVolumeSnapshotBackupPhaseResult(phase datamoverv1alpha1.VolumeSnapshotBackupPhase) (completed bool, errMsg string, known bool) {
+	switch phase {
+	case "", datamoverv1alpha1.SnapMoverBackupPhaseInProgress, datamoverv1alpha1.SnapMoverVolSyncPhaseCompleted,
+		datamoverv1alpha1.SnapMoverBackupPhaseCleanup:
+		return false, "", true
+	case datamoverv1alpha1.SnapMoverBackupPhaseCompleted:
+		return true, "", true
+	case datamoverv1alpha1.SnapMoverBackupPhaseFailed:
+		return true, "VolumeSnapshotBackup has a failed status", true
+	case datamoverv1alpha1.SnapMoverBackupPhasePartiallyFailed:
+		return true, "VolumeSnapshotBackup has a partially failed status", true
+	}
+
+	return false, "", false
+}
+
+// GetVolumeSnapshotRestorePhaseResult maps a VSR phase to the state of its async operation,
+// with the same semantics as GetVolumeSnapshotBackupPhaseResult
+func GetVolumeSnapshotRestorePhaseResult(phase datamoverv1alpha1.VolumeSnapshotRestorePhase) (completed bool, errMsg string, known bool) {
+	switch phase {
+	case "", datamoverv1alpha1.SnapMoverRestorePhaseInProgress, datamoverv1alpha1.SnapMoverRestoreVolSyncPhaseCompleted,
+		datamoverv1alpha1.SnapMoverRestorePhaseCleanup:
+		return false, "", true
+	case datamoverv1alpha1.SnapMoverRestorePhaseCompleted:
+		return true, "", true
+	case datamoverv1alpha1.SnapMoverRestorePhaseFailed:
+		return true, "VolumeSnapshotRestore has a failed status", true
+	case datamoverv1alpha1.SnapMoverRestorePhasePartiallyFailed:
+		return true, "VolumeSnapshotRestore has a partially failed status", true
+	}
+
+	return false, "", false
+}
+
+// DescribeUnknownDataMoverPhase returns the progress description for a VSB/VSR phase this plugin does not recognize
+func DescribeUnknownDataMoverPhase(phase string, created metav1.Time) string {
+	return fmt.Sprintf("Phase: Unknown (%s) Elapsed: %s", phase, time.Since(created.Time).Round(time.Second))
+}
+
+// IsBackupInProgress returns whether the named velero backup exists and is still running
+func IsBackupInProgress(backupNamespace, backupName string) (bool, error) {
+	veleroClient, err := GetVeleroClient()
+	if err != nil {
+		return false, err
+	}
+
+	backup := velerov1api.Backup{}
+	err = veleroClient.Get(context.TODO(), client.ObjectKey{Namespace: backupNamespace, Name: backupName}, &backup)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(err, "failed to get backup %s/%s", backupNamespace, backupName)
+	}
+
+	return IsBackupPhaseInProgress(backup.Status.Phase), nil
+}
+
+// IsBackupPhaseInProgress returns whether a backup in the given phase is still running and may be
+// tracking data mover operations. Deleting and the terminal phases are not in progress.
+func IsBackupPhaseInProgress(phase velerov1api.BackupPhase) bool {
+	switch phase {
+	case "", velerov1api.BackupPhaseNew,
+		velerov1api.BackupPhaseInProgress,
+		velerov1api.BackupPhaseWaitingForPluginOperations,
+		velerov1api.BackupPhaseWaitingForPluginOperationsPartiallyFailed,
+		velerov1api.BackupPhaseFinalizing,
+		velerov1api.BackupPhaseFinalizingPartiallyFailed:
+		return true
+	}
+
+	return false
+}
+
+// VolumeSnapshotRestoreOutcome is the per-volume result recorded in the restore summary annotation
+type VolumeSnapshotRestoreOutcome struct {
+	PVCName  string `json:"pvcName"`
+	VSBName  string `json:"vsbName,omitempty"`
+	VSRName  string `json:"vsrName,omitempty"`
+	Outcome  string `json:"outcome"`
+	Reason   string `json:"reason,omitempty"`
+	Duration string `json:"duration,omitempty"`
+}
+
+const (
+	RestoreOutcomeCompleted = "completed"
+	RestoreOutcomeFailed    = "failed"
+	RestoreOutcomeSkipped   = "skipped"
+)
+
+// SummarizeVolumeSnapshotRestores returns the outcome of each VSR and whether all of them have settled. VSRs still
+// running after the timeout are reported as failed, the same way Progress gives up on them.
+func SummarizeVolumeSnapshotRestores(vsrList datamoverv1alpha1.VolumeSnapshotRestoreList, timeout time.Duration) ([]VolumeSnapshotRestoreOutcome, bool) {
+	outcomes := []VolumeSnapshotRestoreOutcome{}
+	settled := true
+
+	for _, vsr := range vsrList.Items {
+		outcome := VolumeSnapshotRestoreOutcome{
+			PVCName: vsr.Spec.VolumeSnapshotMoverBackupref.BackedUpPVCData.Name,
+			VSBName: vsr.Labels[VolumeSnapshotBackupLabel],
+			VSRName: vsr.Name,
+		}
+
+		switch vsr.Status.Phase {
+		case datamoverv1alpha1.SnapMoverRestorePhaseCompleted:
+			outcome.Outcome = RestoreOutcomeCompleted
+		case datamoverv1alpha1.SnapMoverRestorePhaseFailed, datamoverv1alpha1.SnapMoverRestorePhasePartiallyFailed:
+			outcome.Outcome = RestoreOutcomeFailed
+		default:
+			if time.Since(vsr.CreationTimestamp.Time) <= timeout {
+				// VSR still in progress, nothing to summarize yet
+				settled = false
+				continue
+			}
+			outcome.Outcome = RestoreOutcomeFailed
+			outcome.Reason = fmt.Sprintf("did not complete within %s", timeout)
+		}
+
+		if vsr.Status.StartTimestamp != nil && vsr.Status.CompletionTimestamp != nil {
+			outcome.Duration = vsr.Status.CompletionTimestamp.Sub(vsr.Status.StartTimestamp.Time).String()
+		}
+
+		outcomes = append(outcomes, outcome)
+	}
+
+	return outcomes, settled
+}
+
+// getSkippedVolumeSnapshotBackups returns the VSBs recorded as skipped on the restore
+func getSkippedVolumeSnapshotBackups(restore *velerov1api.Restore) ([]VolumeSnapshotRestoreOutcome, error) {
+	skipped := []VolumeSnapshotRestoreOutcome{}
+	val, ok := restore.Annotations[DataMoverRestoreSkippedAnnotation]
+	if !ok {
+		return skipped, nil
+	}
+
+	if err := json.Unmarshal([]byte(val), &skipped); err != nil {
+		return nil, errors.Wrapf(err, "invalid %s annotation on restore %s", DataMoverRestoreSkippedAnnotation, restore.Name)
+	}
+
+	return skipped, nil
+}
+
+// RecordSkippedVolumeSnapshotBackup records on the restore that no VSR is created for the VSB, so the restore summary
+// can list the volume as skipped
+func RecordSkippedVolumeSnapshotBackup(restore *velerov1api.Restore, vsb *datamoverv1alpha1.VolumeSnapshotBackup, reason string) error {
+	veleroClient, err := GetVeleroClient()
+	if err != nil {
+		return err
+	}
+
+	// velero updates the restore while it runs, patch the live object with optimistic locking and retry on conflicts
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		live := velerov1api.Restore{}
+		if err := veleroClient.Get(context.TODO(), client.ObjectKey{Namespace: restore.Namespace, Name: restore.Name}, &live); err != nil {
+			return errors.Wrapf(err, "failed to get restore %s", restore.Name)
+		}
+
+		skipped, err := getSkippedVolumeSnapshotBackups(&live)
+		if err != nil {
+			return err
+		}
+
+		for _, outcome := range skipped {
+			if outcome.VSBName == vsb.Name {
+				return nil
+			}
+		}
+
+		skipped = append(skipped, VolumeSnapshotRestoreOutcome{
+			PVCName: vsb.Annotations[VolumeSnapshotMoverSourcePVCName],
+			VSBName: vsb.Name,
+			Outcome: RestoreOutcomeSkipped,
+			Reason:  reason,
+		})
+
+		skippedJSON, err := json.Marshal(skipped)
+		if err != nil {
+			return errors.Wrapf(err, "failed to marshal skipped volumesnapshotbackups for restore %s", restore.Name)
+		}
+
+		restoreNew := live.DeepCopy()
+		AddAnnotations(&restoreNew.ObjectMeta, map[string]string{
+			DataMoverRestoreSkippedAnnotation: string(skippedJSON),
+		})
+
+		return veleroClient.Patch(context.TODO(), restoreNew, client.MergeFromWithOptions(&live, client.MergeFromWithOptimisticLock{}))
+	})
+}
+
+// UpdateRestoreDataMoverSummary annotates the restore with a per-volume summary once all of its VSRs have settled.
+// Velero only polls operations once every item of the restore has been processed, the summary is not written
+// before that so it never misses VSRs that are still to be created.
+func UpdateRestoreDataMoverSummary(restore *velerov1api.Restore, timeout time.Duration, log logrus.FieldLogger) error {
+	switch restore.Status.Phase {
+	case velerov1api.RestorePhaseWaitingForPluginOperations, velerov1api.RestorePhaseWaitingForPluginOperationsPartiallyFailed,
+		velerov1api.RestorePhaseCompleted, velerov1api.RestorePhasePartiallyFailed, velerov1api.RestorePhaseFailed:
+	default:
+		log.Debugf("restore %s is still processing items, skipping datamover summary", restore.Name)
+		return nil
+	}
+
+	snapMoverClient, err := GetVolumeSnapshotMoverClient()
+	if err != nil {
+		return err
+	}
+
+	vsrList := datamoverv1alpha1.VolumeSnapshotRestoreList{}
+	VSRListOptions := client.MatchingLabels(map[string]string{
+		velerov1api.RestoreNameLabel: restore.Name,
+	})
+
+	err = snapMoverClient.List(context.TODO(), &vsrList, VSRListOptions)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list volumesnapshotrestores for restore %s", restore.Name)
+	}
+
+	outcomes, settled := SummarizeVolumeSnapshotRestores(vsrList, timeout)
+	if !settled {
+		log.Debugf("volumesnapshotrestores for restore %s have not settled yet, skipping summary", restore.Name)
+		return nil
+	}
+
+	veleroClient, err := GetVeleroClient()
+	if err != nil {
+		return err
+	}
+
+	// the restore passed to Progress may predate the skipped VSBs recorded during Execute
+	live := velerov1api.Restore{}
+	if err := veleroClient.Get(context.TODO(), client.ObjectKey{Namespace: restore.Namespace, Name: restore.Name}, &live); err != nil {
+		return errors.Wrapf(err, "failed to get restore %s", restore.Name)
+	}
+
+	skipped, err := getSkippedVolumeSnapshotBackups(&live)
+	if err != nil {
+		return err
+	}
+	outcomes = append(outcomes, skipped...)
+
+	summary, err := json.Marshal(outcomes)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal datamover summary for restore %s", restore.Name)
+	}
+
+	restoreNew := live.DeepCopy()
+	AddAnnotations(&restoreNew.ObjectMeta, map[string]string{
+		DataMoverRestoreSummaryAnnotation: string(summary),
+	})
+
+	err = veleroClient.Patch(context.TODO(), restoreNew, client.MergeFrom(&live))
+	if err != nil {
+		return errors.Wrapf(err, "failed to patch restore %s with datamover summary", restore.Name)
+	}
+
+	log.Infof("patched restore %s with datamover summary for %d volumes", restore.Name, len(outcomes))
+	return nil
+}
@@ -19,18 +19,30 @@ package util
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	datamoverv1alpha1 "github.com/konveyor/volume-snapshot-mover/api/v1alpha1"
 	snapshotv1api "github.com/kubernetes-csi/external-snapshotter/client/v4/apis/volumesnapshot/v1"
 	snapshotFake "github.com/kubernetes-csi/external-snapshotter/client/v4/clientset/versioned/fake"
 	"github.com/sirupsen/logrus"
+	velerov1api "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/podvolume"
 	corev1api "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes/fake"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 var (
@@ -286,6 +298,66 @@ func TestGetPodsUsingPVC(t *testing.T) {
 	}
 }
 
+func TestGetPodsUsingPVCPaginated(t *testing.T) {
+	newPod := func(i int, claimName string) corev1api.Pod {
+		return corev1api.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod-%d", i), Namespace: "default"},
+			Spec: corev1api.PodSpec{
+				Volumes: []corev1api.Volume{{
+					Name: "data",
+					VolumeSource: corev1api.VolumeSource{
+						PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+					},
+				}},
+			},
+		}
+	}
+	pages := [][]corev1api.Pod{
+		{newPod(0, "csi-pvc1"), newPod(1, "other")},
+		{newPod(2, "other")},
+		{newPod(3, "csi-pvc1")},
+	}
+
+	// the fake clientset drops the limit and continue token of lists, so the pages are served by pagedPods
+	podsGetter := &pagedPods{pages: pages}
+	pods, err := GetPodsUsingPVC("default", "csi-pvc1", podsGetter)
+	assert.NoError(t, err)
+	assert.Len(t, pods, 2)
+	assert.Equal(t, "pod-0", pods[0].Name)
+	assert.Equal(t, "pod-3", pods[1].Name)
+
+	assert.Len(t, podsGetter.listed, 3)
+	for _, opts := range podsGetter.listed {
+		assert.Equal(t, int64(podListPageSize), opts.Limit)
+	}
+	assert.Equal(t, "2", podsGetter.listed[2].Continue)
+}
+
+// pagedPods serves the pod pages in turn, the continue token of a page being the index of the next one
+type pagedPods struct {
+	corev1client.PodInterface
+	pages  [][]corev1api.Pod
+	listed []metav1.ListOptions
+}
+
+func (p *pagedPods) Pods(string) corev1client.PodInterface {
+	return p
+}
+
+func (p *pagedPods) List(_ context.Context, opts metav1.ListOptions) (*corev1api.PodList, error) {
+	p.listed = append(p.listed, opts)
+
+	page := 0
+	if len(opts.Continue) > 0 {
+		page, _ = strconv.Atoi(opts.Continue)
+	}
+	podList := &corev1api.PodList{Items: p.pages[page]}
+	if page+1 < len(p.pages) {
+		podList.Continue = strconv.Itoa(page + 1)
+	}
+	return podList, nil
+}
+
 func TestGetPodVolumeNameForPVC(t *testing.T) {
 	testCases := []struct {
 		name               string
@@ -391,6 +463,54 @@ func TestGetPodVolumeNameForPVC(t *testing.T) {
 	}
 }
 
+func TestAnnotatePodsForFsBackup(t *testing.T) {
+	pvcVolume := func(name, claim string) corev1api.Volume {
+		return corev1api.Volume{
+			Name:         name,
+			VolumeSource: corev1api.VolumeSource{PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+		}
+	}
+	kubeClient := fake.NewSimpleClientset(
+		&corev1api.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "app-ns"},
+			Spec:       corev1api.PodSpec{Volumes: []corev1api.Volume{pvcVolume("data", "data-pvc")}},
+		},
+		&corev1api.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "app-2",
+				Namespace:   "app-ns",
+				Annotations: map[string]string{podvolume.VolumesToBackupAnnotation: "cache"},
+			},
+			Spec: corev1api.PodSpec{Volumes: []corev1api.Volume{pvcVolume("cache", "cache-pvc"), pvcVolume("shared", "data-pvc")}},
+		},
+		&corev1api.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "app-ns"},
+			Spec:       corev1api.PodSpec{Volumes: []corev1api.Volume{pvcVolume("cache", "cache-pvc")}},
+		},
+	)
+
+	pods, err := AnnotatePodsForFsBackup("app-ns", "data-pvc", kubeClient.CoreV1())
+	assert.NoError(t, err)
+	assert.Len(t, pods, 2)
+
+	for name, expected := range map[string]string{"app-1": "data", "app-2": "cache,shared", "other": ""} {
+		pod, err := kubeClient.CoreV1().Pods("app-ns").Get(context.TODO(), name, metav1.GetOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, expected, pod.Annotations[podvolume.VolumesToBackupAnnotation], name)
+	}
+
+	// volumes already opted in are left as is
+	_, err = AnnotatePodsForFsBackup("app-ns", "data-pvc", kubeClient.CoreV1())
+	assert.NoError(t, err)
+	pod, err := kubeClient.CoreV1().Pods("app-ns").Get(context.TODO(), "app-2", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "cache,shared", pod.Annotations[podvolume.VolumesToBackupAnnotation])
+
+	pods, err = AnnotatePodsForFsBackup("app-ns", "unmounted-pvc", kubeClient.CoreV1())
+	assert.NoError(t, err)
+	assert.Empty(t, pods)
+}
+
 func TestContains(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -517,6 +637,7 @@ func TestGetVolumeSnapshotCalssForStorageClass(t *testing.T) {
 
 			if tc.expectError {
 				assert.NotNil(t, actualError)
+				assert.True(t, errors.Is(actualError, ErrNoVolumeSnapshotClass))
 				assert.Nil(t, actualVSC)
 				return
 			}
@@ -707,7 +828,7 @@ func TestGetVolumeSnapshotContentForVolumeSnapshot(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actualVSC, actualError := GetVolumeSnapshotContentForVolumeSnapshot(tc.volSnap, fakeClient.SnapshotV1(), logrus.New().WithField("fake", "test"), tc.wait)
+			actualVSC, actualError := GetVolumeSnapshotContentForVolumeSnapshot(context.TODO(), tc.volSnap, fakeClient.SnapshotV1(), logrus.New().WithField("fake", "test"), tc.wait)
 			if tc.expectError && actualError == nil {
 				assert.NotNil(t, actualError)
 				assert.Nil(t, actualVSC)
@@ -982,6 +1103,74 @@ func TestIsVolumeSnapshotHasVSCDeleteSecret(t *testing.T) {
 	}
 }
 
+func TestGetRestoreVSCDeleteSecret(t *testing.T) {
+	vscWithSecret := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "vsc-1",
+			Annotations: map[string]string{
+				"csi.storage.k8s.io/snapshotter-secret-name":      "vscDelSecret",
+				"csi.storage.k8s.io/snapshotter-secret-namespace": "vsc-ns",
+			},
+		},
+	}
+	vsWithSecret := &snapshotv1api.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "vs-1",
+			Annotations: map[string]string{
+				"velero.io/csi-deletesnapshotsecret-name":      "snapDelSecret",
+				"velero.io/csi-deletesnapshotsecret-namespace": "awesome-ns",
+			},
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		vsc               *snapshotv1api.VolumeSnapshotContent
+		vs                *snapshotv1api.VolumeSnapshot
+		expectedName      string
+		expectedNamespace string
+		expectedFound     bool
+	}{
+		{
+			name:              "secret on the VSR volumesnapshotcontent takes precedence",
+			vsc:               vscWithSecret,
+			vs:                vsWithSecret,
+			expectedName:      "vscDelSecret",
+			expectedNamespace: "vsc-ns",
+			expectedFound:     true,
+		},
+		{
+			name:              "falls back to the volumesnapshot annotations",
+			vsc:               &snapshotv1api.VolumeSnapshotContent{ObjectMeta: metav1.ObjectMeta{Name: "vsc-2"}},
+			vs:                vsWithSecret,
+			expectedName:      "snapDelSecret",
+			expectedNamespace: "awesome-ns",
+			expectedFound:     true,
+		},
+		{
+			name:              "no volumesnapshotcontent",
+			vs:                vsWithSecret,
+			expectedName:      "snapDelSecret",
+			expectedNamespace: "awesome-ns",
+			expectedFound:     true,
+		},
+		{
+			name:          "no secret anywhere",
+			vs:            &snapshotv1api.VolumeSnapshot{ObjectMeta: metav1.ObjectMeta{Name: "vs-2"}},
+			expectedFound: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name, namespace, found := GetRestoreVSCDeleteSecret(tc.vsc, tc.vs)
+			assert.Equal(t, tc.expectedName, name)
+			assert.Equal(t, tc.expectedNamespace, namespace)
+			assert.Equal(t, tc.expectedFound, found)
+		})
+	}
+}
+
 func TestAddAnnotations(t *testing.T) {
 	annotationValues := map[string]string{
 		"k1": "v1",
@@ -1154,6 +1343,34 @@ func TestIsVolumeSnapshotExists(t *testing.T) {
 	}
 }
 
+func TestSnapshotRetentionForBackup(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{SnapshotRetentionDays: "3"}
+	configMapFetchedAt = time.Now()
+
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1"}}
+	retention, err := SnapshotRetentionForBackup(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*24*time.Hour, retention)
+
+	backup.Annotations = map[string]string{SnapshotRetentionAnnotation: "0"}
+	retention, err = SnapshotRetentionForBackup(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), retention)
+
+	backup.Annotations = map[string]string{SnapshotRetentionAnnotation: "30"}
+	retention, err = SnapshotRetentionForBackup(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, 30*24*time.Hour, retention)
+
+	backup.Annotations = map[string]string{SnapshotRetentionAnnotation: "-1"}
+	_, err = SnapshotRetentionForBackup(backup)
+	assert.Error(t, err)
+}
+
 func TestSetVolumeSnapshotContentDeletionPolicy(t *testing.T) {
 	testCases := []struct {
 		name         string
@@ -1325,7 +1542,7 @@ func TestWaitForVolumeSnapshotToHaveSource(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			actual := WaitForVolumeSnapshotSourceToBeReady(tc.volSnapshot, logrus.New().WithField("fake", "test"))
+			actual := WaitForVolumeSnapshotSourceToBeReady(context.TODO(), tc.volSnapshot, time.Minute, logrus.New().WithField("fake", "test"))
 			if actual != nil && tc.wantErr {
 				assert.EqualError(t, errorMsg, "nil volumeSnapshot in WaitForVolumeSnapshotSourceToBeReady")
 
@@ -1336,3 +1553,1296 @@ func TestWaitForVolumeSnapshotToHaveSource(t *testing.T) {
 		})
 	}
 }
+
+func TestWaitForVolumeSnapshotContentToBeReady(t *testing.T) {
+	handle := "snap-handle"
+	ready := true
+	notReady := false
+
+	testCases := []struct {
+		name    string
+		vsc     *snapshotv1api.VolumeSnapshotContent
+		want    bool
+		wantErr bool
+	}{
+		{
+			name: "ready volumesnapshotcontent returns without waiting",
+			vsc: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc-1"},
+				Status:     &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle, ReadyToUse: &ready},
+			},
+			want: true,
+		},
+		{
+			name: "volumesnapshotcontent that never becomes ready times out",
+			vsc: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc-1"},
+				Status:     &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle, ReadyToUse: &notReady},
+			},
+			wantErr: true,
+		},
+		{
+			name: "volumesnapshotcontent without readiness status times out",
+			vsc: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc-1"},
+				Status:     &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := snapshotFake.NewSimpleClientset(tc.vsc)
+			actual, err := WaitForVolumeSnapshotContentToBeReady(context.TODO(), *tc.vsc, client.SnapshotV1(), time.Second, logrus.New().WithField("fake", "test"))
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.want, actual)
+		})
+	}
+}
+
+func TestSummarizeVolumeSnapshotRestores(t *testing.T) {
+	started := metav1.Now()
+	completed := metav1.NewTime(started.Add(90 * time.Second))
+
+	newVSR := func(name string, phase datamoverv1alpha1.VolumeSnapshotRestorePhase) datamoverv1alpha1.VolumeSnapshotRestore {
+		return datamoverv1alpha1.VolumeSnapshotRestore{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				CreationTimestamp: started,
+				Labels:            map[string]string{VolumeSnapshotBackupLabel: name + "-vsb"},
+			},
+			Spec: datamoverv1alpha1.VolumeSnapshotRestoreSpec{
+				VolumeSnapshotMoverBackupref: datamoverv1alpha1.VSBRef{
+					BackedUpPVCData: datamoverv1alpha1.PVCData{Name: name + "-pvc"},
+				},
+			},
+			Status: datamoverv1alpha1.VolumeSnapshotRestoreStatus{
+				Phase:               phase,
+				StartTimestamp:      &started,
+				CompletionTimestamp: &completed,
+			},
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		vsrs            []datamoverv1alpha1.VolumeSnapshotRestore
+		expectedSettled bool
+		expected        []VolumeSnapshotRestoreOutcome
+	}{
+		{
+			name:            "no VSRs is settled",
+			vsrs:            []datamoverv1alpha1.VolumeSnapshotRestore{},
+			expectedSettled: true,
+			expected:        []VolumeSnapshotRestoreOutcome{},
+		},
+		{
+			name: "completed and failed VSRs are settled",
+			vsrs: []datamoverv1alpha1.VolumeSnapshotRestore{
+				newVSR("vsr-1", datamoverv1alpha1.SnapMoverRestorePhaseCompleted),
+				newVSR("vsr-2", datamoverv1alpha1.SnapMoverRestorePhaseFailed),
+				newVSR("vsr-3", datamoverv1alpha1.SnapMoverRestorePhasePartiallyFailed),
+			},
+			expectedSettled: true,
+			expected: []VolumeSnapshotRestoreOutcome{
+				{PVCName: "vsr-1-pvc", VSBName: "vsr-1-vsb", VSRName: "vsr-1", Outcome: RestoreOutcomeCompleted, Duration: "1m30s"},
+				{PVCName: "vsr-2-pvc", VSBName: "vsr-2-vsb", VSRName: "vsr-2", Outcome: RestoreOutcomeFailed, Duration: "1m30s"},
+				{PVCName: "vsr-3-pvc", VSBName: "vsr-3-vsb", VSRName: "vsr-3", Outcome: RestoreOutcomeFailed, Duration: "1m30s"},
+			},
+		},
+		{
+			name: "VSR running past the timeout is settled as failed",
+			vsrs: []datamoverv1alpha1.VolumeSnapshotRestore{
+				func() datamoverv1alpha1.VolumeSnapshotRestore {
+					vsr := newVSR("vsr-1", datamoverv1alpha1.SnapMoverRestorePhaseInProgress)
+					vsr.CreationTimestamp = metav1.NewTime(time.Now().Add(-2 * time.Hour))
+					vsr.Status.CompletionTimestamp = nil
+					return vsr
+				}(),
+			},
+			expectedSettled: true,
+			expected: []VolumeSnapshotRestoreOutcome{
+				{PVCName: "vsr-1-pvc", VSBName: "vsr-1-vsb", VSRName: "vsr-1", Outcome: RestoreOutcomeFailed, Reason: "did not complete within 1h0m0s"},
+			},
+		},
+		{
+			name: "in progress VSR is not settled",
+			vsrs: []datamoverv1alpha1.VolumeSnapshotRestore{
+				newVSR("vsr-1", datamoverv1alpha1.SnapMoverRestorePhaseCompleted),
+				newVSR("vsr-2", ""),
+			},
+			expectedSettled: false,
+			expected: []VolumeSnapshotRestoreOutcome{
+				{PVCName: "vsr-1-pvc", VSBName: "vsr-1-vsb", VSRName: "vsr-1", Outcome: RestoreOutcomeCompleted, Duration: "1m30s"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, settled := SummarizeVolumeSnapshotRestores(datamoverv1alpha1.VolumeSnapshotRestoreList{Items: tc.vsrs}, time.Hour)
+			assert.Equal(t, tc.expectedSettled, settled)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestExpireRetainedVolumeSnapshotContents(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	notExpired := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	objs := []runtime.Object{
+		&snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "expiredVSC",
+				Labels:      map[string]string{VolumeSnapshotRetainedLabel: "true"},
+				Annotations: map[string]string{VolumeSnapshotRetainUntilAnnotation: expired},
+			},
+			Spec: snapshotv1api.VolumeSnapshotContentSpec{
+				DeletionPolicy: snapshotv1api.VolumeSnapshotContentRetain,
+			},
+		},
+		&snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "retainedVSC",
+				Labels:      map[string]string{VolumeSnapshotRetainedLabel: "true"},
+				Annotations: map[string]string{VolumeSnapshotRetainUntilAnnotation: notExpired},
+			},
+			Spec: snapshotv1api.VolumeSnapshotContentSpec{
+				DeletionPolicy: snapshotv1api.VolumeSnapshotContentRetain,
+			},
+		},
+		&snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "unrelatedVSC",
+			},
+		},
+	}
+
+	fakeClient := snapshotFake.NewSimpleClientset(objs...)
+	err := ExpireRetainedVolumeSnapshotContents(fakeClient.SnapshotV1(), logrus.New().WithField("fake", "test"))
+	assert.Nil(t, err)
+
+	_, err = fakeClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), "expiredVSC", metav1.GetOptions{})
+	assert.NotNil(t, err)
+
+	for _, name := range []string{"retainedVSC", "unrelatedVSC"} {
+		_, err = fakeClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), name, metav1.GetOptions{})
+		assert.Nil(t, err)
+	}
+}
+
+func TestExpireRetainedVolumeSnapshotContentsContinuesOnError(t *testing.T) {
+	expired := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	objs := []runtime.Object{}
+	for _, name := range []string{"failingVSC", "expiredVSC"} {
+		objs = append(objs, &snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{VolumeSnapshotRetainedLabel: "true"},
+				Annotations: map[string]string{VolumeSnapshotRetainUntilAnnotation: expired},
+			},
+		})
+	}
+
+	fakeClient := snapshotFake.NewSimpleClientset(objs...)
+	fakeClient.PrependReactor("delete", "volumesnapshotcontents", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.DeleteAction).GetName() == "failingVSC" {
+			return true, nil, errors.New("fake delete error")
+		}
+		return false, nil, nil
+	})
+
+	err := ExpireRetainedVolumeSnapshotContents(fakeClient.SnapshotV1(), logrus.New().WithField("fake", "test"))
+	assert.NotNil(t, err)
+
+	_, err = fakeClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), "expiredVSC", metav1.GetOptions{})
+	assert.NotNil(t, err)
+}
+
+func TestResolveVolumeSnapshotContentNamespace(t *testing.T) {
+	volumeHandle := "vol-handle-1"
+	unknownHandle := "vol-handle-2"
+
+	pv := &corev1api.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "test-pv",
+		},
+		Spec: corev1api.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1api.PersistentVolumeSource{
+				CSI: &corev1api.CSIPersistentVolumeSource{
+					Driver:       "hostpath.csi.k8s.io",
+					VolumeHandle: volumeHandle,
+				},
+			},
+			ClaimRef: &v1.ObjectReference{
+				Kind:      "PersistentVolumeClaim",
+				Name:      "test-pvc",
+				Namespace: "app-ns",
+			},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		snapCont    *snapshotv1api.VolumeSnapshotContent
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "namespace set on the volumesnapshot ref",
+			snapCont: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc-1"},
+				Spec: snapshotv1api.VolumeSnapshotContentSpec{
+					VolumeSnapshotRef: corev1api.ObjectReference{Name: "vs-1", Namespace: "default"},
+				},
+			},
+			expected: "default",
+		},
+		{
+			name: "namespace resolved from the source volume PVC",
+			snapCont: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc-2"},
+				Spec: snapshotv1api.VolumeSnapshotContentSpec{
+					VolumeSnapshotRef: corev1api.ObjectReference{Name: "vs-2"},
+					Source:            snapshotv1api.VolumeSnapshotContentSource{VolumeHandle: &volumeHandle},
+				},
+			},
+			expected: "app-ns",
+		},
+		{
+			name: "no namespace and no source volume handle",
+			snapCont: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc-3"},
+			},
+			expectError: true,
+		},
+		{
+			name: "no namespace and no PV matching the source volume handle",
+			snapCont: &snapshotv1api.VolumeSnapshotContent{
+				ObjectMeta: metav1.ObjectMeta{Name: "vsc-4"},
+				Spec: snapshotv1api.VolumeSnapshotContentSpec{
+					Source: snapshotv1api.VolumeSnapshotContentSource{VolumeHandle: &unknownHandle},
+				},
+			},
+			expectError: true,
+		},
+	}
+
+	pvNamespaceByHandle = map[string]string{}
+	fakeClient := fake.NewSimpleClientset(pv)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := ResolveVolumeSnapshotContentNamespace(tc.snapCont, fakeClient.CoreV1())
+			if tc.expectError {
+				assert.NotNil(t, err)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestResolveVolumeSnapshotContentNamespaceCachesPVs(t *testing.T) {
+	volumeHandle := "vol-handle-1"
+	pv := &corev1api.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pv"},
+		Spec: corev1api.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1api.PersistentVolumeSource{
+				CSI: &corev1api.CSIPersistentVolumeSource{Driver: "hostpath.csi.k8s.io", VolumeHandle: volumeHandle},
+			},
+			ClaimRef: &v1.ObjectReference{Kind: "PersistentVolumeClaim", Name: "test-pvc", Namespace: "app-ns"},
+		},
+	}
+	snapCont := &snapshotv1api.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{Name: "vsc-1"},
+		Spec: snapshotv1api.VolumeSnapshotContentSpec{
+			Source: snapshotv1api.VolumeSnapshotContentSource{VolumeHandle: &volumeHandle},
+		},
+	}
+
+	pvNamespaceByHandle = map[string]string{}
+	fakeClient := fake.NewSimpleClientset(pv)
+	for i := 0; i < 3; i++ {
+		ns, err := ResolveVolumeSnapshotContentNamespace(snapCont, fakeClient.CoreV1())
+		assert.Nil(t, err)
+		assert.Equal(t, "app-ns", ns)
+	}
+
+	lists := 0
+	for _, action := range fakeClient.Actions() {
+		if action.GetVerb() == "list" && action.GetResource().Resource == "persistentvolumes" {
+			lists++
+		}
+	}
+	assert.Equal(t, 1, lists)
+}
+
+func TestDescribeDataMoverProgress(t *testing.T) {
+	created := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+
+	testCases := []struct {
+		name           string
+		phase          string
+		batchingStatus string
+		expected       string
+	}{
+		{
+			name:     "no phase reports pending with elapsed time",
+			expected: "Phase: Pending Elapsed: 2m0s",
+		},
+		{
+			name:     "phase without batching status",
+			phase:    "InProgress",
+			expected: "Phase: InProgress",
+		},
+		{
+			name:           "phase with batching status",
+			phase:          "InProgress",
+			batchingStatus: "Processing",
+			expected:       "Phase: InProgress BatchingStatus: Processing",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, DescribeDataMoverProgress(tc.phase, tc.batchingStatus, created))
+		})
+	}
+}
+
+func TestDataMoverEnabledForBackup(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	// the velero API of the plugin has no snapshotMoveData, serve the backups unstructured
+	newBackup := func(name string, moveData *bool) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(velerov1api.SchemeGroupVersion.WithKind("Backup"))
+		obj.SetNamespace("openshift-adp")
+		obj.SetName(name)
+		if moveData != nil {
+			assert.NoError(t, unstructured.SetNestedField(obj.Object, *moveData, "spec", "snapshotMoveData"))
+		}
+		return obj
+	}
+	enabled, disabled := true, false
+	crClient := crfake.NewClientBuilder().WithScheme(runtime.NewScheme()).WithRuntimeObjects(
+		newBackup("backup-1", nil),
+		newBackup("move-data", &enabled),
+		newBackup("no-move-data", &disabled),
+	).Build()
+	defer SetClients(fake.NewSimpleClientset(), snapshotFake.NewSimpleClientset(), crClient)()
+
+	testCases := []struct {
+		name          string
+		backupName    string
+		dataMoverCase bool
+		annotations   map[string]string
+		expected      bool
+	}{
+		{
+			name:          "backup enables data movement with snapshotMoveData",
+			backupName:    "move-data",
+			dataMoverCase: false,
+			expected:      true,
+		},
+		{
+			name:          "backup disables data movement with snapshotMoveData",
+			backupName:    "no-move-data",
+			dataMoverCase: true,
+			expected:      false,
+		},
+		{
+			name:          "backup annotation opts out despite snapshotMoveData",
+			backupName:    "move-data",
+			dataMoverCase: true,
+			annotations:   map[string]string{VSMEnabledAnnotation: "false"},
+			expected:      false,
+		},
+		{
+			name:          "backup that can't be read",
+			backupName:    "missing",
+			dataMoverCase: true,
+			expected:      true,
+		},
+		{
+			name:          "data mover disabled for the plugin",
+			dataMoverCase: false,
+			annotations:   map[string]string{VSMEnabledAnnotation: "true"},
+			expected:      false,
+		},
+		{
+			name:          "data mover enabled without backup annotation",
+			dataMoverCase: true,
+			expected:      true,
+		},
+		{
+			name:          "data mover enabled but backup opts out",
+			dataMoverCase: true,
+			annotations:   map[string]string{VSMEnabledAnnotation: "false"},
+			expected:      false,
+		},
+		{
+			name:          "data mover enabled with unparsable backup annotation",
+			dataMoverCase: true,
+			annotations:   map[string]string{VSMEnabledAnnotation: "nope"},
+			expected:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			configMapData = map[string]string{VolumeSnapshotMoverEnv: strconv.FormatBool(tc.dataMoverCase)}
+			configMapFetchedAt = time.Now()
+			backupName := tc.backupName
+			if len(backupName) == 0 {
+				backupName = "backup-1"
+			}
+			backup := &velerov1api.Backup{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        backupName,
+					Namespace:   "openshift-adp",
+					Annotations: tc.annotations,
+				},
+			}
+			assert.Equal(t, tc.expected, DataMoverEnabledForBackup(backup))
+		})
+	}
+}
+
+func TestIsBackupPhaseInProgress(t *testing.T) {
+	testCases := []struct {
+		phase    velerov1api.BackupPhase
+		expected bool
+	}{
+		{phase: "", expected: true},
+		{phase: velerov1api.BackupPhaseNew, expected: true},
+		{phase: velerov1api.BackupPhaseInProgress, expected: true},
+		{phase: velerov1api.BackupPhaseWaitingForPluginOperations, expected: true},
+		{phase: velerov1api.BackupPhaseWaitingForPluginOperationsPartiallyFailed, expected: true},
+		{phase: velerov1api.BackupPhaseFinalizing, expected: true},
+		{phase: velerov1api.BackupPhaseFinalizingPartiallyFailed, expected: true},
+		{phase: velerov1api.BackupPhaseFailedValidation, expected: false},
+		{phase: velerov1api.BackupPhaseCompleted, expected: false},
+		{phase: velerov1api.BackupPhasePartiallyFailed, expected: false},
+		{phase: velerov1api.BackupPhaseFailed, expected: false},
+		{phase: velerov1api.BackupPhaseDeleting, expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.phase), func(t *testing.T) {
+			assert.Equal(t, tc.expected, IsBackupPhaseInProgress(tc.phase))
+		})
+	}
+}
+
+func TestGetVolumeSnapshotBackupPhaseResult(t *testing.T) {
+	testCases := []struct {
+		phase     datamoverv1alpha1.VolumeSnapshotBackupPhase
+		completed bool
+		failed    bool
+		known     bool
+	}{
+		{phase: "", known: true},
+		{phase: datamoverv1alpha1.SnapMoverBackupPhaseInProgress, known: true},
+		{phase: datamoverv1alpha1.SnapMoverVolSyncPhaseCompleted, known: true},
+		{phase: datamoverv1alpha1.SnapMoverBackupPhaseCleanup, known: true},
+		{phase: datamoverv1alpha1.SnapMoverBackupPhaseCompleted, completed: true, known: true},
+		{phase: datamoverv1alpha1.SnapMoverBackupPhaseFailed, completed: true, failed: true, known: true},
+		{phase: datamoverv1alpha1.SnapMoverBackupPhasePartiallyFailed, completed: true, failed: true, known: true},
+		{phase: "SomethingNew"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.phase), func(t *testing.T) {
+			completed, errMsg, known := GetVolumeSnapshotBackupPhaseResult(tc.phase)
+			assert.Equal(t, tc.completed, completed)
+			assert.Equal(t, tc.failed, errMsg != "")
+			assert.Equal(t, tc.known, known)
+		})
+	}
+}
+
+func TestGetVolumeSnapshotRestorePhaseResult(t *testing.T) {
+	testCases := []struct {
+		phase     datamoverv1alpha1.VolumeSnapshotRestorePhase
+		completed bool
+		failed    bool
+		known     bool
+	}{
+		{phase: "", known: true},
+		{phase: datamoverv1alpha1.SnapMoverRestorePhaseInProgress, known: true},
+		{phase: datamoverv1alpha1.SnapMoverRestoreVolSyncPhaseCompleted, known: true},
+		{phase: datamoverv1alpha1.SnapMoverRestorePhaseCleanup, known: true},
+		{phase: datamoverv1alpha1.SnapMoverRestorePhaseCompleted, completed: true, known: true},
+		{phase: datamoverv1alpha1.SnapMoverRestorePhaseFailed, completed: true, failed: true, known: true},
+		{phase: datamoverv1alpha1.SnapMoverRestorePhasePartiallyFailed, completed: true, failed: true, known: true},
+		{phase: "SomethingNew"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.phase), func(t *testing.T) {
+			completed, errMsg, known := GetVolumeSnapshotRestorePhaseResult(tc.phase)
+			assert.Equal(t, tc.completed, completed)
+			assert.Equal(t, tc.failed, errMsg != "")
+			assert.Equal(t, tc.known, known)
+		})
+	}
+}
+
+func TestDataMoverSkipReason(t *testing.T) {
+	newBackup := func(annotations map[string]string) *velerov1api.Backup {
+		return &velerov1api.Backup{
+			ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "velero", Annotations: annotations},
+		}
+	}
+	newVSC := func(backupName string) *snapshotv1api.VolumeSnapshotContent {
+		class := "csi-snapclass"
+		return &snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "vsc-1", Labels: map[string]string{BackupNameLabel: backupName}},
+			Spec:       snapshotv1api.VolumeSnapshotContentSpec{VolumeSnapshotClassName: &class},
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		dataMover string
+		excluded  string
+		backup    *velerov1api.Backup
+		vsc       *snapshotv1api.VolumeSnapshotContent
+		skipped   bool
+	}{
+		{
+			name:      "data mover disabled",
+			dataMover: "false",
+			backup:    newBackup(nil),
+			vsc:       newVSC("backup-1"),
+			skipped:   true,
+		},
+		{
+			name:      "backup opted out",
+			dataMover: "true",
+			backup:    newBackup(map[string]string{VSMEnabledAnnotation: "false"}),
+			vsc:       newVSC("backup-1"),
+			skipped:   true,
+		},
+		{
+			name:      "volumesnapshotcontent of another backup",
+			dataMover: "true",
+			backup:    newBackup(nil),
+			vsc:       newVSC("backup-2"),
+			skipped:   true,
+		},
+		{
+			name:      "volumesnapshotclass excluded from data movement",
+			dataMover: "true",
+			excluded:  "appliance-snapclass, csi-snapclass",
+			backup:    newBackup(nil),
+			vsc:       newVSC("backup-1"),
+			skipped:   true,
+		},
+		{
+			name:      "data is moved",
+			dataMover: "true",
+			excluded:  "appliance-snapclass",
+			backup:    newBackup(nil),
+			vsc:       newVSC("backup-1"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(VolumeSnapshotMoverEnv, tc.dataMover)
+			t.Setenv(DatamoverExcludedVolumeSnapshotClasses, tc.excluded)
+			reason := DataMoverSkipReason(tc.backup, tc.vsc, logrus.New())
+			assert.Equal(t, tc.skipped, len(reason) > 0)
+
+			if tc.skipped {
+				SetDataMoverSkippedReason(&tc.vsc.ObjectMeta, reason)
+				assert.Equal(t, reason, tc.vsc.Annotations[DataMoverSkippedReasonAnnotation])
+			}
+		})
+	}
+}
+
+func TestVolumeSkipReason(t *testing.T) {
+	newPVC := func(storageClass string) *corev1api.PersistentVolumeClaim {
+		return &corev1api.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "app"},
+			Spec:       corev1api.PersistentVolumeClaimSpec{StorageClassName: &storageClass},
+		}
+	}
+	newSkippedPVC := func(skip string) *corev1api.PersistentVolumeClaim {
+		pvc := newPVC("gp3")
+		pvc.Annotations = map[string]string{SkipAnnotation: skip}
+		return pvc
+	}
+	populatorGroup := "populators.example.com"
+	populatedPVC := newPVC("gp3")
+	populatedPVC.Spec.DataSourceRef = &corev1api.TypedObjectReference{APIGroup: &populatorGroup, Kind: "Dataset", Name: "dataset-1"}
+
+	testCases := []struct {
+		name            string
+		allowed         string
+		excluded        string
+		populatedPolicy string
+		pvc             *corev1api.PersistentVolumeClaim
+		skipped         bool
+	}{
+		{name: "no restrictions", pvc: newPVC("gp3")},
+		{name: "no restrictions without a PVC"},
+		{name: "allowed storageclass", allowed: "gp3, io2", pvc: newPVC("io2")},
+		{name: "storageclass not allowed", allowed: "gp3", pvc: newPVC("io2"), skipped: true},
+		{name: "unknown storageclass not allowed", allowed: "gp3", skipped: true},
+		{name: "excluded storageclass", excluded: "local-storage", pvc: newPVC("local-storage"), skipped: true},
+		{name: "storageclass not excluded", excluded: "local-storage", pvc: newPVC("gp3")},
+		{name: "allowed storageclass excluded", allowed: "gp3,local-storage", excluded: "local-storage", pvc: newPVC("local-storage"), skipped: true},
+		{name: "unknown storageclass not excluded", excluded: "local-storage"},
+		{name: "PVC opted out", pvc: newSkippedPVC("true"), skipped: true},
+		{name: "PVC opted in", pvc: newSkippedPVC("false")},
+		{name: "invalid opt-out", pvc: newSkippedPVC("yes please")},
+		{name: "populated PVC", pvc: populatedPVC, skipped: true},
+		{name: "populated PVC moved", populatedPolicy: PopulatedPVCPolicyMove, pvc: populatedPVC},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(DatamoverStorageClasses, tc.allowed)
+			t.Setenv(DatamoverExcludedStorageClasses, tc.excluded)
+			t.Setenv(DatamoverPopulatedPVCPolicy, tc.populatedPolicy)
+			assert.Equal(t, tc.skipped, len(VolumeSkipReason(tc.pvc)) > 0)
+		})
+	}
+}
+
+func TestGetVolumePopulator(t *testing.T) {
+	populatorGroup := "populators.example.com"
+	snapshotGroup := snapshotv1api.GroupName
+
+	testCases := []struct {
+		name           string
+		dataSource     *corev1api.TypedLocalObjectReference
+		dataSourceRef  *corev1api.TypedObjectReference
+		expectedResult string
+	}{
+		{name: "no data source"},
+		{
+			name:          "cloned PVC",
+			dataSourceRef: &corev1api.TypedObjectReference{Kind: "PersistentVolumeClaim", Name: "source"},
+		},
+		{
+			name:          "restored volumesnapshot",
+			dataSourceRef: &corev1api.TypedObjectReference{APIGroup: &snapshotGroup, Kind: "VolumeSnapshot", Name: "snap-1"},
+		},
+		{
+			name:           "volume populator",
+			dataSourceRef:  &corev1api.TypedObjectReference{APIGroup: &populatorGroup, Kind: "Dataset", Name: "dataset-1"},
+			expectedResult: "Dataset.populators.example.com/dataset-1",
+		},
+		{
+			name:           "volume populator in dataSource",
+			dataSource:     &corev1api.TypedLocalObjectReference{APIGroup: &populatorGroup, Kind: "Dataset", Name: "dataset-1"},
+			expectedResult: "Dataset.populators.example.com/dataset-1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pvc := &corev1api.PersistentVolumeClaim{Spec: corev1api.PersistentVolumeClaimSpec{DataSource: tc.dataSource, DataSourceRef: tc.dataSourceRef}}
+			assert.Equal(t, tc.expectedResult, GetVolumePopulator(pvc))
+		})
+	}
+	assert.Equal(t, "", GetVolumePopulator(nil))
+}
+
+func TestNamespaceSkipReason(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(
+		&corev1api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "opted-in", Labels: map[string]string{NamespaceDataMoverEnabledLabel: "true"}}},
+		&corev1api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "opted-out", Labels: map[string]string{NamespaceDataMoverEnabledLabel: "false"}}},
+		&corev1api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+	)
+
+	testCases := []struct {
+		name      string
+		optIn     string
+		namespace string
+		skipped   bool
+		wantErr   bool
+	}{
+		{name: "opt-in mode disabled", namespace: "unlabeled"},
+		{name: "opt-in mode disabled for a missing namespace", namespace: "missing"},
+		{name: "namespace opted in", optIn: "true", namespace: "opted-in"},
+		{name: "namespace opted out", optIn: "true", namespace: "opted-out", skipped: true},
+		{name: "unlabeled namespace", optIn: "true", namespace: "unlabeled", skipped: true},
+		{name: "missing namespace", optIn: "true", namespace: "missing", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(DatamoverNamespaceOptIn, tc.optIn)
+			reason, err := NamespaceSkipReason(tc.namespace, kubeClient.CoreV1())
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.skipped, len(reason) > 0)
+		})
+	}
+}
+
+func TestApprovalVolumeSnapshotBackup(t *testing.T) {
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "openshift-adp"}}
+	vsc := &snapshotv1api.VolumeSnapshotContent{ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-1"}}
+	fakeClient := snapshotFake.NewSimpleClientset(vsc)
+
+	assert.Nil(t, MarkVolumeSnapshotContentAwaitingApproval(vsc.Name, fakeClient.SnapshotV1()))
+	marked, err := fakeClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), vsc.Name, metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.True(t, IsAwaitingApproval(&marked.ObjectMeta))
+
+	vsbName := GetApprovalVolumeSnapshotBackupName(vsc.Name)
+	vscName, ok := GetApprovalVolumeSnapshotContentName(vsbName)
+	assert.True(t, ok)
+	assert.Equal(t, vsc.Name, vscName)
+
+	_, ok = GetApprovalVolumeSnapshotContentName("other-vsb")
+	assert.False(t, ok)
+
+	vsb := NewVolumeSnapshotBackup(vsbName, "app-ns", vscName, "restic-secret", backup)
+	assert.Equal(t, vsbName, vsb.Name)
+	assert.Equal(t, "", vsb.GenerateName)
+	assert.Equal(t, "app-ns", vsb.Namespace)
+	assert.Equal(t, "backup-1", vsb.Labels[BackupNameLabel])
+	assert.Equal(t, vsc.Name, vsb.Labels[VolumeSnapshotBackupVolumeSnapshotContent])
+	assert.Equal(t, vsc.Name, vsb.Spec.VolumeSnapshotContent.Name)
+	assert.Equal(t, "openshift-adp", vsb.Spec.ProtectedNamespace)
+	assert.Equal(t, "restic-secret", vsb.Spec.ResticSecretRef.Name)
+
+	generated := NewVolumeSnapshotBackup("", "app-ns", vscName, "restic-secret", backup)
+	assert.Equal(t, "vsb-", generated.GenerateName)
+}
+
+func TestGetVolumeSnapshotBackupName(t *testing.T) {
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", UID: "uid-1"}}
+	otherBackup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", UID: "uid-2"}}
+
+	name := GetVolumeSnapshotBackupName(backup, "snapcontent-1")
+	assert.Equal(t, name, GetVolumeSnapshotBackupName(backup, "snapcontent-1"))
+	assert.True(t, strings.HasPrefix(name, "vsb-"))
+	assert.Len(t, name, len("vsb-")+volumeSnapshotBackupNameHashLength)
+
+	assert.NotEqual(t, name, GetVolumeSnapshotBackupName(backup, "snapcontent-2"))
+	assert.NotEqual(t, name, GetVolumeSnapshotBackupName(otherBackup, "snapcontent-1"))
+}
+
+func TestGetVolumeSnapshotBackupPVCName(t *testing.T) {
+	vsb := &datamoverv1alpha1.VolumeSnapshotBackup{
+		Spec: datamoverv1alpha1.VolumeSnapshotBackupSpec{
+			VolumeSnapshotContent: v1.ObjectReference{Name: "snapcontent-1"},
+		},
+	}
+
+	assert.Equal(t, "snapcontent-1-pvc", GetVolumeSnapshotBackupPVCName(vsb))
+}
+
+func TestGetRetainedVolumeSnapshotContentForRestore(t *testing.T) {
+	handle := "snap-handle"
+	ready := true
+	notReady := false
+
+	retainedVSC := func(name, retainUntil string, readyToUse *bool) *snapshotv1api.VolumeSnapshotContent {
+		return &snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{VolumeSnapshotRetainedLabel: "true"},
+				Annotations: map[string]string{VolumeSnapshotRetainUntilAnnotation: retainUntil},
+			},
+			Status: &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle, ReadyToUse: readyToUse},
+		}
+	}
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	objs := []runtime.Object{
+		retainedVSC("retained", future, &ready),
+		retainedVSC("expired", past, &ready),
+		retainedVSC("not-ready", future, &notReady),
+		&snapshotv1api.VolumeSnapshotContent{
+			ObjectMeta: metav1.ObjectMeta{Name: "not-retained"},
+			Status:     &snapshotv1api.VolumeSnapshotContentStatus{SnapshotHandle: &handle, ReadyToUse: &ready},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		vscName  string
+		topology string
+		expected bool
+	}{
+		{name: "retained and ready", vscName: "retained", expected: true},
+		{name: "retention expired", vscName: "expired"},
+		{name: "not ready", vscName: "not-ready"},
+		{name: "not retained", vscName: "not-retained"},
+		{name: "missing", vscName: "missing"},
+		{name: "restore into a different topology", vscName: "retained", topology: "topology.kubernetes.io/zone=us-east-1b"},
+	}
+
+	client := snapshotFake.NewSimpleClientset(objs...)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			restore := &velerov1api.Restore{}
+			if len(tc.topology) > 0 {
+				restore.Annotations = map[string]string{RestoreTopologyAnnotation: tc.topology}
+			}
+
+			vsc, err := GetRetainedVolumeSnapshotContentForRestore(restore, tc.vscName, client.SnapshotV1())
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, vsc != nil)
+		})
+	}
+}
+
+func TestGetRestorePVCName(t *testing.T) {
+	newRestore := func(mapping map[string]string) *velerov1api.Restore {
+		return &velerov1api.Restore{Spec: velerov1api.RestoreSpec{NamespaceMapping: mapping}}
+	}
+
+	testCases := []struct {
+		name              string
+		restore           *velerov1api.Restore
+		namespace         string
+		expectedNamespace string
+		expectedName      string
+	}{
+		{
+			name:              "no namespace mapping",
+			restore:           newRestore(nil),
+			namespace:         "app-a",
+			expectedNamespace: "app-a",
+			expectedName:      "data",
+		},
+		{
+			name:              "namespace mapped onto its own namespace",
+			restore:           newRestore(map[string]string{"app-a": "app-restored"}),
+			namespace:         "app-a",
+			expectedNamespace: "app-restored",
+			expectedName:      "data",
+		},
+		{
+			name:              "namespaces mapped onto one namespace",
+			restore:           newRestore(map[string]string{"app-a": "shared", "app-b": "shared", "app-c": "app-c-restored"}),
+			namespace:         "app-b",
+			expectedNamespace: "shared",
+			expectedName:      "data-app-b",
+		},
+		{
+			name:              "namespace mapped onto itself keeps its PVC names",
+			restore:           newRestore(map[string]string{"app-a": "shared", "shared": "shared"}),
+			namespace:         "shared",
+			expectedNamespace: "shared",
+			expectedName:      "data",
+		},
+		{
+			name:              "unmapped namespace",
+			restore:           newRestore(map[string]string{"app-a": "shared", "app-b": "shared"}),
+			namespace:         "app-c",
+			expectedNamespace: "app-c",
+			expectedName:      "data",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedNamespace, GetRestoreNamespace(tc.restore, tc.namespace))
+			assert.Equal(t, tc.expectedName, GetRestorePVCName(tc.restore, tc.namespace, "data"))
+		})
+	}
+
+	long := GetRestorePVCName(newRestore(map[string]string{"app-a": "shared", "app-b": "shared"}), "app-a", strings.Repeat("d", 250))
+	assert.LessOrEqual(t, len(long), validation.DNS1123SubdomainMaxLength)
+}
+
+func TestGetRestorePVCSize(t *testing.T) {
+	mapping := &corev1api.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "size-mapping",
+			Namespace: "velero",
+			Labels:    map[string]string{PluginConfigLabel: "", VSMSizeMappingLabel: "RestoreItemAction"},
+		},
+		Data: map[string]string{"app.data": "20Gi", "app.logs": "1Gi"},
+	}
+	defer SetClients(fake.NewSimpleClientset(mapping), snapshotFake.NewSimpleClientset(), nil)()
+
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		pvcName     string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "no size override",
+			pvcName:  "cache",
+			expected: "10Gi",
+		},
+		{
+			name:     "size from the configmap",
+			pvcName:  "data",
+			expected: "20Gi",
+		},
+		{
+			name:        "restore annotation takes precedence",
+			annotations: map[string]string{RestoreSizeAnnotation: "app/data=30Gi, other/data=40Gi"},
+			pvcName:     "data",
+			expected:    "30Gi",
+		},
+		{
+			name:        "size below the backed up size",
+			pvcName:     "logs",
+			expectError: true,
+		},
+		{
+			name:        "malformed restore annotation",
+			annotations: map[string]string{RestoreSizeAnnotation: "app/data"},
+			pvcName:     "data",
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			restore := &velerov1api.Restore{ObjectMeta: metav1.ObjectMeta{Name: "restore-1", Annotations: tc.annotations}}
+			size, err := GetRestorePVCSize(restore, "app", tc.pvcName, "10Gi")
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, size)
+		})
+	}
+}
+
+func TestEnsureResticSecret(t *testing.T) {
+	defer func(data map[string]string, fetchedAt time.Time) {
+		configMapData, configMapFetchedAt = data, fetchedAt
+	}(configMapData, configMapFetchedAt)
+
+	configMapData = map[string]string{}
+	configMapFetchedAt = time.Now()
+
+	client := fake.NewSimpleClientset(
+		&corev1api.Secret{ObjectMeta: metav1.ObjectMeta{Name: "default-volsync-restic", Namespace: "openshift-adp"}},
+		&corev1api.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "dr-restic", Namespace: "dr-secrets"},
+			Data:       map[string][]byte{"RESTIC_PASSWORD": []byte("secret")},
+		},
+	)
+	log := logrus.New().WithField("fake", "test")
+
+	exists, err := EnsureResticSecret("default-volsync-restic", "openshift-adp", "restore-1", client.CoreV1(), log)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	exists, err = EnsureResticSecret("dr-volsync-restic", "openshift-adp", "restore-1", client.CoreV1(), log)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	t.Setenv(DatamoverResticSourceSecret, "dr-secrets/dr-restic")
+	exists, err = EnsureResticSecret("dr-volsync-restic", "openshift-adp", "restore-1", client.CoreV1(), log)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	secret, err := client.CoreV1().Secrets("openshift-adp").Get(context.TODO(), "dr-volsync-restic", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), secret.Data["RESTIC_PASSWORD"])
+	assert.Equal(t, "restore-1", secret.Labels[RestoreNameLabel])
+
+	t.Setenv(DatamoverResticSourceSecret, "dr-secrets/missing")
+	_, err = EnsureResticSecret("other-volsync-restic", "openshift-adp", "restore-1", client.CoreV1(), log)
+	assert.Error(t, err)
+}
+
+func TestDeleteExistingRestorePVC(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		&corev1api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "app"}},
+		&corev1api.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "mounted", Namespace: "app"}},
+		&corev1api.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "app"},
+			Spec: corev1api.PodSpec{
+				Volumes: []corev1api.Volume{{
+					Name: "mounted",
+					VolumeSource: corev1api.VolumeSource{
+						PersistentVolumeClaim: &corev1api.PersistentVolumeClaimVolumeSource{ClaimName: "mounted"},
+					},
+				}},
+			},
+		},
+	)
+
+	exists, err := RestorePVCExists("app", "data", client.CoreV1())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, DeleteExistingRestorePVC("app", "data", client.CoreV1()))
+	exists, err = RestorePVCExists("app", "data", client.CoreV1())
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.Error(t, DeleteExistingRestorePVC("app", "mounted", client.CoreV1()))
+	exists, err = RestorePVCExists("app", "mounted", client.CoreV1())
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.False(t, ReplacesExistingPVCs(&velerov1api.Restore{}))
+	assert.True(t, ReplacesExistingPVCs(&velerov1api.Restore{Spec: velerov1api.RestoreSpec{ExistingResourcePolicy: velerov1api.PolicyTypeUpdate}}))
+}
+
+func TestGetDataMoverCredName(t *testing.T) {
+	newSecret := func(name string, labels map[string]string) *corev1api.Secret {
+		secret := &corev1api.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "openshift-adp", Labels: map[string]string{ResticSecretProviderLabel: "aws"}},
+			Data: map[string][]byte{
+				"RESTIC_REPOSITORY":     []byte("s3:s3.amazonaws.com/bucket"),
+				"RESTIC_PASSWORD":       []byte("password"),
+				"AWS_ACCESS_KEY_ID":     []byte("id"),
+				"AWS_SECRET_ACCESS_KEY": []byte("key"),
+			},
+		}
+		for k, v := range labels {
+			secret.Labels[k] = v
+		}
+		return secret
+	}
+	withoutPassword := newSecret("default-volsync-restic", nil)
+	delete(withoutPassword.Data, "RESTIC_PASSWORD")
+	bslLabels := map[string]string{ResticSecretLabel: "true", velerov1api.StorageLocationLabel: "default"}
+	backup := &velerov1api.Backup{Spec: velerov1api.BackupSpec{StorageLocation: "default"}}
+
+	testCases := []struct {
+		name        string
+		secrets     []runtime.Object
+		vault       bool
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "secret named by convention",
+			secrets:  []runtime.Object{newSecret("default-volsync-restic", nil)},
+			expected: "default-volsync-restic",
+		},
+		{
+			name: "labeled secret takes precedence",
+			secrets: []runtime.Object{
+				newSecret("default-volsync-restic", nil),
+				newSecret("team-restic", bslLabels),
+				newSecret("other-restic", map[string]string{ResticSecretLabel: "true", velerov1api.StorageLocationLabel: "other"}),
+			},
+			expected: "team-restic",
+		},
+		{
+			name:        "several labeled secrets",
+			secrets:     []runtime.Object{newSecret("team-restic", bslLabels), newSecret("team-restic-2", bslLabels)},
+			expectError: true,
+		},
+		{
+			name:        "no secret",
+			expectError: true,
+		},
+		{
+			name:        "secret without password",
+			secrets:     []runtime.Object{withoutPassword},
+			expectError: true,
+		},
+		{
+			name:     "secret without password resolved from vault",
+			secrets:  []runtime.Object{withoutPassword},
+			vault:    true,
+			expected: "default-volsync-restic",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.vault {
+				t.Setenv(DatamoverVaultAddress, "https://vault.example.com:8200")
+			} else {
+				t.Setenv(DatamoverVaultAddress, "")
+			}
+			defer SetClients(fake.NewSimpleClientset(tc.secrets...), snapshotFake.NewSimpleClientset(), nil)()
+
+			name, err := GetDataMoverCredName(backup, "openshift-adp", logrus.New().WithField("fake", "test"))
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, name)
+		})
+	}
+}
+
+func TestGetExistingVSRForVSB(t *testing.T) {
+	newVSR := func(name, vsbName string, deleting bool) *datamoverv1alpha1.VolumeSnapshotRestore {
+		vsr := &datamoverv1alpha1.VolumeSnapshotRestore{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "app",
+				Labels: map[string]string{
+					velerov1api.RestoreNameLabel: "restore-1",
+					PersistentVolumeClaimLabel:   "data",
+					VolumeSnapshotBackupLabel:    vsbName,
+				},
+			},
+		}
+		if deleting {
+			now := metav1.Now()
+			vsr.DeletionTimestamp = &now
+			vsr.Finalizers = []string{"test"}
+		}
+		return vsr
+	}
+
+	scheme, err := NewScheme()
+	assert.NoError(t, err)
+	crClient := crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		newVSR("vsr-deleting", "vsb-1", true),
+		newVSR("vsr-other", "vsb-2", false),
+		newVSR("vsr-1", "vsb-1", false),
+	).Build()
+	defer SetClients(fake.NewSimpleClientset(), snapshotFake.NewSimpleClientset(), crClient)()
+
+	vsr, err := GetExistingVSRForVSB("restore-1", "app", "data", "vsb-1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, vsr) {
+		assert.Equal(t, "vsr-1", vsr.Name)
+	}
+
+	vsr, err = GetExistingVSRForVSB("restore-2", "app", "data", "vsb-1")
+	assert.NoError(t, err)
+	assert.Nil(t, vsr)
+
+	vsr, err = GetExistingVSRForVSB("restore-1", "app", "data", "vsb-3")
+	assert.NoError(t, err)
+	assert.Nil(t, vsr)
+}
+
+func TestGetVSBForVSC(t *testing.T) {
+	backup := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "openshift-adp", UID: "uid-1"}}
+	retried := &velerov1api.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup-1", Namespace: "openshift-adp", UID: "uid-2"}}
+	snapCont := &snapshotv1api.VolumeSnapshotContent{ObjectMeta: metav1.ObjectMeta{Name: "snapcontent-1"}}
+
+	deleting := NewVolumeSnapshotBackup("vsb-deleting", "app", snapCont.Name, "restic-secret", backup)
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	deleting.Finalizers = []string{"test"}
+
+	scheme, err := NewScheme()
+	assert.NoError(t, err)
+	crClient := crfake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(
+		deleting,
+		NewVolumeSnapshotBackup("vsb-1", "app", snapCont.Name, "restic-secret", backup),
+		NewVolumeSnapshotBackup("vsb-2", "app", "snapcontent-2", "restic-secret", backup),
+	).Build()
+	defer SetClients(fake.NewSimpleClientset(), snapshotFake.NewSimpleClientset(), crClient)()
+
+	log := logrus.New().WithField("fake", "test")
+
+	vsb, err := GetVSBForVSC(snapCont, backup, log)
+	assert.NoError(t, err)
+	if assert.NotNil(t, vsb) {
+		assert.Equal(t, "vsb-1", vsb.Name)
+	}
+
+	// a backup recreated with the same name doesn't reuse the VSBs of the one before it
+	vsb, err = GetVSBForVSC(snapCont, retried, log)
+	assert.NoError(t, err)
+	assert.Nil(t, vsb)
+}
+
+func TestSelectRestoreVSR(t *testing.T) {
+	restore := &velerov1api.Restore{ObjectMeta: metav1.ObjectMeta{Name: "restore-1", UID: "uid-1"}}
+	newVSR := func(name, uid, vsbName string, created time.Time) datamoverv1alpha1.VolumeSnapshotRestore {
+		vsr := datamoverv1alpha1.VolumeSnapshotRestore{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "app",
+				CreationTimestamp: metav1.NewTime(created),
+				Labels:            map[string]string{VolumeSnapshotBackupLabel: vsbName},
+			},
+		}
+		if len(uid) > 0 {
+			vsr.Labels[RestoreUIDLabel] = uid
+		}
+		return vsr
+	}
+	now := time.Now()
+	deleting := newVSR("vsr-deleting", "uid-1", "vsb-1", now.Add(-time.Hour))
+	deletedAt := metav1.NewTime(now)
+	deleting.DeletionTimestamp = &deletedAt
+
+	tests := []struct {
+		name        string
+		vsrs        []datamoverv1alpha1.VolumeSnapshotRestore
+		expected    string
+		expectError bool
+	}{
+		{
+			name: "no VSR",
+		},
+		{
+			name:     "VSR of the restore wins over the one of a recreated restore",
+			vsrs:     []datamoverv1alpha1.VolumeSnapshotRestore{newVSR("vsr-old", "uid-0", "vsb-1", now), newVSR("vsr-1", "uid-1", "vsb-1", now)},
+			expected: "vsr-1",
+		},
+		{
+			name: "only VSRs of a recreated restore",
+			vsrs: []datamoverv1alpha1.VolumeSnapshotRestore{newVSR("vsr-old", "uid-0", "vsb-1", now)},
+		},
+		{
+			name:     "VSR created without the UID label",
+			vsrs:     []datamoverv1alpha1.VolumeSnapshotRestore{newVSR("vsr-1", "", "vsb-1", now)},
+			expected: "vsr-1",
+		},
+		{
+			name:     "oldest of duplicate VSRs for the same VSB, ignoring VSRs being deleted",
+			vsrs:     []datamoverv1alpha1.VolumeSnapshotRestore{deleting, newVSR("vsr-2", "uid-1", "vsb-1", now), newVSR("vsr-1", "uid-1", "vsb-1", now.Add(-time.Minute))},
+			expected: "vsr-1",
+		},
+		{
+			name:        "VSRs for different VSBs",
+			vsrs:        []datamoverv1alpha1.VolumeSnapshotRestore{newVSR("vsr-1", "uid-1", "vsb-1", now), newVSR("vsr-2", "uid-1", "vsb-2", now)},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			vsr, err := selectRestoreVSR(tc.vsrs, restore, logrus.New().WithField("fake", "test"))
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if len(tc.expected) == 0 {
+				assert.Nil(t, vsr)
+				return
+			}
+			if assert.NotNil(t, vsr) {
+				assert.Equal(t, tc.expected, vsr.Name)
+			}
+		})
+	}
+}
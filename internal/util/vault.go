@@ -0,0 +1,241 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vmware-tanzu/velero/pkg/label"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+const (
+	// defaultVaultAuthPath is the mount path of the Vault kubernetes auth method
+	defaultVaultAuthPath = "kubernetes"
+	// vaultPasswordKey is the key of the repository password, in the Vault secret and in restic secrets
+	vaultPasswordKey = "RESTIC_PASSWORD"
+	// vaultRequestTimeout bounds each request to Vault
+	vaultRequestTimeout = 10 * time.Second
+)
+
+// vaultTokenFile is the service account token the plugin logs in to Vault with
+var vaultTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// VaultEnabled returns true if the restic repository password is resolved from Vault
+func VaultEnabled() bool {
+	return len(getSetting(DatamoverVaultAddress)) > 0
+}
+
+// vaultResponse is the part of the Vault API responses the plugin reads
+type vaultResponse struct {
+	Auth *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+// GetVaultResticPassword logs in to Vault with the kubernetes auth method and returns the restic repository password
+// read from the configured secret, a KV version 1 or 2 secret holding it under RESTIC_PASSWORD
+func GetVaultResticPassword(ctx context.Context) (string, error) {
+	address := strings.TrimSuffix(getSetting(DatamoverVaultAddress), "/")
+	secretPath := strings.Trim(getSetting(DatamoverVaultSecretPath), "/")
+	role := getSetting(DatamoverVaultRole)
+	if len(secretPath) == 0 || len(role) == 0 {
+		return "", errors.Errorf("%s and %s must be set along with %s", DatamoverVaultSecretPath, DatamoverVaultRole, DatamoverVaultAddress)
+	}
+
+	authPath := strings.Trim(getSetting(DatamoverVaultAuthPath), "/")
+	if len(authPath) == 0 {
+		authPath = defaultVaultAuthPath
+	}
+
+	jwt, err := os.ReadFile(vaultTokenFile)
+	if err != nil {
+		return "", errors.Wrap(err, "error reading the service account token to log in to vault with")
+	}
+
+	httpClient := &http.Client{Timeout: vaultRequestTimeout}
+
+	login, err := json.Marshal(map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	auth, err := vaultRequest(ctx, httpClient, http.MethodPost, fmt.Sprintf("%s/v1/auth/%s/login", address, authPath), "", login)
+	if err != nil {
+		return "", errors.Wrapf(err, "error logging in to vault with role %s", role)
+	}
+	if auth.Auth == nil || len(auth.Auth.ClientToken) == 0 {
+		return "", errors.Errorf("vault login with role %s returned no token", role)
+	}
+
+	secret, err := vaultRequest(ctx, httpClient, http.MethodGet, fmt.Sprintf("%s/v1/%s", address, secretPath), auth.Auth.ClientToken, nil)
+	if err != nil {
+		return "", errors.Wrapf(err, "error reading vault secret %s", secretPath)
+	}
+
+	// KV version 2 nests the secret data under data.data
+	data := secret.Data
+	if _, ok := data[vaultPasswordKey]; !ok {
+		if nested, ok := data["data"].(map[string]interface{}); ok {
+			data = nested
+		}
+	}
+
+	password, ok := data[vaultPasswordKey].(string)
+	if !ok || len(password) == 0 {
+		return "", errors.Errorf("vault secret %s has no %s", secretPath, vaultPasswordKey)
+	}
+
+	return password, nil
+}
+
+// vaultRequest sends a request to the Vault API and decodes its response
+func vaultRequest(ctx context.Context, httpClient *http.Client, method, url, token string, body []byte) (*vaultResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(token) > 0 {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	vaultResp := &vaultResponse{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, vaultResp); err != nil {
+			return nil, errors.Wrapf(err, "error decoding vault response with status %d", resp.StatusCode)
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("vault returned status %d: %s", resp.StatusCode, strings.Join(vaultResp.Errors, ", "))
+	}
+
+	return vaultResp, nil
+}
+
+// GetVaultResticSecretName returns the name of the short-lived restic secret created for the given owner
+func GetVaultResticSecretName(owner string) string {
+	return label.GetValidName(fmt.Sprintf("%s-vault-restic", owner))
+}
+
+// MaterializeVaultResticSecret creates a short-lived copy of the restic secret in the velero namespace, for the VSB or
+// VSR of the given owner, holding the repository password resolved from Vault, and returns its name. The restic secret
+// only needs the repository settings and credentials then, not the password.
+func MaterializeVaultResticSecret(baseName, owner, protectedNS string, secretsGetter corev1client.SecretsGetter) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*vaultRequestTimeout)
+	defer cancel()
+
+	password, err := GetVaultResticPassword(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	base, err := secretsGetter.Secrets(protectedNS).Get(context.TODO(), baseName, metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrapf(err, "error getting restic secret %s/%s", protectedNS, baseName)
+	}
+
+	data := map[string][]byte{}
+	for key, val := range base.Data {
+		data[key] = val
+	}
+	data[vaultPasswordKey] = []byte(password)
+
+	name := GetVaultResticSecretName(owner)
+	secret := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: protectedNS,
+			Labels: map[string]string{
+				VaultResticSecretLabel: "true",
+			},
+		},
+		Type: base.Type,
+		Data: data,
+	}
+	copyResticSecretProvider(base, &secret.ObjectMeta)
+
+	_, err = secretsGetter.Secrets(protectedNS).Create(context.TODO(), secret, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		existing, err := secretsGetter.Secrets(protectedNS).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return "", errors.Wrapf(err, "error getting restic secret %s/%s", protectedNS, name)
+		}
+		if existing.Labels[VaultResticSecretLabel] != "true" {
+			return "", errors.Errorf("secret %s/%s is not a short-lived restic secret", protectedNS, name)
+		}
+
+		existing.Data = data
+		copyResticSecretProvider(base, &existing.ObjectMeta)
+		if _, err := secretsGetter.Secrets(protectedNS).Update(context.TODO(), existing, metav1.UpdateOptions{}); err != nil {
+			return "", errors.Wrapf(err, "error updating restic secret %s/%s", protectedNS, name)
+		}
+		return name, nil
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "error creating restic secret %s/%s", protectedNS, name)
+	}
+
+	return name, nil
+}
+
+// DeleteVaultResticSecret deletes the named restic secret if it is a short-lived one created by
+// MaterializeVaultResticSecret, and leaves any other secret alone
+func DeleteVaultResticSecret(name, protectedNS string, secretsGetter corev1client.SecretsGetter) error {
+	secret, err := secretsGetter.Secrets(protectedNS).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "error getting restic secret %s/%s", protectedNS, name)
+	}
+
+	if secret.Labels[VaultResticSecretLabel] != "true" {
+		return nil
+	}
+
+	err = secretsGetter.Secrets(protectedNS).Delete(context.TODO(), name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return errors.Wrapf(err, "error deleting restic secret %s/%s", protectedNS, name)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1api "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newVaultServer returns a Vault stub that logs in role "velero" with token "jwt" and serves the given secret data
+func newVaultServer(t *testing.T, secretData map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			login := map[string]string{}
+			_ = json.NewDecoder(r.Body).Decode(&login)
+			if login["role"] != "velero" || login["jwt"] != "jwt" {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"invalid role or jwt"}})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": "token"}})
+		case "/v1/secret/data/restic":
+			if r.Header.Get("X-Vault-Token") != "token" {
+				w.WriteHeader(http.StatusForbidden)
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"permission denied"}})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": secretData}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// setVaultSettings points the plugin at the given Vault server
+func setVaultSettings(t *testing.T, address, role string) {
+	data, fetchedAt, tokenFile := configMapData, configMapFetchedAt, vaultTokenFile
+	t.Cleanup(func() {
+		configMapData, configMapFetchedAt, vaultTokenFile = data, fetchedAt, tokenFile
+	})
+
+	vaultTokenFile = filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(vaultTokenFile, []byte("jwt\n"), 0600))
+
+	configMapFetchedAt = time.Now()
+	configMapData = map[string]string{
+		DatamoverVaultAddress:    address,
+		DatamoverVaultSecretPath: "secret/data/restic",
+		DatamoverVaultRole:       role,
+	}
+}
+
+func TestGetVaultResticPassword(t *testing.T) {
+	testCases := []struct {
+		name       string
+		role       string
+		secretData map[string]interface{}
+		expected   string
+		expectErr  bool
+	}{
+		{
+			name:       "password is read from the kv secret",
+			role:       "velero",
+			secretData: map[string]interface{}{vaultPasswordKey: "secret-password"},
+			expected:   "secret-password",
+		},
+		{
+			name:       "secret without the password",
+			role:       "velero",
+			secretData: map[string]interface{}{"other": "value"},
+			expectErr:  true,
+		},
+		{
+			name:       "login is rejected",
+			role:       "other",
+			secretData: map[string]interface{}{vaultPasswordKey: "secret-password"},
+			expectErr:  true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newVaultServer(t, tc.secretData)
+			setVaultSettings(t, server.URL, tc.role)
+
+			password, err := GetVaultResticPassword(context.Background())
+			if tc.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, password)
+		})
+	}
+}
+
+func TestMaterializeVaultResticSecret(t *testing.T) {
+	server := newVaultServer(t, map[string]interface{}{vaultPasswordKey: "secret-password"})
+	setVaultSettings(t, server.URL, "velero")
+
+	base := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-volsync-restic", Namespace: "openshift-adp"},
+		Data:       map[string][]byte{"AWS_ACCESS_KEY_ID": []byte("key")},
+	}
+	kubeClient := fake.NewSimpleClientset(base)
+
+	name, err := MaterializeVaultResticSecret("default-volsync-restic", "snapcontent-1", "openshift-adp", kubeClient.CoreV1())
+	assert.NoError(t, err)
+	assert.Equal(t, "snapcontent-1-vault-restic", name)
+
+	secret, err := kubeClient.CoreV1().Secrets("openshift-adp").Get(context.TODO(), name, metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "true", secret.Labels[VaultResticSecretLabel])
+	assert.Equal(t, map[string][]byte{"AWS_ACCESS_KEY_ID": []byte("key"), vaultPasswordKey: []byte("secret-password")}, secret.Data)
+
+	// materializing again refreshes the existing secret
+	name, err = MaterializeVaultResticSecret("default-volsync-restic", "snapcontent-1", "openshift-adp", kubeClient.CoreV1())
+	assert.NoError(t, err)
+	assert.Equal(t, "snapcontent-1-vault-restic", name)
+
+	// only short-lived secrets are deleted
+	assert.NoError(t, DeleteVaultResticSecret("default-volsync-restic", "openshift-adp", kubeClient.CoreV1()))
+	_, err = kubeClient.CoreV1().Secrets("openshift-adp").Get(context.TODO(), "default-volsync-restic", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	assert.NoError(t, DeleteVaultResticSecret(name, "openshift-adp", kubeClient.CoreV1()))
+	_, err = kubeClient.CoreV1().Secrets("openshift-adp").Get(context.TODO(), name, metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
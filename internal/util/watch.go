@@ -0,0 +1,68 @@
+/*
+Copyright 2020 the Velero contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// newNamedListWatch returns a ListerWatcher for the single object of list's type with the given namespace and name,
+// served by the plugin's controller-runtime client
+func newNamedListWatch(ctx context.Context, c client.WithWatch, list client.ObjectList, namespace, name string) cache.ListerWatcher {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			objs := list.DeepCopyObject().(client.ObjectList)
+			err := c.List(ctx, objs, &client.ListOptions{Namespace: namespace, Raw: &options})
+			return objs, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return c.Watch(ctx, list.DeepCopyObject().(client.ObjectList), &client.ListOptions{Namespace: namespace, Raw: &options})
+		},
+	}
+}
+
+// waitForObject watches the object served by lw until condition reports it done, errors, or timeout elapses. The
+// condition sees the object as soon as it is listed and again on every change, so callers react to status updates
+// without polling. A timeout is reported as wait.ErrWaitTimeout.
+func waitForObject(ctx context.Context, lw cache.ListerWatcher, objType runtime.Object, timeout time.Duration, condition func(runtime.Object) (bool, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := watchtools.UntilWithSync(ctx, lw, objType, nil, func(event watch.Event) (bool, error) {
+		switch event.Type {
+		case watch.Deleted:
+			return false, errors.New("object was deleted while waiting for it")
+		case watch.Added, watch.Modified:
+			return condition(event.Object)
+		}
+		return false, nil
+	})
+	return err
+}
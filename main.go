@@ -17,25 +17,111 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/backup"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/delete"
 	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/restore"
+	"github.com/vmware-tanzu/velero-plugin-for-csi/internal/util"
 	veleroplugin "github.com/vmware-tanzu/velero/pkg/plugin/framework"
 )
 
+// tracingFlushTimeout bounds exporting the spans not exported yet at shutdown
+const tracingFlushTimeout = 5 * time.Second
+
 func main() {
-	veleroplugin.NewServer().
-		BindFlags(pflag.CommandLine).
+	if err := util.ValidatePluginConfig(); err != nil {
+		logrus.WithError(err).Fatal("invalid plugin configuration")
+	}
+
+	flushTracing, err := util.InitTracing(context.Background())
+	if err != nil {
+		logrus.WithError(err).Warn("not exporting traces")
+		flushTracing = func(context.Context) error { return nil }
+	}
+
+	go drainOnShutdown(flushTracing)
+
+	server := veleroplugin.NewServer().BindFlags(pflag.CommandLine)
+
+	// velero starts plugins with its own flags only, so the metrics address defaults to DATAMOVER_METRICS_ADDRESS
+	metricsAddress := pflag.CommandLine.String("metrics-address", util.GetMetricsAddress(), "Address the data mover metrics are served on, e.g. :8085, disabled when empty")
+	// the clients are built on the first item action, after the flags are applied
+	kubeAPIQPS := pflag.CommandLine.Float32("kube-api-qps", util.GetClientQPS(), "Queries per second the plugin process may send to the Kubernetes API server")
+	kubeAPIBurst := pflag.CommandLine.Int("kube-api-burst", util.GetClientBurst(), "Burst of requests the plugin process may send to the Kubernetes API server above its QPS")
+	_ = pflag.CommandLine.Parse(os.Args[1:])
+	util.SetClientRateLimits(*kubeAPIQPS, *kubeAPIBurst)
+	if len(*metricsAddress) > 0 {
+		go serveMetrics(*metricsAddress)
+	}
+
+	server.
+		RegisterBackupItemAction("velero.io/vsm-pvc-backupper", newPVCBackupItemAction).
+		RegisterBackupItemAction("velero.io/vsm-volumesnapshot-backupper", newVolumeSnapshotBackupItemAction).
+		RegisterBackupItemAction("velero.io/vsm-volumesnapshotclass-backupper", newVolumeSnapshotClassBackupItemAction).
 		RegisterBackupItemActionV2("velero.io/vsm-volumesnapshotcontent-backupper", newVolumeSnapContentBackupItemActionV2).
 		RegisterBackupItemAction("velero.io/vsm-volumesnapshotbackup-backupper", newVolumeSnapshotBackupBackupItemAction).
+		RegisterRestoreItemAction("velero.io/vsm-pvc-restorer", newPVCRestoreItemAction).
 		RegisterRestoreItemAction("velero.io/vsm-volumesnapshot-restorer", newVolumeSnapshotRestoreItemAction).
 		RegisterRestoreItemActionV2("velero.io/vsm-datamover-restorer", newVolumeSnapshotBackupRestoreItemActionV2).
 		RegisterDeleteItemAction("velero.io/csi-volumesnapshotbackup-delete", newVolumeSnapshotBackupDeleteItemAction).
 		Serve()
 }
 
+// drainOnShutdown waits for SIGTERM, then stops accepting new item actions and gives the in-flight ones a grace period
+// to return before exiting, so a velero pod rollout doesn't kill them halfway through. The spans of the item actions
+// are flushed before exiting.
+func drainOnShutdown(flushTracing func(context.Context) error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	<-signals
+
+	logrus.Infof("received SIGTERM, draining in-flight item actions for up to %s", util.DefaultShutdownGracePeriod)
+	finished, remaining := util.Drain(util.DefaultShutdownGracePeriod)
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+	if err := flushTracing(ctx); err != nil {
+		logrus.WithError(err).Warn("failed to flush traces")
+	}
+	cancel()
+
+	logger := logrus.WithField("finished", finished)
+	if len(remaining) > 0 {
+		logger.WithField("remaining", remaining).Warn("shutting down with item actions still in flight")
+		os.Exit(1)
+	}
+
+	logger.Info("drained all in-flight item actions, shutting down")
+	os.Exit(0)
+}
+
+// serveMetrics serves the plugin metrics on the address. Velero runs several plugin processes at once, only the first
+// one listening on the address serves its metrics.
+func serveMetrics(address string) {
+	logrus.Infof("serving metrics on %s", address)
+	if err := util.ServeMetrics(address, logrus.StandardLogger()); err != nil {
+		logrus.WithError(err).Warnf("not serving metrics on %s", address)
+	}
+}
+
+func newPVCBackupItemAction(logger logrus.FieldLogger) (interface{}, error) {
+	return &backup.PVCBackupItemAction{Log: logger}, nil
+}
+
+func newVolumeSnapshotBackupItemAction(logger logrus.FieldLogger) (interface{}, error) {
+	return &backup.VolumeSnapshotBackupItemAction{Log: logger}, nil
+}
+
+func newVolumeSnapshotClassBackupItemAction(logger logrus.FieldLogger) (interface{}, error) {
+	return &backup.VolumeSnapshotClassBackupItemAction{Log: logger}, nil
+}
+
 func newVolumeSnapContentBackupItemActionV2(logger logrus.FieldLogger) (interface{}, error) {
 	return &backup.VolumeSnapshotContentBackupItemActionV2{Log: logger}, nil
 }
@@ -44,6 +130,10 @@ func newVolumeSnapshotBackupBackupItemAction(logger logrus.FieldLogger) (interfa
 	return &backup.VolumeSnapshotBackupBackupItemAction{Log: logger}, nil
 }
 
+func newPVCRestoreItemAction(logger logrus.FieldLogger) (interface{}, error) {
+	return &restore.PVCRestoreItemAction{Log: logger}, nil
+}
+
 func newVolumeSnapshotRestoreItemAction(logger logrus.FieldLogger) (interface{}, error) {
 	return &restore.VolumeSnapshotRestoreItemAction{Log: logger}, nil
 }